@@ -12,10 +12,11 @@ import (
     "golang.org/x/oauth2/google"
 )
 
+// init loads .env when there is one; without it the process environment is
+// used as is, e.g. in tests and containers.
 func init() {
-    err := godotenv.Load()
-    if err != nil {
-        log.Fatal("Error loading .env file")
+    if err := godotenv.Load(); err != nil {
+        log.Println("No .env file loaded; using the process environment")
     }
 }
 
@@ -76,10 +77,53 @@ func Connect() {
         &models.OrderedStop{}, 
         &models.FareRule{}, 
         &models.StopDuration{}, 
+        &models.ExchangeRate{}, 
+        &models.IdempotencyKey{}, 
+        &models.WalletTransfer{}, 
+        &models.AutoReloadRule{}, 
+        &models.AutoReloadEvent{}, 
+        &models.NolCardStatusEvent{}, 
+        &models.NolCardUpgradeRule{}, 
+        &models.NolCardUpgrade{}, 
+        &models.CardNumberSeries{}, 
+        &models.NolCardJourney{}, 
+        &models.NolCardRefund{}, 
+        &models.StatementDispatch{}, 
+        &models.SubscriptionRenewal{}, 
+        &models.SubscriptionAdjustment{}, 
+        &models.SubscriptionPlanRoute{}, 
+        &models.Organisation{}, 
+        &models.OrganisationMember{}, 
+        &models.OrganisationInvite{}, 
+        &models.OrganisationCharge{}, 
+        &models.CouponRedemption{}, 
+        &models.ReferralCode{}, 
+        &models.Referral{}, 
+        &models.ReferralProgramme{}, 
+        &models.LoyaltyProgramme{}, 
+        &models.LoyaltyEarnRate{}, 
+        &models.LoyaltyLedgerEntry{}, 
+        &models.Campaign{}, 
+        &models.InvoiceLine{}, 
+        &models.InvoiceSequence{}, 
+        &models.TaxRule{}, 
+        &models.RidershipStopHour{}, 
+        &models.RidershipODDay{}, 
+        &models.ReportSchedule{}, 
+        &models.ReportRun{}, 
     )
     if err != nil {
         log.Fatalf("Error running migrations: %v", err)
     }
+
+    // Payments are read and written through the "payments" table rather than the default table name.
+    if err := DB.Table("payments").AutoMigrate(&models.RazorpayPayment{}); err != nil {
+        log.Fatalf("Error running payments migration: %v", err)
+    }
+    // Older rows stored the gateway's "captured" instead of the canonical "verified".
+    if err := DB.Table("payments").Where("status = ?", "captured").Update("status", models.PaymentStatusVerified).Error; err != nil {
+        log.Fatalf("Error normalising payment statuses: %v", err)
+    }
     log.Println("Database migration completed")
 }
 
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Prototype-1/xtrace/internal/models"
+	"github.com/Prototype-1/xtrace/internal/usecase"
+	"github.com/gin-gonic/gin"
+)
+
+type AutoReloadHandler struct {
+	AutoReloadUsecase usecase.AutoReloadUsecase
+}
+
+func NewAutoReloadHandler(autoReloadUsecase usecase.AutoReloadUsecase) *AutoReloadHandler {
+	return &AutoReloadHandler{AutoReloadUsecase: autoReloadUsecase}
+}
+
+type autoReloadRuleInput struct {
+	TargetType        string  `json:"target_type"`
+	NolCardID         *int    `json:"nol_card_id"`
+	Threshold         float64 `json:"threshold"`
+	ReloadAmount      float64 `json:"reload_amount" binding:"required,gt=0"`
+	FundingSource     string  `json:"funding_source" binding:"required"`
+	MandateCustomerID string  `json:"mandate_customer_id"`
+	MandateTokenID    string  `json:"mandate_token_id"`
+	MonthlyCap        float64 `json:"monthly_cap" binding:"required,gt=0"`
+	Enabled           *bool   `json:"enabled"`
+}
+
+func (h *AutoReloadHandler) CreateRule(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+
+	var input autoReloadRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := &models.AutoReloadRule{
+		UserID:            userID,
+		TargetType:        input.TargetType,
+		NolCardID:         input.NolCardID,
+		Threshold:         input.Threshold,
+		ReloadAmount:      input.ReloadAmount,
+		FundingSource:     input.FundingSource,
+		MandateCustomerID: input.MandateCustomerID,
+		MandateTokenID:    input.MandateTokenID,
+		MonthlyCap:        input.MonthlyCap,
+	}
+	if err := h.AutoReloadUsecase.CreateRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Auto-reload rule created successfully", "rule": rule})
+}
+
+func (h *AutoReloadHandler) UpdateRule(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+	ruleID, err := strconv.ParseUint(c.Param("rule_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	var input autoReloadRuleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	enabled := true
+	if input.Enabled != nil {
+		enabled = *input.Enabled
+	}
+	rule := &models.AutoReloadRule{
+		AutoReloadRuleID:  uint(ruleID),
+		Threshold:         input.Threshold,
+		ReloadAmount:      input.ReloadAmount,
+		FundingSource:     input.FundingSource,
+		MandateCustomerID: input.MandateCustomerID,
+		MandateTokenID:    input.MandateTokenID,
+		MonthlyCap:        input.MonthlyCap,
+		Enabled:           enabled,
+	}
+	if err := h.AutoReloadUsecase.UpdateRule(userID, rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Auto-reload rule updated successfully"})
+}
+
+func (h *AutoReloadHandler) DeleteRule(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+	ruleID, err := strconv.ParseUint(c.Param("rule_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	if err := h.AutoReloadUsecase.DeleteRule(userID, uint(ruleID)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Auto-reload rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Auto-reload rule deleted successfully"})
+}
+
+func (h *AutoReloadHandler) GetRules(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+
+	rules, err := h.AutoReloadUsecase.GetRulesByUserID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve auto-reload rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+func (h *AutoReloadHandler) GetRuleEvents(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+	ruleID, err := strconv.ParseUint(c.Param("rule_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	events, err := h.AutoReloadUsecase.GetEventsByRuleID(userID, uint(ruleID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
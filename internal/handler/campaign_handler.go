@@ -0,0 +1,182 @@
+package handler
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/usecase"
+    "github.com/gin-gonic/gin"
+)
+
+type CampaignHandler struct {
+    CampaignUsecase usecase.CampaignUsecase
+}
+
+func NewCampaignHandler(campaignUsecase usecase.CampaignUsecase) *CampaignHandler {
+    return &CampaignHandler{CampaignUsecase: campaignUsecase}
+}
+
+func respondCampaignError(c *gin.Context, err error, message string) {
+    switch {
+    case errors.Is(err, usecase.ErrCampaignNotFound):
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+    case errors.Is(err, usecase.ErrInvalidCoupon):
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    default:
+        c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+    }
+}
+
+func (h *CampaignHandler) CreateCampaign(c *gin.Context) {
+    var input struct {
+        Name        string    `json:"name" binding:"required"`
+        Description string    `json:"description"`
+        StartDate   time.Time `json:"start_date" binding:"required"`
+        EndDate     time.Time `json:"end_date" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    campaign := models.Campaign{
+        Name:        input.Name,
+        Description: input.Description,
+        StartDate:   input.StartDate,
+        EndDate:     input.EndDate,
+        CreatedBy:   contextUserID(c),
+    }
+    if err := h.CampaignUsecase.CreateCampaign(&campaign); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusCreated, gin.H{"message": "Campaign created", "campaign": campaign})
+}
+
+func (h *CampaignHandler) GetCampaigns(c *gin.Context) {
+    campaigns, err := h.CampaignUsecase.GetCampaigns()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch campaigns"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"campaigns": campaigns})
+}
+
+func (h *CampaignHandler) GetCampaign(c *gin.Context) {
+    campaignID, ok := uintParam(c, "campaign_id", "Invalid campaign ID")
+    if !ok {
+        return
+    }
+    campaign, err := h.CampaignUsecase.GetCampaign(campaignID)
+    if err != nil {
+        respondCampaignError(c, err, "Failed to fetch campaign")
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"campaign": campaign})
+}
+
+func (h *CampaignHandler) ActivateCampaign(c *gin.Context) {
+    h.setActive(c, true)
+}
+
+// DeactivateCampaign disables every code of the campaign at once.
+func (h *CampaignHandler) DeactivateCampaign(c *gin.Context) {
+    h.setActive(c, false)
+}
+
+func (h *CampaignHandler) setActive(c *gin.Context, active bool) {
+    campaignID, ok := uintParam(c, "campaign_id", "Invalid campaign ID")
+    if !ok {
+        return
+    }
+    if err := h.CampaignUsecase.SetActive(campaignID, active); err != nil {
+        respondCampaignError(c, err, "Failed to update campaign")
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"campaign_id": campaignID, "active": active})
+}
+
+func (h *CampaignHandler) GenerateCodes(c *gin.Context) {
+    campaignID, ok := uintParam(c, "campaign_id", "Invalid campaign ID")
+    if !ok {
+        return
+    }
+    var input struct {
+        Count          int     `json:"count" binding:"required"`
+        Pattern        string  `json:"pattern"`
+        DiscountType   string  `json:"discount_type" binding:"required"`
+        DiscountAmount float64 `json:"discount_amount" binding:"required"`
+        PaymentType    string  `json:"payment_type"`
+        UsageLimit     int     `json:"usage_limit"`
+        PerUserLimit   int     `json:"per_user_limit"`
+        MinOrderAmount float64 `json:"min_order_amount"`
+        MaxDiscount    float64 `json:"max_discount"`
+        FirstRideOnly  bool    `json:"first_ride_only"`
+        CardType       string  `json:"card_type"`
+        CategoryID     *int    `json:"category_id"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    template := models.Coupon{
+        DiscountType:   input.DiscountType,
+        DiscountAmount: input.DiscountAmount,
+        PaymentType:    input.PaymentType,
+        UsageLimit:     input.UsageLimit,
+        PerUserLimit:   input.PerUserLimit,
+        MinOrderAmount: input.MinOrderAmount,
+        MaxDiscount:    input.MaxDiscount,
+        FirstRideOnly:  input.FirstRideOnly,
+        CardType:       input.CardType,
+        CategoryID:     input.CategoryID,
+    }
+    generated, err := h.CampaignUsecase.GenerateCodes(campaignID, input.Count, input.Pattern, template)
+    if err != nil {
+        respondCampaignError(c, err, "Failed to generate codes")
+        return
+    }
+    c.JSON(http.StatusCreated, gin.H{"message": "Codes generated", "campaign_id": campaignID, "generated": generated})
+}
+
+// ExportCodes returns the campaign's codes as CSV, or JSON with ?format=json.
+func (h *CampaignHandler) ExportCodes(c *gin.Context) {
+    campaignID, ok := uintParam(c, "campaign_id", "Invalid campaign ID")
+    if !ok {
+        return
+    }
+    codes, err := h.CampaignUsecase.GetCodes(campaignID)
+    if err != nil {
+        respondCampaignError(c, err, "Failed to fetch codes")
+        return
+    }
+    switch c.DefaultQuery("format", "csv") {
+    case "json":
+        c.JSON(http.StatusOK, gin.H{"codes": codes})
+    case "csv":
+        content, err := h.CampaignUsecase.RenderCodesCSV(codes)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate CSV"})
+            return
+        }
+        c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=campaign_%d_codes.csv", campaignID))
+        c.Data(http.StatusOK, "text/csv", content)
+    default:
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json or csv"})
+    }
+}
+
+func (h *CampaignHandler) GetStats(c *gin.Context) {
+    campaignID, ok := uintParam(c, "campaign_id", "Invalid campaign ID")
+    if !ok {
+        return
+    }
+    stats, err := h.CampaignUsecase.GetStats(campaignID)
+    if err != nil {
+        respondCampaignError(c, err, "Failed to fetch campaign stats")
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"stats": stats})
+}
@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorizedUserID returns the :userID path parameter, rejecting the request
+// when it does not belong to the authenticated user.
+func authorizedUserID(c *gin.Context) (uint, bool) {
+	userID, err := strconv.ParseUint(c.Param("userID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+	tokenUserID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return 0, false
+	}
+	if id, ok := tokenUserID.(float64); !ok || uint(id) != uint(userID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only act on your own account"})
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+// contextUserID returns the ID of the authenticated caller set by TokenAuthMiddleware.
+func contextUserID(c *gin.Context) uint {
+	if value, exists := c.Get("user_id"); exists {
+		if id, ok := value.(float64); ok {
+			return uint(id)
+		}
+	}
+	return 0
+}
+
+// dateRangeQuery reads the optional ?from= and ?to= dates (YYYY-MM-DD, to
+// inclusive), defaulting to the current month so far. It returns [from, to).
+func dateRangeQuery(c *gin.Context) (time.Time, time.Time, bool) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := now
+	if value := c.Query("from"); value != "" {
+		day, err := time.ParseInLocation("2006-01-02", value, now.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+			return from, to, false
+		}
+		from = day
+	}
+	if value := c.Query("to"); value != "" {
+		day, err := time.ParseInLocation("2006-01-02", value, now.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+			return from, to, false
+		}
+		to = day.AddDate(0, 0, 1)
+	}
+	return from, to, true
+}
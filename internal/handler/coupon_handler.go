@@ -1,10 +1,12 @@
 package handler
 
 import (
+    "errors"
     "net/http"
     "strconv"
     "github.com/gin-gonic/gin"
     "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
     "github.com/Prototype-1/xtrace/internal/usecase"
 )
 
@@ -23,6 +25,14 @@ func (h *CouponHandler) CreateCoupon(c *gin.Context) {
         return
     }
     if err := h.CouponUsecase.CreateCoupon(coupon); err != nil {
+        if errors.Is(err, usecase.ErrInvalidCoupon) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if errors.Is(err, repository.ErrDuplicateCouponCode) {
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+            return
+        }
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create coupon"})
         return
     }
@@ -38,6 +48,14 @@ func (h *CouponHandler) UpdateCoupon(c *gin.Context) {
     }
     coupon.CouponID = id
     if err := h.CouponUsecase.UpdateCoupon(coupon); err != nil {
+        if errors.Is(err, usecase.ErrInvalidCoupon) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if errors.Is(err, repository.ErrDuplicateCouponCode) {
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+            return
+        }
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update coupon"})
         return
     }
@@ -71,3 +89,17 @@ func (h *CouponHandler) GetAllCoupons(c *gin.Context) {
     }
     c.JSON(http.StatusOK, coupons)
 }
+
+func (h *CouponHandler) GetRedemptions(c *gin.Context) {
+    id, err := strconv.Atoi(c.Param("id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid coupon ID"})
+        return
+    }
+    redemptions, err := h.CouponUsecase.GetRedemptions(id)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch redemptions"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"redemptions": redemptions})
+}
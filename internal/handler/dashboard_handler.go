@@ -0,0 +1,50 @@
+package handler
+
+import (
+    "fmt"
+    "net/http"
+
+    "github.com/gin-gonic/gin"
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/usecase"
+)
+
+type DashboardHandler struct {
+    DashboardUsecase usecase.DashboardUsecase
+}
+
+func NewDashboardHandler(dashboardUsecase usecase.DashboardUsecase) *DashboardHandler {
+    return &DashboardHandler{DashboardUsecase: dashboardUsecase}
+}
+
+// dashboardCacheHeader lets the browser reuse a response for as long as the
+// server caches it.
+func dashboardCacheHeader(c *gin.Context) {
+    c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", int(usecase.DashboardCacheTTL.Seconds())))
+}
+
+func (h *DashboardHandler) GetSummary(c *gin.Context) {
+    summary, err := h.DashboardUsecase.GetSummary()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load dashboard summary"})
+        return
+    }
+    dashboardCacheHeader(c)
+    c.JSON(http.StatusOK, gin.H{"summary": summary})
+}
+
+// GetTimeSeries returns :metric per ?interval= (day, week or month) between
+// ?from= and ?to=.
+func (h *DashboardHandler) GetTimeSeries(c *gin.Context) {
+    from, to, ok := dateRangeQuery(c)
+    if !ok {
+        return
+    }
+    series, err := h.DashboardUsecase.GetTimeSeries(c.Param("metric"), c.DefaultQuery("interval", models.IntervalDay), from, to)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    dashboardCacheHeader(c)
+    c.JSON(http.StatusOK, gin.H{"series": series})
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/usecase"
+    "github.com/gin-gonic/gin"
+)
+
+type ExchangeRateHandler struct {
+    ExchangeRateUsecase usecase.ExchangeRateUsecase
+}
+
+func NewExchangeRateHandler(exchangeRateUsecase usecase.ExchangeRateUsecase) *ExchangeRateHandler {
+    return &ExchangeRateHandler{ExchangeRateUsecase: exchangeRateUsecase}
+}
+
+func (h *ExchangeRateHandler) CreateExchangeRate(c *gin.Context) {
+    var input struct {
+        Currency   string  `json:"currency" binding:"required"`
+        RateToBase float64 `json:"rate_to_base" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    rate, err := h.ExchangeRateUsecase.CreateExchangeRate(input.Currency, input.RateToBase)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Exchange rate created successfully", "exchange_rate": rate})
+}
+
+func (h *ExchangeRateHandler) UpdateExchangeRate(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid exchange rate ID"})
+        return
+    }
+    var input struct {
+        Currency   string  `json:"currency" binding:"required"`
+        RateToBase float64 `json:"rate_to_base" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    if err := h.ExchangeRateUsecase.UpdateExchangeRate(uint(id), input.Currency, input.RateToBase); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Exchange rate updated successfully"})
+}
+
+func (h *ExchangeRateHandler) DeleteExchangeRate(c *gin.Context) {
+    id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid exchange rate ID"})
+        return
+    }
+    if err := h.ExchangeRateUsecase.DeleteExchangeRate(uint(id)); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete exchange rate"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Exchange rate deleted successfully"})
+}
+
+func (h *ExchangeRateHandler) GetAllExchangeRates(c *gin.Context) {
+    rates, err := h.ExchangeRateUsecase.GetAllExchangeRates()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"base_currency": models.BaseCurrency, "exchange_rates": rates})
+}
+
+// QuoteAmount converts a base-currency price into the requested checkout currency.
+func (h *ExchangeRateHandler) QuoteAmount(c *gin.Context) {
+    amount, err := strconv.ParseFloat(c.Query("amount"), 64)
+    if err != nil || amount <= 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid amount"})
+        return
+    }
+    currency := strings.ToUpper(c.DefaultQuery("currency", models.BaseCurrency))
+
+    converted, rate, err := h.ExchangeRateUsecase.ConvertFromBase(amount, currency)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{
+        "base_amount":   amount,
+        "base_currency": models.BaseCurrency,
+        "amount":        converted,
+        "currency":      currency,
+        "exchange_rate": rate,
+    })
+}
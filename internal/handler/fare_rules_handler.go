@@ -1,22 +1,31 @@
 package handler
 
 import (
-	"math"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
     "fmt"
-	"github.com/Prototype-1/xtrace/config"
 	"github.com/Prototype-1/xtrace/internal/models"
+	"github.com/Prototype-1/xtrace/internal/repository"
 	"github.com/Prototype-1/xtrace/internal/usecase"
 	"github.com/gin-gonic/gin"
 )
 
 type FareRuleHandler struct {
     FareRuleUsecase usecase.FareRuleUsecase
+    ExchangeRateUsecase usecase.ExchangeRateUsecase
+    SubscriptionUsecase usecase.SubscriptionUsecase
+    nolCardRepo         repository.NolCardRepository
 }
 
-func NewFareRuleHandler(fareRuleUsecase usecase.FareRuleUsecase) *FareRuleHandler {
-    return &FareRuleHandler{FareRuleUsecase: fareRuleUsecase}
+func NewFareRuleHandler(fareRuleUsecase usecase.FareRuleUsecase, exchangeRateUsecase usecase.ExchangeRateUsecase, subscriptionUsecase usecase.SubscriptionUsecase, nolCardRepo repository.NolCardRepository) *FareRuleHandler {
+    return &FareRuleHandler{
+        FareRuleUsecase:     fareRuleUsecase,
+        ExchangeRateUsecase: exchangeRateUsecase,
+        SubscriptionUsecase: subscriptionUsecase,
+        nolCardRepo:         nolCardRepo,
+    }
 }
 
 func (h *FareRuleHandler) CreateFareRule(c *gin.Context) {
@@ -75,27 +84,6 @@ func (h *FareRuleHandler) GetAllFareRules(c *gin.Context) {
     c.JSON(http.StatusOK, fareRules)
 }
 
-//Haversine equation
-func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
-    const R = 6371 
-    lat1Rad := lat1 * math.Pi / 180
-    lon1Rad := lon1 * math.Pi / 180
-    lat2Rad := lat2 * math.Pi / 180
-    lon2Rad := lon2 * math.Pi / 180
-
-    dlat := lat2Rad - lat1Rad
-    dlon := lon2Rad - lon1Rad
-
-    a := math.Sin(dlat/2)*math.Sin(dlat/2) +
-        math.Cos(lat1Rad)*math.Cos(lat2Rad)*
-            math.Sin(dlon/2)*math.Sin(dlon/2)
-
-    c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-
-    distance := R * c
-    return distance
-}
-
 func (h *FareRuleHandler) CalculateFare(c *gin.Context) {
     routeID, _ := strconv.Atoi(c.Param("route_id"))
     startStopSeq, _ := strconv.Atoi(c.Param("start_stop_sequence"))
@@ -107,54 +95,67 @@ func (h *FareRuleHandler) CalculateFare(c *gin.Context) {
         return
     }
 
-    fareRule, err := h.FareRuleUsecase.GetFareRuleByRouteID(routeID)
+    totalFare, err := h.FareRuleUsecase.CalculateFare(routeID, startStopSeq, endStopSeq, cardType)
     if err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Fare rule not found"})
+        switch {
+        case errors.Is(err, usecase.ErrFareRuleNotFound):
+            c.JSON(http.StatusNotFound, gin.H{"error": "Fare rule not found"})
+        case errors.Is(err, usecase.ErrStopNotFound):
+            c.JSON(http.StatusNotFound, gin.H{"error": "Start or end stop not found"})
+        case errors.Is(err, usecase.ErrInvalidCardType):
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid card type"})
+        default:
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate fare"})
+        }
         return
     }
 
-    // Get the latitude and longitude of the start and end stops
-    var startStop, endStop models.Stop
-    if err := config.DB.Where("stop_id = ?", startStopSeq).First(&startStop).Error; err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Start stop not found"})
-        return
+    // With the caller's nol_card_id, a journey covered by their subscription is quoted at zero.
+    response := gin.H{}
+    if value := c.Query("nol_card_id"); value != "" {
+        nolCardID, err := strconv.Atoi(value)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+            return
+        }
+        nolCard, err := h.nolCardRepo.GetNolCardByID(nolCardID)
+        if err != nil || uint(nolCard.UserID) != contextUserID(c) {
+            c.JSON(http.StatusNotFound, gin.H{"error": "Nol Card not found"})
+            return
+        }
+        subscription, err := h.SubscriptionUsecase.FindCoveringSubscriptionBySequence(uint(nolCardID), routeID, startStopSeq, endStopSeq)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check subscription coverage"})
+            return
+        }
+        if subscription != nil {
+            response["full_fare"] = totalFare
+            response["subscription_id"] = subscription.SubscriptionID
+            if subscription.RideAllowance > 0 {
+                response["rides_remaining"] = subscription.RideAllowance - subscription.RidesUsed
+            }
+            totalFare = 0
+        }
     }
-    if err := config.DB.Where("stop_id = ?", endStopSeq).First(&endStop).Error; err != nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "End stop not found"})
+
+    currency := strings.ToUpper(c.DefaultQuery("currency", models.BaseCurrency))
+    if currency == models.BaseCurrency {
+        response["total_fare"] = totalFare
+        response["currency"] = currency
+        c.JSON(http.StatusOK, response)
         return
     }
-    traveledKm := Haversine(float64(startStop.Latitude), float64(startStop.Longitude),
-        float64(endStop.Latitude), float64(endStop.Longitude))
-
-    numberOfStops := int(math.Abs(float64(endStopSeq - startStopSeq)))
-    additionalStops := numberOfStops - fareRule.BaseStops
-
-    var baseFare float64
-    switch cardType {
-    case "Ordinary":
-        baseFare = fareRule.OrdinaryFare
-    case "Silver":
-        baseFare = fareRule.SilverFare
-    case "Gold":
-        baseFare = fareRule.GoldFare
-    default:
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid card type"})
+    convertedFare, rate, err := h.ExchangeRateUsecase.ConvertFromBase(totalFare, currency)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
-
-    totalFare := baseFare
-
-    additionalKm := traveledKm - fareRule.BaseKm
-    if additionalKm > 0 {
-        totalFare += additionalKm * fareRule.FarePerKm
-    }
-    if additionalStops > 0 {
-        totalFare += float64(additionalStops) * fareRule.FarePerStop
-    }
-    if totalFare < fareRule.OrdinaryFare {
-        totalFare = fareRule.OrdinaryFare
-    }
-    c.JSON(http.StatusOK, gin.H{"total_fare": totalFare})
+    response["total_fare"] = convertedFare
+    response["currency"] = currency
+    response["base_fare"] = totalFare
+    response["base_currency"] = models.BaseCurrency
+    response["exchange_rate"] = rate
+    c.JSON(http.StatusOK, response)
 }
 
 func (h *FareRuleHandler) CalculateTravelTimes(c *gin.Context) {
@@ -1,112 +1,197 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
-	"github.com/gin-gonic/gin" 
+	"strings"
+
+	"github.com/gin-gonic/gin"
 	"github.com/Prototype-1/xtrace/internal/models"
 	"github.com/Prototype-1/xtrace/internal/repository"
-	"github.com/Prototype-1/xtrace/pkg/utils"
-	 "github.com/jung-kurt/gofpdf"
+	"github.com/Prototype-1/xtrace/internal/usecase"
 )
 
 type InvoiceHandler struct {
-	UserRepository    repository.UserRepository
-	InvoiceRepository repository.InvoiceRepository
+	InvoiceUsecase usecase.InvoiceUsecase
 }
 
-func NewInvoiceHandler(userRepo repository.UserRepository, invoiceRepo repository.InvoiceRepository) *InvoiceHandler {
+func NewInvoiceHandler(invoiceUsecase usecase.InvoiceUsecase) *InvoiceHandler {
 	return &InvoiceHandler{
-		UserRepository:    userRepo,
-		InvoiceRepository: invoiceRepo,
+		InvoiceUsecase: invoiceUsecase,
 	}
 }
 
-func (h *InvoiceHandler) GenerateInvoicePDF(invoice *models.Invoice) (string, error) {
-    pdf := gofpdf.New("P", "mm", "A4", "")
-    pdf.AddPage()
-    pdf.SetFont("Arial", "B", 16)
-    
-    pdf.Cell(40, 10, "Invoice Details")
-    pdf.Ln(12) 
-
-    pdf.SetFont("Arial", "", 12)
-    pdf.Cell(40, 10, fmt.Sprintf("Invoice ID: %d", invoice.InvoiceID))
-    pdf.Ln(8)
-    pdf.Cell(40, 10, fmt.Sprintf("Original Amount: %.2f", invoice.OriginalAmount))
-    pdf.Ln(8)
-    pdf.Cell(40, 10, fmt.Sprintf("Discount: %.2f", invoice.DiscountAmount))
-    pdf.Ln(8)
-    pdf.Cell(40, 10, fmt.Sprintf("Amount Due: %.2f", invoice.Amount))
-    pdf.Ln(8)
-    pdf.Cell(40, 10, fmt.Sprint("Payment Type: ", invoice.PaymentType))
-    pdf.Ln(8)
-    pdf.Cell(40, 10, fmt.Sprintf("Status: %s", invoice.Status))
-
-    // Save the PDF to a temporary file
-    fileName := fmt.Sprintf("invoice_%d.pdf", invoice.InvoiceID)
-    err := pdf.OutputFileAndClose(fileName)
-    if err != nil {
-        return "", err
-    }
+func respondInvoiceError(c *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, usecase.ErrInvoiceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, repository.ErrCreditExceedsInvoice):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
 
-    return fileName, nil
+// GetInvoices lists the user's invoices and credit notes, newest first.
+// Paginated with ?page= and ?page_size=.
+func (h *InvoiceHandler) GetInvoices(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", strconv.Itoa(usecase.DefaultInvoicePageSize)))
+	if err != nil || pageSize < 1 || pageSize > usecase.MaxInvoicePageSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("page_size must be between 1 and %d", usecase.MaxInvoicePageSize)})
+		return
+	}
+	invoices, total, err := h.InvoiceUsecase.GetUserInvoices(userID, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invoices": invoices, "page": page, "page_size": pageSize, "total": total})
 }
 
-func (h *InvoiceHandler) GetUserEmail(c *gin.Context) {
-	userID, err := strconv.Atoi(c.Query("userID")) 
+func (h *InvoiceHandler) GetInvoice(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+	invoiceID, ok := uintParam(c, "invoice_id", "Invalid invoice ID")
+	if !ok {
+		return
+	}
+	invoice, err := h.InvoiceUsecase.GetUserInvoice(userID, invoiceID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		respondInvoiceError(c, err, "Failed to fetch invoice")
 		return
 	}
+	c.JSON(http.StatusOK, gin.H{"invoice": invoice})
+}
 
-	user, err := h.UserRepository.GetUserByID(uint(userID))
+// DownloadInvoice returns the invoice or credit note as a PDF.
+func (h *InvoiceHandler) DownloadInvoice(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+	invoiceID, ok := uintParam(c, "invoice_id", "Invalid invoice ID")
+	if !ok {
+		return
+	}
+	invoice, err := h.InvoiceUsecase.GetUserInvoice(userID, invoiceID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user email"})
+		respondInvoiceError(c, err, "Failed to fetch invoice")
 		return
 	}
-	if user == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	content, err := h.InvoiceUsecase.RenderPDF(invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate PDF"})
 		return
 	}
-
-	c.JSON(http.StatusOK, gin.H{"email": user.Email}) 
+	fileName := fmt.Sprintf("invoice_%d.pdf", invoice.InvoiceID)
+	if invoice.InvoiceNumber != nil {
+		fileName = strings.ReplaceAll(*invoice.InvoiceNumber, "/", "-") + ".pdf"
+	}
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Data(http.StatusOK, "application/pdf", content)
 }
 
-func (h *InvoiceHandler) SendInvoice(c *gin.Context) {
-    var req struct {
-        UserID uint   `json:"userID"`
-        Email  string `json:"email"`
-    }
-
-    if err := c.ShouldBindJSON(&req); err != nil { 
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
-        return
-    }
-
-    invoice, err := h.InvoiceRepository.GetInvoiceByUserID(req.UserID)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoice"})
-        return
-    }
-    if invoice == nil {
-        c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
-        return
-    }
+// ResendInvoice emails the invoice to the account's registered address.
+func (h *InvoiceHandler) ResendInvoice(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+	invoiceID, ok := uintParam(c, "invoice_id", "Invalid invoice ID")
+	if !ok {
+		return
+	}
+	if err := h.InvoiceUsecase.ResendInvoice(userID, invoiceID); err != nil {
+		respondInvoiceError(c, err, "Failed to send invoice email")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Invoice sent successfully!"})
+}
 
-    pdfFile, err := h.GenerateInvoicePDF(invoice)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate PDF"})
-        return
-    }
+// Admin endpoints.
 
-    if err = utils.SendEmailWithAttachment(req.Email, "Your Invoice", "Please find the attached invoice.", pdfFile); err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send invoice email"})
-        return
-    }
+// IssueCreditNote records a refund made outside the app against an invoice.
+func (h *InvoiceHandler) IssueCreditNote(c *gin.Context) {
+	invoiceID, ok := uintParam(c, "invoice_id", "Invalid invoice ID")
+	if !ok {
+		return
+	}
+	var input struct {
+		Amount float64 `json:"amount" binding:"required"`
+		Reason string  `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	note, err := h.InvoiceUsecase.IssueCreditNote(invoiceID, input.Amount, input.Reason)
+	switch {
+	case errors.Is(err, usecase.ErrInvoiceNotFound), errors.Is(err, repository.ErrCreditExceedsInvoice):
+		respondInvoiceError(c, err, "Failed to issue credit note")
+		return
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Credit note issued", "credit_note": note})
+}
 
-    c.JSON(http.StatusOK, gin.H{"message": "Invoice sent successfully!"})
+// GetTaxSummary totals GST on finalised invoices less credit notes for
+// ?from= and ?to=.
+func (h *InvoiceHandler) GetTaxSummary(c *gin.Context) {
+	from, to, ok := dateRangeQuery(c)
+	if !ok {
+		return
+	}
+	summary, err := h.InvoiceUsecase.GetTaxSummary(from, to)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"summary": summary})
 }
 
+func (h *InvoiceHandler) GetTaxRules(c *gin.Context) {
+	rules, err := h.InvoiceUsecase.GetTaxRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tax rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"tax_rules": rules})
+}
 
+func (h *InvoiceHandler) SetTaxRule(c *gin.Context) {
+	var input struct {
+		Description string  `json:"description"`
+		HSNSAC      string  `json:"hsn_sac"`
+		GSTRate     float64 `json:"gst_rate"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule := models.TaxRule{
+		ItemType:    c.Param("item_type"),
+		Description: input.Description,
+		HSNSAC:      input.HSNSAC,
+		GSTRate:     input.GSTRate,
+	}
+	if err := h.InvoiceUsecase.SetTaxRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Tax rule updated", "tax_rule": rule})
+}
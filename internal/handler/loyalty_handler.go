@@ -0,0 +1,104 @@
+package handler
+
+import (
+    "net/http"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/usecase"
+    "github.com/gin-gonic/gin"
+)
+
+type LoyaltyHandler struct {
+    LoyaltyUsecase usecase.LoyaltyUsecase
+}
+
+func NewLoyaltyHandler(loyaltyUsecase usecase.LoyaltyUsecase) *LoyaltyHandler {
+    return &LoyaltyHandler{LoyaltyUsecase: loyaltyUsecase}
+}
+
+func (h *LoyaltyHandler) GetBalance(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    balance, err := h.LoyaltyUsecase.GetBalance(userID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty points"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"balance": balance})
+}
+
+func (h *LoyaltyHandler) GetHistory(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    balance, err := h.LoyaltyUsecase.GetBalance(userID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty points"})
+        return
+    }
+    history, err := h.LoyaltyUsecase.GetHistory(userID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch points history"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"balance": balance, "history": history})
+}
+
+// Admin endpoints.
+
+func (h *LoyaltyHandler) GetProgramme(c *gin.Context) {
+    programme, err := h.LoyaltyUsecase.GetProgramme()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch loyalty programme"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"programme": programme})
+}
+
+func (h *LoyaltyHandler) UpdateProgramme(c *gin.Context) {
+    var programme models.LoyaltyProgramme
+    if err := c.ShouldBindJSON(&programme); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if err := h.LoyaltyUsecase.UpdateProgramme(&programme); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Loyalty programme updated", "programme": programme})
+}
+
+func (h *LoyaltyHandler) GetEarnRates(c *gin.Context) {
+    rates, err := h.LoyaltyUsecase.GetEarnRates()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch earn rates"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"earn_rates": rates})
+}
+
+func (h *LoyaltyHandler) SetEarnRate(c *gin.Context) {
+    var input struct {
+        BookingRate      float64 `json:"booking_rate"`
+        SubscriptionRate float64 `json:"subscription_rate"`
+        JourneyRate      float64 `json:"journey_rate"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    rate := models.LoyaltyEarnRate{
+        CardType:         c.Param("card_type"),
+        BookingRate:      input.BookingRate,
+        SubscriptionRate: input.SubscriptionRate,
+        JourneyRate:      input.JourneyRate,
+    }
+    if err := h.LoyaltyUsecase.SetEarnRate(&rate); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Earn rate updated", "earn_rate": rate})
+}
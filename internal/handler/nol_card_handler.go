@@ -1,12 +1,15 @@
 package handler
 
 import (
+    "errors"
     "net/http"
     "strconv"
     "fmt"
     "github.com/gin-gonic/gin"
     "github.com/Prototype-1/xtrace/internal/models" 
+    "github.com/Prototype-1/xtrace/internal/repository"
     "github.com/Prototype-1/xtrace/internal/usecase"
+    "gorm.io/gorm"
 )
 
 type NolCardHandler struct {
@@ -29,15 +32,27 @@ func (h *NolCardHandler) GetNolCardDetails(c *gin.Context) {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Nol Card details"})
         return
     }
+    if !nolCard.IsUsable() {
+        c.JSON(http.StatusOK, gin.H{
+            "nol_card_id": nolCard.NolCardID,
+            "card_number": nolCard.CardNumber,
+            "balance":     nolCard.Balance,
+            "card_type":   nolCard.CardType,
+            "status":      nolCard.Status,
+            "message":     fmt.Sprintf("This card is %s and cannot be used for travel or payments.", nolCard.Status),
+        })
+        return
+    }
     var message string
-    switch nolCard.CardType {
-    case "gold":
+    cardType, _ := models.NormalizeCardType(nolCard.CardType)
+    switch cardType {
+    case models.CardTypeGold:
         if nolCard.Balance < 50 {
             message = "Insufficient balance in your Gold Pass card. Please top up."
         } else {
             message = "There is sufficient balance in your card, Happy Journey."
         }
-    case "silver":
+    case models.CardTypeSilver:
         if nolCard.Balance < 30 {
             message = "Insufficient balance in your Silver Pass card. Please top up."
         } else {
@@ -56,6 +71,7 @@ func (h *NolCardHandler) GetNolCardDetails(c *gin.Context) {
         "card_number": nolCard.CardNumber,
         "balance":     nolCard.Balance,
         "card_type":   nolCard.CardType,
+        "status":      nolCard.Status,
         "message":     message,
     })
 }
@@ -72,13 +88,191 @@ func (h *NolCardHandler) AddNolCard(c *gin.Context) {
         return
     }
 
-    err := h.NolCardUsecase.AddNolCard(nolCard)
+    created, err := h.NolCardUsecase.AddNolCard(nolCard)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Nol card added successfully", "nol_card": created})
+}
+
+
+func (h *NolCardHandler) GetUserNolCards(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+
+    nolCards, err := h.NolCardUsecase.GetNolCardsByUserID(int(userID))
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Nol Cards"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"nol_cards": nolCards})
+}
+
+func (h *NolCardHandler) ReportLost(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    nolCardID, err := strconv.Atoi(c.Param("nol_card_id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+        return
+    }
+
+    var input struct {
+        Reason string `json:"reason"`
+    }
+    _ = c.ShouldBindJSON(&input)
+
+    if err := h.NolCardUsecase.ReportLost(userID, nolCardID, input.Reason); err != nil {
+        respondNolCardStatusError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Nol Card hotlisted. Contact support to get a replacement card with your balance."})
+}
+
+// UpdateNolCardStatus handles the admin block, unblock, activate and hotlist actions.
+func (h *NolCardHandler) UpdateNolCardStatus(c *gin.Context) {
+    nolCardID, err := strconv.Atoi(c.Param("nol_card_id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+        return
+    }
+
+    var input struct {
+        Action string `json:"action" binding:"required"`
+        Reason string `json:"reason"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    adminID := contextUserID(c)
+    switch input.Action {
+    case "activate":
+        err = h.NolCardUsecase.ActivateNolCard(adminID, nolCardID)
+    case "block":
+        err = h.NolCardUsecase.BlockNolCard(adminID, nolCardID, input.Reason)
+    case "unblock":
+        err = h.NolCardUsecase.UnblockNolCard(adminID, nolCardID)
+    case "hotlist":
+        err = h.NolCardUsecase.HotlistNolCard(adminID, nolCardID, input.Reason)
+    default:
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Action must be activate, block, unblock or hotlist"})
+        return
+    }
+    if err != nil {
+        respondNolCardStatusError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Nol Card status updated successfully"})
+}
+
+func (h *NolCardHandler) ReplaceNolCard(c *gin.Context) {
+    nolCardID, err := strconv.Atoi(c.Param("nol_card_id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+        return
+    }
+
+    var input struct {
+        Reason string `json:"reason"`
+    }
+    _ = c.ShouldBindJSON(&input)
+
+    newCard, err := h.NolCardUsecase.ReplaceNolCard(contextUserID(c), nolCardID, input.Reason)
+    if err != nil {
+        respondNolCardStatusError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Nol Card replaced successfully", "nol_card": newCard})
+}
+
+func (h *NolCardHandler) GetNolCardHistory(c *gin.Context) {
+    nolCardID, err := strconv.Atoi(c.Param("nol_card_id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+        return
+    }
+
+    events, err := h.NolCardUsecase.GetNolCardHistory(nolCardID)
     if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get Nol Card history"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+func (h *NolCardHandler) GetCardNumberSeries(c *gin.Context) {
+    series, err := h.NolCardUsecase.GetCardNumberSeries()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get card number prefixes"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"series": series})
+}
+
+func (h *NolCardHandler) SetCardNumberPrefix(c *gin.Context) {
+    var input struct {
+        CardType string `json:"card_type" binding:"required"`
+        Prefix   string `json:"prefix" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
 
-    c.JSON(http.StatusOK, gin.H{"message": "Nol card added successfully"})
+    if err := h.NolCardUsecase.SetCardNumberPrefix(input.CardType, input.Prefix); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Card number prefix updated successfully"})
 }
 
+// GetInvalidCardNumbers lists existing cards whose numbers fail the checksum.
+func (h *NolCardHandler) GetInvalidCardNumbers(c *gin.Context) {
+    nolCards, err := h.NolCardUsecase.ReportInvalidCardNumbers()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check card numbers"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"count": len(nolCards), "nol_cards": nolCards})
+}
+
+// ReissueCardNumber gives one card from the invalid-numbers report a valid
+// generated number.
+func (h *NolCardHandler) ReissueCardNumber(c *gin.Context) {
+    nolCardID, err := strconv.Atoi(c.Param("nol_card_id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+        return
+    }
+
+    var input struct {
+        Reason string `json:"reason"`
+    }
+    _ = c.ShouldBindJSON(&input)
 
+    nolCard, err := h.NolCardUsecase.ReissueCardNumber(contextUserID(c), nolCardID, input.Reason)
+    if err != nil {
+        respondNolCardStatusError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Nol Card number reissued successfully", "nol_card": nolCard})
+}
+
+func respondNolCardStatusError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, gorm.ErrRecordNotFound):
+        c.JSON(http.StatusNotFound, gin.H{"error": "Nol Card not found"})
+    case errors.Is(err, repository.ErrInvalidNolCardTransition):
+        c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+    default:
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    }
+}
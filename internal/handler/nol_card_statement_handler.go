@@ -0,0 +1,167 @@
+package handler
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "github.com/Prototype-1/xtrace/internal/usecase"
+    "github.com/gin-gonic/gin"
+)
+
+type NolCardStatementHandler struct {
+    StatementUsecase usecase.NolCardStatementUsecase
+}
+
+func NewNolCardStatementHandler(statementUsecase usecase.NolCardStatementUsecase) *NolCardStatementHandler {
+    return &NolCardStatementHandler{StatementUsecase: statementUsecase}
+}
+
+// ChargeJourney records a tap-off reported by a gate or validator, which
+// authenticates as an admin. The card is charged whoever it belongs to.
+func (h *NolCardStatementHandler) ChargeJourney(c *gin.Context) {
+    nolCardID, err := strconv.Atoi(c.Param("nol_card_id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+        return
+    }
+
+    var input struct {
+        RouteID    int `json:"route_id" binding:"required"`
+        FromStopID int `json:"from_stop_id" binding:"required"`
+        ToStopID   int `json:"to_stop_id" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    journey, err := h.StatementUsecase.ChargeJourney(0, nolCardID, input.RouteID, input.FromStopID, input.ToStopID)
+    if err != nil {
+        switch {
+        case errors.Is(err, usecase.ErrNolCardNotFound):
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        case errors.Is(err, usecase.ErrFareRuleNotFound), errors.Is(err, usecase.ErrStopNotFound):
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        case errors.Is(err, repository.ErrNolCardNotUsable):
+            c.JSON(http.StatusForbidden, gin.H{"error": "This NolCard cannot be used for travel"})
+        case errors.Is(err, repository.ErrInsufficientBalance):
+            c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient NolCard balance for this journey"})
+        default:
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to charge journey"})
+        }
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Journey charged successfully", "journey": journey})
+}
+
+func (h *NolCardStatementHandler) RefundToCard(c *gin.Context) {
+    nolCardID, err := strconv.Atoi(c.Param("nol_card_id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+        return
+    }
+
+    var input struct {
+        JourneyID *uint   `json:"journey_id"`
+        Amount    float64 `json:"amount"`
+        Reason    string  `json:"reason" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    refund, err := h.StatementUsecase.RefundToCard(contextUserID(c), nolCardID, input.JourneyID, input.Amount, input.Reason)
+    if err != nil {
+        switch {
+        case errors.Is(err, usecase.ErrNolCardNotFound):
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        case errors.Is(err, repository.ErrNolCardNotUsable):
+            c.JSON(http.StatusConflict, gin.H{"error": "This NolCard cannot be credited in its current state"})
+        default:
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        }
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Refund credited to NolCard", "refund": refund})
+}
+
+// GetUserStatement serves the caller's own card statement.
+func (h *NolCardStatementHandler) GetUserStatement(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    h.writeStatement(c, userID)
+}
+
+// GetStatement serves any card's statement to an admin.
+func (h *NolCardStatementHandler) GetStatement(c *gin.Context) {
+    h.writeStatement(c, 0)
+}
+
+// writeStatement reads the from/to dates (YYYY-MM-DD, inclusive; defaulting to
+// the current month) and responds with JSON, CSV or PDF per the format query.
+func (h *NolCardStatementHandler) writeStatement(c *gin.Context, userID uint) {
+    nolCardID, err := strconv.Atoi(c.Param("nol_card_id"))
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+        return
+    }
+
+    now := time.Now()
+    from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+    to := now
+    if value := c.Query("from"); value != "" {
+        if from, err = time.ParseInLocation("2006-01-02", value, now.Location()); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+            return
+        }
+    }
+    if value := c.Query("to"); value != "" {
+        day, err := time.ParseInLocation("2006-01-02", value, now.Location())
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+            return
+        }
+        to = day.AddDate(0, 0, 1)
+    }
+
+    statement, err := h.StatementUsecase.GetStatement(userID, nolCardID, from, to)
+    if err != nil {
+        if errors.Is(err, usecase.ErrNolCardNotFound) {
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    fileName := fmt.Sprintf("statement_%s_%s_%s", statement.CardNumber, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+    switch c.DefaultQuery("format", "json") {
+    case "json":
+        c.JSON(http.StatusOK, statement)
+    case "csv":
+        content, err := h.StatementUsecase.RenderCSV(statement)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate CSV"})
+            return
+        }
+        c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", fileName))
+        c.Data(http.StatusOK, "text/csv", content)
+    case "pdf":
+        content, err := h.StatementUsecase.RenderPDF(statement)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate PDF"})
+            return
+        }
+        c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.pdf", fileName))
+        c.Data(http.StatusOK, "application/pdf", content)
+    default:
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json, csv or pdf"})
+    }
+}
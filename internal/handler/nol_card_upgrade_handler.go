@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Prototype-1/xtrace/internal/models"
+	"github.com/Prototype-1/xtrace/internal/usecase"
+	"github.com/gin-gonic/gin"
+)
+
+type NolCardUpgradeHandler struct {
+	NolCardUpgradeUsecase usecase.NolCardUpgradeUsecase
+}
+
+func NewNolCardUpgradeHandler(nolCardUpgradeUsecase usecase.NolCardUpgradeUsecase) *NolCardUpgradeHandler {
+	return &NolCardUpgradeHandler{NolCardUpgradeUsecase: nolCardUpgradeUsecase}
+}
+
+func (h *NolCardUpgradeHandler) CreateUpgradeRule(c *gin.Context) {
+	var rule models.NolCardUpgradeRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	rule.Enabled = true
+	if err := h.NolCardUpgradeUsecase.CreateRule(&rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Upgrade rule created successfully", "rule": rule})
+}
+
+func (h *NolCardUpgradeHandler) UpdateUpgradeRule(c *gin.Context) {
+	ruleID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+	var input struct {
+		Price           float64 `json:"price"`
+		MinSpend        float64 `json:"min_spend"`
+		SpendWindowDays int     `json:"spend_window_days"`
+		MinCardAgeDays  int     `json:"min_card_age_days"`
+		Enabled         *bool   `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	enabled := true
+	if input.Enabled != nil {
+		enabled = *input.Enabled
+	}
+	rule := &models.NolCardUpgradeRule{
+		UpgradeRuleID:   uint(ruleID),
+		Price:           input.Price,
+		MinSpend:        input.MinSpend,
+		SpendWindowDays: input.SpendWindowDays,
+		MinCardAgeDays:  input.MinCardAgeDays,
+		Enabled:         enabled,
+	}
+	if err := h.NolCardUpgradeUsecase.UpdateRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Upgrade rule updated successfully"})
+}
+
+func (h *NolCardUpgradeHandler) DeleteUpgradeRule(c *gin.Context) {
+	ruleID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+	if err := h.NolCardUpgradeUsecase.DeleteRule(uint(ruleID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete upgrade rule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Upgrade rule deleted successfully"})
+}
+
+func (h *NolCardUpgradeHandler) GetAllUpgradeRules(c *gin.Context) {
+	rules, err := h.NolCardUpgradeUsecase.GetAllRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get upgrade rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+func (h *NolCardUpgradeHandler) GetUpgradeOptions(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+	nolCardID, err := strconv.Atoi(c.Param("nol_card_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+		return
+	}
+
+	options, err := h.NolCardUpgradeUsecase.GetUpgradeOptions(userID, nolCardID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"options": options})
+}
+
+func (h *NolCardUpgradeHandler) RequestUpgrade(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+	nolCardID, err := strconv.Atoi(c.Param("nol_card_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Nol Card ID"})
+		return
+	}
+
+	var input struct {
+		ToCardType string `json:"to_card_type" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upgrade, err := h.NolCardUpgradeUsecase.RequestUpgrade(userID, nolCardID, input.ToCardType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Upgrade requested. Complete it by creating a payment with payment_type card_upgrade for this card.",
+		"upgrade": upgrade,
+	})
+}
+
+func (h *NolCardUpgradeHandler) GetUserUpgrades(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+
+	upgrades, err := h.NolCardUpgradeUsecase.GetUpgradesByUserID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get upgrades"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"upgrades": upgrades})
+}
@@ -0,0 +1,445 @@
+package handler
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "strconv"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "github.com/Prototype-1/xtrace/internal/usecase"
+    "github.com/gin-gonic/gin"
+)
+
+type OrganisationHandler struct {
+    OrganisationUsecase usecase.OrganisationUsecase
+}
+
+func NewOrganisationHandler(organisationUsecase usecase.OrganisationUsecase) *OrganisationHandler {
+    return &OrganisationHandler{OrganisationUsecase: organisationUsecase}
+}
+
+func uintParam(c *gin.Context, name, message string) (uint, bool) {
+    value, err := strconv.ParseUint(c.Param(name), 10, 32)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": message})
+        return 0, false
+    }
+    return uint(value), true
+}
+
+func respondOrganisationError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, usecase.ErrOrganisationNotFound), errors.Is(err, usecase.ErrNolCardNotFound):
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+    case errors.Is(err, usecase.ErrNotOrganisationAdmin), errors.Is(err, usecase.ErrEmailNotVerified):
+        c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+    case errors.Is(err, usecase.ErrNotOrganisationMember):
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+    case errors.Is(err, repository.ErrOrganisationFundsExhausted):
+        c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+    case errors.Is(err, repository.ErrNolCardNotUsable):
+        c.JSON(http.StatusForbidden, gin.H{"error": "This NolCard cannot be used"})
+    default:
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    }
+}
+
+// Platform admin endpoints.
+
+func (h *OrganisationHandler) CreateOrganisation(c *gin.Context) {
+    var input struct {
+        Name         string  `json:"name" binding:"required"`
+        EmailDomain  string  `json:"email_domain"`
+        BillingMode  string  `json:"billing_mode"`
+        CreditLimit  float64 `json:"credit_limit"`
+        BillingEmail string  `json:"billing_email"`
+        AdminUserID  uint    `json:"admin_user_id" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    organisation := &models.Organisation{
+        Name:         input.Name,
+        EmailDomain:  input.EmailDomain,
+        BillingMode:  input.BillingMode,
+        CreditLimit:  input.CreditLimit,
+        BillingEmail: input.BillingEmail,
+    }
+    if err := h.OrganisationUsecase.CreateOrganisation(organisation, input.AdminUserID); err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusCreated, gin.H{"message": "Organisation created successfully", "organisation": organisation})
+}
+
+func (h *OrganisationHandler) GetOrganisations(c *gin.Context) {
+    organisations, err := h.OrganisationUsecase.GetOrganisations()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch organisations"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"organisations": organisations})
+}
+
+func (h *OrganisationHandler) GetOrganisation(c *gin.Context) {
+    organisationID, ok := uintParam(c, "organisation_id", "Invalid organisation ID")
+    if !ok {
+        return
+    }
+    organisation, err := h.OrganisationUsecase.GetOrganisation(organisationID)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"organisation": organisation})
+}
+
+func (h *OrganisationHandler) UpdateBilling(c *gin.Context) {
+    organisationID, ok := uintParam(c, "organisation_id", "Invalid organisation ID")
+    if !ok {
+        return
+    }
+    var input struct {
+        BillingMode  string  `json:"billing_mode" binding:"required"`
+        CreditLimit  float64 `json:"credit_limit"`
+        BillingEmail string  `json:"billing_email"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if err := h.OrganisationUsecase.UpdateBilling(organisationID, input.BillingMode, input.CreditLimit, input.BillingEmail); err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Billing settings updated successfully"})
+}
+
+func (h *OrganisationHandler) UpdateTaxDetails(c *gin.Context) {
+    organisationID, ok := uintParam(c, "organisation_id", "Invalid organisation ID")
+    if !ok {
+        return
+    }
+    var input struct {
+        GSTIN          string `json:"gstin"`
+        BillingAddress string `json:"billing_address"`
+        StateCode      string `json:"state_code"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if err := h.OrganisationUsecase.UpdateTaxDetails(organisationID, input.GSTIN, input.BillingAddress, input.StateCode); err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Tax details updated successfully"})
+}
+
+func (h *OrganisationHandler) FundOrganisation(c *gin.Context) {
+    organisationID, ok := uintParam(c, "organisation_id", "Invalid organisation ID")
+    if !ok {
+        return
+    }
+    var input struct {
+        Amount float64 `json:"amount" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    organisation, err := h.OrganisationUsecase.FundOrganisation(organisationID, input.Amount)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Organisation balance funded", "balance": organisation.Balance})
+}
+
+func (h *OrganisationHandler) SettleInvoice(c *gin.Context) {
+    organisationID, ok := uintParam(c, "organisation_id", "Invalid organisation ID")
+    if !ok {
+        return
+    }
+    invoiceID, ok := uintParam(c, "invoice_id", "Invalid invoice ID")
+    if !ok {
+        return
+    }
+    if err := h.OrganisationUsecase.SettleInvoice(organisationID, invoiceID); err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Invoice settled"})
+}
+
+// Member endpoints.
+
+func (h *OrganisationHandler) GetMemberships(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    memberships, err := h.OrganisationUsecase.GetMemberships(userID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch organisations"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"memberships": memberships})
+}
+
+func (h *OrganisationHandler) AcceptInvite(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    var input struct {
+        Token string `json:"token" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    member, err := h.OrganisationUsecase.AcceptInvite(userID, input.Token)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Joined organisation", "membership": member})
+}
+
+func (h *OrganisationHandler) JoinByDomain(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    member, err := h.OrganisationUsecase.JoinByDomain(userID)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Joined organisation", "membership": member})
+}
+
+// organisationAdminParams reads :userID and :organisation_id for the routes an
+// organisation admin uses.
+func organisationAdminParams(c *gin.Context) (uint, uint, bool) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return 0, 0, false
+    }
+    organisationID, ok := uintParam(c, "organisation_id", "Invalid organisation ID")
+    if !ok {
+        return 0, 0, false
+    }
+    return userID, organisationID, true
+}
+
+func (h *OrganisationHandler) GetOwnOrganisation(c *gin.Context) {
+    userID, organisationID, ok := organisationAdminParams(c)
+    if !ok {
+        return
+    }
+    organisation, err := h.OrganisationUsecase.GetOrganisationForAdmin(userID, organisationID)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"organisation": organisation})
+}
+
+func (h *OrganisationHandler) GetMembers(c *gin.Context) {
+    userID, organisationID, ok := organisationAdminParams(c)
+    if !ok {
+        return
+    }
+    members, err := h.OrganisationUsecase.GetMembers(userID, organisationID)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+func (h *OrganisationHandler) RemoveMember(c *gin.Context) {
+    userID, organisationID, ok := organisationAdminParams(c)
+    if !ok {
+        return
+    }
+    memberUserID, ok := uintParam(c, "member_user_id", "Invalid member ID")
+    if !ok {
+        return
+    }
+    if err := h.OrganisationUsecase.RemoveMember(userID, organisationID, memberUserID); err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Member removed"})
+}
+
+func (h *OrganisationHandler) InviteMember(c *gin.Context) {
+    userID, organisationID, ok := organisationAdminParams(c)
+    if !ok {
+        return
+    }
+    var input struct {
+        Email string `json:"email" binding:"required"`
+        Role  string `json:"role"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    invite, err := h.OrganisationUsecase.InviteMember(userID, organisationID, input.Email, input.Role)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusCreated, gin.H{"message": "Invite sent", "invite": invite})
+}
+
+func (h *OrganisationHandler) GetInvites(c *gin.Context) {
+    userID, organisationID, ok := organisationAdminParams(c)
+    if !ok {
+        return
+    }
+    invites, err := h.OrganisationUsecase.GetInvites(userID, organisationID)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+func (h *OrganisationHandler) FundMemberSubscription(c *gin.Context) {
+    userID, organisationID, ok := organisationAdminParams(c)
+    if !ok {
+        return
+    }
+    memberUserID, ok := uintParam(c, "member_user_id", "Invalid member ID")
+    if !ok {
+        return
+    }
+    var input struct {
+        PlanID    uint `json:"plan_id" binding:"required"`
+        NolCardID int  `json:"nol_card_id" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    subscription, err := h.OrganisationUsecase.FundMemberSubscription(userID, organisationID, memberUserID, input.PlanID, input.NolCardID)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusCreated, gin.H{"message": "Subscription purchased for member", "subscription": subscription})
+}
+
+func (h *OrganisationHandler) FundMemberTopup(c *gin.Context) {
+    userID, organisationID, ok := organisationAdminParams(c)
+    if !ok {
+        return
+    }
+    memberUserID, ok := uintParam(c, "member_user_id", "Invalid member ID")
+    if !ok {
+        return
+    }
+    var input struct {
+        NolCardID int     `json:"nol_card_id" binding:"required"`
+        Amount    float64 `json:"amount" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    topup, err := h.OrganisationUsecase.FundMemberTopup(userID, organisationID, memberUserID, input.NolCardID, input.Amount)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusCreated, gin.H{"message": "NolCard topped up for member", "topup": topup})
+}
+
+// GetUsageReport reads from/to dates (YYYY-MM-DD, inclusive; defaulting to the
+// current month) and responds with JSON or CSV per the format query.
+func (h *OrganisationHandler) GetUsageReport(c *gin.Context) {
+    userID, organisationID, ok := organisationAdminParams(c)
+    if !ok {
+        return
+    }
+
+    now := time.Now()
+    from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+    to := now
+    var err error
+    if value := c.Query("from"); value != "" {
+        if from, err = time.ParseInLocation("2006-01-02", value, now.Location()); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from date, expected YYYY-MM-DD"})
+            return
+        }
+    }
+    if value := c.Query("to"); value != "" {
+        day, err := time.ParseInLocation("2006-01-02", value, now.Location())
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to date, expected YYYY-MM-DD"})
+            return
+        }
+        to = day.AddDate(0, 0, 1)
+    }
+
+    usage, err := h.OrganisationUsecase.GetUsageReport(userID, organisationID, from, to)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    switch c.DefaultQuery("format", "json") {
+    case "json":
+        c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "usage": usage})
+    case "csv":
+        content, err := h.OrganisationUsecase.RenderUsageCSV(usage)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate CSV"})
+            return
+        }
+        fileName := fmt.Sprintf("usage_%d_%s_%s.csv", organisationID, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"))
+        c.Header("Content-Disposition", "attachment; filename="+fileName)
+        c.Data(http.StatusOK, "text/csv", content)
+    default:
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be json or csv"})
+    }
+}
+
+func (h *OrganisationHandler) GetInvoices(c *gin.Context) {
+    userID, organisationID, ok := organisationAdminParams(c)
+    if !ok {
+        return
+    }
+    invoices, err := h.OrganisationUsecase.GetInvoices(userID, organisationID)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"invoices": invoices})
+}
+
+func (h *OrganisationHandler) GetInvoice(c *gin.Context) {
+    userID, organisationID, ok := organisationAdminParams(c)
+    if !ok {
+        return
+    }
+    invoiceID, ok := uintParam(c, "invoice_id", "Invalid invoice ID")
+    if !ok {
+        return
+    }
+    invoice, charges, err := h.OrganisationUsecase.GetInvoice(userID, organisationID, invoiceID)
+    if err != nil {
+        respondOrganisationError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"invoice": invoice, "charges": charges})
+}
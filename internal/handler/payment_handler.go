@@ -1,10 +1,12 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 	"github.com/Prototype-1/xtrace/internal/usecase"
 	"github.com/Prototype-1/xtrace/internal/models"
@@ -19,10 +21,14 @@ type RazorpayHandler struct {
     WalletUsecase          usecase.WalletUsecase
     razorpayClient         *razorpay.Client 
 	NolCardTopupUsecase         usecase.NolCardTopupUsecase
+	NolCardUpgradeUsecase       usecase.NolCardUpgradeUsecase
 	InvoiceUsecase         usecase.InvoiceUsecase 
+	ExchangeRateUsecase    usecase.ExchangeRateUsecase
+	ReferralUsecase        usecase.ReferralUsecase
+	LoyaltyUsecase         usecase.LoyaltyUsecase
 }
 
-func NewRazorpayHandler(walletUsecase usecase.WalletUsecase, razorpayPaymentUsecase usecase.RazorpayPaymentUsecase, bookingUsecase usecase.BookingUsecase, subscriptionUsecase usecase.SubscriptionUsecase, razorpayClient *razorpay.Client, NolCardTopupUsecase         usecase.NolCardTopupUsecase, invoiceUsecase usecase.InvoiceUsecase) *RazorpayHandler {
+func NewRazorpayHandler(walletUsecase usecase.WalletUsecase, razorpayPaymentUsecase usecase.RazorpayPaymentUsecase, bookingUsecase usecase.BookingUsecase, subscriptionUsecase usecase.SubscriptionUsecase, razorpayClient *razorpay.Client, NolCardTopupUsecase         usecase.NolCardTopupUsecase, invoiceUsecase usecase.InvoiceUsecase, exchangeRateUsecase usecase.ExchangeRateUsecase, nolCardUpgradeUsecase usecase.NolCardUpgradeUsecase, referralUsecase usecase.ReferralUsecase, loyaltyUsecase usecase.LoyaltyUsecase) *RazorpayHandler {
 	return &RazorpayHandler{
 		RazorpayPaymentUsecase: razorpayPaymentUsecase,
 		BookingUsecase:         bookingUsecase, 
@@ -30,15 +36,23 @@ func NewRazorpayHandler(walletUsecase usecase.WalletUsecase, razorpayPaymentUsec
 		SubscriptionUsecase:    subscriptionUsecase,
         razorpayClient:         razorpayClient,
 		NolCardTopupUsecase: NolCardTopupUsecase,
+		NolCardUpgradeUsecase: nolCardUpgradeUsecase,
 		InvoiceUsecase:         invoiceUsecase,
+		ExchangeRateUsecase:    exchangeRateUsecase,
+		ReferralUsecase:        referralUsecase,
+		LoyaltyUsecase:         loyaltyUsecase,
 	}
 }
 
+// CreatePayment opens a checkout in the requested currency. Only top-ups take
+// their amount from the client, in that currency; bookings, subscriptions and
+// upgrades are always charged their server-side price.
 func (h *RazorpayHandler) CreatePayment(c *gin.Context) {
 	log.Println("CreatePayment endpoint hit")
 
 var originalAmount float64 
 var finalAmount float64
+var pendingUpgrade *models.NolCardUpgrade
 
 	userIDParam := c.Param("userID")
 	userID, err := strconv.ParseUint(userIDParam, 10, 64)
@@ -47,7 +61,7 @@ var finalAmount float64
 		return
 	}
 	var input struct {		
-		Amount         float64 `json:"amount" binding:"required,numeric"`
+		Amount         float64 `json:"amount" binding:"omitempty,gt=0"`
 		Currency       string  `json:"currency" binding:"required"`
 		PaymentType    string  `json:"payment_type" binding:"required"`
 		CouponCode     string  `json:"coupon_code"`
@@ -55,6 +69,7 @@ var finalAmount float64
 		NolCardID      *uint   `json:"nol_card_id,omitempty"`
 		SubscriptionID *uint   `json:"subscription_id,omitempty"`
 		BookingID      *uint   `json:"booking_id,omitempty"`
+		RedeemPoints   int     `json:"redeem_points"`
 	}
 
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -64,6 +79,7 @@ var finalAmount float64
 	}
 
 	log.Printf("Input received: %+v", input)
+	currency := strings.ToUpper(input.Currency)
 
 	existingPayment, err := h.RazorpayPaymentUsecase.GetExistingPayment(uint(userID), input.PaymentType, input.WalletID, input.NolCardID, input.SubscriptionID, input.BookingID)
 	if err != nil {
@@ -72,7 +88,7 @@ var finalAmount float64
 		return
 	}
 	if existingPayment != nil {
-		if input.PaymentType != "wallet_topup" {
+		if input.PaymentType != "wallet_topup" && input.PaymentType != models.PaymentTypeCardUpgrade {
 			c.JSON(http.StatusConflict, gin.H{"error": "A payment already exists for this Payment Type and related ID"})
 			return
 		}
@@ -96,20 +112,59 @@ var finalAmount float64
 		*bookingIDPtr = *input.BookingID
 	}
 
+	couponTarget := models.CouponTarget{UserID: uint(userID), PaymentType: input.PaymentType}
 	switch input.PaymentType {
 	case "wallet_topup":
 		if walletIDPtr == nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Wallet ID is required for Wallet Topup"})
 			return
 		}
-		originalAmount = input.Amount
+		if input.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Amount is required for Wallet Topup"})
+			return
+		}
+		// Top-ups are entered in the checkout currency; the wallet is credited in the base currency.
+		originalAmount, _, err = h.ExchangeRateUsecase.ConvertToBase(input.Amount, currency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 	case "nol_card_topup":
 		if nolCardIDPtr == nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Nol Card ID is required for Nol Card Topup"})
 			return
 		}
-		originalAmount = input.Amount
+		if !h.nolCardUsable(c, int(*nolCardIDPtr)) {
+			return
+		}
+		if input.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Amount is required for Nol Card Topup"})
+			return
+		}
+		couponTarget.CardType = h.nolCardType(int(*nolCardIDPtr))
+		originalAmount, _, err = h.ExchangeRateUsecase.ConvertToBase(input.Amount, currency)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+	case models.PaymentTypeCardUpgrade:
+		if nolCardIDPtr == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Nol Card ID is required for a card upgrade"})
+			return
+		}
+		if !h.nolCardUsable(c, int(*nolCardIDPtr)) {
+			return
+		}
+		// The price comes from the pending upgrade, never from the client.
+		pendingUpgrade, err = h.NolCardUpgradeUsecase.GetPendingUpgrade(uint(userID), int(*nolCardIDPtr))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		originalAmount = pendingUpgrade.Price
+		couponTarget.CardType = h.nolCardType(int(*nolCardIDPtr))
 
 	case "subscription":
 		if subscriptionIDPtr == nil {
@@ -121,14 +176,29 @@ var finalAmount float64
             c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Subscription ID"})
             return
         }
+        if !h.nolCardUsable(c, int(subscription.NolCardID)) {
+            return
+        }
         originalAmount = subscription.Price
+        couponTarget.CardType = subscription.CardType
+        if plan, err := h.SubscriptionUsecase.GetSubscriptionPlan(subscription.PlanID); err == nil {
+            couponTarget.CategoryID = plan.CategoryID
+        }
 
 	case "booking":
 		if bookingIDPtr == nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Booking ID is required for Booking payment"})
 			return
 		}
-		originalAmount = input.Amount
+		booking, err := h.BookingUsecase.GetBookingByID(*bookingIDPtr)
+		if err != nil || booking.UserID != uint(userID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Booking ID"})
+			return
+		}
+		// The fare comes from the booking, never from the client.
+		originalAmount = booking.BookingAmount
+		couponTarget.RouteID = booking.RouteID
+		couponTarget.CardType = booking.CardType
 		
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Payment Type"})
@@ -138,22 +208,66 @@ var finalAmount float64
 	finalAmount = originalAmount
 	var discountAmount float64
 
+// The coupon slot and any points are held from here; every failure below releases them.
+var redemption *models.CouponRedemption
 if input.CouponCode != "" {
-    discountAmount, err = h.RazorpayPaymentUsecase.ApplyCoupon(input.CouponCode, finalAmount)
+    redemption, err = h.RazorpayPaymentUsecase.ReserveCoupon(input.CouponCode, finalAmount, couponTarget)
     if err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
         return
     }
+    discountAmount = redemption.Discount
     finalAmount -= discountAmount
 }
+var pointsRedemption *models.LoyaltyLedgerEntry
+releaseReservations := func() {
+    if redemption != nil {
+        if err := h.RazorpayPaymentUsecase.ReleaseCouponReservation(redemption.RedemptionID); err != nil {
+            log.Printf("Failed to release coupon reservation %d: %v", redemption.RedemptionID, err)
+        }
+    }
+    if pointsRedemption != nil {
+        if err := h.LoyaltyUsecase.ReverseRedemption(pointsRedemption.EntryID); err != nil {
+            log.Printf("Failed to reverse loyalty redemption %d: %v", pointsRedemption.EntryID, err)
+        }
+    }
+}
+
+// Points are applied after the coupon, as a separate discount line.
+if input.RedeemPoints > 0 && finalAmount > 0 {
+    pointsRedemption, err = h.LoyaltyUsecase.RedeemPoints(uint(userID), input.RedeemPoints, input.PaymentType, finalAmount)
+    if err != nil {
+        releaseReservations()
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    discountAmount += pointsRedemption.Amount
+    finalAmount -= pointsRedemption.Amount
+}
 
 if finalAmount <= 0 {
-    c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero after applying the coupon"})
+    releaseReservations()
+    c.JSON(http.StatusBadRequest, gin.H{"error": "Amount must be greater than zero after applying discounts"})
     return
 }
 
-orderID, err := h.RazorpayPaymentUsecase.CreateRazorpayOrder(finalAmount, input.Currency, userID)
+// Prices are kept in the base currency, so convert at quote time for the order.
+chargeAmount, exchangeRate, err := h.ExchangeRateUsecase.ConvertFromBase(finalAmount, currency)
+if err != nil {
+    releaseReservations()
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+}
+chargeOriginalAmount, _, err := h.ExchangeRateUsecase.ConvertFromBase(originalAmount, currency)
 if err != nil {
+    releaseReservations()
+    c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    return
+}
+
+orderID, err := h.RazorpayPaymentUsecase.CreateRazorpayOrder(chargeAmount, currency, userID)
+if err != nil {
+    releaseReservations()
     c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating Razorpay order: " + err.Error()})
     return
 }
@@ -163,8 +277,8 @@ if err != nil {
 
 	payment, err := h.RazorpayPaymentUsecase.CreatePayment(
 		uint(userID),
-		finalAmount,
-		input.Currency,
+		chargeAmount,
+		currency,
 		input.CouponCode,
 		input.PaymentType,
 		walletIDPtr,       
@@ -175,10 +289,11 @@ if err != nil {
 	)
 
 log.Printf("Subscription ID: %v", subscriptionIDPtr)
-log.Printf("Creating payment for user %d with amount %.2f", userID, input.Amount)
-log.Printf("Original amount: %.2f, Discount: %.2f, Final amount: %.2f", input.Amount, discountAmount, finalAmount)
+log.Printf("Creating payment for user %d with amount %.2f %s", userID, chargeAmount, currency)
+log.Printf("Original amount: %.2f, Discount: %.2f, Final amount: %.2f", originalAmount, discountAmount, finalAmount)
 
 	if err != nil {
+		releaseReservations()
 		log.Printf("Failed to create payment record: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment: " + err.Error()})
 		return
@@ -186,12 +301,35 @@ log.Printf("Original amount: %.2f, Discount: %.2f, Final amount: %.2f", input.Am
 
 	log.Printf("Payment record created successfully. Payment ID: %d, Razorpay Order ID: %s", payment.PaymentID, orderID)
 
-	invoice, err := h.InvoiceUsecase.CreateInvoice(
+	if redemption != nil {
+		if err := h.RazorpayPaymentUsecase.AttachCouponReservation(redemption.RedemptionID, payment.PaymentID, orderID); err != nil {
+			log.Printf("Failed to link coupon reservation %d to payment: %v", redemption.RedemptionID, err)
+		}
+	}
+
+	if pointsRedemption != nil {
+		if err := h.LoyaltyUsecase.AttachRedemption(pointsRedemption.EntryID, payment.PaymentID, orderID); err != nil {
+			log.Printf("Failed to link loyalty redemption %d to payment: %v", pointsRedemption.EntryID, err)
+		}
+	}
+
+	if pendingUpgrade != nil {
+		if err := h.NolCardUpgradeUsecase.AttachPayment(pendingUpgrade.UpgradeID, payment.PaymentID, orderID); err != nil {
+			log.Printf("Failed to link payment to upgrade: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link payment to upgrade"})
+			return
+		}
+	}
+
+	// The invoice stays a proforma draft until VerifyPayment confirms the capture.
+	invoice, err := h.InvoiceUsecase.CreateDraftInvoice(
 		uint(userID),        
 		payment.PaymentID,  
-		originalAmount,      
+		chargeOriginalAmount,      
 		input.PaymentType,   
-		discountAmount,      
+		chargeOriginalAmount - chargeAmount,      
+		currency,
+		exchangeRate,
 	)
 	if err != nil {
 		log.Printf("Failed to create invoice: %v", err)
@@ -200,18 +338,78 @@ log.Printf("Original amount: %.2f, Discount: %.2f, Final amount: %.2f", input.Am
 	}
 	
 	// Log or process the created invoice as needed
-	log.Printf("Invoice created successfully. Invoice ID: %d", invoice.InvoiceID)
+	log.Printf("Draft invoice created successfully. Invoice ID: %d", invoice.InvoiceID)
 	
 
 	c.JSON(http.StatusOK, gin.H{
 		"payment":     payment,
 		"order_id":    orderID,
 		"razorpay_id": payment.RazorpayID,
-		"original_amount":  input.Amount,
-        "discounted_amount": finalAmount,
+		"original_amount":  chargeOriginalAmount,
+        "discounted_amount": chargeAmount,
+		"currency":          currency,
+		"exchange_rate":     exchangeRate,
+		"settled_amount":    payment.SettledAmount,
+		"settled_currency":  payment.SettledCurrency,
+		"points_redeemed":   pointsRedeemed(pointsRedemption),
+		"points_discount":   pointsDiscount(pointsRedemption),
 	})
 }
 
+func pointsRedeemed(entry *models.LoyaltyLedgerEntry) int {
+	if entry == nil {
+		return 0
+	}
+	return -entry.Points
+}
+
+func pointsDiscount(entry *models.LoyaltyLedgerEntry) float64 {
+	if entry == nil {
+		return 0
+	}
+	return entry.Amount
+}
+
+// nolCardUsable writes an error response and returns false when the card does
+// not exist or is blocked, hotlisted, replaced or expired.
+func (h *RazorpayHandler) nolCardUsable(c *gin.Context, nolCardID int) bool {
+	nolCard, err := h.NolCardTopupUsecase.GetNolCardByID(nolCardID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Nol Card not found"})
+		return false
+	}
+	if !nolCard.IsUsable() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This NolCard is " + nolCard.Status + " and cannot be used"})
+		return false
+	}
+	return true
+}
+
+// nolCardType returns the card's type for coupon targeting, or "" if unknown.
+func (h *RazorpayHandler) nolCardType(nolCardID int) string {
+	nolCard, err := h.NolCardTopupUsecase.GetNolCardByID(nolCardID)
+	if err != nil {
+		return ""
+	}
+	return nolCard.CardType
+}
+
+// paymentCardType returns the card type a booking or subscription payment was
+// made for, or "" if unknown.
+func (h *RazorpayHandler) paymentCardType(payment *models.RazorpayPayment) string {
+	if payment.BookingID != nil {
+		if booking, err := h.BookingUsecase.GetBookingByID(*payment.BookingID); err == nil {
+			return booking.CardType
+		}
+	}
+	if payment.SubscriptionID != nil {
+		if subscription, err := h.SubscriptionUsecase.GetSubscriptionByID(*payment.SubscriptionID); err == nil {
+			return subscription.CardType
+		}
+	}
+	return ""
+}
+
 func (h *RazorpayHandler) GetAmountByPaymentType(c *gin.Context) {
     paymentType := c.Param("type")
     id := c.Param("id")
@@ -268,12 +466,41 @@ func (h *RazorpayHandler) VerifyPayment(c *gin.Context) {
 
     log.Printf("Verifying payment - Order ID: %s, Payment ID: %s", input.OrderID, input.PaymentID)
 
+    // A retried verification must not credit the wallet or NolCard a second time.
+    if existing, err := h.RazorpayPaymentUsecase.GetPaymentByOrderID(input.OrderID); err == nil && existing != nil && existing.Status == models.PaymentStatusVerified {
+        h.finalizeInvoice(existing)
+        c.JSON(http.StatusOK, gin.H{
+            "verified": true,
+            "message": "Payment already verified",
+            "payment_type": existing.PaymentType,
+        })
+        return
+    }
+
     err := h.RazorpayPaymentUsecase.VerifyPayment(input.OrderID, input.PaymentID, input.RazorpaySignature)
     if err != nil {
         log.Printf("Payment verification failed: %v", err)
+        if releaseErr := h.RazorpayPaymentUsecase.ReleaseCouponForOrder(input.OrderID); releaseErr != nil {
+            log.Printf("Failed to release coupon for order %s: %v", input.OrderID, releaseErr)
+        }
+        if reverseErr := h.LoyaltyUsecase.ReverseRedemptionForOrder(input.OrderID); reverseErr != nil {
+            log.Printf("Failed to return loyalty points for order %s: %v", input.OrderID, reverseErr)
+        }
+        if voidErr := h.InvoiceUsecase.VoidForOrder(input.OrderID); voidErr != nil {
+            log.Printf("Failed to void draft invoice for order %s: %v", input.OrderID, voidErr)
+        }
+        // Marked failed first so a successful refund below is recorded against this payment.
+        if statusErr := h.RazorpayPaymentUsecase.UpdatePaymentStatus(input.OrderID, input.PaymentID, models.PaymentStatusFailed); statusErr != nil {
+            log.Printf("Failed to mark payment for order %s as failed: %v", input.OrderID, statusErr)
+        }
         refundErr := h.RazorpayPaymentUsecase.ProcessRefund(input.PaymentID)
         if refundErr != nil {
             log.Printf("Refund process failed: %v", refundErr)
+            if !errors.Is(refundErr, usecase.ErrRefundNotEligible) {
+                if statusErr := h.RazorpayPaymentUsecase.UpdatePaymentStatus(input.OrderID, input.PaymentID, models.PaymentStatusRefundPending); statusErr != nil {
+                    log.Printf("Failed to mark payment for order %s as awaiting refund: %v", input.OrderID, statusErr)
+                }
+            }
             c.JSON(http.StatusInternalServerError, gin.H{
                 "verified": false,
                 "error": "Payment verification and refund failed",
@@ -299,14 +526,28 @@ func (h *RazorpayHandler) VerifyPayment(c *gin.Context) {
         return
     }
 
+    if err := h.LoyaltyUsecase.ConfirmRedemptionForOrder(input.OrderID); err != nil {
+        log.Printf("Failed to confirm loyalty redemption for order %s: %v", input.OrderID, err)
+    }
+    h.finalizeInvoice(payment)
+
     var processingErr error
     switch payment.PaymentType {
     case "nol_card_topup":
         processingErr = h.handleNOLCardTopup(payment)
     case "wallet_topup":
         processingErr = h.handleWalletTopup(payment)
+    case models.PaymentTypeCardUpgrade:
+        processingErr = h.NolCardUpgradeUsecase.CompleteUpgradeForPayment(payment)
     case "subscription", "booking":
         log.Printf("Payment verified successfully for %s", payment.PaymentType)
+        // The purchase is already paid; failed rewards are logged, not surfaced.
+        if err := h.ReferralUsecase.RewardFirstBooking(payment); err != nil {
+            log.Printf("Failed to reward referral for payment %d: %v", payment.PaymentID, err)
+        }
+        if _, err := h.LoyaltyUsecase.EarnForPayment(payment, h.paymentCardType(payment)); err != nil {
+            log.Printf("Failed to award loyalty points for payment %d: %v", payment.PaymentID, err)
+        }
         c.JSON(http.StatusOK, gin.H{
             "verified": true,
             "message": "Payment verified successfully",
@@ -322,6 +563,15 @@ func (h *RazorpayHandler) VerifyPayment(c *gin.Context) {
         return
     }
 
+    if errors.Is(processingErr, usecase.ErrUpgradeNotPending) {
+        log.Printf("Refunding payment for order %s: %v", input.OrderID, processingErr)
+        h.refundOrphanedUpgradePayment(payment)
+        c.JSON(http.StatusConflict, gin.H{
+            "verified": false,
+            "error": "This card upgrade was cancelled before the payment arrived; the payment is being refunded",
+        })
+        return
+    }
     if processingErr != nil {
         log.Printf("Error handling %s: %v", payment.PaymentType, processingErr)
         c.JSON(http.StatusInternalServerError, gin.H{
@@ -339,6 +589,30 @@ func (h *RazorpayHandler) VerifyPayment(c *gin.Context) {
     })
 }
 
+// finalizeInvoice issues the numbered invoice for a captured payment. The
+// money is already taken, so a failure is logged rather than surfaced.
+func (h *RazorpayHandler) finalizeInvoice(payment *models.RazorpayPayment) {
+    invoice, err := h.InvoiceUsecase.FinalizeForPayment(payment.PaymentID)
+    if err != nil {
+        log.Printf("Failed to finalise invoice for payment %d: %v", payment.PaymentID, err)
+        return
+    }
+    log.Printf("Invoice %s issued for payment %d", *invoice.InvoiceNumber, payment.PaymentID)
+}
+
+// refundOrphanedUpgradePayment refunds a verified payment whose card upgrade
+// was cancelled while the rider was paying. The refund also credits the
+// invoice that was just issued; if it fails, the payment is left awaiting a
+// refund so it can be retried.
+func (h *RazorpayHandler) refundOrphanedUpgradePayment(payment *models.RazorpayPayment) {
+    if err := h.RazorpayPaymentUsecase.ProcessRefund(payment.RazorpayID); err != nil {
+        log.Printf("Failed to refund payment for order %s: %v", payment.OrderID, err)
+        if statusErr := h.RazorpayPaymentUsecase.UpdatePaymentStatus(payment.OrderID, payment.RazorpayID, models.PaymentStatusRefundPending); statusErr != nil {
+            log.Printf("Failed to mark payment for order %s as awaiting refund: %v", payment.OrderID, statusErr)
+        }
+    }
+}
+
 func (h *RazorpayHandler) handleNOLCardTopup( payment *models.RazorpayPayment) error {
     if payment.NolCardID == nil {
         return fmt.Errorf("nol_card_id is nil")
@@ -353,7 +627,7 @@ func (h *RazorpayHandler) handleNOLCardTopup( payment *models.RazorpayPayment) e
 
     nolCardTopup := models.NolCardTopup{
         NolCardID: nolCard.NolCardID,
-        Amount:    payment.Amount,
+        Amount:    settledAmount(payment),
         TopupDate: time.Now(),
     }
 
@@ -373,27 +647,23 @@ func (h *RazorpayHandler) handleWalletTopup(payment *models.RazorpayPayment) err
         return fmt.Errorf("failed to retrieve wallet: %w", err)
     }
 
-    err = h.WalletUsecase.TopUpWallet(&wallet.WalletID, nil, payment.Amount, "Top-up via successful payment", "top-up")
+    err = h.WalletUsecase.TopUpWallet(&wallet.WalletID, nil, settledAmount(payment), "Wallet topped up via Razorpay payment", "top-up")
     if err != nil {
         return fmt.Errorf("failed to update wallet balance: %w", err)
     }
 
-    transaction := &models.WalletTransaction{
-        WalletID:        wallet.WalletID,
-        AdminID:         nil,
-        Amount:          payment.Amount,
-        TransactionType: "top-up",
-        Description:     "Wallet topped up via Razorpay payment",
-    }
-   
-    err = h.WalletUsecase.RecordWalletTransaction(transaction)
-    if err != nil {
-        return fmt.Errorf("failed to record wallet transaction: %w", err)
-    }
-
     return nil
 }
 
+// settledAmount returns the base-currency value of a payment, falling back to
+// the charged amount for payments recorded before multi-currency support.
+func settledAmount(payment *models.RazorpayPayment) float64 {
+    if payment.SettledAmount > 0 {
+        return payment.SettledAmount
+    }
+    return payment.Amount
+}
+
 func (h *RazorpayHandler) GetPaymentStatus(c *gin.Context) {
 	paymentIDParam := c.Param("payment_id")
 	paymentID, err := strconv.Atoi(paymentIDParam)
@@ -422,18 +692,39 @@ func (h *RazorpayHandler) FetchApplicableCoupons(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"coupons": coupons})
 }
 
+// ApplyCoupon previews a coupon against an amount in ?currency (the base
+// currency when omitted). Coupons themselves are priced in the base currency.
 func (h *RazorpayHandler) ApplyCoupon(c *gin.Context) {
 	var req struct {
 		CouponCode  string  `json:"coupon_code"`
 		Amount      float64 `json:"amount"`
+		Currency    string  `json:"currency"`
 		PaymentType string  `json:"payment_type"`
+		CardType    string  `json:"card_type"`
+		RouteID     uint    `json:"route_id"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
 
-	discount, err := h.RazorpayPaymentUsecase.ApplyCoupon(req.CouponCode, req.Amount)
+	// Per-user rules are checked when the payment is created; this preview is unauthenticated.
+	target := models.CouponTarget{PaymentType: req.PaymentType, CardType: req.CardType, RouteID: req.RouteID}
+	currency := strings.ToUpper(req.Currency)
+	if currency == "" {
+		currency = models.BaseCurrency
+	}
+	baseAmount, _, err := h.ExchangeRateUsecase.ConvertToBase(req.Amount, currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	baseDiscount, err := h.RazorpayPaymentUsecase.ApplyCoupon(req.CouponCode, baseAmount, target)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	discount, _, err := h.ExchangeRateUsecase.ConvertFromBase(baseDiscount, currency)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -451,6 +742,7 @@ func (h *RazorpayHandler) ApplyCoupon(c *gin.Context) {
 		"original_amount": req.Amount,
 		"discount_amount": discount,
 		"final_amount":    finalAmount,
+		"currency":        currency,
 		"coupon_code":     req.CouponCode,
 	})
 	
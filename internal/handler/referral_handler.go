@@ -0,0 +1,114 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "strings"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "github.com/Prototype-1/xtrace/internal/usecase"
+    "github.com/gin-gonic/gin"
+)
+
+type ReferralHandler struct {
+    ReferralUsecase usecase.ReferralUsecase
+}
+
+func NewReferralHandler(referralUsecase usecase.ReferralUsecase) *ReferralHandler {
+    return &ReferralHandler{ReferralUsecase: referralUsecase}
+}
+
+// signupDeviceID identifies the signing-up device for the referral fraud
+// guards. It is empty when the app sends no device ID, and the device checks
+// are then skipped; the client IP is shared too widely to stand in for it.
+func signupDeviceID(c *gin.Context, deviceID string) string {
+    if deviceID = strings.TrimSpace(deviceID); deviceID != "" {
+        return deviceID
+    }
+    return strings.TrimSpace(c.GetHeader("X-Device-ID"))
+}
+
+func (h *ReferralHandler) GetMyReferrals(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    code, err := h.ReferralUsecase.GetOrCreateCode(userID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referral code"})
+        return
+    }
+    referrals, err := h.ReferralUsecase.GetReferralsByReferrer(userID)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referrals"})
+        return
+    }
+    var earned float64
+    for _, referral := range referrals {
+        if referral.Status == models.ReferralStatusRewarded {
+            earned += referral.ReferrerReward
+        }
+    }
+    c.JSON(http.StatusOK, gin.H{
+        "referral_code": code.Code,
+        "referrals":     referrals,
+        "total_earned":  earned,
+    })
+}
+
+// Admin endpoints.
+
+func (h *ReferralHandler) GetReferrals(c *gin.Context) {
+    referrals, err := h.ReferralUsecase.GetReferrals(c.Query("status"))
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referrals"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"referrals": referrals})
+}
+
+func (h *ReferralHandler) RejectReferral(c *gin.Context) {
+    referralID, ok := uintParam(c, "referral_id", "Invalid referral ID")
+    if !ok {
+        return
+    }
+    var input struct {
+        Reason string `json:"reason"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if err := h.ReferralUsecase.RejectReferral(referralID, input.Reason); err != nil {
+        if errors.Is(err, repository.ErrReferralNotEligible) {
+            c.JSON(http.StatusConflict, gin.H{"error": "Only pending or verified referrals can be rejected"})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject referral"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Referral rejected"})
+}
+
+func (h *ReferralHandler) GetProgramme(c *gin.Context) {
+    programme, err := h.ReferralUsecase.GetProgramme()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch referral programme"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"programme": programme})
+}
+
+func (h *ReferralHandler) UpdateProgramme(c *gin.Context) {
+    var programme models.ReferralProgramme
+    if err := c.ShouldBindJSON(&programme); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if err := h.ReferralUsecase.UpdateProgramme(&programme); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Referral programme updated", "programme": programme})
+}
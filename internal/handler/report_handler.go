@@ -0,0 +1,181 @@
+package handler
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "path/filepath"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/usecase"
+    "gorm.io/gorm"
+)
+
+type ReportHandler struct {
+    ReportUsecase usecase.ReportUsecase
+}
+
+func NewReportHandler(reportUsecase usecase.ReportUsecase) *ReportHandler {
+    return &ReportHandler{ReportUsecase: reportUsecase}
+}
+
+type reportScheduleRequest struct {
+    ReportType string `json:"report_type" binding:"required"`
+    Cron       string `json:"cron" binding:"required"`
+    Period     string `json:"period"`
+    Recipients string `json:"recipients"`
+    Active     *bool  `json:"active"`
+}
+
+func (r reportScheduleRequest) schedule() *models.ReportSchedule {
+    active := true
+    if r.Active != nil {
+        active = *r.Active
+    }
+    return &models.ReportSchedule{
+        ReportType: r.ReportType,
+        Cron:       r.Cron,
+        Period:     r.Period,
+        Recipients: r.Recipients,
+        Active:     active,
+    }
+}
+
+// withReportLinks fills in the download links of a run's output.
+func withReportLinks(run *models.ReportRun) {
+    links := make(map[string]string)
+    if run.CSVFile != "" {
+        links["csv"] = fmt.Sprintf("/admin/reports/runs/%d/download?format=csv", run.RunID)
+    }
+    if run.PDFFile != "" {
+        links["pdf"] = fmt.Sprintf("/admin/reports/runs/%d/download?format=pdf", run.RunID)
+    }
+    if len(links) > 0 {
+        run.Links = links
+    }
+}
+
+// CreateSchedule subscribes the signed-in admin to a report. Recipients
+// default to the admin's own email.
+func (h *ReportHandler) CreateSchedule(c *gin.Context) {
+    var req reportScheduleRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+        return
+    }
+    schedule := req.schedule()
+    schedule.AdminID = contextUserID(c)
+    if err := h.ReportUsecase.CreateSchedule(schedule); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusCreated, gin.H{"message": "Report schedule created", "schedule": schedule})
+}
+
+func (h *ReportHandler) GetSchedules(c *gin.Context) {
+    schedules, err := h.ReportUsecase.GetSchedules()
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report schedules"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"schedules": schedules})
+}
+
+func (h *ReportHandler) UpdateSchedule(c *gin.Context) {
+    scheduleID, ok := uintParam(c, "schedule_id", "Invalid schedule ID")
+    if !ok {
+        return
+    }
+    var req reportScheduleRequest
+    if err := c.ShouldBindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+        return
+    }
+    schedule := req.schedule()
+    schedule.ScheduleID = scheduleID
+    if err := h.ReportUsecase.UpdateSchedule(schedule); err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            c.JSON(http.StatusNotFound, gin.H{"error": "Report schedule not found"})
+            return
+        }
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Report schedule updated", "schedule": schedule})
+}
+
+func (h *ReportHandler) DeleteSchedule(c *gin.Context) {
+    scheduleID, ok := uintParam(c, "schedule_id", "Invalid schedule ID")
+    if !ok {
+        return
+    }
+    if err := h.ReportUsecase.DeleteSchedule(scheduleID); err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            c.JSON(http.StatusNotFound, gin.H{"error": "Report schedule not found"})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete report schedule"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Report schedule deleted"})
+}
+
+// RunSchedule generates and emails a schedule's report straight away.
+func (h *ReportHandler) RunSchedule(c *gin.Context) {
+    scheduleID, ok := uintParam(c, "schedule_id", "Invalid schedule ID")
+    if !ok {
+        return
+    }
+    run, err := h.ReportUsecase.RunSchedule(scheduleID)
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            c.JSON(http.StatusNotFound, gin.H{"error": "Report schedule not found"})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to run report"})
+        return
+    }
+    withReportLinks(run)
+    c.JSON(http.StatusOK, gin.H{"run": run})
+}
+
+// GetRuns lists the latest runs, optionally of one ?schedule_id=, up to ?limit=.
+func (h *ReportHandler) GetRuns(c *gin.Context) {
+    scheduleID, ok := positiveIntValue(c, c.Query("schedule_id"), "schedule_id")
+    if !ok {
+        return
+    }
+    limit, _ := strconv.Atoi(c.Query("limit"))
+    runs, err := h.ReportUsecase.GetRuns(uint(scheduleID), limit)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch report runs"})
+        return
+    }
+    for i := range runs {
+        withReportLinks(&runs[i])
+    }
+    c.JSON(http.StatusOK, gin.H{"runs": runs})
+}
+
+// DownloadRun serves a run's ?format=csv (the default) or pdf output.
+func (h *ReportHandler) DownloadRun(c *gin.Context) {
+    runID, ok := uintParam(c, "run_id", "Invalid run ID")
+    if !ok {
+        return
+    }
+    path, err := h.ReportUsecase.GetRunFile(runID, c.DefaultQuery("format", "csv"))
+    if err != nil {
+        switch {
+        case errors.Is(err, gorm.ErrRecordNotFound):
+            c.JSON(http.StatusNotFound, gin.H{"error": "Report run not found"})
+        case errors.Is(err, usecase.ErrReportOutputMissing):
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+        default:
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        }
+        return
+    }
+    c.FileAttachment(path, filepath.Base(path))
+}
@@ -1,49 +1,63 @@
 package handler
 
 import (
+    "fmt"
     "net/http"
+
     "github.com/gin-gonic/gin"
-    "github.com/Prototype-1/xtrace/config"
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/usecase"
 )
 
-type RevenueHandler struct{}
+type RevenueHandler struct {
+    RevenueUsecase usecase.RevenueUsecase
+}
 
-func NewRevenueHandler() *RevenueHandler {
-    return &RevenueHandler{}
+func NewRevenueHandler(revenueUsecase usecase.RevenueUsecase) *RevenueHandler {
+    return &RevenueHandler{RevenueUsecase: revenueUsecase}
 }
 
-func (h *RevenueHandler) GetTotalRevenue(c *gin.Context) {
-    var totalRevenue float64
+// revenueReport builds the report for ?from=, ?to=, ?group_by= and the
+// optional ?payment_type=, writing the error response on failure.
+func (h *RevenueHandler) revenueReport(c *gin.Context) (*models.RevenueReport, bool) {
+    from, to, ok := dateRangeQuery(c)
+    if !ok {
+        return nil, false
+    }
+    report, err := h.RevenueUsecase.GetRevenue(models.RevenueFilter{
+        From:        from,
+        To:          to,
+        GroupBy:     c.DefaultQuery("group_by", models.RevenueByDay),
+        PaymentType: c.Query("payment_type"),
+    })
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return nil, false
+    }
+    return report, true
+}
 
-    result := config.DB.Table("payments").Where("status = ?", "captured").Select("COALESCE(SUM(amount), 0)").Scan(&totalRevenue)
-    if result.Error != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch total revenue"})
+// GetRevenue returns gross, discounts, refunds and net revenue grouped by
+// day, week, month, payment type, category, route, card type or coupon.
+func (h *RevenueHandler) GetRevenue(c *gin.Context) {
+    report, ok := h.revenueReport(c)
+    if !ok {
         return
     }
-
-    c.JSON(http.StatusOK, gin.H{"total": totalRevenue})
+    c.JSON(http.StatusOK, gin.H{"revenue": report})
 }
 
-func (h *RevenueHandler) GetMonthlyRevenue(c *gin.Context) {
-    type MonthlyRevenue struct {
-        Month string  `json:"month"`
-        Total float64 `json:"total"`
+func (h *RevenueHandler) ExportRevenue(c *gin.Context) {
+    report, ok := h.revenueReport(c)
+    if !ok {
+        return
     }
-
-    var monthlyRevenues []MonthlyRevenue
-
-    query := `
-        SELECT TO_CHAR(payment_date, 'YYYY-MM') AS month, COALESCE(SUM(amount), 0) AS total
-        FROM payments
-        WHERE status = 'captured'
-        GROUP BY month
-        ORDER BY month;
-    `
-    result := config.DB.Raw(query).Scan(&monthlyRevenues)
-    if result.Error != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch monthly revenue"})
+    content, err := h.RevenueUsecase.RenderCSV(report)
+    if err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export revenue"})
         return
     }
-
-    c.JSON(http.StatusOK, monthlyRevenues)
+    fileName := fmt.Sprintf("revenue_%s_%s_%s.csv", report.GroupBy, report.From.Format("20060102"), report.To.AddDate(0, 0, -1).Format("20060102"))
+    c.Header("Content-Disposition", "attachment; filename="+fileName)
+    c.Data(http.StatusOK, "text/csv", content)
 }
@@ -0,0 +1,126 @@
+package handler
+
+import (
+    "net/http"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/usecase"
+)
+
+type RidershipHandler struct {
+    RidershipUsecase usecase.RidershipUsecase
+}
+
+func NewRidershipHandler(ridershipUsecase usecase.RidershipUsecase) *RidershipHandler {
+    return &RidershipHandler{RidershipUsecase: ridershipUsecase}
+}
+
+// positiveIntValue parses an optional ID, writing the error response when it
+// is not a positive integer. Empty values are 0.
+func positiveIntValue(c *gin.Context, value, name string) (int, bool) {
+    if value == "" {
+        return 0, true
+    }
+    id, err := strconv.Atoi(value)
+    if err != nil || id <= 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + name})
+        return 0, false
+    }
+    return id, true
+}
+
+// ridershipFilter reads ?from=, ?to=, ?route_id=, ?stop_id= and ?source=.
+// A :route_id path parameter takes the place of ?route_id=.
+func ridershipFilter(c *gin.Context) (models.RidershipFilter, bool) {
+    filter := models.RidershipFilter{Source: c.Query("source")}
+    var ok bool
+    if filter.From, filter.To, ok = dateRangeQuery(c); !ok {
+        return filter, false
+    }
+    routeID := c.Param("route_id")
+    if routeID == "" {
+        routeID = c.Query("route_id")
+    }
+    if filter.RouteID, ok = positiveIntValue(c, routeID, "route_id"); !ok {
+        return filter, false
+    }
+    if filter.StopID, ok = positiveIntValue(c, c.Query("stop_id"), "stop_id"); !ok {
+        return filter, false
+    }
+    return filter, true
+}
+
+// GetStopHours returns boardings and alightings per stop per hour of day.
+func (h *RidershipHandler) GetStopHours(c *gin.Context) {
+    filter, ok := ridershipFilter(c)
+    if !ok {
+        return
+    }
+    rows, err := h.RidershipUsecase.GetStopHours(filter)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"stop_hours": rows})
+}
+
+// GetODMatrix returns trips between each pair of stops, busiest first.
+func (h *RidershipHandler) GetODMatrix(c *gin.Context) {
+    filter, ok := ridershipFilter(c)
+    if !ok {
+        return
+    }
+    pairs, err := h.RidershipUsecase.GetODMatrix(filter)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"od_matrix": pairs})
+}
+
+func (h *RidershipHandler) GetLoadProfile(c *gin.Context) {
+    filter, ok := ridershipFilter(c)
+    if !ok {
+        return
+    }
+    profiles, err := h.RidershipUsecase.GetLoadProfiles(filter)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"load_profiles": profiles})
+}
+
+// GetHeatmap returns boardings by weekday and hour.
+func (h *RidershipHandler) GetHeatmap(c *gin.Context) {
+    filter, ok := ridershipFilter(c)
+    if !ok {
+        return
+    }
+    cells, err := h.RidershipUsecase.GetHeatmap(filter)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"heatmap": cells})
+}
+
+// RebuildRollups recomputes the rollups for ?from= to ?to=, e.g. after
+// journeys were corrected.
+func (h *RidershipHandler) RebuildRollups(c *gin.Context) {
+    from, to, ok := dateRangeQuery(c)
+    if !ok {
+        return
+    }
+    if !to.After(from) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "to must be after from"})
+        return
+    }
+    if err := h.RidershipUsecase.Rebuild(from, to); err != nil {
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild ridership rollups"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Ridership rollups rebuilt"})
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
     "log"
@@ -17,15 +19,17 @@ type SubscriptionHandler struct {
     subscriptionRepo    repository.SubscriptionRepository
     RazorpayPaymentUsecase   usecase.RazorpayPaymentUsecase 
     WalletUsecase     usecase.WalletUsecase
+    LoyaltyUsecase    usecase.LoyaltyUsecase
 }
 
-func NewSubscriptionHandler(subscriptionUsecase usecase.SubscriptionUsecase, nolCardRepo repository.NolCardRepository, subscriptionRepo repository.SubscriptionRepository, razorpayPaymentUsecase usecase.RazorpayPaymentUsecase, walletUsecase usecase.WalletUsecase) *SubscriptionHandler {
+func NewSubscriptionHandler(subscriptionUsecase usecase.SubscriptionUsecase, nolCardRepo repository.NolCardRepository, subscriptionRepo repository.SubscriptionRepository, razorpayPaymentUsecase usecase.RazorpayPaymentUsecase, walletUsecase usecase.WalletUsecase, loyaltyUsecase usecase.LoyaltyUsecase) *SubscriptionHandler {
     return &SubscriptionHandler{
         SubscriptionUsecase: subscriptionUsecase,
         nolCardRepo:         nolCardRepo,
         subscriptionRepo:    subscriptionRepo,
         RazorpayPaymentUsecase: razorpayPaymentUsecase,
         WalletUsecase:     walletUsecase, 
+        LoyaltyUsecase:    loyaltyUsecase,
     }
 }
 
@@ -59,6 +63,10 @@ func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
         c.JSON(http.StatusNotFound, gin.H{"error": "Nol Card not found"})
         return
     }
+    if !nolCard.IsUsable() {
+        c.JSON(http.StatusForbidden, gin.H{"error": "This NolCard is " + nolCard.Status + " and cannot be used"})
+        return
+    }
 
     existingSubscription, err := h.subscriptionRepo.GetSubscriptionByUserAndCard(input.UserID, uint(nolCard.NolCardID))
     if err == nil && existingSubscription != nil {
@@ -81,24 +89,40 @@ func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
         Price:        plan.Price,
         StartDate:    time.Now(), 
         EndDate:      time.Now().AddDate(0, 0, plan.DurationDays), 
+        Status:       models.SubscriptionStatusActive,
+        RideAllowance: plan.RideAllowance,
     }
     
     if input.PaymentMethod == "wallet" {
-       // err := h.WalletUsecase.MakePayment(input.WalletID, plan.Price)
-        // if err != nil {
-        //     c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient wallet balance or payment failed"})
-        //     return
-        // }
-        err := h.subscriptionRepo.CreateSubscription(newSubscription)
-        log.Println(err)
+        if contextUserID(c) != input.UserID {
+            c.JSON(http.StatusForbidden, gin.H{"error": "You can only pay from your own wallet"})
+            return
+        }
+        subscription, invoice, err := h.SubscriptionUsecase.SubscribeWithWallet(input.UserID, input.PlanID, uint(nolCard.NolCardID))
         if err != nil {
+            log.Println(err)
+            if errors.Is(err, repository.ErrInsufficientBalance) {
+                c.JSON(http.StatusPaymentRequired, gin.H{"error": "Insufficient wallet balance"})
+                return
+            }
             c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create subscription"})
             return
         }
+        walletPayment := &models.RazorpayPayment{
+            PaymentID:     invoice.PaymentID,
+            UserID:        input.UserID,
+            SettledAmount: subscription.Price,
+            Status:        models.PaymentStatusVerified,
+            PaymentType:   "subscription",
+        }
+        if _, err := h.LoyaltyUsecase.EarnForPayment(walletPayment, subscription.CardType); err != nil {
+            log.Printf("Failed to award loyalty points for subscription %d: %v", subscription.SubscriptionID, err)
+        }
         c.JSON(http.StatusOK, gin.H{
             "message":             "Subscription created successfully using wallet.",
-            "subscription_amount": newSubscription.Price,
-            "subscription_id":     newSubscription.SubscriptionID,
+            "subscription_amount": subscription.Price,
+            "subscription_id":     subscription.SubscriptionID,
+            "invoice_id":          invoice.InvoiceID,
         })
 
     } else if input.PaymentMethod == "razorpay" {
@@ -267,6 +291,12 @@ func (h *SubscriptionHandler) CreateSubscriptionPlan(c *gin.Context) {
         Price       float64 `json:"price"`
         DurationDays int    `json:"duration_days"`
         CardType      string  `json:"card_type"`
+        MaxPauseDays *int   `json:"max_pause_days"`
+        CategoryID         *int  `json:"category_id"`
+        RouteIDs           []int `json:"route_ids"`
+        CorridorFromStopID *int  `json:"corridor_from_stop_id"`
+        CorridorToStopID   *int  `json:"corridor_to_stop_id"`
+        RideAllowance      int   `json:"ride_allowance"`
     }
 
     if err := c.ShouldBindJSON(&input); err != nil {
@@ -289,9 +319,21 @@ func (h *SubscriptionHandler) CreateSubscriptionPlan(c *gin.Context) {
         Price:        input.Price,
         DurationDays: input.DurationDays,
         CardType:      input.CardType,
+        CategoryID:         input.CategoryID,
+        RouteIDs:           input.RouteIDs,
+        CorridorFromStopID: input.CorridorFromStopID,
+        CorridorToStopID:   input.CorridorToStopID,
+        RideAllowance:      input.RideAllowance,
+    }
+    if input.MaxPauseDays != nil {
+        plan.MaxPauseDays = *input.MaxPauseDays
     }
 
     err := h.SubscriptionUsecase.CreateSubscriptionPlan(plan)
+    if errors.Is(err, usecase.ErrInvalidPlanScope) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
@@ -313,6 +355,12 @@ func (h *SubscriptionHandler) UpdateSubscriptionPlan(c *gin.Context) {
         Price            float64 `json:"price"`
         DurationDays     int     `json:"duration_days"`
         CardType        string  `json:"card_type"`
+        MaxPauseDays *int   `json:"max_pause_days"`
+        CategoryID         *int  `json:"category_id"`
+        RouteIDs           []int `json:"route_ids"`
+        CorridorFromStopID *int  `json:"corridor_from_stop_id"`
+        CorridorToStopID   *int  `json:"corridor_to_stop_id"`
+        RideAllowance      int   `json:"ride_allowance"`
     }
 
     if err := c.ShouldBindJSON(&input); err != nil {
@@ -336,11 +384,25 @@ func (h *SubscriptionHandler) UpdateSubscriptionPlan(c *gin.Context) {
         Price:            input.Price,
         DurationDays:     input.DurationDays,
         CardType:      input.CardType,
+        CategoryID:         input.CategoryID,
+        RouteIDs:           input.RouteIDs,
+        CorridorFromStopID: input.CorridorFromStopID,
+        CorridorToStopID:   input.CorridorToStopID,
+        RideAllowance:      input.RideAllowance,
+    }
+    if input.MaxPauseDays != nil {
+        plan.MaxPauseDays = *input.MaxPauseDays
+    } else if existing, err := h.SubscriptionUsecase.GetSubscriptionPlan(plan.PlanID); err == nil && existing != nil {
+        plan.MaxPauseDays = existing.MaxPauseDays
     }
 
     log.Printf("Updating plan with ID %d: %+v", plan.PlanID, plan) // Debug log
 
     err = h.SubscriptionUsecase.UpdateSubscriptionPlan(plan)
+    if errors.Is(err, usecase.ErrInvalidPlanScope) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
@@ -378,9 +440,138 @@ func (h *SubscriptionHandler) GetAllSubscriptionPlans(c *gin.Context) {
     c.JSON(http.StatusOK, plans)
 }
 
+// subscriptionParam reads :subscription_id for the subscriber lifecycle routes.
+func subscriptionParam(c *gin.Context) (uint, bool) {
+    subscriptionID, err := strconv.ParseUint(c.Param("subscription_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+        return 0, false
+    }
+    return uint(subscriptionID), true
+}
 
+func respondSubscriptionError(c *gin.Context, err error) {
+    switch {
+    case errors.Is(err, usecase.ErrSubscriptionNotFound):
+        c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+    default:
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+    }
+}
 
+func (h *SubscriptionHandler) PauseSubscription(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    subscriptionID, ok := subscriptionParam(c)
+    if !ok {
+        return
+    }
 
+    subscription, err := h.SubscriptionUsecase.PauseSubscription(userID, subscriptionID)
+    if err != nil {
+        respondSubscriptionError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Subscription paused", "subscription": subscription})
+}
 
+func (h *SubscriptionHandler) ResumeSubscription(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    subscriptionID, ok := subscriptionParam(c)
+    if !ok {
+        return
+    }
 
+    subscription, err := h.SubscriptionUsecase.ResumeSubscription(userID, subscriptionID)
+    if err != nil {
+        respondSubscriptionError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Subscription resumed", "subscription": subscription})
+}
 
+func (h *SubscriptionHandler) CancelSubscription(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    subscriptionID, ok := subscriptionParam(c)
+    if !ok {
+        return
+    }
+    var input struct {
+        Reason string `json:"reason"`
+    }
+    _ = c.ShouldBindJSON(&input)
+
+    adjustment, err := h.SubscriptionUsecase.CancelSubscription(userID, subscriptionID, input.Reason)
+    if err != nil {
+        respondSubscriptionError(c, err)
+        return
+    }
+    message := "Subscription cancelled"
+    if adjustment.RefundStatus == models.AdjustmentRefundFailed {
+        message = "Subscription cancelled, but the refund could not be processed yet"
+    }
+    c.JSON(http.StatusOK, gin.H{
+        "message":       message,
+        "refund_amount": -adjustment.Amount,
+        "refund_method": adjustment.Method,
+        "adjustment":    adjustment,
+    })
+}
+
+func (h *SubscriptionHandler) ChangePlan(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    subscriptionID, ok := subscriptionParam(c)
+    if !ok {
+        return
+    }
+    var input struct {
+        PlanID uint `json:"plan_id" binding:"required"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    adjustment, err := h.SubscriptionUsecase.ChangePlan(userID, subscriptionID, input.PlanID)
+    if err != nil {
+        respondSubscriptionError(c, err)
+        return
+    }
+    message := "Plan changed"
+    switch {
+    case adjustment.Amount > 0:
+        message = fmt.Sprintf("Plan changed, %.2f charged to your wallet", adjustment.Amount)
+    case adjustment.Amount < 0:
+        message = fmt.Sprintf("Plan changed, %.2f credited to your wallet", -adjustment.Amount)
+    }
+    c.JSON(http.StatusOK, gin.H{"message": message, "adjustment": adjustment})
+}
+
+func (h *SubscriptionHandler) GetAdjustments(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    subscriptionID, ok := subscriptionParam(c)
+    if !ok {
+        return
+    }
+
+    adjustments, err := h.SubscriptionUsecase.GetAdjustments(userID, subscriptionID)
+    if err != nil {
+        respondSubscriptionError(c, err)
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"adjustments": adjustments})
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+    "errors"
+    "net/http"
+    "strconv"
+
+    "github.com/Prototype-1/xtrace/internal/usecase"
+    "github.com/gin-gonic/gin"
+)
+
+type SubscriptionRenewalHandler struct {
+    RenewalUsecase usecase.SubscriptionRenewalUsecase
+}
+
+func NewSubscriptionRenewalHandler(renewalUsecase usecase.SubscriptionRenewalUsecase) *SubscriptionRenewalHandler {
+    return &SubscriptionRenewalHandler{RenewalUsecase: renewalUsecase}
+}
+
+func (h *SubscriptionRenewalHandler) SetAutoRenew(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    subscriptionID, err := strconv.ParseUint(c.Param("subscription_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+        return
+    }
+
+    var input struct {
+        AutoRenew         *bool  `json:"auto_renew" binding:"required"`
+        RenewalMethod     string `json:"renewal_method"`
+        MandateCustomerID string `json:"mandate_customer_id"`
+        MandateTokenID    string `json:"mandate_token_id"`
+    }
+    if err := c.ShouldBindJSON(&input); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
+    subscription, err := h.RenewalUsecase.SetAutoRenew(userID, uint(subscriptionID), *input.AutoRenew,
+        input.RenewalMethod, input.MandateCustomerID, input.MandateTokenID)
+    if err != nil {
+        if errors.Is(err, usecase.ErrSubscriptionNotFound) {
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"message": "Auto-renew settings updated", "subscription": subscription})
+}
+
+func (h *SubscriptionRenewalHandler) GetRenewals(c *gin.Context) {
+    userID, ok := authorizedUserID(c)
+    if !ok {
+        return
+    }
+    subscriptionID, err := strconv.ParseUint(c.Param("subscription_id"), 10, 64)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subscription ID"})
+        return
+    }
+
+    renewals, err := h.RenewalUsecase.GetRenewals(userID, uint(subscriptionID))
+    if err != nil {
+        if errors.Is(err, usecase.ErrSubscriptionNotFound) {
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get renewals"})
+        return
+    }
+    c.JSON(http.StatusOK, gin.H{"renewals": renewals})
+}
@@ -2,6 +2,7 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -18,106 +19,136 @@ import (
 	"google.golang.org/api/option"
 )
 
-func UserSignUp(c *gin.Context) {
-    var input models.AuthInput
+// UserSignUp creates the account, attributing any referral code, and emails
+// the sign-up OTP.
+func UserSignUp(referralUsecase usecase.ReferralUsecase) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var input models.AuthInput
 
-    if err := c.ShouldBindJSON(&input); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-        return
-    }
+        if err := c.ShouldBindJSON(&input); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
 
-    if len(input.Password) < 8 {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters long"})
-        return
-    }
+        if len(input.Password) < 8 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 8 characters long"})
+            return
+        }
 
-    existingUser, err := repository.GetUserByEmail(input.Email)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking user existence"})
-        return
-    }
-    if existingUser != nil {
-        c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
-        return
-    }
+        existingUser, err := repository.GetUserByEmail(input.Email)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking user existence"})
+            return
+        }
+        if existingUser != nil {
+            c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+            return
+        }
 
-    hashedPassword, err := utils.HashPassword(input.Password)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Error hashing password"})
-        return
-    }
+        hashedPassword, err := utils.HashPassword(input.Password)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Error hashing password"})
+            return
+        }
 
-    user := models.User{
-        FirstName: input.FirstName,
-        LastName:  input.LastName,
-        Email:     input.Email,
-        Password:  hashedPassword,
-        Role:      "user",
-    }
+        user := models.User{
+            FirstName: input.FirstName,
+            LastName:  input.LastName,
+            Email:     input.Email,
+            Password:  hashedPassword,
+            Role:      "user",
+        }
 
-    err = repository.CreateUser(&user)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
-        return
-    }
+        err = repository.CreateUser(&user)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+            return
+        }
 
-    otpValue := utils.GenerateOTP()
-    otp := models.OTP{
-        UserID: user.ID,
-        OTP:    otpValue,
-        Expiry: time.Now().Add(5 * time.Minute),
-    }
-    err = repository.CreateOTP(otp)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create OTP"})
-        return
-    }
+        // A bad referral code must not block the sign-up itself.
+        if input.ReferralCode != "" {
+            if _, err := referralUsecase.AttributeSignup(&user, input.ReferralCode, signupDeviceID(c, input.DeviceID)); err != nil {
+                log.Printf("Referral not attributed for user %d: %v", user.ID, err)
+            }
+        }
 
-    err = utils.SendEmail(user.Email, "Your OTP Code", fmt.Sprintf("Your OTP code is: %s", otpValue))
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send OTP email"})
-        return
-    }
+        otpValue := utils.GenerateOTP()
+        otp := models.OTP{
+            UserID: user.ID,
+            OTP:    otpValue,
+            Expiry: time.Now().Add(5 * time.Minute),
+        }
+        err = repository.CreateOTP(otp)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create OTP"})
+            return
+        }
 
-    c.JSON(http.StatusCreated, gin.H{"message": "OTP is shared through your email. Verify to complete sign-up."})
-}
+        err = utils.SendEmail(user.Email, "Your OTP Code", fmt.Sprintf("Your OTP code is: %s", otpValue))
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send OTP email"})
+            return
+        }
 
-func VerifyOTP(c *gin.Context) {
-    var input struct {
-        Email string `json:"email" binding:"required"`
-        OTP   string `json:"otp" binding:"required"`
+        c.JSON(http.StatusCreated, gin.H{"message": "OTP is shared through your email. Verify to complete sign-up."})
     }
+}
 
-    if err := c.ShouldBindJSON(&input); err != nil {
-        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-        return
-    }
+// VerifyOTP activates the account and, with it, any pending referral.
+func VerifyOTP(referralUsecase usecase.ReferralUsecase) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var input struct {
+            Email        string `json:"email" binding:"required"`
+            OTP          string `json:"otp" binding:"required"`
+            ReferralCode string `json:"referral_code"`
+            DeviceID     string `json:"device_id"`
+        }
 
-    // Retrieve user by email
-    user, err := repository.GetUserByEmail(input.Email)
-    if err != nil || user == nil {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email"})
-        return
-    }
+        if err := c.ShouldBindJSON(&input); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
 
-    // Retrieve the OTP
-    otp, err := repository.GetOTPByUserID(user.ID)
-    if err != nil {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "OTP required or expired"})
-        return
-    }
+        // Retrieve user by email
+        user, err := repository.GetUserByEmail(input.Email)
+        if err != nil || user == nil {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email"})
+            return
+        }
 
-    if otp.OTP != input.OTP || otp.Used || time.Now().After(otp.Expiry) {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired OTP"})
-        return
-    }
-    err = repository.MarkOTPAsUsed(otp.ID)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify OTP"})
-        return
-    }
+        // Retrieve the OTP
+        otp, err := repository.GetOTPByUserID(user.ID)
+        if err != nil {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "OTP required or expired"})
+            return
+        }
 
-    c.JSON(http.StatusOK, gin.H{"message": "OTP verified successfully. Your account is now active."})
+        if otp.OTP != input.OTP || otp.Used || time.Now().After(otp.Expiry) {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired OTP"})
+            return
+        }
+        err = repository.MarkOTPAsUsed(otp.ID)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify OTP"})
+            return
+        }
+        if err := repository.MarkEmailVerified(user.ID); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify OTP"})
+            return
+        }
+
+        // A code may also be entered at verification if none was given at sign-up.
+        if input.ReferralCode != "" {
+            if _, err := referralUsecase.AttributeSignup(user, input.ReferralCode, signupDeviceID(c, input.DeviceID)); err != nil && !errors.Is(err, usecase.ErrAlreadyReferred) {
+                log.Printf("Referral not attributed for user %d: %v", user.ID, err)
+            }
+        }
+        if err := referralUsecase.ActivateReferral(user.ID); err != nil {
+            log.Printf("Failed to activate referral for user %d: %v", user.ID, err)
+        }
+
+        c.JSON(http.StatusOK, gin.H{"message": "OTP verified successfully. Your account is now active."})
+    }
 }
 
 func UserLogin(c *gin.Context) {
@@ -3,6 +3,8 @@ package handler
 import (
 	"net/http"
 	"strconv"
+	"strings"
+	"github.com/Prototype-1/xtrace/internal/models"
 	"github.com/Prototype-1/xtrace/internal/usecase"
 	"github.com/gin-gonic/gin"
 	"log"
@@ -12,12 +14,16 @@ import (
 type WalletHandler struct {
 	WalletUsecase usecase.WalletUsecase
     RazorpayPaymentUsecase usecase.RazorpayPaymentUsecase
+    ExchangeRateUsecase usecase.ExchangeRateUsecase
+    AutoReloadUsecase usecase.AutoReloadUsecase
 }
 
-func NewWalletHandler(walletUsecase usecase.WalletUsecase, razorpayPaymentUsecase usecase.RazorpayPaymentUsecase) *WalletHandler {
+func NewWalletHandler(walletUsecase usecase.WalletUsecase, razorpayPaymentUsecase usecase.RazorpayPaymentUsecase, exchangeRateUsecase usecase.ExchangeRateUsecase, autoReloadUsecase usecase.AutoReloadUsecase) *WalletHandler {
 	return &WalletHandler{
         WalletUsecase: walletUsecase,
         RazorpayPaymentUsecase: razorpayPaymentUsecase,
+        ExchangeRateUsecase: exchangeRateUsecase,
+        AutoReloadUsecase: autoReloadUsecase,
     }
 }
 
@@ -88,6 +94,7 @@ func (h *WalletHandler) TopUpWalletByUser(c *gin.Context) {
 
     var input struct {
         Amount      float64 `json:"amount" binding:"required,numeric"`
+        Currency    string   `json:"currency"`
         Description string   `json:"description" binding:"required"`
     }
 
@@ -96,6 +103,17 @@ func (h *WalletHandler) TopUpWalletByUser(c *gin.Context) {
         return
     }
 
+    // Wallets are kept in the base currency, so foreign top-ups are converted first.
+    currency := strings.ToUpper(input.Currency)
+    if currency == "" {
+        currency = models.BaseCurrency
+    }
+    creditAmount, _, err := h.ExchangeRateUsecase.ConvertToBase(input.Amount, currency)
+    if err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+
     wallet, err := h.WalletUsecase.GetWalletByUserID(uint(userID))
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve wallet"})
@@ -104,7 +122,7 @@ func (h *WalletHandler) TopUpWalletByUser(c *gin.Context) {
     var adminID *uint = nil 
     transactionType := "top-up" 
 
-    if err := h.WalletUsecase.TopUpWallet(&wallet.WalletID, adminID, input.Amount, input.Description, transactionType); err != nil {
+    if err := h.WalletUsecase.TopUpWallet(&wallet.WalletID, adminID, creditAmount, input.Description, transactionType); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to top up wallet"})
         return
     }
@@ -118,7 +136,7 @@ func (h *WalletHandler) TopUpWalletByUser(c *gin.Context) {
     payment, err := h.RazorpayPaymentUsecase.CreatePayment(
         uint(userID),
         input.Amount,
-        "INR",
+        currency,
         "", 
         "wallet_topup", 
         walletIDPtr,   
@@ -142,6 +160,7 @@ func (h *WalletHandler) TopUpWalletByUser(c *gin.Context) {
     c.JSON(http.StatusOK, gin.H{
         "message":      "Please proceed with the payment",
         "new_balance":  updatedWallet.Balance,
+        "credited_amount": creditAmount,
         "payment":      payment, 
     })
 }
@@ -164,6 +183,7 @@ func (h *WalletHandler) MakePayment(c *gin.Context) {
         c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
         return
     }
+    go h.AutoReloadUsecase.CheckWallet(input.WalletID)
 
     c.JSON(http.StatusOK, gin.H{"message": "Payment made successfully"})
 }
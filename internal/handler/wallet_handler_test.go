@@ -0,0 +1,166 @@
+package handler
+
+import (
+    "fmt"
+    "math/rand"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+
+    "github.com/Prototype-1/xtrace/internal/middleware"
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "github.com/Prototype-1/xtrace/internal/usecase"
+    "github.com/gin-gonic/gin"
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "gorm.io/gorm/logger"
+)
+
+// noAutoReload stands in for the auto-reload usecase so that a payment never
+// tops the wallet back up behind the test's back.
+type noAutoReload struct {
+    usecase.AutoReloadUsecase
+}
+
+func (noAutoReload) CheckWallet(walletID uint) {}
+
+// newTestWalletRouter serves the wallet payment endpoint, behind the
+// idempotency middleware as in main.go, over the Postgres database in
+// TEST_DATABASE_URL. It returns the router, the database and a wallet holding
+// balance, skipping the test when the database is not configured.
+func newTestWalletRouter(t *testing.T, balance float64) (*gin.Engine, *gorm.DB, *models.Wallet) {
+    t.Helper()
+    dsn := os.Getenv("TEST_DATABASE_URL")
+    if dsn == "" {
+        t.Skip("TEST_DATABASE_URL is not set")
+    }
+    db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+    if err != nil {
+        t.Fatalf("connecting to test database: %v", err)
+    }
+    if err := db.AutoMigrate(&models.Wallet{}, &models.WalletTransaction{}, &models.IdempotencyKey{}); err != nil {
+        t.Fatalf("migrating test database: %v", err)
+    }
+    wallet := &models.Wallet{UserID: uint(rand.Int31()), Balance: balance}
+    if err := db.Create(wallet).Error; err != nil {
+        t.Fatalf("creating wallet: %v", err)
+    }
+    t.Cleanup(func() {
+        db.Where("user_id = ?", wallet.UserID).Delete(&models.IdempotencyKey{})
+        db.Where("wallet_id = ?", wallet.WalletID).Delete(&models.WalletTransaction{})
+        db.Where("wallet_id = ?", wallet.WalletID).Delete(&models.Wallet{})
+    })
+
+    walletUsecase := usecase.NewWalletUsecase(repository.NewWalletRepository(db), repository.NewWalletTransactionRepository(db))
+    walletHandler := NewWalletHandler(walletUsecase, nil, nil, noAutoReload{})
+
+    gin.SetMode(gin.TestMode)
+    router := gin.New()
+    idempotency := middleware.IdempotencyMiddleware(repository.NewIdempotencyRepository(db))
+    router.POST("/user/:userID/wallet/payment", idempotency, walletHandler.MakePayment)
+    return router, db, wallet
+}
+
+func postWalletPayment(router *gin.Engine, wallet *models.Wallet, amount float64, idempotencyKey string) *httptest.ResponseRecorder {
+    body := fmt.Sprintf(`{"wallet_id": %d, "amount": %.2f, "transaction_type": "payment"}`, wallet.WalletID, amount)
+    req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/user/%d/wallet/payment", wallet.UserID), strings.NewReader(body))
+    req.Header.Set("Content-Type", "application/json")
+    if idempotencyKey != "" {
+        req.Header.Set(middleware.IdempotencyKeyHeader, idempotencyKey)
+    }
+    recorder := httptest.NewRecorder()
+    router.ServeHTTP(recorder, req)
+    return recorder
+}
+
+// walletBalanceAndDebits returns the wallet's balance and its ledger entries,
+// which in these tests are all payments.
+func walletBalanceAndDebits(t *testing.T, db *gorm.DB, walletID uint) (float64, int64) {
+    t.Helper()
+    var wallet models.Wallet
+    if err := db.Where("wallet_id = ?", walletID).First(&wallet).Error; err != nil {
+        t.Fatalf("reading wallet: %v", err)
+    }
+    var debits int64
+    if err := db.Model(&models.WalletTransaction{}).Where("wallet_id = ?", walletID).Count(&debits).Error; err != nil {
+        t.Fatalf("counting wallet transactions: %v", err)
+    }
+    return wallet.Balance, debits
+}
+
+// TestWalletHandlerConcurrentPaymentsNeverOverspend fires payments at the
+// endpoint in parallel and checks that exactly as many succeed as the balance
+// covers, each with one ledger entry, and that the rest are refused.
+func TestWalletHandlerConcurrentPaymentsNeverOverspend(t *testing.T) {
+    router, db, wallet := newTestWalletRouter(t, 100)
+
+    const workers = 40
+    var (
+        wg   sync.WaitGroup
+        mu   sync.Mutex
+        paid int
+    )
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            recorder := postWalletPayment(router, wallet, 10, "")
+            mu.Lock()
+            defer mu.Unlock()
+            switch recorder.Code {
+            case http.StatusOK:
+                paid++
+            case http.StatusInternalServerError:
+                if !strings.Contains(recorder.Body.String(), "insufficient") {
+                    t.Errorf("unexpected payment failure: %s", recorder.Body.String())
+                }
+            default:
+                t.Errorf("unexpected status %d: %s", recorder.Code, recorder.Body.String())
+            }
+        }()
+    }
+    wg.Wait()
+
+    balance, debits := walletBalanceAndDebits(t, db, wallet.WalletID)
+    if paid != 10 || balance != 0 {
+        t.Errorf("paid = %d, balance = %.2f; want 10 payments and a zero balance", paid, balance)
+    }
+    if debits != int64(paid) {
+        t.Errorf("ledger debits = %d, want %d", debits, paid)
+    }
+}
+
+// TestWalletHandlerRetriedPaymentChargesOnce sends the same payment with one
+// Idempotency-Key many times at once: the wallet is charged a single time and
+// every other attempt is either replayed or told the key is in progress.
+func TestWalletHandlerRetriedPaymentChargesOnce(t *testing.T) {
+    router, db, wallet := newTestWalletRouter(t, 100)
+
+    const workers = 20
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            recorder := postWalletPayment(router, wallet, 30, "retry-payment")
+            if recorder.Code != http.StatusOK && recorder.Code != http.StatusConflict {
+                t.Errorf("unexpected status %d: %s", recorder.Code, recorder.Body.String())
+            }
+        }()
+    }
+    wg.Wait()
+
+    replay := postWalletPayment(router, wallet, 30, "retry-payment")
+    if replay.Code != http.StatusOK || replay.Header().Get("Idempotent-Replayed") != "true" {
+        t.Errorf("retry after completion: status %d, replayed %q; want a replayed 200", replay.Code, replay.Header().Get("Idempotent-Replayed"))
+    }
+
+    balance, debits := walletBalanceAndDebits(t, db, wallet.WalletID)
+    if balance != 70 || debits != 1 {
+        t.Errorf("balance = %.2f, ledger debits = %d; want one charge of 30", balance, debits)
+    }
+}
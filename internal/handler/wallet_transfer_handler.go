@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Prototype-1/xtrace/internal/usecase"
+	"github.com/gin-gonic/gin"
+)
+
+type WalletTransferHandler struct {
+	WalletTransferUsecase usecase.WalletTransferUsecase
+	AutoReloadUsecase     usecase.AutoReloadUsecase
+}
+
+func NewWalletTransferHandler(walletTransferUsecase usecase.WalletTransferUsecase, autoReloadUsecase usecase.AutoReloadUsecase) *WalletTransferHandler {
+	return &WalletTransferHandler{WalletTransferUsecase: walletTransferUsecase, AutoReloadUsecase: autoReloadUsecase}
+}
+
+func (h *WalletTransferHandler) TransferToWallet(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		RecipientEmail string  `json:"recipient_email" binding:"required,email"`
+		Amount         float64 `json:"amount" binding:"required,gt=0"`
+		Note           string  `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transfer, err := h.WalletTransferUsecase.InitiateWalletTransfer(userID, input.RecipientEmail, input.Amount, input.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "OTP sent to your email. Confirm to complete the transfer.",
+		"transfer": transfer,
+	})
+}
+
+func (h *WalletTransferHandler) TransferToNolCard(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		CardNumber string  `json:"card_number" binding:"required"`
+		Amount     float64 `json:"amount" binding:"required,gt=0"`
+		Note       string  `json:"note"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transfer, err := h.WalletTransferUsecase.InitiateNolCardTransfer(userID, input.CardNumber, input.Amount, input.Note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "OTP sent to your email. Confirm to complete the transfer.",
+		"transfer": transfer,
+	})
+}
+
+func (h *WalletTransferHandler) ConfirmTransfer(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+	transferID, err := strconv.ParseUint(c.Param("transfer_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer ID"})
+		return
+	}
+
+	var input struct {
+		OTP string `json:"otp" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transfer, err := h.WalletTransferUsecase.ConfirmTransfer(userID, uint(transferID), input.OTP)
+	if err != nil {
+		switch {
+		case errors.Is(err, usecase.ErrTransferNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, usecase.ErrInvalidTransferOTP):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	go h.AutoReloadUsecase.CheckWallet(transfer.SenderWalletID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer completed successfully", "transfer": transfer})
+}
+
+func (h *WalletTransferHandler) GetTransfers(c *gin.Context) {
+	userID, ok := authorizedUserID(c)
+	if !ok {
+		return
+	}
+
+	transfers, err := h.WalletTransferUsecase.GetTransfersByUserID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve transfers"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"transfers": transfers})
+}
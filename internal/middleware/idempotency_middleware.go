@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"github.com/Prototype-1/xtrace/internal/models"
+	"github.com/Prototype-1/xtrace/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyKeyTTL is how long a stored response can be replayed for.
+const IdempotencyKeyTTL = 24 * time.Hour
+
+type idempotencyResponseWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *idempotencyResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyResponseWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware makes a money-moving endpoint safe to retry. Requests
+// carrying an Idempotency-Key header are stored per key and user: a retry with
+// the same body gets the stored response back, a reused key with a different
+// body is rejected, and requests without the header run as before.
+func IdempotencyMiddleware(repo repository.IdempotencyRepository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			c.Abort()
+			return
+		}
+
+		userID, ok := idempotencyUserID(c)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key requires an identified user"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		existing, err := repo.GetKey(key, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check Idempotency-Key"})
+			c.Abort()
+			return
+		}
+		if existing != nil && time.Now().After(existing.ExpiresAt) {
+			if err := repo.DeleteKey(existing.IdempotencyKeyID); err != nil {
+				log.Printf("Error deleting expired idempotency key %d: %v", existing.IdempotencyKeyID, err)
+			}
+			existing = nil
+		}
+		if existing != nil {
+			replayIdempotentResponse(c, existing, requestHash)
+			return
+		}
+
+		record := &models.IdempotencyKey{
+			Key:         key,
+			UserID:      userID,
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			RequestHash: requestHash,
+			ExpiresAt:   time.Now().Add(IdempotencyKeyTTL),
+		}
+		if err := repo.ReserveKey(record); err != nil {
+			// Lost the race against a concurrent request with the same key.
+			c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is already in progress"})
+			c.Abort()
+			return
+		}
+
+		writer := &idempotencyResponseWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = writer
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				// A panic is a server error too: release the key so a retry
+				// can run, and let the recovery middleware answer.
+				if err := repo.DeleteKey(record.IdempotencyKeyID); err != nil {
+					log.Printf("Error releasing idempotency key %d: %v", record.IdempotencyKeyID, err)
+				}
+				panic(recovered)
+			}
+		}()
+		c.Next()
+
+		status := writer.Status()
+		if status >= http.StatusInternalServerError {
+			// Server errors are not cached so that the client can retry them.
+			if err := repo.DeleteKey(record.IdempotencyKeyID); err != nil {
+				log.Printf("Error releasing idempotency key %d: %v", record.IdempotencyKeyID, err)
+			}
+			return
+		}
+		if err := repo.SaveResponse(record.IdempotencyKeyID, status, writer.body.String()); err != nil {
+			log.Printf("Error saving idempotent response for key %d: %v", record.IdempotencyKeyID, err)
+		}
+	}
+}
+
+func replayIdempotentResponse(c *gin.Context, existing *models.IdempotencyKey, requestHash string) {
+	if existing.RequestHash != requestHash {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key has already been used with a different request"})
+		c.Abort()
+		return
+	}
+	if !existing.Completed {
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is already in progress"})
+		c.Abort()
+		return
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(existing.StatusCode, "application/json; charset=utf-8", []byte(existing.ResponseBody))
+	c.Abort()
+}
+
+// idempotencyUserID scopes keys to the authenticated user, falling back to the
+// :userID path parameter on routes that are not behind TokenAuthMiddleware.
+func idempotencyUserID(c *gin.Context) (uint, bool) {
+	if value, exists := c.Get("user_id"); exists {
+		if id, ok := value.(float64); ok && id > 0 {
+			return uint(id), true
+		}
+	}
+	id, err := strconv.ParseUint(c.Param("userID"), 10, 64)
+	if err != nil || id == 0 {
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -15,16 +15,129 @@ type Subscription struct {
      NolCardID      uint      `json:"nol_card_id"`
 	Price          float64       `json:"price"`
 	DurationDays       int       `json:"duration_days"`
+    Status               string     `gorm:"size:20;not null;default:'active'" json:"status"`
+    AutoRenew            bool       `gorm:"default:false" json:"auto_renew"`
+    RenewalMethod        string     `gorm:"size:20" json:"renewal_method,omitempty"`
+    MandateCustomerID    string     `gorm:"size:100" json:"mandate_customer_id,omitempty"`
+    MandateTokenID       string     `gorm:"size:100" json:"mandate_token_id,omitempty"`
+    GraceEndsAt          *time.Time `json:"grace_ends_at,omitempty"`
+    LastReminderDays     int        `gorm:"default:0" json:"-"`
+    LastRenewalAttemptAt *time.Time `json:"last_renewal_attempt_at,omitempty"`
+    LastRenewalError     string     `gorm:"size:255" json:"last_renewal_error,omitempty"`
+    RideAllowance        int        `gorm:"default:0" json:"ride_allowance"`
+    RidesUsed            int        `gorm:"default:0" json:"rides_used"`
+    PausedAt             *time.Time `json:"paused_at,omitempty"`
+    PausedDays           int        `gorm:"default:0" json:"paused_days"`
+    CancelledAt          *time.Time `json:"cancelled_at,omitempty"`
     CreatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
     UpdatedAt      time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 }
 
+// Subscription states. A subscription whose auto-renewal failed stays in grace
+// until GraceEndsAt, then expires.
+const (
+    SubscriptionStatusActive  = "active"
+    SubscriptionStatusGrace   = "grace"
+    SubscriptionStatusExpired = "expired"
+    SubscriptionStatusPaused    = "paused"
+    SubscriptionStatusCancelled = "cancelled"
+
+    RenewalMethodWallet  = "wallet"
+    RenewalMethodMandate = "mandate"
+
+    RenewalStatusPending = "pending"
+    RenewalStatusSuccess = "success"
+    RenewalStatusFailed  = "failed"
+)
+
+// SubscriptionLiveStatuses are the states in which a subscription can cover
+// travel. Rows created before statuses existed have an empty status.
+var SubscriptionLiveStatuses = []string{SubscriptionStatusActive, SubscriptionStatusGrace, ""}
+
+// IsActive reports whether the subscription covers travel at the given time.
+func (s Subscription) IsActive(now time.Time) bool {
+    switch s.Status {
+    case SubscriptionStatusActive, "":
+        return s.EndDate.After(now)
+    case SubscriptionStatusGrace:
+        return s.EndDate.After(now) || (s.GraceEndsAt != nil && s.GraceEndsAt.After(now))
+    }
+    return false
+}
+
+// SubscriptionRenewal records every automatic renewal attempt.
+type SubscriptionRenewal struct {
+    RenewalID         uint      `gorm:"primaryKey;autoIncrement" json:"renewal_id"`
+    SubscriptionID    uint      `gorm:"not null;index" json:"subscription_id"`
+    UserID            uint      `gorm:"not null;index" json:"user_id"`
+    PlanID            uint      `json:"plan_id"`
+    Amount            float64   `gorm:"not null" json:"amount"`
+    Method            string    `gorm:"size:20;not null" json:"method"`
+    Status            string    `gorm:"size:20;not null" json:"status"`
+    PreviousEndDate   time.Time `json:"previous_end_date"`
+    NewEndDate        time.Time `json:"new_end_date"`
+    PaymentID         *uint     `json:"payment_id,omitempty"`
+    OrderID           string    `gorm:"size:100" json:"order_id,omitempty"`
+    RazorpayPaymentID string    `gorm:"size:100" json:"razorpay_payment_id,omitempty"`
+    Reason            string    `gorm:"size:255" json:"reason,omitempty"`
+    CreatedAt         time.Time `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt         time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
 type SubscriptionPlan struct {
     PlanID       uint      `gorm:"primaryKey" json:"plan_id"`
     PlanName     string    `json:"plan_name"`
     Price        float64   `json:"price"`
     DurationDays int       `json:"duration_days"`
     CardType      string    `json:"card_type"`
+    MaxPauseDays int       `gorm:"default:30" json:"max_pause_days"`
+    // Scope. Unset fields do not restrict the plan; every set field must match
+    // for a journey to be covered.
+    CategoryID         *int  `json:"category_id,omitempty"`
+    RouteIDs           []int `gorm:"-" json:"route_ids,omitempty"`
+    CorridorFromStopID *int  `json:"corridor_from_stop_id,omitempty"`
+    CorridorToStopID   *int  `json:"corridor_to_stop_id,omitempty"`
+    // RideAllowance caps covered journeys per term; 0 means unlimited.
+    RideAllowance int       `gorm:"default:0" json:"ride_allowance"`
     CreatedAt    time.Time `json:"created_at"`
     UpdatedAt    time.Time `json:"updated_at"`
 }
+
+// SubscriptionPlanRoute limits a plan to specific routes.
+type SubscriptionPlanRoute struct {
+    PlanID  uint `gorm:"primaryKey" json:"plan_id"`
+    RouteID int  `gorm:"primaryKey" json:"route_id"`
+}
+
+// Subscription adjustment types.
+const (
+    AdjustmentPause      = "pause"
+    AdjustmentResume     = "resume"
+    AdjustmentCancel     = "cancel"
+    AdjustmentPlanChange = "plan_change"
+
+    // A cancellation refunded through the gateway is recorded as pending
+    // before the refund is requested, then marked with the outcome.
+    AdjustmentRefundPending = "refund_pending"
+    AdjustmentRefunded      = "refunded"
+    AdjustmentRefundFailed  = "refund_failed"
+)
+
+// SubscriptionAdjustment is the audit trail of pauses, cancellations and plan
+// changes. Amount is what the user was charged; a negative amount was credited
+// or refunded to them.
+type SubscriptionAdjustment struct {
+    AdjustmentID   uint      `gorm:"primaryKey;autoIncrement" json:"adjustment_id"`
+    SubscriptionID uint      `gorm:"not null;index" json:"subscription_id"`
+    UserID         uint      `gorm:"not null;index" json:"user_id"`
+    Type           string    `gorm:"size:20;not null" json:"type"`
+    FromPlanID     uint      `json:"from_plan_id,omitempty"`
+    ToPlanID       uint      `json:"to_plan_id,omitempty"`
+    Days           int       `json:"days,omitempty"`
+    Amount         float64   `json:"amount"`
+    Method         string    `gorm:"size:20" json:"method,omitempty"`
+    Reference      string    `gorm:"size:100" json:"reference,omitempty"`
+    Details        string    `gorm:"size:255" json:"details,omitempty"`
+    RefundStatus   string    `gorm:"size:20" json:"refund_status,omitempty"`
+    CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
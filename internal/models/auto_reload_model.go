@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+const (
+    AutoReloadTargetWallet  = "wallet"
+    AutoReloadTargetNolCard = "nol_card"
+
+    AutoReloadFundingWallet  = "wallet"
+    AutoReloadFundingMandate = "mandate"
+
+    AutoReloadStatusPending = "pending"
+    AutoReloadStatusSuccess = "success"
+    AutoReloadStatusFailed  = "failed"
+)
+
+// AutoReloadRule tops up a wallet or NolCard by ReloadAmount whenever its
+// balance drops below Threshold, funded from the user's wallet or a saved
+// Razorpay recurring mandate (customer + token).
+type AutoReloadRule struct {
+    AutoReloadRuleID  uint       `gorm:"primaryKey;autoIncrement" json:"auto_reload_rule_id"`
+    UserID            uint       `gorm:"not null;index" json:"user_id"`
+    TargetType        string     `gorm:"size:20;not null" json:"target_type"`
+    WalletID          *uint      `gorm:"index" json:"wallet_id,omitempty"`
+    NolCardID         *int       `gorm:"index" json:"nol_card_id,omitempty"`
+    Threshold         float64    `gorm:"not null" json:"threshold"`
+    ReloadAmount      float64    `gorm:"not null" json:"reload_amount"`
+    FundingSource     string     `gorm:"size:20;not null" json:"funding_source"`
+    MandateCustomerID string     `gorm:"size:100" json:"mandate_customer_id,omitempty"`
+    MandateTokenID    string     `gorm:"size:100" json:"mandate_token_id,omitempty"`
+    MonthlyCap        float64    `gorm:"not null" json:"monthly_cap"`
+    Enabled           bool       `gorm:"default:true" json:"enabled"`
+    InProgress        bool       `gorm:"default:false" json:"in_progress"`
+    LastReloadAt      *time.Time `json:"last_reload_at,omitempty"`
+    CreatedAt         time.Time  `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt         time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// AutoReloadEvent records every reload attempt made for a rule.
+type AutoReloadEvent struct {
+    AutoReloadEventID uint      `gorm:"primaryKey;autoIncrement" json:"auto_reload_event_id"`
+    AutoReloadRuleID  uint      `gorm:"not null;index" json:"auto_reload_rule_id"`
+    UserID            uint      `gorm:"not null;index" json:"user_id"`
+    Amount            float64   `gorm:"not null" json:"amount"`
+    BalanceBefore     float64   `json:"balance_before"`
+    FundingSource     string    `gorm:"size:20" json:"funding_source"`
+    Status            string    `gorm:"size:20;not null" json:"status"`
+    PaymentID         *uint     `json:"payment_id,omitempty"`
+    OrderID           string    `gorm:"size:100" json:"order_id,omitempty"`
+    RazorpayPaymentID string    `gorm:"size:100" json:"razorpay_payment_id,omitempty"`
+    Reason            string    `gorm:"size:255" json:"reason,omitempty"`
+    CreatedAt         time.Time `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt         time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
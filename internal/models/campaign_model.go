@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// Campaign owns a batch of generated coupon codes. Deactivating it disables
+// every one of its codes at once.
+type Campaign struct {
+    CampaignID  uint      `gorm:"primaryKey;autoIncrement" json:"campaign_id"`
+    Name        string    `gorm:"size:150;not null" json:"name"`
+    Description string    `gorm:"size:500" json:"description"`
+    Active      bool      `gorm:"not null;default:true" json:"active"`
+    StartDate   time.Time `json:"start_date"`
+    EndDate     time.Time `json:"end_date"`
+    CreatedBy   uint      `json:"created_by"`
+    CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// CampaignStats summarises redemptions of a campaign's codes. Revenue is the
+// order value of redeemed payments before and after the coupon discount.
+type CampaignStats struct {
+    CampaignID     uint    `json:"campaign_id"`
+    Codes          int64   `json:"codes"`
+    CodesRedeemed  int64   `json:"codes_redeemed"`
+    Redemptions    int64   `json:"redemptions"`
+    Reserved       int64   `json:"reserved"`
+    RedemptionRate float64 `json:"redemption_rate"`
+    DiscountGiven  float64 `json:"discount_given"`
+    GrossRevenue   float64 `json:"gross_revenue"`
+    NetRevenue     float64 `json:"net_revenue"`
+}
+
+// CampaignCode is one row of a campaign's code export.
+type CampaignCode struct {
+    CouponID    int       `json:"coupon_id"`
+    Code        string    `json:"code"`
+    UsageLimit  int       `json:"usage_limit"`
+    Redemptions int64     `json:"redemptions"`
+    EndDate     time.Time `json:"end_date"`
+}
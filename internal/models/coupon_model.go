@@ -2,15 +2,65 @@ package models
 
 import "time"
 
+// Coupon discount types.
+const (
+    DiscountTypeFixed      = "fixed"
+    DiscountTypePercentage = "percentage"
+)
+
+// Coupon rules left at their zero value don't restrict: a UsageLimit or
+// PerUserLimit of 0 is unlimited, a MaxDiscount of 0 is uncapped, and an empty
+// CardType or nil CategoryID matches every purchase.
 type Coupon struct {
     CouponID        int       `gorm:"primary_key;auto_increment" json:"coupon_id"`
-    Code            string    `json:"code"`
+    Code            string    `gorm:"uniqueIndex" json:"code"`
     DiscountAmount  float64   `json:"discount_amount"`
     DiscountType    string    `json:"discount_type"`
     StartDate       time.Time `json:"start_date"`
     EndDate         time.Time `json:"end_date"`
     PaymentType   string        `json:"payment_type"`
+    UsageLimit      int       `gorm:"default:0" json:"usage_limit"`
+    PerUserLimit    int       `gorm:"default:0" json:"per_user_limit"`
+    MinOrderAmount  float64   `gorm:"default:0" json:"min_order_amount"`
+    MaxDiscount     float64   `gorm:"default:0" json:"max_discount"`
+    FirstRideOnly   bool      `gorm:"default:false" json:"first_ride_only"`
+    CardType        string    `gorm:"size:20" json:"card_type"`
+    CategoryID      *int      `json:"category_id"`
+    // CampaignID is set on codes generated for a campaign.
+    CampaignID      *uint     `gorm:"index" json:"campaign_id,omitempty"`
     CreatedAt       time.Time `json:"created_at"`
     UpdatedAt       time.Time `json:"updated_at"`
 }
 
+// Coupon redemption states. A slot is reserved when the payment is created,
+// becomes redeemed once the payment is verified and is released if it fails.
+const (
+    RedemptionStatusReserved = "reserved"
+    RedemptionStatusRedeemed = "redeemed"
+    RedemptionStatusReleased = "released"
+)
+
+// CouponRedemption is one use of a coupon. Reserved and redeemed rows count
+// against the coupon's limits.
+type CouponRedemption struct {
+    RedemptionID uint      `gorm:"primaryKey;autoIncrement" json:"redemption_id"`
+    CouponID     int       `gorm:"not null;index" json:"coupon_id"`
+    UserID       uint      `gorm:"not null;index" json:"user_id"`
+    PaymentID    *uint     `gorm:"index" json:"payment_id,omitempty"`
+    OrderID      string    `gorm:"size:64;index" json:"order_id"`
+    OrderAmount  float64   `json:"order_amount"`
+    Discount     float64   `json:"discount"`
+    Status       string    `gorm:"size:20;not null;index" json:"status"`
+    CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// CouponTarget describes the purchase a coupon is being applied to. UserID is
+// 0 for anonymous previews, which skips the per-user rules.
+type CouponTarget struct {
+    UserID      uint
+    PaymentType string
+    CardType    string
+    RouteID     uint
+    CategoryID  *int
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+    "time"
+)
+
+// Dashboard time-series metrics.
+const (
+    MetricSignups        = "signups"
+    MetricBookings       = "bookings"
+    MetricJourneys       = "journeys"
+    MetricRevenue        = "revenue"
+    MetricFailedPayments = "failed_payments"
+)
+
+var DashboardMetrics = []string{MetricSignups, MetricBookings, MetricJourneys, MetricRevenue, MetricFailedPayments}
+
+// Time-series intervals. Weeks start on Monday.
+const (
+    IntervalDay   = "day"
+    IntervalWeek  = "week"
+    IntervalMonth = "month"
+)
+
+// PlanSubscriptions is the number of live subscriptions on one plan.
+type PlanSubscriptions struct {
+    PlanID        uint   `json:"plan_id"`
+    PlanName      string `json:"plan_name"`
+    Subscriptions int64  `json:"subscriptions"`
+}
+
+// DashboardSummary is the admin dashboard's headline figures. Amounts are in
+// the base currency; "today" is since local midnight.
+type DashboardSummary struct {
+    TotalUsers          int64               `json:"total_users"`
+    ActiveUsers         int64               `json:"active_users"`
+    NewSignupsToday     int64               `json:"new_signups_today"`
+    NewSignups30Days    int64               `json:"new_signups_30_days"`
+    ActiveSubscriptions int64               `json:"active_subscriptions"`
+    SubscriptionsByPlan []PlanSubscriptions `json:"subscriptions_by_plan"`
+    WalletFloat         float64             `json:"wallet_float"`
+    NolCardFloat        float64             `json:"nol_card_float"`
+    BookingsToday       int64               `json:"bookings_today"`
+    FailedPaymentsToday int64               `json:"failed_payments_today"`
+    OpenRefunds         int64               `json:"open_refunds"`
+    OpenRefundAmount    float64             `json:"open_refund_amount"`
+    GeneratedAt         time.Time           `json:"generated_at"`
+}
+
+type TimeSeriesPoint struct {
+    Period string  `json:"period"`
+    Value  float64 `json:"value"`
+}
+
+type TimeSeries struct {
+    Metric      string            `json:"metric"`
+    Interval    string            `json:"interval"`
+    From        time.Time         `json:"from"`
+    To          time.Time         `json:"to"`
+    Points      []TimeSeriesPoint `json:"points"`
+    GeneratedAt time.Time         `json:"generated_at"`
+}
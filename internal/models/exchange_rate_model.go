@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// BaseCurrency is the currency fares, wallets and NolCard balances are kept in.
+// An amount sent to the API together with a currency is in that currency;
+// an amount sent without one (wallet, NolCard and invoice adjustments) is in
+// BaseCurrency.
+const BaseCurrency = "INR"
+
+// ExchangeRate stores how many units of BaseCurrency one unit of Currency buys.
+type ExchangeRate struct {
+    ExchangeRateID uint      `gorm:"primaryKey;autoIncrement" json:"exchange_rate_id"`
+    Currency       string    `gorm:"size:3;uniqueIndex;not null" json:"currency"`
+    RateToBase     float64   `gorm:"not null" json:"rate_to_base"`
+    CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// IdempotencyKey remembers the outcome of a money-moving request so that a
+// retried request with the same Idempotency-Key header is replayed, not re-run.
+type IdempotencyKey struct {
+    IdempotencyKeyID uint      `gorm:"primaryKey;autoIncrement" json:"idempotency_key_id"`
+    Key              string    `gorm:"column:idempotency_key;size:255;not null;uniqueIndex:idx_idempotency_key_user" json:"key"`
+    UserID           uint      `gorm:"not null;uniqueIndex:idx_idempotency_key_user" json:"user_id"`
+    Method           string    `gorm:"size:10;not null" json:"method"`
+    Path             string    `gorm:"size:255;not null" json:"path"`
+    RequestHash      string    `gorm:"size:64;not null" json:"request_hash"`
+    StatusCode       int       `json:"status_code"`
+    ResponseBody     string    `gorm:"type:text" json:"response_body"`
+    Completed        bool      `gorm:"default:false" json:"completed"`
+    ExpiresAt        time.Time `gorm:"not null;index" json:"expires_at"`
+    CreatedAt        time.Time `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt        time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
@@ -1,18 +1,156 @@
 package models
 
-import "time"
+import (
+    "fmt"
+    "time"
+)
 
+// InvoiceNumberPrefix starts every invoice number, e.g. "XT/2025-26/000042".
+// Credit notes are numbered in their own series, e.g. "XT/CN/2025-26/000007".
+const InvoiceNumberPrefix = "XT"
+
+// Invoice document types. A credit note reverses part or all of an invoice
+// and holds positive amounts.
+const (
+    DocumentTypeInvoice    = "invoice"
+    DocumentTypeCreditNote = "credit_note"
+)
+
+// Invoice statuses. Checkout invoices start as unnumbered drafts (a proforma)
+// and are numbered when the payment is confirmed, or voided if it never is.
+const (
+    InvoiceStatusDraft  = "Draft"
+    InvoiceStatusPaid   = "Paid"
+    InvoiceStatusDue    = "Due"
+    InvoiceStatusVoid   = "Void"
+    InvoiceStatusIssued = "Issued"
+)
+
+// FinalisedInvoiceStatuses are the statuses reports and invoice lists count.
+var FinalisedInvoiceStatuses = []string{InvoiceStatusPaid, InvoiceStatusDue, InvoiceStatusIssued}
+
+// InvoiceSeries returns the numbering series of a document type.
+func InvoiceSeries(documentType string) string {
+    if documentType == DocumentTypeCreditNote {
+        return InvoiceNumberPrefix + "/CN"
+    }
+    return InvoiceNumberPrefix
+}
+
+// Invoice amounts are tax-inclusive: the GST lines are carved out of the
+// amount charged. Seller and buyer details are copied in when the invoice is
+// issued so later profile changes never alter it.
 type Invoice struct {
     InvoiceID      uint      `gorm:"primaryKey"`
+    // InvoiceNumber is sequential and gap-free within a financial year.
+    InvoiceNumber  *string   `gorm:"size:32;uniqueIndex"`
+    FinancialYear  string    `gorm:"size:7;index"`
+    DocumentType   string    `gorm:"size:20;not null;default:'invoice';index"`
+    // OriginalInvoiceID and Reason are set on credit notes.
+    OriginalInvoiceID *uint  `gorm:"index"`
+    Reason         string    `gorm:"size:255"`
     UserID         uint      `gorm:"not null"`
     PaymentID      uint      `gorm:"not null"`
     InvoiceDate    time.Time `gorm:""`
     OriginalAmount float64   `gorm:"not null"`
     DiscountAmount float64   `gorm:"default:0"`
     Amount         float64   `gorm:"not null"`
+    Currency        string    `gorm:"size:3;default:INR"`
+    SettledAmount   float64   `gorm:"default:0"`
+    SettledCurrency string    `gorm:"size:3;default:INR"`
+    ExchangeRate    float64   `gorm:"default:1"`
     Status         string    `gorm:"size:50"`
     PaymentType    string    `gorm:"not null"`
+    // OrganisationID and Period are set on an organisation's monthly consolidated invoice.
+    OrganisationID  *uint     `gorm:"index"`
+    Period          string    `gorm:"size:7"`
+    SellerName      string    `gorm:"size:150"`
+    SellerGSTIN     string    `gorm:"size:15"`
+    SellerAddress   string    `gorm:"size:255"`
+    SellerStateCode string    `gorm:"size:2"`
+    BuyerName       string    `gorm:"size:150"`
+    BuyerEmail      string    `gorm:"size:255"`
+    BuyerGSTIN      string    `gorm:"size:15"`
+    BuyerAddress    string    `gorm:"size:255"`
+    // BuyerStateCode is the place of supply; empty means the seller's state.
+    BuyerStateCode  string    `gorm:"size:2"`
+    TaxableAmount   float64   `gorm:"default:0"`
+    CGSTAmount      float64   `gorm:"default:0"`
+    SGSTAmount      float64   `gorm:"default:0"`
+    IGSTAmount      float64   `gorm:"default:0"`
+    TaxAmount       float64   `gorm:"default:0"`
+    Lines           []InvoiceLine `gorm:"foreignKey:InvoiceID"`
     CreatedAt      time.Time `gorm:"autoCreateTime"`
     UpdatedAt      time.Time `gorm:"autoUpdateTime"`
 }
 
+// InvoiceTaxSummary totals finalised invoices and credit notes over a period
+// for GST returns. Net figures are invoices less credit notes.
+type InvoiceTaxSummary struct {
+    From          time.Time `json:"from"`
+    To            time.Time `json:"to"`
+    Invoices      int64     `json:"invoices"`
+    CreditNotes   int64     `json:"credit_notes"`
+    TaxableAmount float64   `json:"taxable_amount"`
+    CGSTAmount    float64   `json:"cgst_amount"`
+    SGSTAmount    float64   `json:"sgst_amount"`
+    IGSTAmount    float64   `json:"igst_amount"`
+    TaxAmount     float64   `json:"tax_amount"`
+    TotalAmount   float64   `json:"total_amount"`
+}
+
+// InvoiceLine is one item on an invoice. ItemType selects the TaxRule.
+type InvoiceLine struct {
+    LineID        uint    `gorm:"primaryKey;autoIncrement"`
+    InvoiceID     uint    `gorm:"not null;index"`
+    ItemType      string  `gorm:"size:30;not null"`
+    Description   string  `gorm:"size:255"`
+    HSNSAC        string  `gorm:"size:10"`
+    Quantity      int     `gorm:"not null;default:1"`
+    UnitPrice     float64 `gorm:"not null"`
+    Discount      float64 `gorm:"default:0"`
+    TaxableAmount float64 `gorm:"default:0"`
+    GSTRate       float64 `gorm:"default:0"`
+    CGSTAmount    float64 `gorm:"default:0"`
+    SGSTAmount    float64 `gorm:"default:0"`
+    IGSTAmount    float64 `gorm:"default:0"`
+    Total         float64 `gorm:"not null"`
+}
+
+// InvoiceSequence holds the last number issued in a series and financial year.
+type InvoiceSequence struct {
+    Series        string    `gorm:"primaryKey;size:20"`
+    FinancialYear string    `gorm:"primaryKey;size:7"`
+    LastNumber    int64     `gorm:"not null;default:0"`
+    UpdatedAt     time.Time `gorm:"autoUpdateTime"`
+}
+
+// TaxRule is the GST treatment of one kind of sale. Stored-value top-ups are
+// not a supply and carry no tax.
+type TaxRule struct {
+    RuleID      uint      `gorm:"primaryKey;autoIncrement" json:"rule_id"`
+    ItemType    string    `gorm:"size:30;not null;uniqueIndex" json:"item_type"`
+    Description string    `gorm:"size:150" json:"description"`
+    HSNSAC      string    `gorm:"size:10" json:"hsn_sac"`
+    GSTRate     float64   `gorm:"not null;default:0" json:"gst_rate"`
+    UpdatedAt   time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// DefaultTaxRules seed TaxRule on first start.
+var DefaultTaxRules = []TaxRule{
+    {ItemType: "booking", Description: "Passenger transport by road", HSNSAC: "996411", GSTRate: 5},
+    {ItemType: "subscription", Description: "Travel pass subscription", HSNSAC: "996411", GSTRate: 5},
+    {ItemType: PaymentTypeCardUpgrade, Description: "NolCard upgrade fee", HSNSAC: "998599", GSTRate: 18},
+    {ItemType: "nol_card_topup", Description: "NolCard top-up (stored value)"},
+    {ItemType: "wallet_topup", Description: "Wallet top-up (stored value)"},
+}
+
+// FinancialYear returns the Indian financial year (April to March) of t,
+// e.g. "2025-26".
+func FinancialYear(t time.Time) string {
+    start := t.Year()
+    if t.Month() < time.April {
+        start--
+    }
+    return fmt.Sprintf("%d-%02d", start, (start+1)%100)
+}
@@ -0,0 +1,92 @@
+package models
+
+import "time"
+
+// Spend that earns loyalty points.
+const (
+    LoyaltySourceBooking      = "booking"
+    LoyaltySourceSubscription = "subscription"
+    LoyaltySourceJourney      = "journey"
+    LoyaltySourceCheckout     = "checkout"
+)
+
+// Loyalty ledger entry types. Earn and reversal entries add points; redeem,
+// expire and clawback entries take them away. A clawback takes back points
+// earned on a payment that was later refunded.
+const (
+    LoyaltyEntryEarn     = "earn"
+    LoyaltyEntryRedeem   = "redeem"
+    LoyaltyEntryReversal = "reversal"
+    LoyaltyEntryExpire   = "expire"
+    LoyaltyEntryClawback = "clawback"
+)
+
+// States of a redeem entry. Points are held as pending when the payment is
+// created, confirmed once it is verified and reversed if it fails.
+const (
+    LoyaltyRedemptionPending   = "pending"
+    LoyaltyRedemptionConfirmed = "confirmed"
+    LoyaltyRedemptionReversed  = "reversed"
+)
+
+// LoyaltyProgramme holds the redemption and expiry rules. There is a single
+// row, created with these defaults on first use.
+type LoyaltyProgramme struct {
+    ProgrammeID      uint      `gorm:"primaryKey" json:"programme_id"`
+    // PointValue is the discount, in the base currency, one point is worth.
+    PointValue       float64   `gorm:"not null;default:0.1" json:"point_value"`
+    // ExpiryMonths is how long earned points last; 0 means they never expire.
+    ExpiryMonths     int       `gorm:"not null;default:12" json:"expiry_months"`
+    // MaxRedeemPercent caps the share of an order that points may pay for.
+    MaxRedeemPercent float64   `gorm:"not null;default:50" json:"max_redeem_percent"`
+    MinRedeemPoints  int       `gorm:"not null;default:100" json:"min_redeem_points"`
+    UpdatedAt        time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// LoyaltyEarnRate is the number of points earned per unit of base currency
+// spent, for one card type.
+type LoyaltyEarnRate struct {
+    RateID           uint      `gorm:"primaryKey;autoIncrement" json:"rate_id"`
+    CardType         string    `gorm:"size:20;not null;uniqueIndex" json:"card_type"`
+    BookingRate      float64   `gorm:"not null;default:0" json:"booking_rate"`
+    SubscriptionRate float64   `gorm:"not null;default:0" json:"subscription_rate"`
+    JourneyRate      float64   `gorm:"not null;default:0" json:"journey_rate"`
+    UpdatedAt        time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// DefaultLoyaltyEarnRates seed LoyaltyEarnRate on first start.
+var DefaultLoyaltyEarnRates = map[string]float64{
+    CardTypeOrdinary: 1,
+    CardTypeSilver:   1.5,
+    CardTypeGold:     2,
+}
+
+// LoyaltyLedgerEntry is one movement of a user's points. Reference identifies
+// what caused it, e.g. "payment:12", and makes each movement happen once.
+// Remaining is the part of an earn or reversal entry not yet redeemed or
+// expired; points are spent oldest-expiry first.
+type LoyaltyLedgerEntry struct {
+    EntryID     uint       `gorm:"primaryKey;autoIncrement" json:"entry_id"`
+    UserID      uint       `gorm:"not null;index" json:"user_id"`
+    Type        string     `gorm:"size:20;not null;uniqueIndex:idx_loyalty_reference" json:"type"`
+    Source      string     `gorm:"size:20" json:"source"`
+    Reference   string     `gorm:"size:64;not null;uniqueIndex:idx_loyalty_reference" json:"reference"`
+    Points      int        `gorm:"not null" json:"points"`
+    Remaining   int        `gorm:"not null;default:0" json:"-"`
+    // Amount is the spend that earned the points, or the discount they bought.
+    Amount      float64    `json:"amount"`
+    Status      string     `gorm:"size:20;index" json:"status,omitempty"`
+    PaymentID   *uint      `gorm:"index" json:"payment_id,omitempty"`
+    OrderID     string     `gorm:"size:64;index" json:"order_id,omitempty"`
+    ExpiresAt   *time.Time `gorm:"index" json:"expires_at,omitempty"`
+    Description string     `gorm:"size:255" json:"description"`
+    CreatedAt   time.Time  `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// LoyaltyBalance summarises a user's spendable points.
+type LoyaltyBalance struct {
+    Points          int        `json:"points"`
+    Value           float64    `json:"value"`
+    ExpiringPoints  int        `json:"expiring_points"`
+    NextExpiry      *time.Time `json:"next_expiry,omitempty"`
+}
@@ -0,0 +1,75 @@
+package models
+
+import "time"
+
+// Statement entry types.
+const (
+    StatementEntryTopup        = "topup"
+    StatementEntryJourney      = "journey"
+    StatementEntrySubscription = "subscription"
+    StatementEntryRefund       = "refund"
+    StatementEntryTransfer     = "transfer"
+)
+
+// NolCardJourney is a fare deducted from a card for one journey.
+type NolCardJourney struct {
+    JourneyID  uint      `gorm:"primaryKey;autoIncrement" json:"journey_id"`
+    NolCardID  int       `gorm:"not null;index" json:"nol_card_id"`
+    UserID     int       `gorm:"index" json:"user_id"`
+    RouteID    int       `gorm:"not null" json:"route_id"`
+    FromStopID int       `gorm:"not null" json:"from_stop_id"`
+    ToStopID   int       `gorm:"not null" json:"to_stop_id"`
+    CardType   string    `gorm:"size:20" json:"card_type"`
+    Fare       float64   `gorm:"not null" json:"fare"`
+    FullFare   float64   `json:"full_fare"`
+    // SubscriptionID is set when a subscription covered the journey.
+    SubscriptionID *uint `gorm:"index" json:"subscription_id,omitempty"`
+    CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// NolCardRefund is money credited back to a card by an admin, usually for an
+// overcharged journey.
+type NolCardRefund struct {
+    RefundID  uint      `gorm:"primaryKey;autoIncrement" json:"refund_id"`
+    NolCardID int       `gorm:"not null;index" json:"nol_card_id"`
+    JourneyID *uint     `gorm:"index" json:"journey_id,omitempty"`
+    Amount    float64   `gorm:"not null" json:"amount"`
+    Reason    string    `gorm:"size:255" json:"reason"`
+    AdminID   uint      `json:"admin_id"`
+    CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// StatementDispatch records that a monthly statement was emailed, so the job
+// never sends the same period twice.
+type StatementDispatch struct {
+    DispatchID uint      `gorm:"primaryKey;autoIncrement" json:"dispatch_id"`
+    NolCardID  int       `gorm:"not null;uniqueIndex:idx_statement_dispatch_period" json:"nol_card_id"`
+    Period     string    `gorm:"size:7;not null;uniqueIndex:idx_statement_dispatch_period" json:"period"`
+    SentAt     time.Time `gorm:"autoCreateTime" json:"sent_at"`
+}
+
+// StatementEntry is one line of a card statement. Subscription activations are
+// paid outside the card balance and appear with zero credit and debit.
+type StatementEntry struct {
+    Date        time.Time `json:"date"`
+    Type        string    `json:"type"`
+    Reference   string    `json:"reference"`
+    Description string    `json:"description"`
+    Credit      float64   `json:"credit"`
+    Debit       float64   `json:"debit"`
+    Balance     float64   `json:"balance"`
+}
+
+// NolCardStatement is a card's activity for a date range with running balances.
+type NolCardStatement struct {
+    NolCardID      int              `json:"nol_card_id"`
+    CardNumber     string           `json:"card_number"`
+    CardType       string           `json:"card_type"`
+    From           time.Time        `json:"from"`
+    To             time.Time        `json:"to"`
+    OpeningBalance float64          `json:"opening_balance"`
+    ClosingBalance float64          `json:"closing_balance"`
+    TotalCredits   float64          `json:"total_credits"`
+    TotalDebits    float64          `json:"total_debits"`
+    Entries        []StatementEntry `json:"entries"`
+}
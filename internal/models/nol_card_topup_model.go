@@ -6,10 +6,58 @@ type NolCard struct {
     NolCardID  int       `json:"nol_card_id" gorm:"primary_key;primaryKey;autoIncrement"`
     UserID     int       `json:"user_id"`
     CardNumber string    `json:"card_number"`
+    // LegacyCardNumber is the number a card had before it was reissued one
+    // from its type's series; lookups by number still accept it.
+    LegacyCardNumber string `gorm:"size:32;index" json:"legacy_card_number,omitempty"`
     Balance    float64   `json:"balance"`
     CreatedAt  time.Time `json:"created_at"`
     UpdatedAt  time.Time `json:"updated_at"`
     CardType   string    `json:"card_type"` 
+    Status          string     `gorm:"size:20;not null;default:'active'" json:"status"`
+    StatusReason    string     `gorm:"size:255" json:"status_reason,omitempty"`
+    StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+    ReplacedByID    *int       `json:"replaced_by_id,omitempty"`
+    ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+}
+
+// NolCard lifecycle states.
+const (
+    NolCardStatusIssued    = "issued"
+    NolCardStatusActive    = "active"
+    NolCardStatusBlocked   = "blocked"
+    NolCardStatusHotlisted = "hotlisted"
+    NolCardStatusReplaced  = "replaced"
+    NolCardStatusExpired   = "expired"
+)
+
+// NolCardUsableStatuses are the states in which a card may be topped up,
+// charged for a journey or used to pay. Cards migrated before the lifecycle
+// existed have an empty status and are treated as active.
+var NolCardUsableStatuses = []string{NolCardStatusIssued, NolCardStatusActive, ""}
+
+// IsUsable reports whether the card may take part in journeys and payments.
+func (n NolCard) IsUsable() bool {
+    for _, status := range NolCardUsableStatuses {
+        if n.Status == status {
+            return true
+        }
+    }
+    return false
+}
+
+// NolCardStatusEvent is the audit trail of lifecycle transitions.
+type NolCardStatusEvent struct {
+    EventID    uint      `gorm:"primaryKey;autoIncrement" json:"event_id"`
+    NolCardID  int       `gorm:"not null;index" json:"nol_card_id"`
+    FromStatus string    `gorm:"size:20" json:"from_status"`
+    ToStatus   string    `gorm:"size:20;not null" json:"to_status"`
+    Reason     string    `gorm:"size:255" json:"reason"`
+    ActorID    uint      `json:"actor_id"`
+    ActorRole  string    `gorm:"size:20" json:"actor_role"`
+    // BalanceMoved is the balance moved onto (positive) or off (negative) the
+    // card by the change, as when a card is replaced.
+    BalanceMoved float64 `gorm:"not null;default:0" json:"balance_moved,omitempty"`
+    CreatedAt  time.Time `gorm:"autoCreateTime" json:"created_at"`
 }
 
 type NolCardTopup struct {
@@ -17,6 +65,25 @@ type NolCardTopup struct {
     NolCardID  int       `json:"nol_card_id"`
     Amount     float64   `json:"amount"`
     TopupDate  time.Time `json:"topup_date"`
+    TransferID *uint     `json:"transfer_id,omitempty"`
     CreatedAt  time.Time `json:"created_at"`
     UpdatedAt  time.Time `json:"updated_at"`
 }
+
+// CardNumberSeries holds the configurable prefix used to generate card
+// numbers for a card type, and the next sequence number to issue.
+type CardNumberSeries struct {
+    SeriesID     uint      `gorm:"primaryKey;autoIncrement" json:"series_id"`
+    CardType     string    `gorm:"size:20;not null;uniqueIndex" json:"card_type"`
+    Prefix       string    `gorm:"size:8;not null" json:"prefix"`
+    NextSequence int64     `gorm:"not null;default:1" json:"next_sequence"`
+    CreatedAt    time.Time `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt    time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// DefaultCardNumberPrefixes seed CardNumberSeries on first start.
+var DefaultCardNumberPrefixes = map[string]string{
+    CardTypeOrdinary: "6010",
+    CardTypeSilver:   "6020",
+    CardTypeGold:     "6030",
+}
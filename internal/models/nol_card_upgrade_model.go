@@ -0,0 +1,68 @@
+package models
+
+import (
+    "strings"
+    "time"
+)
+
+const (
+    CardTypeOrdinary = "Ordinary"
+    CardTypeSilver   = "Silver"
+    CardTypeGold     = "Gold"
+
+    PaymentTypeCardUpgrade = "card_upgrade"
+
+    UpgradeStatusPending   = "pending"
+    UpgradeStatusCompleted = "completed"
+    UpgradeStatusCancelled = "cancelled"
+)
+
+// CardTierRank orders the card types from lowest to highest tier.
+var CardTierRank = map[string]int{
+    CardTypeOrdinary: 1,
+    CardTypeSilver:   2,
+    CardTypeGold:     3,
+}
+
+// NormalizeCardType maps any casing of a card type to its canonical name.
+func NormalizeCardType(cardType string) (string, bool) {
+    for name := range CardTierRank {
+        if strings.EqualFold(name, strings.TrimSpace(cardType)) {
+            return name, true
+        }
+    }
+    return "", false
+}
+
+// NolCardUpgradeRule is the admin-configured price and eligibility for moving
+// a card from one tier to a higher one.
+type NolCardUpgradeRule struct {
+    UpgradeRuleID   uint      `gorm:"primaryKey;autoIncrement" json:"upgrade_rule_id"`
+    FromCardType    string    `gorm:"size:20;not null;uniqueIndex:idx_upgrade_rule_tiers" json:"from_card_type"`
+    ToCardType      string    `gorm:"size:20;not null;uniqueIndex:idx_upgrade_rule_tiers" json:"to_card_type"`
+    Price           float64   `gorm:"not null" json:"price"`
+    MinSpend        float64   `gorm:"default:0" json:"min_spend"`
+    SpendWindowDays int       `gorm:"default:90" json:"spend_window_days"`
+    MinCardAgeDays  int       `gorm:"default:0" json:"min_card_age_days"`
+    Enabled         bool      `gorm:"default:true" json:"enabled"`
+    CreatedAt       time.Time `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt       time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// NolCardUpgrade is a user's upgrade request. The card type only changes once
+// the linked card_upgrade payment is verified.
+type NolCardUpgrade struct {
+    UpgradeID     uint       `gorm:"primaryKey;autoIncrement" json:"upgrade_id"`
+    NolCardID     int        `gorm:"not null;index" json:"nol_card_id"`
+    UserID        uint       `gorm:"not null;index" json:"user_id"`
+    UpgradeRuleID uint       `json:"upgrade_rule_id"`
+    FromCardType  string     `gorm:"size:20;not null" json:"from_card_type"`
+    ToCardType    string     `gorm:"size:20;not null" json:"to_card_type"`
+    Price         float64    `gorm:"not null" json:"price"`
+    Status        string     `gorm:"size:20;not null" json:"status"`
+    PaymentID     *uint      `json:"payment_id,omitempty"`
+    OrderID       string     `gorm:"size:100" json:"order_id,omitempty"`
+    CompletedAt   *time.Time `json:"completed_at,omitempty"`
+    CreatedAt     time.Time  `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt     time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
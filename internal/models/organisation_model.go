@@ -0,0 +1,102 @@
+package models
+
+import "time"
+
+// Organisation billing modes.
+const (
+    BillingModePrepaid  = "prepaid"
+    BillingModePostpaid = "postpaid"
+)
+
+// Organisation member roles and states.
+const (
+    OrganisationRoleAdmin    = "admin"
+    OrganisationRoleEmployee = "employee"
+
+    MemberStatusActive  = "active"
+    MemberStatusRemoved = "removed"
+
+    InviteStatusPending  = "pending"
+    InviteStatusAccepted = "accepted"
+    InviteStatusRevoked  = "revoked"
+)
+
+// Kinds of spend an organisation funds for its members.
+const (
+    OrganisationChargeSubscription = "subscription"
+    OrganisationChargeTopup        = "nol_card_topup"
+)
+
+// Organisation is an employer account that pays for its members' travel.
+// Prepaid organisations spend from Balance; postpaid ones accrue Outstanding
+// up to CreditLimit and settle the monthly invoice.
+type Organisation struct {
+    OrganisationID uint      `gorm:"primaryKey;autoIncrement" json:"organisation_id"`
+    Name           string    `gorm:"size:150;not null" json:"name"`
+    EmailDomain    string    `gorm:"size:255;index" json:"email_domain"`
+    BillingMode    string    `gorm:"size:20;not null;default:'prepaid'" json:"billing_mode"`
+    Balance        float64   `gorm:"not null;default:0" json:"balance"`
+    CreditLimit    float64   `gorm:"not null;default:0" json:"credit_limit"`
+    Outstanding    float64   `gorm:"not null;default:0" json:"outstanding"`
+    BillingEmail   string    `gorm:"size:255" json:"billing_email"`
+    // GSTIN, BillingAddress and StateCode are printed as the buyer on its invoices.
+    GSTIN          string    `gorm:"size:15" json:"gstin"`
+    BillingAddress string    `gorm:"size:255" json:"billing_address"`
+    StateCode      string    `gorm:"size:2" json:"state_code"`
+    CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type OrganisationMember struct {
+    MemberID       uint      `gorm:"primaryKey;autoIncrement" json:"member_id"`
+    OrganisationID uint      `gorm:"not null;uniqueIndex:idx_organisation_member" json:"organisation_id"`
+    UserID         uint      `gorm:"not null;uniqueIndex:idx_organisation_member;index" json:"user_id"`
+    Role           string    `gorm:"size:20;not null;default:'employee'" json:"role"`
+    Status         string    `gorm:"size:20;not null;default:'active'" json:"status"`
+    // JoinedVia is "domain", "invite" or "admin".
+    JoinedVia      string    `gorm:"size:20" json:"joined_via"`
+    CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt      time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+type OrganisationInvite struct {
+    InviteID       uint      `gorm:"primaryKey;autoIncrement" json:"invite_id"`
+    OrganisationID uint      `gorm:"not null;index" json:"organisation_id"`
+    Email          string    `gorm:"size:255;not null" json:"email"`
+    Token          string    `gorm:"size:64;not null;uniqueIndex" json:"-"`
+    Role           string    `gorm:"size:20;not null" json:"role"`
+    Status         string    `gorm:"size:20;not null;default:'pending'" json:"status"`
+    InvitedBy      uint      `json:"invited_by"`
+    ExpiresAt      time.Time `json:"expires_at"`
+    CreatedAt      time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// OrganisationCharge is one purchase funded by the organisation for a member.
+// InvoiceID is set once the charge is billed on a monthly invoice.
+type OrganisationCharge struct {
+    ChargeID       uint      `gorm:"primaryKey;autoIncrement" json:"charge_id"`
+    OrganisationID uint      `gorm:"not null;index" json:"organisation_id"`
+    UserID         uint      `gorm:"not null;index" json:"user_id"`
+    Type           string    `gorm:"size:30;not null" json:"type"`
+    Amount         float64   `gorm:"not null" json:"amount"`
+    NolCardID      int       `json:"nol_card_id"`
+    SubscriptionID *uint     `json:"subscription_id,omitempty"`
+    TopupID        *int      `json:"topup_id,omitempty"`
+    ActorID        uint      `json:"actor_id"`
+    InvoiceID      *uint     `gorm:"index" json:"invoice_id,omitempty"`
+    CreatedAt      time.Time `gorm:"autoCreateTime;index" json:"created_at"`
+}
+
+// OrganisationUsage is one employee's line in the usage report.
+type OrganisationUsage struct {
+    UserID            uint    `json:"user_id"`
+    Name              string  `json:"name"`
+    Email             string  `json:"email"`
+    Subscriptions     int     `json:"subscriptions"`
+    SubscriptionSpend float64 `json:"subscription_spend"`
+    Topups            int     `json:"topups"`
+    TopupSpend        float64 `json:"topup_spend"`
+    Journeys          int     `json:"journeys"`
+    JourneyFares      float64 `json:"journey_fares"`
+    Total             float64 `json:"total"`
+}
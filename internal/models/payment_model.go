@@ -1,9 +1,38 @@
 package models
 
 import (
+    "strings"
     "time"
 )
 
+// Payment statuses. A payment is "verified" once the money has been captured,
+// whether through the gateway or from the wallet, and "refunded" once all of it
+// has been given back. Partial refunds leave it verified with RefundedAmount set.
+// A failed payment whose captured money could not be refunded is left
+// "refund_pending" until someone refunds it.
+const (
+    PaymentStatusCreated       = "created"
+    PaymentStatusVerified      = "verified"
+    PaymentStatusFailed        = "failed"
+    PaymentStatusRefunded      = "refunded"
+    PaymentStatusRefundPending = "refund_pending"
+)
+
+// RevenuePaymentStatuses are the statuses of payments that took money.
+var RevenuePaymentStatuses = []string{PaymentStatusVerified, PaymentStatusRefunded}
+
+// NormalizePaymentStatus maps gateway and legacy spellings onto the canonical
+// payment statuses. Razorpay reports a settled payment as "captured".
+func NormalizePaymentStatus(status string) string {
+    switch status = strings.ToLower(strings.TrimSpace(status)); status {
+    case "captured", "paid", PaymentStatusVerified:
+        return PaymentStatusVerified
+    case "", PaymentStatusCreated:
+        return PaymentStatusCreated
+    }
+    return status
+}
+
 type RazorpayPayment struct {
     PaymentID      uint    `gorm:"primaryKey;autoIncrement" json:"payment_id"`
     UserID         uint      `json:"user_id"`
@@ -11,7 +40,12 @@ type RazorpayPayment struct {
     OrderID        string    `json:"order_id"`    
     Amount         float64   `json:"amount"`      
     Currency       string    `json:"currency"`    
+    SettledAmount   float64   `json:"settled_amount"`
+    SettledCurrency string    `json:"settled_currency"`
+    ExchangeRate    float64   `gorm:"default:1" json:"exchange_rate"`
     Status         string    `json:"status"`     
+    // RefundedAmount is in Currency, like Amount.
+    RefundedAmount float64   `gorm:"default:0" json:"refunded_amount"`
     Method         string    `json:"method"`      
     CouponCode     string    `json:"coupon_code"`
     WalletID       *uint      `json:"wallet_id"`     
@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+// Referral states. A referral is pending until the referee verifies their
+// OTP, verified until their first paid booking and rewarded once both wallets
+// are credited. Attributions that trip a fraud guard are kept as rejected.
+const (
+    ReferralStatusPending  = "pending"
+    ReferralStatusVerified = "verified"
+    ReferralStatusRewarded = "rewarded"
+    ReferralStatusRejected = "rejected"
+)
+
+// WalletTransactionReferralReward is the wallet transaction type used for
+// referral credits.
+const WalletTransactionReferralReward = "referral_reward"
+
+// ReferralCode is the shareable code of a user, created on first request.
+type ReferralCode struct {
+    UserID    uint      `gorm:"primaryKey" json:"user_id"`
+    Code      string    `gorm:"size:16;not null;uniqueIndex" json:"code"`
+    CreatedAt time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Referral links a new user to the user whose code they signed up with.
+// DeviceID is the client's device identifier, or its IP when none was sent.
+type Referral struct {
+    ReferralID     uint       `gorm:"primaryKey;autoIncrement" json:"referral_id"`
+    ReferrerID     uint       `gorm:"not null;index" json:"referrer_id"`
+    RefereeID      uint       `gorm:"not null;uniqueIndex" json:"referee_id"`
+    Code           string     `gorm:"size:16;not null" json:"code"`
+    Status         string     `gorm:"size:20;not null;index" json:"status"`
+    RejectReason   string     `gorm:"size:255" json:"reject_reason,omitempty"`
+    DeviceID       string     `gorm:"size:128;index" json:"-"`
+    EmailDomain    string     `gorm:"size:255;index" json:"-"`
+    ReferrerReward float64    `gorm:"default:0" json:"referrer_reward"`
+    RefereeReward  float64    `gorm:"default:0" json:"referee_reward"`
+    PaymentID      *uint      `json:"payment_id,omitempty"`
+    VerifiedAt     *time.Time `json:"verified_at,omitempty"`
+    RewardedAt     *time.Time `json:"rewarded_at,omitempty"`
+    CreatedAt      time.Time  `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt      time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// ReferralProgramme holds the reward rules. There is a single row, seeded with
+// these defaults on startup. A limit of 0 disables that guard.
+type ReferralProgramme struct {
+    ProgrammeID           uint      `gorm:"primaryKey" json:"programme_id"`
+    Active                bool      `gorm:"not null;default:true" json:"active"`
+    ReferrerReward        float64   `gorm:"not null;default:100" json:"referrer_reward"`
+    RefereeReward         float64   `gorm:"not null;default:50" json:"referee_reward"`
+    // MinBookingAmount is the smallest first booking that qualifies.
+    MinBookingAmount      float64   `gorm:"not null;default:0" json:"min_booking_amount"`
+    // RewardWindowDays is how long after signing up the referee has to book.
+    RewardWindowDays      int       `gorm:"not null;default:90" json:"reward_window_days"`
+    MaxReferralsPerDevice int       `gorm:"not null;default:1" json:"max_referrals_per_device"`
+    // MaxReferralsPerDomain caps referees one referrer brings from a single
+    // non-public email domain.
+    MaxReferralsPerDomain int       `gorm:"not null;default:5" json:"max_referrals_per_domain"`
+    UpdatedAt             time.Time `gorm:"autoUpdateTime" json:"updated_at"`
+}
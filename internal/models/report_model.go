@@ -0,0 +1,116 @@
+package models
+
+import (
+    "time"
+)
+
+// Report types that can be scheduled.
+const (
+    ReportTypeRevenue               = "revenue"
+    ReportTypeRefunds               = "refunds"
+    ReportTypeReconciliation        = "reconciliation"
+    ReportTypeRidership             = "ridership"
+    ReportTypeExpiringSubscriptions = "expiring_subscriptions"
+)
+
+var ReportTypes = []string{
+    ReportTypeRevenue, ReportTypeRefunds, ReportTypeReconciliation,
+    ReportTypeRidership, ReportTypeExpiringSubscriptions,
+}
+
+// Report periods. A run reports on the last complete period before it, e.g.
+// yesterday for a daily report run this morning.
+const (
+    ReportPeriodDay   = "day"
+    ReportPeriodWeek  = "week"
+    ReportPeriodMonth = "month"
+)
+
+// Report run statuses.
+const (
+    ReportRunRunning = "running"
+    ReportRunSuccess = "success"
+    ReportRunFailed  = "failed"
+)
+
+// ReportSchedule emails a report to its recipients each time Cron fires.
+type ReportSchedule struct {
+    ScheduleID uint       `gorm:"primaryKey;autoIncrement" json:"schedule_id"`
+    AdminID    uint       `gorm:"not null;index" json:"admin_id"`
+    ReportType string     `gorm:"size:30;not null" json:"report_type"`
+    // Cron is a five-field cron expression evaluated in server time.
+    Cron       string     `gorm:"size:100;not null" json:"cron"`
+    Period     string     `gorm:"size:10;not null;default:'day'" json:"period"`
+    // Recipients is a comma-separated list of email addresses.
+    Recipients string     `gorm:"size:1000;not null" json:"recipients"`
+    Active     bool       `gorm:"not null;default:true" json:"active"`
+    NextRunAt  *time.Time `gorm:"index" json:"next_run_at"`
+    LastRunAt  *time.Time `json:"last_run_at,omitempty"`
+    CreatedAt  time.Time  `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt  time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
+}
+
+// ReportRun is one generation of a report, scheduled or run by hand. The CSV
+// and PDF are kept on disk for download.
+type ReportRun struct {
+    RunID      uint       `gorm:"primaryKey;autoIncrement" json:"run_id"`
+    ScheduleID *uint      `gorm:"index" json:"schedule_id,omitempty"`
+    ReportType string     `gorm:"size:30;not null" json:"report_type"`
+    PeriodFrom time.Time  `json:"period_from"`
+    PeriodTo   time.Time  `json:"period_to"`
+    Status     string     `gorm:"size:20;not null;index" json:"status"`
+    Error      string     `gorm:"size:500" json:"error,omitempty"`
+    Rows       int        `gorm:"default:0" json:"rows"`
+    Recipients string     `gorm:"size:1000" json:"recipients"`
+    CSVFile    string     `gorm:"size:255" json:"-"`
+    PDFFile    string     `gorm:"size:255" json:"-"`
+    // Links are the download URLs of the output, filled in when returned.
+    Links      map[string]string `gorm:"-" json:"links,omitempty"`
+    StartedAt  time.Time  `json:"started_at"`
+    FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ReportTable is a rendered report before it is written out as CSV or PDF.
+type ReportTable struct {
+    Title   string
+    Columns []string
+    Rows    [][]string
+}
+
+// RefundReportRow is a payment with money refunded or still to refund.
+type RefundReportRow struct {
+    PaymentID      uint
+    OrderID        string
+    UserID         uint
+    PaymentType    string
+    Method         string
+    Currency       string
+    Amount         float64
+    RefundedAmount float64
+    Status         string
+    UpdatedAt      time.Time
+}
+
+// ReconciliationException is a payment whose invoice is missing or does not
+// agree with it.
+type ReconciliationException struct {
+    PaymentID     uint
+    OrderID       string
+    PaymentType   string
+    PaymentStatus string
+    PaymentAmount float64
+    InvoiceNumber string
+    InvoiceAmount float64
+    Issue         string
+}
+
+// ExpiringSubscription is a live subscription that ends soon.
+type ExpiringSubscription struct {
+    SubscriptionID uint
+    UserID         uint
+    Email          string
+    PlanName       string
+    CardType       string
+    EndDate        time.Time
+    AutoRenew      bool
+}
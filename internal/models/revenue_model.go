@@ -0,0 +1,53 @@
+package models
+
+import (
+    "time"
+)
+
+// Revenue groupings. Day, week and month give a time series; the rest break
+// revenue down by what was sold.
+const (
+    RevenueByDay         = "day"
+    RevenueByWeek        = "week"
+    RevenueByMonth       = "month"
+    RevenueByPaymentType = "payment_type"
+    RevenueByCategory    = "category"
+    RevenueByRoute       = "route"
+    RevenueByCardType    = "card_type"
+    RevenueByCoupon      = "coupon"
+)
+
+// RevenueGroupings lists every grouping the revenue report accepts.
+var RevenueGroupings = []string{
+    RevenueByDay, RevenueByWeek, RevenueByMonth, RevenueByPaymentType,
+    RevenueByCategory, RevenueByRoute, RevenueByCardType, RevenueByCoupon,
+}
+
+// RevenueFilter selects payments made in [From, To). PaymentType is optional.
+type RevenueFilter struct {
+    From        time.Time
+    To          time.Time
+    GroupBy     string
+    PaymentType string
+}
+
+// RevenueRow is the revenue of one group in the base currency. Gross is the
+// price before coupon and points discounts; Net is what was kept after
+// discounts and refunds.
+type RevenueRow struct {
+    Key            string  `json:"key"`
+    Payments       int64   `json:"payments"`
+    GrossAmount    float64 `json:"gross_amount"`
+    DiscountAmount float64 `json:"discount_amount"`
+    RefundAmount   float64 `json:"refund_amount"`
+    NetAmount      float64 `json:"net_amount"`
+}
+
+type RevenueReport struct {
+    From        time.Time    `json:"from"`
+    To          time.Time    `json:"to"`
+    GroupBy     string       `json:"group_by"`
+    PaymentType string       `json:"payment_type,omitempty"`
+    Rows        []RevenueRow `json:"rows"`
+    Total       RevenueRow   `json:"total"`
+}
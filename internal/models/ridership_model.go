@@ -0,0 +1,96 @@
+package models
+
+import (
+    "time"
+)
+
+// Ridership sources. A booking has no stops of its own and counts as a trip
+// over its whole route; journeys covered by a subscription count as
+// subscription usage.
+const (
+    RidershipSourceBooking      = "booking"
+    RidershipSourceNolCard      = "nolcard"
+    RidershipSourceSubscription = "subscription"
+)
+
+var RidershipSources = []string{RidershipSourceBooking, RidershipSourceNolCard, RidershipSourceSubscription}
+
+// RidershipStopHour is the rollup of boardings and alightings at a stop on a
+// route in one hour. Rollups are rebuilt a day at a time by the ridership job.
+type RidershipStopHour struct {
+    Date        time.Time `gorm:"type:date;primaryKey" json:"date"`
+    Hour        int       `gorm:"primaryKey" json:"hour"`
+    RouteID     int       `gorm:"primaryKey" json:"route_id"`
+    StopID      int       `gorm:"primaryKey" json:"stop_id"`
+    Source      string    `gorm:"primaryKey;size:20" json:"source"`
+    Boardings   int64     `gorm:"not null;default:0" json:"boardings"`
+    Alightings  int64     `gorm:"not null;default:0" json:"alightings"`
+    RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+// RidershipODDay is the rollup of trips between two stops on a route in one day.
+type RidershipODDay struct {
+    Date        time.Time `gorm:"type:date;primaryKey" json:"date"`
+    RouteID     int       `gorm:"primaryKey" json:"route_id"`
+    FromStopID  int       `gorm:"primaryKey" json:"from_stop_id"`
+    ToStopID    int       `gorm:"primaryKey" json:"to_stop_id"`
+    Source      string    `gorm:"primaryKey;size:20" json:"source"`
+    Trips       int64     `gorm:"not null;default:0" json:"trips"`
+    RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+// RidershipFilter selects rollup days in [From, To). RouteID, StopID and
+// Source are optional.
+type RidershipFilter struct {
+    From    time.Time
+    To      time.Time
+    RouteID int
+    StopID  int
+    Source  string
+}
+
+type StopHourRidership struct {
+    StopID     int    `json:"stop_id"`
+    StopName   string `json:"stop_name"`
+    Hour       int    `json:"hour"`
+    Boardings  int64  `json:"boardings"`
+    Alightings int64  `json:"alightings"`
+}
+
+type ODPair struct {
+    FromStopID   int    `json:"from_stop_id"`
+    FromStopName string `json:"from_stop_name"`
+    ToStopID     int    `json:"to_stop_id"`
+    ToStopName   string `json:"to_stop_name"`
+    Trips        int64  `json:"trips"`
+}
+
+// Route directions. Outbound runs in increasing stop sequence.
+const (
+    DirectionOutbound = "outbound"
+    DirectionInbound  = "inbound"
+)
+
+// RouteLoadPoint is the passenger load leaving a stop, in travel order.
+type RouteLoadPoint struct {
+    StopSequence int    `json:"stop_sequence"`
+    StopID       int    `json:"stop_id"`
+    StopName     string `json:"stop_name"`
+    Boardings    int64  `json:"boardings"`
+    Alightings   int64  `json:"alightings"`
+    Load         int64  `json:"load"`
+}
+
+type RouteLoadProfile struct {
+    RouteID   int              `json:"route_id"`
+    Direction string           `json:"direction"`
+    Points    []RouteLoadPoint `json:"points"`
+    PeakLoad  int64            `json:"peak_load"`
+}
+
+// HeatmapCell is the boardings in one hour of one weekday (0 is Sunday).
+type HeatmapCell struct {
+    Weekday   int   `json:"weekday"`
+    Hour      int   `json:"hour"`
+    Boardings int64 `json:"boardings"`
+}
@@ -19,6 +19,8 @@ type User struct {
     BlockedStatus bool   `gorm:"default:false"`
     InactiveStatus bool  `gorm:"default:false"`
     SuspendedAt    *time.Time
+    // EmailVerifiedAt is set once the sign-up OTP sent to Email is verified.
+    EmailVerifiedAt *time.Time
 }
 
 type AuthInput struct {
@@ -26,6 +28,9 @@ type AuthInput struct {
     LastName  string `json:"last_name"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+    // Optional; the code of the user who referred this sign-up.
+    ReferralCode string `json:"referral_code"`
+    DeviceID     string `json:"device_id"`
 }
 
 type TokenDetails struct {
@@ -15,9 +15,40 @@ type Wallet struct {
 type WalletTransaction struct {
     TransactionID uint      `gorm:"primaryKey;autoIncrement" json:"transaction_id"`
     WalletID      uint      `gorm:"not null" json:"wallet_id"`
-    AdminID       *uint      `gorm:"not null" json:"admin_id"` 
+    AdminID       *uint      `json:"admin_id"` 
     Amount        float64   `gorm:"not null" json:"amount"`
     TransactionType          string    `gorm:"not null" json:"type"`  
     Description   string    `gorm:"size:255" json:"description"`
+    TransferID    *uint     `gorm:"index" json:"transfer_id,omitempty"`
+    SubscriptionID *uint    `gorm:"index" json:"subscription_id,omitempty"`
     CreatedAt     time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+const (
+    TransferToWallet  = "wallet"
+    TransferToNolCard = "nol_card"
+
+    TransferStatusPending   = "pending"
+    TransferStatusCompleted = "completed"
+    TransferStatusCancelled = "cancelled"
+)
+
+// WalletTransfer is a user-initiated move of wallet balance to another
+// wallet or to a NolCard. It stays pending until the sender confirms the OTP.
+type WalletTransfer struct {
+    TransferID         uint       `gorm:"primaryKey;autoIncrement" json:"transfer_id"`
+    SenderUserID       uint       `gorm:"not null;index" json:"sender_user_id"`
+    SenderWalletID     uint       `gorm:"not null" json:"sender_wallet_id"`
+    RecipientType      string     `gorm:"size:20;not null" json:"recipient_type"`
+    RecipientWalletID  *uint      `json:"recipient_wallet_id,omitempty"`
+    RecipientNolCardID *int       `json:"recipient_nol_card_id,omitempty"`
+    Amount             float64    `gorm:"not null" json:"amount"`
+    Note               string     `gorm:"size:255" json:"note"`
+    Status             string     `gorm:"size:20;not null;default:'pending'" json:"status"`
+    OTP                string     `gorm:"size:10" json:"-"`
+    OTPExpiry          time.Time  `json:"-"`
+    OTPAttempts        int        `gorm:"default:0" json:"-"`
+    CompletedAt        *time.Time `json:"completed_at,omitempty"`
+    CreatedAt          time.Time  `gorm:"autoCreateTime" json:"created_at"`
+    UpdatedAt          time.Time  `gorm:"autoUpdateTime" json:"updated_at"`
 }
\ No newline at end of file
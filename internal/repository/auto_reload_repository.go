@@ -0,0 +1,139 @@
+package repository
+
+import (
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+type AutoReloadRepository interface {
+    CreateRule(rule *models.AutoReloadRule) error
+    UpdateRule(rule *models.AutoReloadRule) error
+    DeleteRule(ruleID, userID uint) error
+    GetRuleByID(ruleID uint) (*models.AutoReloadRule, error)
+    GetRulesByUserID(userID uint) ([]models.AutoReloadRule, error)
+    GetEnabledRules() ([]models.AutoReloadRule, error)
+    GetEnabledRulesForTarget(targetType string, targetID uint) ([]models.AutoReloadRule, error)
+    ClaimRule(ruleID uint) (bool, error)
+    ReleaseRule(ruleID uint, reloaded bool) error
+
+    CreateEvent(event *models.AutoReloadEvent) error
+    UpdateEvent(event *models.AutoReloadEvent) error
+    GetEventsByRuleID(ruleID uint) ([]models.AutoReloadEvent, error)
+    GetPendingEvents() ([]models.AutoReloadEvent, error)
+    SumReloadsSince(ruleID uint, since time.Time) (float64, error)
+}
+
+type autoReloadRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewAutoReloadRepository(db *gorm.DB) AutoReloadRepository {
+    return &autoReloadRepositoryImpl{DB: db}
+}
+
+func (r *autoReloadRepositoryImpl) CreateRule(rule *models.AutoReloadRule) error {
+    return r.DB.Create(rule).Error
+}
+
+func (r *autoReloadRepositoryImpl) UpdateRule(rule *models.AutoReloadRule) error {
+    return r.DB.Model(&models.AutoReloadRule{}).
+        Where("auto_reload_rule_id = ?", rule.AutoReloadRuleID).
+        Select("threshold", "reload_amount", "funding_source", "mandate_customer_id", "mandate_token_id", "monthly_cap", "enabled").
+        Updates(rule).Error
+}
+
+func (r *autoReloadRepositoryImpl) DeleteRule(ruleID, userID uint) error {
+    result := r.DB.Where("auto_reload_rule_id = ? AND user_id = ?", ruleID, userID).Delete(&models.AutoReloadRule{})
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
+
+func (r *autoReloadRepositoryImpl) GetRuleByID(ruleID uint) (*models.AutoReloadRule, error) {
+    var rule models.AutoReloadRule
+    if err := r.DB.Where("auto_reload_rule_id = ?", ruleID).First(&rule).Error; err != nil {
+        return nil, err
+    }
+    return &rule, nil
+}
+
+func (r *autoReloadRepositoryImpl) GetRulesByUserID(userID uint) ([]models.AutoReloadRule, error) {
+    var rules []models.AutoReloadRule
+    err := r.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&rules).Error
+    return rules, err
+}
+
+func (r *autoReloadRepositoryImpl) GetEnabledRules() ([]models.AutoReloadRule, error) {
+    var rules []models.AutoReloadRule
+    err := r.DB.Where("enabled = ? AND in_progress = ?", true, false).Find(&rules).Error
+    return rules, err
+}
+
+func (r *autoReloadRepositoryImpl) GetEnabledRulesForTarget(targetType string, targetID uint) ([]models.AutoReloadRule, error) {
+    var rules []models.AutoReloadRule
+    query := r.DB.Where("enabled = ? AND in_progress = ? AND target_type = ?", true, false, targetType)
+    if targetType == models.AutoReloadTargetWallet {
+        query = query.Where("wallet_id = ?", targetID)
+    } else {
+        query = query.Where("nol_card_id = ?", targetID)
+    }
+    err := query.Find(&rules).Error
+    return rules, err
+}
+
+// ClaimRule marks a rule as in progress. It returns false when another worker
+// already holds it, so a balance drop never triggers two reloads.
+func (r *autoReloadRepositoryImpl) ClaimRule(ruleID uint) (bool, error) {
+    result := r.DB.Model(&models.AutoReloadRule{}).
+        Where("auto_reload_rule_id = ? AND in_progress = ?", ruleID, false).
+        Update("in_progress", true)
+    if result.Error != nil {
+        return false, result.Error
+    }
+    return result.RowsAffected == 1, nil
+}
+
+func (r *autoReloadRepositoryImpl) ReleaseRule(ruleID uint, reloaded bool) error {
+    updates := map[string]interface{}{"in_progress": false}
+    if reloaded {
+        updates["last_reload_at"] = time.Now()
+    }
+    return r.DB.Model(&models.AutoReloadRule{}).Where("auto_reload_rule_id = ?", ruleID).Updates(updates).Error
+}
+
+func (r *autoReloadRepositoryImpl) CreateEvent(event *models.AutoReloadEvent) error {
+    return r.DB.Create(event).Error
+}
+
+func (r *autoReloadRepositoryImpl) UpdateEvent(event *models.AutoReloadEvent) error {
+    return r.DB.Save(event).Error
+}
+
+func (r *autoReloadRepositoryImpl) GetEventsByRuleID(ruleID uint) ([]models.AutoReloadEvent, error) {
+    var events []models.AutoReloadEvent
+    err := r.DB.Where("auto_reload_rule_id = ?", ruleID).Order("created_at DESC").Find(&events).Error
+    return events, err
+}
+
+func (r *autoReloadRepositoryImpl) GetPendingEvents() ([]models.AutoReloadEvent, error) {
+    var events []models.AutoReloadEvent
+    err := r.DB.Where("status = ?", models.AutoReloadStatusPending).Find(&events).Error
+    return events, err
+}
+
+// SumReloadsSince counts successful and in-flight reloads against the monthly cap.
+func (r *autoReloadRepositoryImpl) SumReloadsSince(ruleID uint, since time.Time) (float64, error) {
+    var total float64
+    err := r.DB.Model(&models.AutoReloadEvent{}).
+        Where("auto_reload_rule_id = ? AND created_at >= ? AND status IN ?",
+            ruleID, since, []string{models.AutoReloadStatusPending, models.AutoReloadStatusSuccess}).
+        Select("COALESCE(SUM(amount), 0)").
+        Scan(&total).Error
+    return total, err
+}
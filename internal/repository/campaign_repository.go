@@ -0,0 +1,137 @@
+package repository
+
+import (
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+// Codes are inserted and looked up in chunks of this size.
+const campaignCodeBatchSize = 1000
+
+type CampaignRepository interface {
+    CreateCampaign(campaign *models.Campaign) error
+    GetCampaignByID(campaignID uint) (*models.Campaign, error)
+    GetCampaigns() ([]models.Campaign, error)
+    SetActive(campaignID uint, active bool) error
+    ExistingCodes(codes []string) (map[string]bool, error)
+    CreateCoupons(coupons []models.Coupon) error
+    GetCampaignCodes(campaignID uint) ([]models.CampaignCode, error)
+    GetCampaignStats(campaignID uint) (*models.CampaignStats, error)
+}
+
+type campaignRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewCampaignRepository(db *gorm.DB) CampaignRepository {
+    return &campaignRepositoryImpl{DB: db}
+}
+
+func (r *campaignRepositoryImpl) CreateCampaign(campaign *models.Campaign) error {
+    return r.DB.Create(campaign).Error
+}
+
+func (r *campaignRepositoryImpl) GetCampaignByID(campaignID uint) (*models.Campaign, error) {
+    var campaign models.Campaign
+    if err := r.DB.Where("campaign_id = ?", campaignID).First(&campaign).Error; err != nil {
+        return nil, err
+    }
+    return &campaign, nil
+}
+
+func (r *campaignRepositoryImpl) GetCampaigns() ([]models.Campaign, error) {
+    var campaigns []models.Campaign
+    err := r.DB.Order("created_at DESC").Find(&campaigns).Error
+    return campaigns, err
+}
+
+func (r *campaignRepositoryImpl) SetActive(campaignID uint, active bool) error {
+    result := r.DB.Model(&models.Campaign{}).Where("campaign_id = ?", campaignID).Update("active", active)
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
+
+// ExistingCodes returns which of codes are already used by a coupon.
+func (r *campaignRepositoryImpl) ExistingCodes(codes []string) (map[string]bool, error) {
+    existing := make(map[string]bool)
+    for start := 0; start < len(codes); start += campaignCodeBatchSize {
+        end := start + campaignCodeBatchSize
+        if end > len(codes) {
+            end = len(codes)
+        }
+        var found []string
+        if err := r.DB.Model(&models.Coupon{}).Where("code IN ?", codes[start:end]).Pluck("code", &found).Error; err != nil {
+            return nil, err
+        }
+        for _, code := range found {
+            existing[code] = true
+        }
+    }
+    return existing, nil
+}
+
+// CreateCoupons inserts a generated batch in one transaction, so a failed
+// generation leaves no partial batch behind. It fails with
+// ErrDuplicateCouponCode if any code has been taken since it was checked.
+func (r *campaignRepositoryImpl) CreateCoupons(coupons []models.Coupon) error {
+    return couponCodeError(r.DB.Transaction(func(tx *gorm.DB) error {
+        return tx.CreateInBatches(coupons, campaignCodeBatchSize).Error
+    }))
+}
+
+func (r *campaignRepositoryImpl) GetCampaignCodes(campaignID uint) ([]models.CampaignCode, error) {
+    var codes []models.CampaignCode
+    err := r.DB.Table("coupons").
+        Select("coupons.coupon_id, coupons.code, coupons.usage_limit, coupons.end_date, COUNT(coupon_redemptions.redemption_id) AS redemptions").
+        Joins("LEFT JOIN coupon_redemptions ON coupon_redemptions.coupon_id = coupons.coupon_id AND coupon_redemptions.status = ?", models.RedemptionStatusRedeemed).
+        Where("coupons.campaign_id = ?", campaignID).
+        Group("coupons.coupon_id, coupons.code, coupons.usage_limit, coupons.end_date").
+        Order("coupons.coupon_id").
+        Scan(&codes).Error
+    return codes, err
+}
+
+func (r *campaignRepositoryImpl) GetCampaignStats(campaignID uint) (*models.CampaignStats, error) {
+    stats := &models.CampaignStats{CampaignID: campaignID}
+    if err := r.DB.Model(&models.Coupon{}).Where("campaign_id = ?", campaignID).Count(&stats.Codes).Error; err != nil {
+        return nil, err
+    }
+
+    var totals struct {
+        CodesRedeemed int64
+        Redemptions   int64
+        DiscountGiven float64
+        GrossRevenue  float64
+    }
+    err := r.DB.Table("coupon_redemptions").
+        Select("COUNT(DISTINCT coupon_redemptions.coupon_id) AS codes_redeemed, COUNT(*) AS redemptions, "+
+            "COALESCE(SUM(coupon_redemptions.discount), 0) AS discount_given, COALESCE(SUM(coupon_redemptions.order_amount), 0) AS gross_revenue").
+        Joins("JOIN coupons ON coupons.coupon_id = coupon_redemptions.coupon_id").
+        Where("coupons.campaign_id = ? AND coupon_redemptions.status = ?", campaignID, models.RedemptionStatusRedeemed).
+        Scan(&totals).Error
+    if err != nil {
+        return nil, err
+    }
+    err = r.DB.Table("coupon_redemptions").
+        Joins("JOIN coupons ON coupons.coupon_id = coupon_redemptions.coupon_id").
+        Where("coupons.campaign_id = ? AND coupon_redemptions.status = ?", campaignID, models.RedemptionStatusReserved).
+        Count(&stats.Reserved).Error
+    if err != nil {
+        return nil, err
+    }
+
+    stats.CodesRedeemed = totals.CodesRedeemed
+    stats.Redemptions = totals.Redemptions
+    stats.DiscountGiven = totals.DiscountGiven
+    stats.GrossRevenue = totals.GrossRevenue
+    stats.NetRevenue = totals.GrossRevenue - totals.DiscountGiven
+    if stats.Codes > 0 {
+        stats.RedemptionRate = float64(stats.CodesRedeemed) / float64(stats.Codes)
+    }
+    return stats, nil
+}
@@ -1,11 +1,31 @@
 package repository
 
 import (
+    "errors"
+
+    "github.com/jackc/pgx/v5/pgconn"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
     "github.com/Prototype-1/xtrace/internal/models"
     "time"
 )
 
+var (
+    ErrCouponLimitReached     = errors.New("coupon has reached its usage limit")
+    ErrCouponUserLimitReached = errors.New("you have already used this coupon the maximum number of times")
+    ErrDuplicateCouponCode    = errors.New("coupon code already exists")
+)
+
+// couponCodeError reports a clash on the unique coupon code as
+// ErrDuplicateCouponCode and passes any other error through.
+func couponCodeError(err error) error {
+    var pgErr *pgconn.PgError
+    if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+        return ErrDuplicateCouponCode
+    }
+    return err
+}
+
 type CouponRepository interface {
     CreateCoupon(coupon models.Coupon) error
     UpdateCoupon(coupon models.Coupon) error
@@ -16,6 +36,18 @@ type CouponRepository interface {
     GetCouponByCode(code string) (*models.Coupon, error)
     IsCouponValid(code string) (bool, error)   
     GetCouponsByPaymentType(paymentType string) ([]*models.Coupon, error)
+
+    CountRedemptions(couponID int, userID uint) (int64, error)
+    HasTravelled(userID uint) (bool, error)
+    GetRouteCategoryID(routeID uint) (int, error)
+    ReserveRedemption(redemption *models.CouponRedemption) error
+    AttachRedemption(redemptionID, paymentID uint, orderID string) error
+    ConfirmRedemption(orderID string) error
+    ReleaseRedemption(redemptionID uint) error
+    ReleaseRedemptionByOrderID(orderID string) error
+    ReleaseStaleRedemptions(reservedBefore time.Time) (int64, error)
+    GetRedemptions(couponID int) ([]models.CouponRedemption, error)
+    IsCampaignActive(campaignID uint) (bool, error)
 }
 
 type CouponRepositoryImpl struct {
@@ -27,11 +59,11 @@ func NewCouponRepository(db *gorm.DB) CouponRepository {
 }
 
 func (r *CouponRepositoryImpl) CreateCoupon(coupon models.Coupon) error {
-    return r.DB.Create(&coupon).Error
+    return couponCodeError(r.DB.Create(&coupon).Error)
 }
 
 func (r *CouponRepositoryImpl) UpdateCoupon(coupon models.Coupon) error {
-    return r.DB.Save(&coupon).Error
+    return couponCodeError(r.DB.Save(&coupon).Error)
 }
 
 func (r *CouponRepositoryImpl) DeleteCoupon(id int) error {
@@ -44,9 +76,10 @@ func (r *CouponRepositoryImpl) GetCouponByID(id int) (models.Coupon, error) {
     return coupon, err
 }
 
+// GetAllCoupons lists stand-alone coupons; campaign codes are listed per campaign.
 func (r *CouponRepositoryImpl) GetAllCoupons() ([]models.Coupon, error) {
     var coupons []models.Coupon
-    err := r.DB.Find(&coupons).Error
+    err := r.DB.Where("campaign_id IS NULL").Find(&coupons).Error
     return coupons, err
 }
 
@@ -78,9 +111,167 @@ func (c *CouponRepositoryImpl) IsCouponValid(code string) (bool, error) {
 
 func (r *CouponRepositoryImpl) GetCouponsByPaymentType(paymentType string) ([]*models.Coupon, error) {
 	var coupons []*models.Coupon
-	if err := r.DB.Table("coupons").Where("payment_type = ?", paymentType).Find(&coupons).Error; err != nil {
+	// Campaign codes are handed out individually and never advertised.
+	if err := r.DB.Table("coupons").Where("payment_type = ? AND campaign_id IS NULL", paymentType).Find(&coupons).Error; err != nil {
 		return nil, err
 	}
 
 	return coupons, nil
-}
\ No newline at end of file
+}
+
+// activeRedemptions are the rows that hold a slot against a coupon's limits.
+var activeRedemptions = []string{models.RedemptionStatusReserved, models.RedemptionStatusRedeemed}
+
+// CountRedemptions counts the coupon's active redemptions, for one user when userID is set.
+func (r *CouponRepositoryImpl) CountRedemptions(couponID int, userID uint) (int64, error) {
+    var count int64
+    query := r.DB.Model(&models.CouponRedemption{}).Where("coupon_id = ? AND status IN ?", couponID, activeRedemptions)
+    if userID != 0 {
+        query = query.Where("user_id = ?", userID)
+    }
+    err := query.Count(&count).Error
+    return count, err
+}
+
+// HasTravelled reports whether the user has made a journey or paid for a booking.
+func (r *CouponRepositoryImpl) HasTravelled(userID uint) (bool, error) {
+    var journeys int64
+    if err := r.DB.Model(&models.NolCardJourney{}).Where("user_id = ?", userID).Count(&journeys).Error; err != nil {
+        return false, err
+    }
+    if journeys > 0 {
+        return true, nil
+    }
+    var bookings int64
+    err := r.DB.Table("payments").Where("user_id = ? AND payment_type = ? AND status = ?", userID, "booking", models.PaymentStatusVerified).
+        Count(&bookings).Error
+    return bookings > 0, err
+}
+
+func (r *CouponRepositoryImpl) GetRouteCategoryID(routeID uint) (int, error) {
+    var route models.Route
+    if err := r.DB.Select("category_id").Where("route_id = ?", routeID).First(&route).Error; err != nil {
+        return 0, err
+    }
+    return route.CategoryID, nil
+}
+
+// ReserveRedemption takes a slot on the coupon. The coupon row is locked so
+// concurrent payments cannot both take the last slot.
+func (r *CouponRepositoryImpl) ReserveRedemption(redemption *models.CouponRedemption) error {
+    tx := r.DB.Begin()
+    if err := checkRedemptionLimitsTx(tx, redemption.CouponID, redemption.UserID); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    redemption.Status = models.RedemptionStatusReserved
+    if err := tx.Create(redemption).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// checkRedemptionLimitsTx locks the coupon row and reports whether one more
+// redemption by the user would exceed its usage or per-user limit.
+func checkRedemptionLimitsTx(tx *gorm.DB, couponID int, userID uint) error {
+    var coupon models.Coupon
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("coupon_id = ?", couponID).First(&coupon).Error; err != nil {
+        return err
+    }
+    if coupon.UsageLimit > 0 {
+        var used int64
+        if err := tx.Model(&models.CouponRedemption{}).
+            Where("coupon_id = ? AND status IN ?", coupon.CouponID, activeRedemptions).Count(&used).Error; err != nil {
+            return err
+        }
+        if used >= int64(coupon.UsageLimit) {
+            return ErrCouponLimitReached
+        }
+    }
+    if coupon.PerUserLimit > 0 {
+        var used int64
+        if err := tx.Model(&models.CouponRedemption{}).
+            Where("coupon_id = ? AND user_id = ? AND status IN ?", coupon.CouponID, userID, activeRedemptions).
+            Count(&used).Error; err != nil {
+            return err
+        }
+        if used >= int64(coupon.PerUserLimit) {
+            return ErrCouponUserLimitReached
+        }
+    }
+    return nil
+}
+
+func (r *CouponRepositoryImpl) AttachRedemption(redemptionID, paymentID uint, orderID string) error {
+    return r.DB.Model(&models.CouponRedemption{}).Where("redemption_id = ?", redemptionID).
+        Updates(map[string]interface{}{"payment_id": paymentID, "order_id": orderID}).Error
+}
+
+// ConfirmRedemption marks the reservation for a verified order as redeemed.
+// A reservation released as stale before the payment went through is taken
+// again only if the coupon's limits still allow it; otherwise it stays
+// released and ErrCouponLimitReached or ErrCouponUserLimitReached is returned.
+func (r *CouponRepositoryImpl) ConfirmRedemption(orderID string) error {
+    tx := r.DB.Begin()
+    var redemption models.CouponRedemption
+    err := tx.Where("order_id = ?", orderID).Order("redemption_id DESC").First(&redemption).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        tx.Rollback()
+        return nil
+    }
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+    if redemption.Status == models.RedemptionStatusReleased {
+        if err := checkRedemptionLimitsTx(tx, redemption.CouponID, redemption.UserID); err != nil {
+            tx.Rollback()
+            return err
+        }
+    }
+    if err := tx.Model(&models.CouponRedemption{}).
+        Where("redemption_id = ? AND status IN ?", redemption.RedemptionID, []string{models.RedemptionStatusReserved, models.RedemptionStatusReleased}).
+        Update("status", models.RedemptionStatusRedeemed).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+func (r *CouponRepositoryImpl) ReleaseRedemption(redemptionID uint) error {
+    return r.DB.Model(&models.CouponRedemption{}).
+        Where("redemption_id = ? AND status = ?", redemptionID, models.RedemptionStatusReserved).
+        Update("status", models.RedemptionStatusReleased).Error
+}
+
+func (r *CouponRepositoryImpl) ReleaseRedemptionByOrderID(orderID string) error {
+    return r.DB.Model(&models.CouponRedemption{}).
+        Where("order_id = ? AND status = ?", orderID, models.RedemptionStatusReserved).
+        Update("status", models.RedemptionStatusReleased).Error
+}
+
+// ReleaseStaleRedemptions frees slots held by payments that were never completed.
+func (r *CouponRepositoryImpl) ReleaseStaleRedemptions(reservedBefore time.Time) (int64, error) {
+    result := r.DB.Model(&models.CouponRedemption{}).
+        Where("status = ? AND created_at < ?", models.RedemptionStatusReserved, reservedBefore).
+        Update("status", models.RedemptionStatusReleased)
+    return result.RowsAffected, result.Error
+}
+
+func (r *CouponRepositoryImpl) GetRedemptions(couponID int) ([]models.CouponRedemption, error) {
+    var redemptions []models.CouponRedemption
+    err := r.DB.Where("coupon_id = ?", couponID).Order("created_at DESC").Find(&redemptions).Error
+    return redemptions, err
+}
+
+// IsCampaignActive reports whether the campaign is switched on and running today.
+func (r *CouponRepositoryImpl) IsCampaignActive(campaignID uint) (bool, error) {
+    var campaign models.Campaign
+    if err := r.DB.Where("campaign_id = ?", campaignID).First(&campaign).Error; err != nil {
+        return false, err
+    }
+    now := time.Now()
+    return campaign.Active && campaign.StartDate.Before(now) && campaign.EndDate.After(now), nil
+}
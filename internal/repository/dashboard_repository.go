@@ -0,0 +1,141 @@
+package repository
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+type DashboardRepository interface {
+    GetSummary(now, activeSince time.Time) (*models.DashboardSummary, error)
+    GetTimeSeries(metric, interval string, from, to time.Time) ([]models.TimeSeriesPoint, error)
+}
+
+type dashboardRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewDashboardRepository(db *gorm.DB) DashboardRepository {
+    return &dashboardRepositoryImpl{DB: db}
+}
+
+// GetSummary counts users who signed in since activeSince as active.
+func (r *dashboardRepositoryImpl) GetSummary(now, activeSince time.Time) (*models.DashboardSummary, error) {
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    summary := &models.DashboardSummary{GeneratedAt: now}
+    users := func() *gorm.DB { return r.DB.Model(&models.User{}).Where("role = ?", "user") }
+
+    if err := users().Count(&summary.TotalUsers).Error; err != nil {
+        return nil, err
+    }
+    if err := users().Where("created_at >= ?", today).Count(&summary.NewSignupsToday).Error; err != nil {
+        return nil, err
+    }
+    if err := users().Where("created_at >= ?", now.AddDate(0, 0, -30)).Count(&summary.NewSignups30Days).Error; err != nil {
+        return nil, err
+    }
+    if err := r.DB.Model(&models.UserSession{}).
+        Where("role = ? AND created_at >= ?", "user", activeSince).
+        Distinct("user_id").Count(&summary.ActiveUsers).Error; err != nil {
+        return nil, err
+    }
+
+    live := activeSubscriptions(r.DB.Model(&models.Subscription{}), now).
+        Select("plan_id, COUNT(*) AS subscriptions").Group("plan_id")
+    if err := r.DB.Table("(?) AS s", live).
+        Select("s.plan_id, COALESCE(sp.plan_name, '') AS plan_name, s.subscriptions").
+        Joins("LEFT JOIN subscription_plans sp ON sp.plan_id = s.plan_id").
+        Order("s.subscriptions DESC, s.plan_id").
+        Scan(&summary.SubscriptionsByPlan).Error; err != nil {
+        return nil, err
+    }
+    for _, plan := range summary.SubscriptionsByPlan {
+        summary.ActiveSubscriptions += plan.Subscriptions
+    }
+
+    if err := r.DB.Model(&models.Wallet{}).Select("COALESCE(SUM(balance), 0)").Scan(&summary.WalletFloat).Error; err != nil {
+        return nil, err
+    }
+    if err := r.DB.Model(&models.NolCard{}).Select("COALESCE(SUM(balance), 0)").Scan(&summary.NolCardFloat).Error; err != nil {
+        return nil, err
+    }
+    if err := r.DB.Model(&models.Booking{}).Where("booking_date >= ?", today).Count(&summary.BookingsToday).Error; err != nil {
+        return nil, err
+    }
+    if err := r.DB.Table("payments").Where("status = ? AND updated_at >= ?", models.PaymentStatusFailed, today).
+        Count(&summary.FailedPaymentsToday).Error; err != nil {
+        return nil, err
+    }
+
+    var refunds struct {
+        Count  int64
+        Amount float64
+    }
+    if err := r.DB.Table("payments").
+        Select("COUNT(*) AS count, COALESCE(SUM(COALESCE(NULLIF(settled_amount, 0), amount) - refunded_amount * COALESCE(NULLIF(exchange_rate, 0), 1)), 0) AS amount").
+        Where("status = ?", models.PaymentStatusRefundPending).Scan(&refunds).Error; err != nil {
+        return nil, err
+    }
+    summary.OpenRefunds = refunds.Count
+    summary.OpenRefundAmount = refunds.Amount
+    return summary, nil
+}
+
+// metricSource is the table, timestamp and aggregate behind a metric.
+type metricSource struct {
+    table string
+    at    string
+    value string
+    where string
+    args  []interface{}
+}
+
+var dashboardMetricSources = map[string]metricSource{
+    models.MetricSignups: {
+        table: "users", at: "created_at", value: "COUNT(*)",
+        where: "role = ? AND deleted_at IS NULL", args: []interface{}{"user"},
+    },
+    models.MetricBookings: {table: "bookings", at: "booking_date", value: "COUNT(*)"},
+    models.MetricJourneys: {table: "nol_card_journeys", at: "created_at", value: "COUNT(*)"},
+    // Revenue net of refunds, as in the revenue report: wallet payments are
+    // left out, their money was counted as a top-up.
+    models.MetricRevenue: {
+        table: "payments", at: "payment_date",
+        value: "SUM(COALESCE(NULLIF(settled_amount, 0), amount) - refunded_amount * COALESCE(NULLIF(exchange_rate, 0), 1))",
+        where: "status IN ? AND COALESCE(method, '') <> ?", args: []interface{}{models.RevenuePaymentStatuses, "wallet"},
+    },
+    models.MetricFailedPayments: {
+        table: "payments", at: "updated_at", value: "COUNT(*)",
+        where: "status = ?", args: []interface{}{models.PaymentStatusFailed},
+    },
+}
+
+var timeSeriesFormats = map[string]string{
+    models.IntervalDay:   "YYYY-MM-DD",
+    models.IntervalWeek:  "YYYY-MM-DD",
+    models.IntervalMonth: "YYYY-MM",
+}
+
+// GetTimeSeries buckets a metric by day, week (starting Monday) or month.
+// Empty periods are not returned.
+func (r *dashboardRepositoryImpl) GetTimeSeries(metric, interval string, from, to time.Time) ([]models.TimeSeriesPoint, error) {
+    source, ok := dashboardMetricSources[metric]
+    if !ok {
+        return nil, fmt.Errorf("unknown metric %q", metric)
+    }
+    format, ok := timeSeriesFormats[interval]
+    if !ok {
+        return nil, fmt.Errorf("unknown interval %q", interval)
+    }
+    query := r.DB.Table(source.table).
+        Select(fmt.Sprintf("TO_CHAR(DATE_TRUNC('%s', %s), '%s') AS period, COALESCE(%s, 0) AS value", interval, source.at, format, source.value)).
+        Where(source.at+" >= ? AND "+source.at+" < ?", from, to)
+    if source.where != "" {
+        query = query.Where(source.where, source.args...)
+    }
+    var points []models.TimeSeriesPoint
+    err := query.Group("1").Order("1").Scan(&points).Error
+    return points, err
+}
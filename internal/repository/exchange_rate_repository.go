@@ -0,0 +1,66 @@
+package repository
+
+import (
+    "errors"
+    "strings"
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+type ExchangeRateRepository interface {
+    CreateExchangeRate(rate *models.ExchangeRate) error
+    UpdateExchangeRate(rate *models.ExchangeRate) error
+    DeleteExchangeRate(id uint) error
+    GetAllExchangeRates() ([]models.ExchangeRate, error)
+    GetExchangeRateByCurrency(currency string) (*models.ExchangeRate, error)
+}
+
+type exchangeRateRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewExchangeRateRepository(db *gorm.DB) ExchangeRateRepository {
+    return &exchangeRateRepositoryImpl{DB: db}
+}
+
+func (r *exchangeRateRepositoryImpl) CreateExchangeRate(rate *models.ExchangeRate) error {
+    return r.DB.Create(rate).Error
+}
+
+func (r *exchangeRateRepositoryImpl) UpdateExchangeRate(rate *models.ExchangeRate) error {
+    result := r.DB.Model(&models.ExchangeRate{}).
+        Where("exchange_rate_id = ?", rate.ExchangeRateID).
+        Updates(map[string]interface{}{
+            "currency":     rate.Currency,
+            "rate_to_base": rate.RateToBase,
+        })
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return errors.New("exchange rate not found")
+    }
+    return nil
+}
+
+func (r *exchangeRateRepositoryImpl) DeleteExchangeRate(id uint) error {
+    return r.DB.Delete(&models.ExchangeRate{}, id).Error
+}
+
+func (r *exchangeRateRepositoryImpl) GetAllExchangeRates() ([]models.ExchangeRate, error) {
+    var rates []models.ExchangeRate
+    err := r.DB.Order("currency").Find(&rates).Error
+    return rates, err
+}
+
+func (r *exchangeRateRepositoryImpl) GetExchangeRateByCurrency(currency string) (*models.ExchangeRate, error) {
+    var rate models.ExchangeRate
+    err := r.DB.Where("currency = ?", strings.ToUpper(currency)).First(&rate).Error
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &rate, nil
+}
@@ -12,6 +12,7 @@ type FareRuleRepository interface {
     GetFareRuleByID(id int) (models.FareRule, error)
     GetAllFareRules() ([]models.FareRule, error)
     GetFareRuleByRouteID(routeID int) (models.FareRule, error)
+    GetStopByID(stopID int) (models.Stop, error)
     //GetTravelTimeBetweenStops(fromStopID, toStopID uint) (int, error)
     CreateStopDuration(stopDuration models.StopDuration) error
     UpdateStopDuration(stopDuration models.StopDuration) error
@@ -57,6 +58,12 @@ func (r *FareRuleRepositoryImpl) GetFareRuleByRouteID(routeID int) (models.FareR
     return fareRule, err
 }
 
+func (r *FareRuleRepositoryImpl) GetStopByID(stopID int) (models.Stop, error) {
+    var stop models.Stop
+    err := r.DB.Where("stop_id = ?", stopID).First(&stop).Error
+    return stop, err
+}
+
 func (r *FareRuleRepositoryImpl) CreateStopDuration(stopDuration models.StopDuration) error {
     return r.DB.Create(&stopDuration).Error
 }
@@ -0,0 +1,60 @@
+package repository
+
+import (
+    "errors"
+    "time"
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+type IdempotencyRepository interface {
+    GetKey(key string, userID uint) (*models.IdempotencyKey, error)
+    ReserveKey(record *models.IdempotencyKey) error
+    SaveResponse(id uint, statusCode int, responseBody string) error
+    DeleteKey(id uint) error
+    DeleteExpiredKeys() error
+}
+
+type idempotencyRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewIdempotencyRepository(db *gorm.DB) IdempotencyRepository {
+    return &idempotencyRepositoryImpl{DB: db}
+}
+
+func (r *idempotencyRepositoryImpl) GetKey(key string, userID uint) (*models.IdempotencyKey, error) {
+    var record models.IdempotencyKey
+    err := r.DB.Where("idempotency_key = ? AND user_id = ?", key, userID).First(&record).Error
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &record, nil
+}
+
+// ReserveKey inserts the key before the request runs; the unique index on
+// (key, user_id) makes a concurrent duplicate fail here instead of double-charging.
+func (r *idempotencyRepositoryImpl) ReserveKey(record *models.IdempotencyKey) error {
+    return r.DB.Create(record).Error
+}
+
+func (r *idempotencyRepositoryImpl) SaveResponse(id uint, statusCode int, responseBody string) error {
+    return r.DB.Model(&models.IdempotencyKey{}).
+        Where("idempotency_key_id = ?", id).
+        Updates(map[string]interface{}{
+            "status_code":   statusCode,
+            "response_body": responseBody,
+            "completed":     true,
+        }).Error
+}
+
+func (r *idempotencyRepositoryImpl) DeleteKey(id uint) error {
+    return r.DB.Delete(&models.IdempotencyKey{}, id).Error
+}
+
+func (r *idempotencyRepositoryImpl) DeleteExpiredKeys() error {
+    return r.DB.Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyKey{}).Error
+}
@@ -1,13 +1,45 @@
 package repository
 
 import (
+    "errors"
+    "fmt"
+    "time"
+
     "github.com/Prototype-1/xtrace/internal/models"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
 )
 
+// ErrCreditExceedsInvoice is returned when credit notes would exceed the invoiced amount.
+var ErrCreditExceedsInvoice = errors.New("credit exceeds the amount left on the invoice")
+
 type InvoiceRepository interface {
-    Create(invoice *models.Invoice) (*models.Invoice, error)
-    GetInvoiceByUserID(userID uint) (*models.Invoice, error)
+    // CreateDraft saves an unnumbered draft for a payment that is not yet confirmed.
+    CreateDraft(invoice *models.Invoice) error
+    // FinalizeForPayment numbers the payment's draft and marks it paid. An
+    // already paid invoice is returned unchanged.
+    FinalizeForPayment(paymentID uint) (*models.Invoice, error)
+    VoidForOrder(orderID string) error
+    // VoidStaleDrafts voids drafts created before createdBefore whose payment
+    // was never verified.
+    VoidStaleDrafts(createdBefore time.Time) (int64, error)
+    GetTaxSummary(from, to time.Time) (*models.InvoiceTaxSummary, error)
+    GetInvoiceByID(invoiceID uint) (*models.Invoice, error)
+    GetInvoiceByPaymentID(paymentID uint) (*models.Invoice, error)
+    GetInvoicesByUserID(userID uint, offset, limit int) ([]models.Invoice, int64, error)
+    // GetLatestSubscriptionInvoice returns the newest invoice for a payment of the subscription.
+    GetLatestSubscriptionInvoice(subscriptionID uint) (*models.Invoice, error)
+    // GetLatestNolCardInvoice returns the newest invoice for a top-up of the card.
+    GetLatestNolCardInvoice(nolCardID int) (*models.Invoice, error)
+    // CreateCreditNote numbers and saves a credit note against its original
+    // invoice, provided the credits stay within the invoiced amount.
+    CreateCreditNote(note *models.Invoice) error
+
+    // Tax rules
+    EnsureDefaultTaxRules() error
+    GetTaxRules() ([]models.TaxRule, error)
+    GetTaxRule(itemType string) (*models.TaxRule, error)
+    SaveTaxRule(rule *models.TaxRule) error
 }
 
 type invoiceRepositoryImpl struct {
@@ -20,18 +52,253 @@ func NewInvoiceRepository(db *gorm.DB) InvoiceRepository {
     }
 }
 
-func (r *invoiceRepositoryImpl) Create(invoice *models.Invoice) (*models.Invoice, error) {
-    if err := r.DB.Create(invoice).Error; err != nil {
+// assignInvoiceNumberTx takes the next number of the document's series and
+// financial year. The sequence row is locked until tx ends, and a rolled-back
+// invoice rolls the counter back with it, so numbers are never skipped or
+// reused. A document is numbered once and never renumbered.
+func assignInvoiceNumberTx(tx *gorm.DB, invoice *models.Invoice) error {
+    if invoice.InvoiceNumber != nil {
+        return fmt.Errorf("invoice is already numbered %s", *invoice.InvoiceNumber)
+    }
+    if invoice.DocumentType == "" {
+        invoice.DocumentType = models.DocumentTypeInvoice
+    }
+    if invoice.InvoiceDate.IsZero() {
+        invoice.InvoiceDate = time.Now()
+    }
+    series := models.InvoiceSeries(invoice.DocumentType)
+    year := models.FinancialYear(invoice.InvoiceDate)
+    if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+        Create(&models.InvoiceSequence{Series: series, FinancialYear: year}).Error; err != nil {
+        return err
+    }
+    var sequence models.InvoiceSequence
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("series = ? AND financial_year = ?", series, year).First(&sequence).Error; err != nil {
+        return err
+    }
+    sequence.LastNumber++
+    if err := tx.Model(&models.InvoiceSequence{}).Where("series = ? AND financial_year = ?", series, year).
+        Update("last_number", sequence.LastNumber).Error; err != nil {
+        return err
+    }
+    number := fmt.Sprintf("%s/%s/%06d", series, year, sequence.LastNumber)
+    invoice.InvoiceNumber = &number
+    invoice.FinancialYear = year
+    return nil
+}
+
+// createInvoiceTx numbers the invoice and saves it with its lines.
+func createInvoiceTx(tx *gorm.DB, invoice *models.Invoice) error {
+    if err := assignInvoiceNumberTx(tx, invoice); err != nil {
+        return err
+    }
+    return tx.Create(invoice).Error
+}
+
+func (r *invoiceRepositoryImpl) CreateDraft(invoice *models.Invoice) error {
+    invoice.Status = models.InvoiceStatusDraft
+    invoice.DocumentType = models.DocumentTypeInvoice
+    return r.DB.Create(invoice).Error
+}
+
+func (r *invoiceRepositoryImpl) FinalizeForPayment(paymentID uint) (*models.Invoice, error) {
+    var invoice models.Invoice
+    err := r.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("payment_id = ? AND document_type = ?", paymentID, models.DocumentTypeInvoice).
+            First(&invoice).Error; err != nil {
+            return err
+        }
+        if invoice.InvoiceNumber != nil || invoice.Status == models.InvoiceStatusPaid {
+            return nil
+        }
+        // A payment captured after its draft was voided is still invoiced.
+        if invoice.Status != models.InvoiceStatusDraft && invoice.Status != models.InvoiceStatusVoid {
+            return fmt.Errorf("invoice %d is %s and cannot be finalised", invoice.InvoiceID, invoice.Status)
+        }
+        invoice.InvoiceDate = time.Now()
+        if err := assignInvoiceNumberTx(tx, &invoice); err != nil {
+            return err
+        }
+        invoice.Status = models.InvoiceStatusPaid
+        return tx.Model(&models.Invoice{}).Where("invoice_id = ?", invoice.InvoiceID).
+            Updates(map[string]interface{}{
+                "invoice_number": invoice.InvoiceNumber,
+                "financial_year": invoice.FinancialYear,
+                "invoice_date":   invoice.InvoiceDate,
+                "status":         invoice.Status,
+                "updated_at":     time.Now(),
+            }).Error
+    })
+    if err != nil {
+        return nil, err
+    }
+    return &invoice, nil
+}
+
+func (r *invoiceRepositoryImpl) VoidForOrder(orderID string) error {
+    return r.DB.Model(&models.Invoice{}).
+        Where("status = ? AND payment_id IN (?)", models.InvoiceStatusDraft,
+            r.DB.Table("payments").Select("payment_id").Where("order_id = ?", orderID)).
+        Updates(map[string]interface{}{"status": models.InvoiceStatusVoid, "updated_at": time.Now()}).Error
+}
+
+func (r *invoiceRepositoryImpl) VoidStaleDrafts(createdBefore time.Time) (int64, error) {
+    result := r.DB.Model(&models.Invoice{}).
+        Where("status = ? AND created_at < ? AND payment_id NOT IN (?)", models.InvoiceStatusDraft, createdBefore,
+            r.DB.Table("payments").Select("payment_id").Where("status = ?", models.PaymentStatusVerified)).
+        Updates(map[string]interface{}{"status": models.InvoiceStatusVoid, "updated_at": time.Now()})
+    return result.RowsAffected, result.Error
+}
+
+// GetTaxSummary reports in the base currency.
+func (r *invoiceRepositoryImpl) GetTaxSummary(from, to time.Time) (*models.InvoiceTaxSummary, error) {
+    var rows []struct {
+        DocumentType  string
+        Documents     int64
+        TaxableAmount float64
+        CGSTAmount    float64
+        SGSTAmount    float64
+        IGSTAmount    float64
+        TotalAmount   float64
+    }
+    err := r.DB.Model(&models.Invoice{}).
+        Select("document_type, COUNT(*) AS documents, COALESCE(SUM(taxable_amount * exchange_rate), 0) AS taxable_amount, "+
+            "COALESCE(SUM(cgst_amount * exchange_rate), 0) AS cgst_amount, COALESCE(SUM(sgst_amount * exchange_rate), 0) AS sgst_amount, "+
+            "COALESCE(SUM(igst_amount * exchange_rate), 0) AS igst_amount, COALESCE(SUM(settled_amount), 0) AS total_amount").
+        Where("status IN ? AND invoice_date >= ? AND invoice_date < ?", models.FinalisedInvoiceStatuses, from, to).
+        Group("document_type").Scan(&rows).Error
+    if err != nil {
         return nil, err
     }
-    return invoice, nil
+    summary := &models.InvoiceTaxSummary{From: from, To: to}
+    for _, row := range rows {
+        sign := 1.0
+        if row.DocumentType == models.DocumentTypeCreditNote {
+            sign = -1
+            summary.CreditNotes = row.Documents
+        } else {
+            summary.Invoices = row.Documents
+        }
+        summary.TaxableAmount += sign * row.TaxableAmount
+        summary.CGSTAmount += sign * row.CGSTAmount
+        summary.SGSTAmount += sign * row.SGSTAmount
+        summary.IGSTAmount += sign * row.IGSTAmount
+        summary.TotalAmount += sign * row.TotalAmount
+    }
+    summary.TaxAmount = summary.CGSTAmount + summary.SGSTAmount + summary.IGSTAmount
+    return summary, nil
 }
 
-func (r *invoiceRepositoryImpl) GetInvoiceByUserID(userID uint) (*models.Invoice, error) {
+func (r *invoiceRepositoryImpl) GetInvoiceByID(invoiceID uint) (*models.Invoice, error) {
     var invoice models.Invoice
-    result := r.DB.Where("user_id = ?", userID).First(&invoice)
-    if result.Error != nil {
-        return nil, result.Error
+    if err := r.DB.Preload("Lines").Where("invoice_id = ?", invoiceID).First(&invoice).Error; err != nil {
+        return nil, err
     }
     return &invoice, nil
 }
+
+func (r *invoiceRepositoryImpl) GetInvoiceByPaymentID(paymentID uint) (*models.Invoice, error) {
+    var invoice models.Invoice
+    if err := r.DB.Preload("Lines").Where("payment_id = ? AND document_type = ?", paymentID, models.DocumentTypeInvoice).
+        First(&invoice).Error; err != nil {
+        return nil, err
+    }
+    return &invoice, nil
+}
+
+// GetInvoicesByUserID returns a page of the user's finalised invoices and
+// credit notes, newest first, with the total count.
+func (r *invoiceRepositoryImpl) GetInvoicesByUserID(userID uint, offset, limit int) ([]models.Invoice, int64, error) {
+    var total int64
+    query := r.DB.Model(&models.Invoice{}).Where("user_id = ? AND status IN ?", userID, models.FinalisedInvoiceStatuses).Session(&gorm.Session{})
+    if err := query.Count(&total).Error; err != nil {
+        return nil, 0, err
+    }
+    var invoices []models.Invoice
+    err := query.Order("invoice_date DESC, invoice_id DESC").
+        Offset(offset).Limit(limit).Find(&invoices).Error
+    return invoices, total, err
+}
+
+func (r *invoiceRepositoryImpl) GetLatestSubscriptionInvoice(subscriptionID uint) (*models.Invoice, error) {
+    var invoice models.Invoice
+    err := r.DB.Preload("Lines").
+        Where("document_type = ? AND status IN ? AND payment_id IN (?)", models.DocumentTypeInvoice, models.FinalisedInvoiceStatuses,
+            r.DB.Table("payments").Select("payment_id").Where("subscription_id = ?", subscriptionID)).
+        Order("invoice_date DESC").First(&invoice).Error
+    if err != nil {
+        return nil, err
+    }
+    return &invoice, nil
+}
+
+func (r *invoiceRepositoryImpl) GetLatestNolCardInvoice(nolCardID int) (*models.Invoice, error) {
+    var invoice models.Invoice
+    err := r.DB.Preload("Lines").
+        Where("document_type = ? AND status IN ? AND payment_id IN (?)", models.DocumentTypeInvoice, models.FinalisedInvoiceStatuses,
+            r.DB.Table("payments").Select("payment_id").Where("nol_card_id = ? AND payment_type = ?", nolCardID, "nol_card_topup")).
+        Order("invoice_date DESC").First(&invoice).Error
+    if err != nil {
+        return nil, err
+    }
+    return &invoice, nil
+}
+
+func (r *invoiceRepositoryImpl) CreateCreditNote(note *models.Invoice) error {
+    return r.DB.Transaction(func(tx *gorm.DB) error {
+        var original models.Invoice
+        if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+            Where("invoice_id = ? AND document_type = ?", *note.OriginalInvoiceID, models.DocumentTypeInvoice).
+            First(&original).Error; err != nil {
+            return err
+        }
+        if original.Status != models.InvoiceStatusPaid && original.Status != models.InvoiceStatusDue {
+            return fmt.Errorf("invoice is %s, only finalised invoices can be credited", original.Status)
+        }
+        var credited float64
+        if err := tx.Model(&models.Invoice{}).
+            Where("original_invoice_id = ? AND document_type = ?", original.InvoiceID, models.DocumentTypeCreditNote).
+            Select("COALESCE(SUM(amount), 0)").Scan(&credited).Error; err != nil {
+            return err
+        }
+        if credited+note.Amount > original.Amount+0.005 {
+            return ErrCreditExceedsInvoice
+        }
+        note.DocumentType = models.DocumentTypeCreditNote
+        return createInvoiceTx(tx, note)
+    })
+}
+
+func (r *invoiceRepositoryImpl) EnsureDefaultTaxRules() error {
+    for _, rule := range models.DefaultTaxRules {
+        rule := rule
+        if err := r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&rule).Error; err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (r *invoiceRepositoryImpl) GetTaxRules() ([]models.TaxRule, error) {
+    var rules []models.TaxRule
+    err := r.DB.Order("item_type").Find(&rules).Error
+    return rules, err
+}
+
+func (r *invoiceRepositoryImpl) GetTaxRule(itemType string) (*models.TaxRule, error) {
+    var rule models.TaxRule
+    if err := r.DB.Where("item_type = ?", itemType).First(&rule).Error; err != nil {
+        return nil, err
+    }
+    return &rule, nil
+}
+
+// SaveTaxRule creates or replaces the rule for rule.ItemType.
+func (r *invoiceRepositoryImpl) SaveTaxRule(rule *models.TaxRule) error {
+    return r.DB.Clauses(clause.OnConflict{
+        Columns:   []clause.Column{{Name: "item_type"}},
+        DoUpdates: clause.AssignmentColumns([]string{"description", "hsnsac", "gst_rate", "updated_at"}),
+    }).Create(rule).Error
+}
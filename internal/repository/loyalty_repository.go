@@ -0,0 +1,423 @@
+package repository
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+var ErrInsufficientPoints = errors.New("insufficient loyalty points")
+
+type LoyaltyRepository interface {
+    GetProgramme() (*models.LoyaltyProgramme, error)
+    UpdateProgramme(programme *models.LoyaltyProgramme) error
+    EnsureDefaultEarnRates() error
+    GetEarnRates() ([]models.LoyaltyEarnRate, error)
+    GetEarnRate(cardType string) (*models.LoyaltyEarnRate, error)
+    SaveEarnRate(rate *models.LoyaltyEarnRate) error
+    Earn(entry *models.LoyaltyLedgerEntry) (bool, error)
+    Redeem(entry *models.LoyaltyLedgerEntry) error
+    AttachRedemption(entryID, paymentID uint, orderID string) error
+    ConfirmRedemptionByOrderID(orderID string) error
+    RestoreRedemptionByOrderID(orderID string) error
+    ReverseRedemption(entryID uint) error
+    GetPendingRedemptionByOrderID(orderID string) (*models.LoyaltyLedgerEntry, error)
+    GetStaleRedemptionIDs(olderThan time.Time) ([]uint, error)
+    GetExpiredEntryIDs(now time.Time) ([]uint, error)
+    ExpireEntry(entryID uint, now time.Time) (int, error)
+    GetAvailableEntries(userID uint, now time.Time) ([]models.LoyaltyLedgerEntry, error)
+    GetLedger(userID uint) ([]models.LoyaltyLedgerEntry, error)
+}
+
+type loyaltyRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewLoyaltyRepository(db *gorm.DB) LoyaltyRepository {
+    return &loyaltyRepositoryImpl{DB: db}
+}
+
+// GetProgramme returns the programme rules, creating the default row on first use.
+func (r *loyaltyRepositoryImpl) GetProgramme() (*models.LoyaltyProgramme, error) {
+    programme := models.LoyaltyProgramme{}
+    defaults := models.LoyaltyProgramme{
+        PointValue:       0.1,
+        ExpiryMonths:     12,
+        MaxRedeemPercent: 50,
+        MinRedeemPoints:  100,
+    }
+    err := r.DB.Where(models.LoyaltyProgramme{ProgrammeID: 1}).Attrs(defaults).FirstOrCreate(&programme).Error
+    if err != nil {
+        return nil, err
+    }
+    return &programme, nil
+}
+
+func (r *loyaltyRepositoryImpl) UpdateProgramme(programme *models.LoyaltyProgramme) error {
+    programme.ProgrammeID = 1
+    return r.DB.Save(programme).Error
+}
+
+// EnsureDefaultEarnRates seeds a rate for every card type that has none.
+func (r *loyaltyRepositoryImpl) EnsureDefaultEarnRates() error {
+    for cardType, rate := range models.DefaultLoyaltyEarnRates {
+        earnRate := models.LoyaltyEarnRate{CardType: cardType, BookingRate: rate, SubscriptionRate: rate, JourneyRate: rate}
+        if err := r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&earnRate).Error; err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (r *loyaltyRepositoryImpl) GetEarnRates() ([]models.LoyaltyEarnRate, error) {
+    var rates []models.LoyaltyEarnRate
+    err := r.DB.Order("card_type").Find(&rates).Error
+    return rates, err
+}
+
+func (r *loyaltyRepositoryImpl) GetEarnRate(cardType string) (*models.LoyaltyEarnRate, error) {
+    var rate models.LoyaltyEarnRate
+    if err := r.DB.Where("card_type = ?", cardType).First(&rate).Error; err != nil {
+        return nil, err
+    }
+    return &rate, nil
+}
+
+func (r *loyaltyRepositoryImpl) SaveEarnRate(rate *models.LoyaltyEarnRate) error {
+    return r.DB.Clauses(clause.OnConflict{
+        Columns:   []clause.Column{{Name: "card_type"}},
+        DoUpdates: clause.AssignmentColumns([]string{"booking_rate", "subscription_rate", "journey_rate", "updated_at"}),
+    }).Create(rate).Error
+}
+
+// Earn records an earn entry. It reports false without error when the
+// reference has already earned points.
+func (r *loyaltyRepositoryImpl) Earn(entry *models.LoyaltyLedgerEntry) (bool, error) {
+    entry.Type = models.LoyaltyEntryEarn
+    entry.Remaining = entry.Points
+    result := r.DB.Clauses(clause.OnConflict{
+        Columns:   []clause.Column{{Name: "type"}, {Name: "reference"}},
+        DoNothing: true,
+    }).Create(entry)
+    if result.Error != nil {
+        return false, result.Error
+    }
+    return result.RowsAffected > 0, nil
+}
+
+// Redeem holds entry's points (a positive number in entry.Points) against the
+// user's unexpired batches, soonest expiry first, and records the pending
+// redeem entry. The entry remembers the earliest expiry it drew from so a
+// reversal does not extend the life of the points.
+func (r *loyaltyRepositoryImpl) Redeem(entry *models.LoyaltyLedgerEntry) error {
+    points := entry.Points
+    now := time.Now()
+    tx := r.DB.Begin()
+    drawn, expiresAt, err := drawPointsTx(tx, entry.UserID, points, now, 0)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+    if drawn < points {
+        tx.Rollback()
+        return ErrInsufficientPoints
+    }
+
+    entry.Type = models.LoyaltyEntryRedeem
+    entry.Source = models.LoyaltySourceCheckout
+    entry.Points = -points
+    entry.Remaining = 0
+    entry.Status = models.LoyaltyRedemptionPending
+    entry.ExpiresAt = expiresAt
+    if entry.Reference == "" {
+        entry.Reference = fmt.Sprintf("redeem:%d:%d", entry.UserID, now.UnixNano())
+    }
+    if err := tx.Create(entry).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// drawPointsTx takes up to points from the user's unexpired batches, batch
+// firstID first and then soonest expiry first, inside an open transaction.
+// It returns how many points were taken and the earliest expiry drawn from.
+func drawPointsTx(tx *gorm.DB, userID uint, points int, now time.Time, firstID uint) (int, *time.Time, error) {
+    var batches []models.LoyaltyLedgerEntry
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("user_id = ? AND remaining > 0 AND (expires_at IS NULL OR expires_at > ?)", userID, now).
+        Order("expires_at ASC NULLS LAST, entry_id ASC").
+        Find(&batches).Error; err != nil {
+        return 0, nil, err
+    }
+    for i := range batches {
+        if batches[i].EntryID == firstID {
+            batches[0], batches[i] = batches[i], batches[0]
+            break
+        }
+    }
+
+    var expiresAt *time.Time
+    drawn := 0
+    for _, batch := range batches {
+        if drawn == points {
+            break
+        }
+        take := batch.Remaining
+        if take > points-drawn {
+            take = points - drawn
+        }
+        if err := tx.Model(&models.LoyaltyLedgerEntry{}).Where("entry_id = ?", batch.EntryID).
+            Update("remaining", gorm.Expr("remaining - ?", take)).Error; err != nil {
+            return 0, nil, err
+        }
+        if batch.ExpiresAt != nil && (expiresAt == nil || batch.ExpiresAt.Before(*expiresAt)) {
+            expiresAt = batch.ExpiresAt
+        }
+        drawn += take
+    }
+    return drawn, expiresAt, nil
+}
+
+func (r *loyaltyRepositoryImpl) AttachRedemption(entryID, paymentID uint, orderID string) error {
+    return r.DB.Model(&models.LoyaltyLedgerEntry{}).
+        Where("entry_id = ?", entryID).
+        Updates(map[string]interface{}{"payment_id": paymentID, "order_id": orderID}).Error
+}
+
+func (r *loyaltyRepositoryImpl) ConfirmRedemptionByOrderID(orderID string) error {
+    return r.DB.Model(&models.LoyaltyLedgerEntry{}).
+        Where("order_id = ? AND type = ? AND status = ?", orderID, models.LoyaltyEntryRedeem, models.LoyaltyRedemptionPending).
+        Update("status", models.LoyaltyRedemptionConfirmed).Error
+}
+
+// RestoreRedemptionByOrderID holds an order's points again when its
+// redemption was reversed as stale before the payment completed. The points
+// are drawn afresh as a new pending redemption, failing with
+// ErrInsufficientPoints if the user no longer has them.
+func (r *loyaltyRepositoryImpl) RestoreRedemptionByOrderID(orderID string) error {
+    tx := r.DB.Begin()
+    var redemption models.LoyaltyLedgerEntry
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("order_id = ? AND type = ?", orderID, models.LoyaltyEntryRedeem).
+        Order("entry_id DESC").First(&redemption).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        tx.Rollback()
+        return nil
+    }
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+    if redemption.Status != models.LoyaltyRedemptionReversed {
+        tx.Rollback()
+        return nil
+    }
+
+    points := -redemption.Points
+    drawn, expiresAt, err := drawPointsTx(tx, redemption.UserID, points, time.Now(), 0)
+    if err != nil {
+        tx.Rollback()
+        return err
+    }
+    if drawn < points {
+        tx.Rollback()
+        return ErrInsufficientPoints
+    }
+    restored := models.LoyaltyLedgerEntry{
+        UserID:      redemption.UserID,
+        Type:        models.LoyaltyEntryRedeem,
+        Source:      models.LoyaltySourceCheckout,
+        Reference:   fmt.Sprintf("restore:%d", redemption.EntryID),
+        Points:      redemption.Points,
+        Amount:      redemption.Amount,
+        Status:      models.LoyaltyRedemptionPending,
+        PaymentID:   redemption.PaymentID,
+        OrderID:     redemption.OrderID,
+        ExpiresAt:   expiresAt,
+        Description: redemption.Description,
+    }
+    if err := tx.Create(&restored).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// ReverseRedemption gives the points of a pending redemption back as a new
+// batch. Confirmed or already reversed redemptions are left alone.
+func (r *loyaltyRepositoryImpl) ReverseRedemption(entryID uint) error {
+    tx := r.DB.Begin()
+    var redemption models.LoyaltyLedgerEntry
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("entry_id = ? AND type = ?", entryID, models.LoyaltyEntryRedeem).
+        First(&redemption).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    if redemption.Status != models.LoyaltyRedemptionPending {
+        tx.Rollback()
+        return nil
+    }
+    if err := tx.Model(&models.LoyaltyLedgerEntry{}).Where("entry_id = ?", entryID).
+        Update("status", models.LoyaltyRedemptionReversed).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    reversal := models.LoyaltyLedgerEntry{
+        UserID:      redemption.UserID,
+        Type:        models.LoyaltyEntryReversal,
+        Source:      models.LoyaltySourceCheckout,
+        Reference:   fmt.Sprintf("reversal:%d", redemption.EntryID),
+        Points:      -redemption.Points,
+        Remaining:   -redemption.Points,
+        Amount:      redemption.Amount,
+        OrderID:     redemption.OrderID,
+        ExpiresAt:   redemption.ExpiresAt,
+        Description: "Points returned from an unpaid order",
+    }
+    if err := tx.Create(&reversal).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// clawBackPointsTx takes back the share of the points a payment earned that
+// matches the share of it refunded so far, inside an open transaction.
+// Points already spent come out of the user's other batches; any shortfall
+// is taken on a later refund of the same payment.
+func clawBackPointsTx(tx *gorm.DB, paymentID uint) error {
+    var payment models.RazorpayPayment
+    if err := tx.Table("payments").Where("payment_id = ?", paymentID).First(&payment).Error; err != nil {
+        return err
+    }
+    if payment.Amount <= 0 || payment.RefundedAmount <= 0 {
+        return nil
+    }
+    var earned models.LoyaltyLedgerEntry
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("type = ? AND reference = ?", models.LoyaltyEntryEarn, fmt.Sprintf("payment:%d", paymentID)).
+        First(&earned).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+
+    due := earned.Points
+    if share := payment.RefundedAmount / payment.Amount; share < 1 {
+        due = int(math.Round(float64(earned.Points) * share))
+    }
+    var taken int
+    if err := tx.Model(&models.LoyaltyLedgerEntry{}).
+        Where("type = ? AND reference LIKE ?", models.LoyaltyEntryClawback, fmt.Sprintf("clawback:%d:%%", earned.EntryID)).
+        Select("COALESCE(SUM(-points), 0)").Scan(&taken).Error; err != nil {
+        return err
+    }
+    if due <= taken {
+        return nil
+    }
+    drawn, _, err := drawPointsTx(tx, earned.UserID, due-taken, time.Now(), earned.EntryID)
+    if err != nil || drawn == 0 {
+        return err
+    }
+    return tx.Create(&models.LoyaltyLedgerEntry{
+        UserID:      earned.UserID,
+        Type:        models.LoyaltyEntryClawback,
+        Source:      earned.Source,
+        Reference:   fmt.Sprintf("clawback:%d:%d", earned.EntryID, taken+drawn),
+        Points:      -drawn,
+        PaymentID:   &paymentID,
+        Description: fmt.Sprintf("Points taken back for refunded payment %d", paymentID),
+    }).Error
+}
+
+func (r *loyaltyRepositoryImpl) GetPendingRedemptionByOrderID(orderID string) (*models.LoyaltyLedgerEntry, error) {
+    var entry models.LoyaltyLedgerEntry
+    err := r.DB.Where("order_id = ? AND type = ? AND status = ?", orderID, models.LoyaltyEntryRedeem, models.LoyaltyRedemptionPending).
+        First(&entry).Error
+    if err != nil {
+        return nil, err
+    }
+    return &entry, nil
+}
+
+// GetStaleRedemptionIDs lists pending redemptions created before olderThan
+// whose payment was never verified.
+func (r *loyaltyRepositoryImpl) GetStaleRedemptionIDs(olderThan time.Time) ([]uint, error) {
+    var ids []uint
+    err := r.DB.Model(&models.LoyaltyLedgerEntry{}).
+        Where("type = ? AND status = ? AND created_at < ?", models.LoyaltyEntryRedeem, models.LoyaltyRedemptionPending, olderThan).
+        Where("payment_id IS NULL OR payment_id NOT IN (?)",
+            r.DB.Table("payments").Select("payment_id").Where("status = ?", models.PaymentStatusVerified)).
+        Pluck("entry_id", &ids).Error
+    return ids, err
+}
+
+func (r *loyaltyRepositoryImpl) GetExpiredEntryIDs(now time.Time) ([]uint, error) {
+    var ids []uint
+    err := r.DB.Model(&models.LoyaltyLedgerEntry{}).
+        Where("remaining > 0 AND expires_at IS NOT NULL AND expires_at <= ?", now).
+        Pluck("entry_id", &ids).Error
+    return ids, err
+}
+
+// ExpireEntry writes off what is left of an expired batch and returns the
+// number of points expired.
+func (r *loyaltyRepositoryImpl) ExpireEntry(entryID uint, now time.Time) (int, error) {
+    tx := r.DB.Begin()
+    var batch models.LoyaltyLedgerEntry
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("entry_id = ?", entryID).First(&batch).Error; err != nil {
+        tx.Rollback()
+        return 0, err
+    }
+    if batch.Remaining <= 0 || batch.ExpiresAt == nil || batch.ExpiresAt.After(now) {
+        tx.Rollback()
+        return 0, nil
+    }
+    if err := tx.Model(&models.LoyaltyLedgerEntry{}).Where("entry_id = ?", entryID).
+        Update("remaining", 0).Error; err != nil {
+        tx.Rollback()
+        return 0, err
+    }
+    expiry := models.LoyaltyLedgerEntry{
+        UserID:      batch.UserID,
+        Type:        models.LoyaltyEntryExpire,
+        Source:      batch.Source,
+        Reference:   fmt.Sprintf("expire:%d", batch.EntryID),
+        Points:      -batch.Remaining,
+        Description: fmt.Sprintf("Points earned on %s expired", batch.CreatedAt.Format("2006-01-02")),
+    }
+    if err := tx.Create(&expiry).Error; err != nil {
+        tx.Rollback()
+        return 0, err
+    }
+    if err := tx.Commit().Error; err != nil {
+        return 0, err
+    }
+    return batch.Remaining, nil
+}
+
+// GetAvailableEntries lists the user's unexpired batches with points left,
+// soonest expiry first.
+func (r *loyaltyRepositoryImpl) GetAvailableEntries(userID uint, now time.Time) ([]models.LoyaltyLedgerEntry, error) {
+    var entries []models.LoyaltyLedgerEntry
+    err := r.DB.Where("user_id = ? AND remaining > 0 AND (expires_at IS NULL OR expires_at > ?)", userID, now).
+        Order("expires_at ASC NULLS LAST, entry_id ASC").
+        Find(&entries).Error
+    return entries, err
+}
+
+func (r *loyaltyRepositoryImpl) GetLedger(userID uint) ([]models.LoyaltyLedgerEntry, error) {
+    var entries []models.LoyaltyLedgerEntry
+    err := r.DB.Where("user_id = ?", userID).Order("created_at DESC, entry_id DESC").Find(&entries).Error
+    return entries, err
+}
@@ -1,14 +1,39 @@
 package repository
 
 import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
     "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/pkg/utils"
     "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+var (
+    // ErrNolCardNotUsable is returned for journeys, top-ups and payments on a
+    // card that is blocked, hotlisted, replaced or expired.
+    ErrNolCardNotUsable = errors.New("NolCard is blocked, hotlisted, replaced or expired")
+    ErrInvalidNolCardTransition = errors.New("NolCard status change not allowed")
 )
 
 type NolCardRepository interface {
     GetNolCardByID(nolCardID int) (models.NolCard, error)
-    AddNolCard(nolCard models.NolCard) error
+    AddNolCard(nolCard *models.NolCard) error
     GetNolCardByNumber(cardNumber string) (*models.NolCard, error)
+    GenerateCardNumber(cardType string) (string, error)
+    GetCardNumberSeries() ([]models.CardNumberSeries, error)
+    SetCardNumberPrefix(cardType, prefix string) error
+    EnsureDefaultCardNumberSeries() error
+    GetNolCardsWithInvalidNumbers() ([]models.NolCard, error)
+    ReissueCardNumber(nolCardID int, cardNumber, reason string, actorID uint) error
+    GetNolCardsByUserID(userID int) ([]models.NolCard, error)
+    UpdateNolCardStatus(nolCardID int, allowedFrom []string, toStatus, reason string, actorID uint, actorRole string) error
+    ReplaceNolCard(oldCardID int, newCard *models.NolCard, reason string, actorID uint) error
+    GetStatusEvents(nolCardID int) ([]models.NolCardStatusEvent, error)
+    ExpireNolCards() (int64, error)
 }
 
 type NolCardRepositoryImpl struct {
@@ -25,16 +50,357 @@ func (r *NolCardRepositoryImpl) GetNolCardByID(nolCardID int) (models.NolCard, e
     return nolCard, err
 }
 
-func (r *NolCardRepositoryImpl) AddNolCard(nolCard models.NolCard) error {
-    return r.DB.Create(&nolCard).Error
+func (r *NolCardRepositoryImpl) AddNolCard(nolCard *models.NolCard) error {
+    return r.DB.Create(nolCard).Error
 }
 
+// GetNolCardByNumber finds a card by its current or legacy number. A number
+// that matches nothing and fails the checksum is reported as invalid rather
+// than not found.
 func (r *NolCardRepositoryImpl) GetNolCardByNumber(cardNumber string) (*models.NolCard, error) {
+    cardNumber = strings.TrimSpace(cardNumber)
     var nolCard models.NolCard
-    err := r.DB.Where("card_number = ?", cardNumber).First(&nolCard).Error
+    err := r.DB.Where("card_number = ? OR legacy_card_number = ?", cardNumber, cardNumber).
+        Order("nol_card_id DESC").First(&nolCard).Error
     if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) && utils.ValidateCardNumber(cardNumber) != nil {
+            return nil, utils.ErrInvalidCardNumber
+        }
         return nil, err
     }
     return &nolCard, nil
 }
 
+func (r *NolCardRepositoryImpl) GetNolCardsByUserID(userID int) ([]models.NolCard, error) {
+    var nolCards []models.NolCard
+    err := r.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&nolCards).Error
+    return nolCards, err
+}
+
+// UpdateNolCardStatus moves a card to toStatus only if it is currently in one
+// of allowedFrom, and records the transition.
+func (r *NolCardRepositoryImpl) UpdateNolCardStatus(nolCardID int, allowedFrom []string, toStatus, reason string, actorID uint, actorRole string) error {
+    tx := r.DB.Begin()
+
+    var nolCard models.NolCard
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("nol_card_id = ?", nolCardID).First(&nolCard).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    if !containsStatus(allowedFrom, nolCard.Status) {
+        tx.Rollback()
+        return fmt.Errorf("%w: card is %s", ErrInvalidNolCardTransition, displayStatus(nolCard.Status))
+    }
+
+    now := time.Now()
+    if err := tx.Model(&models.NolCard{}).Where("nol_card_id = ?", nolCardID).
+        Updates(map[string]interface{}{"status": toStatus, "status_reason": reason, "status_changed_at": now}).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    event := models.NolCardStatusEvent{
+        NolCardID:  nolCardID,
+        FromStatus: nolCard.Status,
+        ToStatus:   toStatus,
+        Reason:     reason,
+        ActorID:    actorID,
+        ActorRole:  actorRole,
+    }
+    if err := tx.Create(&event).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    return tx.Commit().Error
+}
+
+// ReplaceNolCard issues newCard to the owner of the old card and moves the
+// balance, active subscriptions and auto-reload rules onto it in one transaction.
+func (r *NolCardRepositoryImpl) ReplaceNolCard(oldCardID int, newCard *models.NolCard, reason string, actorID uint) error {
+    tx := r.DB.Begin()
+
+    var oldCard models.NolCard
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("nol_card_id = ?", oldCardID).First(&oldCard).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    if oldCard.Status == models.NolCardStatusReplaced {
+        tx.Rollback()
+        return fmt.Errorf("%w: card was already replaced", ErrInvalidNolCardTransition)
+    }
+
+    now := time.Now()
+    newCard.UserID = oldCard.UserID
+    if newCard.CardType == "" {
+        newCard.CardType = oldCard.CardType
+    }
+    newCard.Balance = oldCard.Balance
+    newCard.Status = models.NolCardStatusActive
+    newCard.StatusChangedAt = &now
+    if err := tx.Create(newCard).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    if err := tx.Model(&models.NolCard{}).Where("nol_card_id = ?", oldCard.NolCardID).
+        Updates(map[string]interface{}{
+            "balance":           0,
+            "status":            models.NolCardStatusReplaced,
+            "status_reason":     reason,
+            "status_changed_at": now,
+            "replaced_by_id":    newCard.NolCardID,
+        }).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    if err := tx.Model(&models.Subscription{}).
+        Where("nol_card_id = ? AND end_date > ?", oldCard.NolCardID, now).
+        Update("nol_card_id", newCard.NolCardID).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    if err := tx.Model(&models.AutoReloadRule{}).
+        Where("nol_card_id = ?", oldCard.NolCardID).
+        Update("nol_card_id", newCard.NolCardID).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    events := []models.NolCardStatusEvent{
+        {
+            NolCardID:  oldCard.NolCardID,
+            FromStatus: oldCard.Status,
+            ToStatus:   models.NolCardStatusReplaced,
+            Reason:     fmt.Sprintf("%s; balance %.2f moved to card %s", reason, oldCard.Balance, newCard.CardNumber),
+            ActorID:    actorID,
+            ActorRole:  "admin",
+            BalanceMoved: -oldCard.Balance,
+        },
+        {
+            NolCardID: newCard.NolCardID,
+            ToStatus:  models.NolCardStatusActive,
+            Reason:    fmt.Sprintf("issued as replacement for card %s", oldCard.CardNumber),
+            ActorID:   actorID,
+            ActorRole: "admin",
+            BalanceMoved: oldCard.Balance,
+        },
+    }
+    if err := tx.Create(&events).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    return tx.Commit().Error
+}
+
+func (r *NolCardRepositoryImpl) GetStatusEvents(nolCardID int) ([]models.NolCardStatusEvent, error) {
+    var events []models.NolCardStatusEvent
+    err := r.DB.Where("nol_card_id = ?", nolCardID).Order("created_at ASC").Find(&events).Error
+    return events, err
+}
+
+// ExpireNolCards moves every usable card past its expiry date to expired and
+// records a status event for each in the same transaction.
+func (r *NolCardRepositoryImpl) ExpireNolCards() (int64, error) {
+    tx := r.DB.Begin()
+
+    now := time.Now()
+    var nolCards []models.NolCard
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("expires_at IS NOT NULL AND expires_at <= ? AND status IN ?", now, models.NolCardUsableStatuses).
+        Find(&nolCards).Error; err != nil {
+        tx.Rollback()
+        return 0, err
+    }
+    if len(nolCards) == 0 {
+        tx.Rollback()
+        return 0, nil
+    }
+
+    const reason = "card validity ended"
+    nolCardIDs := make([]int, 0, len(nolCards))
+    events := make([]models.NolCardStatusEvent, 0, len(nolCards))
+    for _, nolCard := range nolCards {
+        nolCardIDs = append(nolCardIDs, nolCard.NolCardID)
+        events = append(events, models.NolCardStatusEvent{
+            NolCardID:  nolCard.NolCardID,
+            FromStatus: nolCard.Status,
+            ToStatus:   models.NolCardStatusExpired,
+            Reason:     reason,
+            ActorRole:  "system",
+        })
+    }
+
+    result := tx.Model(&models.NolCard{}).
+        Where("nol_card_id IN ?", nolCardIDs).
+        Updates(map[string]interface{}{
+            "status":            models.NolCardStatusExpired,
+            "status_reason":     reason,
+            "status_changed_at": now,
+        })
+    if result.Error != nil {
+        tx.Rollback()
+        return 0, result.Error
+    }
+    if err := tx.Create(&events).Error; err != nil {
+        tx.Rollback()
+        return 0, err
+    }
+
+    if err := tx.Commit().Error; err != nil {
+        return 0, err
+    }
+    return result.RowsAffected, nil
+}
+
+// GenerateCardNumber issues the next number in the card type's series:
+// prefix, zero-padded sequence and a Luhn check digit. The series row is locked
+// so concurrent issues never get the same number.
+func (r *NolCardRepositoryImpl) GenerateCardNumber(cardType string) (string, error) {
+    tx := r.DB.Begin()
+
+    var series models.CardNumberSeries
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("card_type = ?", cardType).First(&series).Error; err != nil {
+        tx.Rollback()
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return "", fmt.Errorf("no card number prefix configured for card type %s", cardType)
+        }
+        return "", err
+    }
+
+    width := utils.CardNumberLength - 1 - len(series.Prefix)
+    sequence := series.NextSequence
+    var number string
+    for {
+        payload := fmt.Sprintf("%s%0*d", series.Prefix, width, sequence)
+        if len(payload) != utils.CardNumberLength-1 {
+            tx.Rollback()
+            return "", fmt.Errorf("card number series for %s is exhausted", cardType)
+        }
+        checkDigit, err := utils.LuhnCheckDigit(payload)
+        if err != nil {
+            tx.Rollback()
+            return "", err
+        }
+        number = fmt.Sprintf("%s%d", payload, checkDigit)
+        sequence++
+
+        // Skip numbers already taken by cards issued before generation existed.
+        var count int64
+        if err := tx.Model(&models.NolCard{}).Where("card_number = ?", number).Count(&count).Error; err != nil {
+            tx.Rollback()
+            return "", err
+        }
+        if count == 0 {
+            break
+        }
+    }
+
+    if err := tx.Model(&models.CardNumberSeries{}).Where("series_id = ?", series.SeriesID).
+        Update("next_sequence", sequence).Error; err != nil {
+        tx.Rollback()
+        return "", err
+    }
+    if err := tx.Commit().Error; err != nil {
+        return "", err
+    }
+    return number, nil
+}
+
+func (r *NolCardRepositoryImpl) GetCardNumberSeries() ([]models.CardNumberSeries, error) {
+    var series []models.CardNumberSeries
+    err := r.DB.Order("card_type").Find(&series).Error
+    return series, err
+}
+
+func (r *NolCardRepositoryImpl) SetCardNumberPrefix(cardType, prefix string) error {
+    series := models.CardNumberSeries{CardType: cardType, Prefix: prefix, NextSequence: 1}
+    return r.DB.Clauses(clause.OnConflict{
+        Columns:   []clause.Column{{Name: "card_type"}},
+        DoUpdates: clause.AssignmentColumns([]string{"prefix", "updated_at"}),
+    }).Create(&series).Error
+}
+
+// EnsureDefaultCardNumberSeries seeds a prefix for every card type that has none.
+func (r *NolCardRepositoryImpl) EnsureDefaultCardNumberSeries() error {
+    for cardType, prefix := range models.DefaultCardNumberPrefixes {
+        series := models.CardNumberSeries{CardType: cardType, Prefix: prefix, NextSequence: 1}
+        if err := r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&series).Error; err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// GetNolCardsWithInvalidNumbers lists cards whose stored number fails validation,
+// typically free-text numbers entered before numbers were generated.
+func (r *NolCardRepositoryImpl) GetNolCardsWithInvalidNumbers() ([]models.NolCard, error) {
+    var nolCards []models.NolCard
+    if err := r.DB.Order("nol_card_id").Find(&nolCards).Error; err != nil {
+        return nil, err
+    }
+    invalid := make([]models.NolCard, 0)
+    for _, nolCard := range nolCards {
+        if utils.ValidateCardNumber(nolCard.CardNumber) != nil {
+            invalid = append(invalid, nolCard)
+        }
+    }
+    return invalid, nil
+}
+
+// ReissueCardNumber gives a card whose number fails validation the generated
+// cardNumber, keeping the old one as LegacyCardNumber so the physical card is
+// still found, and records the change in the card's history.
+func (r *NolCardRepositoryImpl) ReissueCardNumber(nolCardID int, cardNumber, reason string, actorID uint) error {
+    tx := r.DB.Begin()
+
+    var nolCard models.NolCard
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("nol_card_id = ?", nolCardID).First(&nolCard).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    if utils.ValidateCardNumber(nolCard.CardNumber) == nil {
+        tx.Rollback()
+        return fmt.Errorf("%w: card number %s is already valid", ErrInvalidNolCardTransition, nolCard.CardNumber)
+    }
+
+    if err := tx.Model(&models.NolCard{}).Where("nol_card_id = ?", nolCardID).
+        Updates(map[string]interface{}{"card_number": cardNumber, "legacy_card_number": nolCard.CardNumber}).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    event := models.NolCardStatusEvent{
+        NolCardID:  nolCardID,
+        FromStatus: nolCard.Status,
+        ToStatus:   nolCard.Status,
+        Reason:     fmt.Sprintf("%s; number %s reissued as %s", reason, nolCard.CardNumber, cardNumber),
+        ActorID:    actorID,
+        ActorRole:  "admin",
+    }
+    if err := tx.Create(&event).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    return tx.Commit().Error
+}
+
+func containsStatus(statuses []string, status string) bool {
+    for _, s := range statuses {
+        if s == status {
+            return true
+        }
+    }
+    return false
+}
+
+func displayStatus(status string) string {
+    if status == "" {
+        return models.NolCardStatusActive
+    }
+    return status
+}
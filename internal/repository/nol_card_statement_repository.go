@@ -0,0 +1,172 @@
+package repository
+
+import (
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+type NolCardStatementRepository interface {
+    ChargeJourney(journey *models.NolCardJourney) error
+    GetJourneyByID(journeyID uint) (*models.NolCardJourney, error)
+    GetJourneysByCardID(nolCardID int, from, to time.Time) ([]models.NolCardJourney, error)
+    RefundToCard(refund *models.NolCardRefund) error
+    GetRefundsByCardID(nolCardID int, from, to time.Time) ([]models.NolCardRefund, error)
+    GetTopupsByCardID(nolCardID int, from, to time.Time) ([]models.NolCardTopup, error)
+    GetSubscriptionsByCardID(nolCardID int, from, to time.Time) ([]models.Subscription, error)
+    GetBalanceMovesByCardID(nolCardID int, from, to time.Time) ([]models.NolCardStatusEvent, error)
+    NetMovementSince(nolCardID int, since time.Time) (float64, error)
+    GetCardsWithActivity(from, to time.Time) ([]models.NolCard, error)
+    HasDispatch(nolCardID int, period string) (bool, error)
+    RecordDispatch(nolCardID int, period string) error
+}
+
+type nolCardStatementRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewNolCardStatementRepository(db *gorm.DB) NolCardStatementRepository {
+    return &nolCardStatementRepositoryImpl{DB: db}
+}
+
+// ChargeJourney deducts the fare and records the journey in one transaction.
+// A journey covered by a subscription uses up one ride; if the allowance ran
+// out in the meantime the full fare is charged instead.
+func (r *nolCardStatementRepositoryImpl) ChargeJourney(journey *models.NolCardJourney) error {
+    tx := r.DB.Begin()
+    if journey.SubscriptionID != nil {
+        result := tx.Model(&models.Subscription{}).
+            Where("subscription_id = ? AND (ride_allowance = 0 OR rides_used < ride_allowance)", *journey.SubscriptionID).
+            Update("rides_used", gorm.Expr("rides_used + 1"))
+        if result.Error != nil {
+            tx.Rollback()
+            return result.Error
+        }
+        if result.RowsAffected == 0 {
+            journey.SubscriptionID = nil
+            journey.Fare = journey.FullFare
+        }
+    }
+    if journey.Fare > 0 {
+        if err := debitNolCardTx(tx, journey.NolCardID, journey.Fare); err != nil {
+            tx.Rollback()
+            return err
+        }
+    }
+    if err := tx.Create(journey).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+func (r *nolCardStatementRepositoryImpl) GetJourneyByID(journeyID uint) (*models.NolCardJourney, error) {
+    var journey models.NolCardJourney
+    if err := r.DB.Where("journey_id = ?", journeyID).First(&journey).Error; err != nil {
+        return nil, err
+    }
+    return &journey, nil
+}
+
+func (r *nolCardStatementRepositoryImpl) GetJourneysByCardID(nolCardID int, from, to time.Time) ([]models.NolCardJourney, error) {
+    var journeys []models.NolCardJourney
+    err := r.DB.Where("nol_card_id = ? AND created_at >= ? AND created_at < ?", nolCardID, from, to).
+        Order("created_at ASC").Find(&journeys).Error
+    return journeys, err
+}
+
+// RefundToCard credits the card and records the refund in one transaction.
+func (r *nolCardStatementRepositoryImpl) RefundToCard(refund *models.NolCardRefund) error {
+    tx := r.DB.Begin()
+    if err := creditNolCardTx(tx, refund.NolCardID, refund.Amount); err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := tx.Create(refund).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+func (r *nolCardStatementRepositoryImpl) GetRefundsByCardID(nolCardID int, from, to time.Time) ([]models.NolCardRefund, error) {
+    var refunds []models.NolCardRefund
+    err := r.DB.Where("nol_card_id = ? AND created_at >= ? AND created_at < ?", nolCardID, from, to).
+        Order("created_at ASC").Find(&refunds).Error
+    return refunds, err
+}
+
+func (r *nolCardStatementRepositoryImpl) GetTopupsByCardID(nolCardID int, from, to time.Time) ([]models.NolCardTopup, error) {
+    var topups []models.NolCardTopup
+    err := r.DB.Where("nol_card_id = ? AND created_at >= ? AND created_at < ?", nolCardID, from, to).
+        Order("created_at ASC").Find(&topups).Error
+    return topups, err
+}
+
+func (r *nolCardStatementRepositoryImpl) GetSubscriptionsByCardID(nolCardID int, from, to time.Time) ([]models.Subscription, error) {
+    var subscriptions []models.Subscription
+    err := r.DB.Where("nol_card_id = ? AND created_at >= ? AND created_at < ?", nolCardID, from, to).
+        Order("created_at ASC").Find(&subscriptions).Error
+    return subscriptions, err
+}
+
+// GetBalanceMovesByCardID returns the status changes that moved balance onto
+// or off the card, such as a replacement.
+func (r *nolCardStatementRepositoryImpl) GetBalanceMovesByCardID(nolCardID int, from, to time.Time) ([]models.NolCardStatusEvent, error) {
+    var events []models.NolCardStatusEvent
+    err := r.DB.Where("nol_card_id = ? AND balance_moved <> 0 AND created_at >= ? AND created_at < ?", nolCardID, from, to).
+        Order("created_at ASC").Find(&events).Error
+    return events, err
+}
+
+// NetMovementSince is the sum of credits minus debits recorded on the card from
+// since onwards. Subtracting it from the current balance gives the balance at since.
+func (r *nolCardStatementRepositoryImpl) NetMovementSince(nolCardID int, since time.Time) (float64, error) {
+    var topups, refunds, journeys, moves float64
+    if err := r.DB.Model(&models.NolCardTopup{}).
+        Where("nol_card_id = ? AND created_at >= ?", nolCardID, since).
+        Select("COALESCE(SUM(amount), 0)").Scan(&topups).Error; err != nil {
+        return 0, err
+    }
+    if err := r.DB.Model(&models.NolCardRefund{}).
+        Where("nol_card_id = ? AND created_at >= ?", nolCardID, since).
+        Select("COALESCE(SUM(amount), 0)").Scan(&refunds).Error; err != nil {
+        return 0, err
+    }
+    if err := r.DB.Model(&models.NolCardJourney{}).
+        Where("nol_card_id = ? AND created_at >= ?", nolCardID, since).
+        Select("COALESCE(SUM(fare), 0)").Scan(&journeys).Error; err != nil {
+        return 0, err
+    }
+    if err := r.DB.Model(&models.NolCardStatusEvent{}).
+        Where("nol_card_id = ? AND created_at >= ?", nolCardID, since).
+        Select("COALESCE(SUM(balance_moved), 0)").Scan(&moves).Error; err != nil {
+        return 0, err
+    }
+    return topups + refunds - journeys + moves, nil
+}
+
+// GetCardsWithActivity returns cards with at least one statement entry in the range.
+func (r *nolCardStatementRepositoryImpl) GetCardsWithActivity(from, to time.Time) ([]models.NolCard, error) {
+    var nolCards []models.NolCard
+    err := r.DB.Where(`nol_card_id IN (SELECT nol_card_id FROM nol_card_topups WHERE created_at >= ? AND created_at < ?)
+        OR nol_card_id IN (SELECT nol_card_id FROM nol_card_journeys WHERE created_at >= ? AND created_at < ?)
+        OR nol_card_id IN (SELECT nol_card_id FROM nol_card_refunds WHERE created_at >= ? AND created_at < ?)
+        OR nol_card_id IN (SELECT nol_card_id FROM subscriptions WHERE created_at >= ? AND created_at < ?)
+        OR nol_card_id IN (SELECT nol_card_id FROM nol_card_status_events WHERE balance_moved <> 0 AND created_at >= ? AND created_at < ?)`,
+        from, to, from, to, from, to, from, to, from, to).
+        Find(&nolCards).Error
+    return nolCards, err
+}
+
+func (r *nolCardStatementRepositoryImpl) HasDispatch(nolCardID int, period string) (bool, error) {
+    var count int64
+    err := r.DB.Model(&models.StatementDispatch{}).
+        Where("nol_card_id = ? AND period = ?", nolCardID, period).Count(&count).Error
+    return count > 0, err
+}
+
+func (r *nolCardStatementRepositoryImpl) RecordDispatch(nolCardID int, period string) error {
+    return r.DB.Create(&models.StatementDispatch{NolCardID: nolCardID, Period: period}).Error
+}
@@ -33,12 +33,9 @@ func (r *NolCardTopupRepositoryImpl) AddTopupAndUpdateBalance(topup models.NolCa
         return err
     }
 
-    currentBalance := nolCard.Balance 
-
-    newBalance := currentBalance + topup.Amount 
-
-    // Update the balance in the NolCard table
-    if err := tx.Model(&models.NolCard{}).Where("nol_card_id = ?", nolCard.NolCardID).Update("balance", newBalance).Error; err != nil {
+    // Increment in place so concurrent top-ups on the same card cannot
+    // overwrite each other with a stale balance.
+    if err := creditNolCardTx(tx, nolCard.NolCardID, topup.Amount); err != nil {
         tx.Rollback() // Rollback on error
         return err
     }
@@ -47,11 +44,55 @@ func (r *NolCardTopupRepositoryImpl) AddTopupAndUpdateBalance(topup models.NolCa
     if err := tx.Commit().Error; err != nil {
         return err
     }
-    log.Printf("Current Balance: %f, Topup Amount: %f, New Balance: %f", currentBalance, topup.Amount, newBalance)
+    log.Printf("Nol card %d topped up by %f", nolCard.NolCardID, topup.Amount)
 
     return nil // Successful operation
 }
 
+// creditNolCardTx increments a card balance inside an open transaction. Cards
+// that are not in a usable state are rejected.
+func creditNolCardTx(tx *gorm.DB, nolCardID int, amount float64) error {
+    result := tx.Model(&models.NolCard{}).
+        Where("nol_card_id = ? AND status IN ?", nolCardID, models.NolCardUsableStatuses).
+        Update("balance", gorm.Expr("balance + ?", amount))
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        var count int64
+        if err := tx.Model(&models.NolCard{}).Where("nol_card_id = ?", nolCardID).Count(&count).Error; err != nil {
+            return err
+        }
+        if count == 0 {
+            return gorm.ErrRecordNotFound
+        }
+        return ErrNolCardNotUsable
+    }
+    return nil
+}
+
+// debitNolCardTx decrements a usable card's balance inside an open transaction
+// and returns ErrInsufficientBalance when the balance does not cover amount.
+func debitNolCardTx(tx *gorm.DB, nolCardID int, amount float64) error {
+    result := tx.Model(&models.NolCard{}).
+        Where("nol_card_id = ? AND status IN ? AND balance >= ?", nolCardID, models.NolCardUsableStatuses, amount).
+        Update("balance", gorm.Expr("balance - ?", amount))
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        var nolCard models.NolCard
+        if err := tx.Where("nol_card_id = ?", nolCardID).First(&nolCard).Error; err != nil {
+            return err
+        }
+        if !nolCard.IsUsable() {
+            return ErrNolCardNotUsable
+        }
+        return ErrInsufficientBalance
+    }
+    return nil
+}
+
 func (r *NolCardTopupRepositoryImpl) GetTopupsByCardID(nolCardID int) ([]models.NolCardTopup, error) {
     var topups []models.NolCardTopup
     err := r.db.Where("nol_card_id = ?", nolCardID).Find(&topups).Error
@@ -0,0 +1,209 @@
+package repository
+
+import (
+    "errors"
+    "math/rand"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+// newTestNolCard creates an active card holding balance and removes it, with
+// its top-ups and journeys, when the test ends.
+func newTestNolCard(t *testing.T, db *gorm.DB, balance float64) *models.NolCard {
+    t.Helper()
+    if err := db.AutoMigrate(&models.NolCard{}, &models.NolCardTopup{}, &models.NolCardJourney{}); err != nil {
+        t.Fatalf("migrating test database: %v", err)
+    }
+    nolCard := &models.NolCard{
+        UserID:     int(rand.Int31()),
+        CardNumber: "TEST" + time.Now().Format("150405.000000"),
+        CardType:   models.CardTypeOrdinary,
+        Balance:    balance,
+        Status:     models.NolCardStatusActive,
+    }
+    if err := db.Create(nolCard).Error; err != nil {
+        t.Fatalf("creating NolCard: %v", err)
+    }
+    t.Cleanup(func() {
+        db.Where("nol_card_id = ?", nolCard.NolCardID).Delete(&models.NolCardTopup{})
+        db.Where("nol_card_id = ?", nolCard.NolCardID).Delete(&models.NolCardJourney{})
+        db.Where("nol_card_id = ?", nolCard.NolCardID).Delete(&models.NolCard{})
+    })
+    return nolCard
+}
+
+func nolCardState(t *testing.T, db *gorm.DB, nolCardID int) (float64, int64, int64) {
+    t.Helper()
+    var nolCard models.NolCard
+    if err := db.Where("nol_card_id = ?", nolCardID).First(&nolCard).Error; err != nil {
+        t.Fatalf("reading NolCard: %v", err)
+    }
+    var topups, journeys int64
+    if err := db.Model(&models.NolCardTopup{}).Where("nol_card_id = ?", nolCardID).Count(&topups).Error; err != nil {
+        t.Fatalf("counting top-ups: %v", err)
+    }
+    if err := db.Model(&models.NolCardJourney{}).Where("nol_card_id = ?", nolCardID).Count(&journeys).Error; err != nil {
+        t.Fatalf("counting journeys: %v", err)
+    }
+    return nolCard.Balance, topups, journeys
+}
+
+func testJourney(nolCardID int, fare float64) *models.NolCardJourney {
+    return &models.NolCardJourney{NolCardID: nolCardID, RouteID: 1, FromStopID: 1, ToStopID: 2, Fare: fare, FullFare: fare}
+}
+
+func TestNolCardTopupConcurrentTopupsAreNotLost(t *testing.T) {
+    db := testDB(t)
+    repo := NewNolCardTopupRepository(db)
+    nolCard := newTestNolCard(t, db, 0)
+
+    const workers = 50
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            topup := models.NolCardTopup{NolCardID: nolCard.NolCardID, Amount: 5, TopupDate: time.Now()}
+            if err := repo.AddTopupAndUpdateBalance(topup, *nolCard); err != nil {
+                t.Errorf("unexpected top-up error: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    balance, topups, _ := nolCardState(t, db, nolCard.NolCardID)
+    if balance != workers*5 {
+        t.Errorf("balance = %.2f, want %d", balance, workers*5)
+    }
+    if topups != workers {
+        t.Errorf("top-ups = %d, want %d", topups, workers)
+    }
+}
+
+func TestNolCardJourneyConcurrentChargesNeverOverspend(t *testing.T) {
+    db := testDB(t)
+    repo := NewNolCardStatementRepository(db)
+    nolCard := newTestNolCard(t, db, 100)
+
+    const workers = 50
+    var (
+        wg      sync.WaitGroup
+        mu      sync.Mutex
+        charged int
+    )
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            err := repo.ChargeJourney(testJourney(nolCard.NolCardID, 10))
+            mu.Lock()
+            defer mu.Unlock()
+            switch {
+            case err == nil:
+                charged++
+            case !errors.Is(err, ErrInsufficientBalance):
+                t.Errorf("unexpected charge error: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    balance, _, journeys := nolCardState(t, db, nolCard.NolCardID)
+    if charged != 10 || balance != 0 {
+        t.Errorf("charged = %d, balance = %.2f; want 10 journeys and a zero balance", charged, balance)
+    }
+    if journeys != int64(charged) {
+        t.Errorf("journeys = %d, want %d", journeys, charged)
+    }
+}
+
+func TestNolCardConcurrentTopupsAndCharges(t *testing.T) {
+    db := testDB(t)
+    topupRepo := NewNolCardTopupRepository(db)
+    statementRepo := NewNolCardStatementRepository(db)
+    nolCard := newTestNolCard(t, db, 50)
+
+    const workers = 40
+    var (
+        wg      sync.WaitGroup
+        mu      sync.Mutex
+        charged int
+    )
+    for i := 0; i < workers; i++ {
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            topup := models.NolCardTopup{NolCardID: nolCard.NolCardID, Amount: 10, TopupDate: time.Now()}
+            if err := topupRepo.AddTopupAndUpdateBalance(topup, *nolCard); err != nil {
+                t.Errorf("unexpected top-up error: %v", err)
+            }
+        }()
+        go func() {
+            defer wg.Done()
+            err := statementRepo.ChargeJourney(testJourney(nolCard.NolCardID, 25))
+            mu.Lock()
+            defer mu.Unlock()
+            switch {
+            case err == nil:
+                charged++
+            case !errors.Is(err, ErrInsufficientBalance):
+                t.Errorf("unexpected charge error: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    balance, topups, journeys := nolCardState(t, db, nolCard.NolCardID)
+    want := 50 + workers*10 - float64(charged)*25
+    if balance < 0 {
+        t.Errorf("balance = %.2f, went negative", balance)
+    }
+    if balance != want {
+        t.Errorf("balance = %.2f, want %.2f after %d journeys", balance, want, charged)
+    }
+    if topups != workers || journeys != int64(charged) {
+        t.Errorf("top-ups = %d, journeys = %d; want %d and %d", topups, journeys, workers, charged)
+    }
+}
+
+// A card that is no longer usable takes neither top-ups nor charges, however
+// many arrive at once.
+func TestNolCardBlockedCardRejectsConcurrentMoves(t *testing.T) {
+    db := testDB(t)
+    topupRepo := NewNolCardTopupRepository(db)
+    statementRepo := NewNolCardStatementRepository(db)
+    nolCard := newTestNolCard(t, db, 100)
+    if err := db.Model(&models.NolCard{}).Where("nol_card_id = ?", nolCard.NolCardID).
+        Update("status", models.NolCardStatusBlocked).Error; err != nil {
+        t.Fatalf("blocking NolCard: %v", err)
+    }
+
+    const workers = 20
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            topup := models.NolCardTopup{NolCardID: nolCard.NolCardID, Amount: 10, TopupDate: time.Now()}
+            if err := topupRepo.AddTopupAndUpdateBalance(topup, *nolCard); !errors.Is(err, ErrNolCardNotUsable) {
+                t.Errorf("top-up error = %v, want ErrNolCardNotUsable", err)
+            }
+        }()
+        go func() {
+            defer wg.Done()
+            if err := statementRepo.ChargeJourney(testJourney(nolCard.NolCardID, 10)); !errors.Is(err, ErrNolCardNotUsable) {
+                t.Errorf("charge error = %v, want ErrNolCardNotUsable", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    balance, topups, journeys := nolCardState(t, db, nolCard.NolCardID)
+    if balance != 100 || topups != 0 || journeys != 0 {
+        t.Errorf("balance = %.2f, top-ups = %d, journeys = %d; want the card untouched", balance, topups, journeys)
+    }
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+    "errors"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+// ErrUpgradeNotApplicable is returned when the card no longer has the tier the
+// upgrade was priced for, or the upgrade was already settled.
+var ErrUpgradeNotApplicable = errors.New("upgrade no longer applies to this card")
+
+type NolCardUpgradeRepository interface {
+    CreateRule(rule *models.NolCardUpgradeRule) error
+    UpdateRule(rule *models.NolCardUpgradeRule) error
+    DeleteRule(ruleID uint) error
+    GetAllRules() ([]models.NolCardUpgradeRule, error)
+    GetRuleByID(ruleID uint) (*models.NolCardUpgradeRule, error)
+    GetEnabledRulesFrom(fromCardType string) ([]models.NolCardUpgradeRule, error)
+
+    CreateUpgrade(upgrade *models.NolCardUpgrade) error
+    CancelPendingUpgrades(nolCardID int) error
+    GetPendingUpgradeByCard(nolCardID int) (*models.NolCardUpgrade, error)
+    GetUpgradesByUserID(userID uint) ([]models.NolCardUpgrade, error)
+    AttachPayment(upgradeID, paymentID uint, orderID string) error
+    CompleteUpgrade(upgradeID uint) error
+
+    GetUserSpendSince(userID uint, since time.Time) (float64, error)
+}
+
+type nolCardUpgradeRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewNolCardUpgradeRepository(db *gorm.DB) NolCardUpgradeRepository {
+    return &nolCardUpgradeRepositoryImpl{DB: db}
+}
+
+func (r *nolCardUpgradeRepositoryImpl) CreateRule(rule *models.NolCardUpgradeRule) error {
+    return r.DB.Create(rule).Error
+}
+
+func (r *nolCardUpgradeRepositoryImpl) UpdateRule(rule *models.NolCardUpgradeRule) error {
+    return r.DB.Model(&models.NolCardUpgradeRule{}).
+        Where("upgrade_rule_id = ?", rule.UpgradeRuleID).
+        Select("price", "min_spend", "spend_window_days", "min_card_age_days", "enabled").
+        Updates(rule).Error
+}
+
+func (r *nolCardUpgradeRepositoryImpl) DeleteRule(ruleID uint) error {
+    return r.DB.Where("upgrade_rule_id = ?", ruleID).Delete(&models.NolCardUpgradeRule{}).Error
+}
+
+func (r *nolCardUpgradeRepositoryImpl) GetAllRules() ([]models.NolCardUpgradeRule, error) {
+    var rules []models.NolCardUpgradeRule
+    err := r.DB.Order("from_card_type, to_card_type").Find(&rules).Error
+    return rules, err
+}
+
+func (r *nolCardUpgradeRepositoryImpl) GetRuleByID(ruleID uint) (*models.NolCardUpgradeRule, error) {
+    var rule models.NolCardUpgradeRule
+    if err := r.DB.Where("upgrade_rule_id = ?", ruleID).First(&rule).Error; err != nil {
+        return nil, err
+    }
+    return &rule, nil
+}
+
+func (r *nolCardUpgradeRepositoryImpl) GetEnabledRulesFrom(fromCardType string) ([]models.NolCardUpgradeRule, error) {
+    var rules []models.NolCardUpgradeRule
+    err := r.DB.Where("from_card_type = ? AND enabled = ?", fromCardType, true).Find(&rules).Error
+    return rules, err
+}
+
+func (r *nolCardUpgradeRepositoryImpl) CreateUpgrade(upgrade *models.NolCardUpgrade) error {
+    return r.DB.Create(upgrade).Error
+}
+
+func (r *nolCardUpgradeRepositoryImpl) CancelPendingUpgrades(nolCardID int) error {
+    return r.DB.Model(&models.NolCardUpgrade{}).
+        Where("nol_card_id = ? AND status = ?", nolCardID, models.UpgradeStatusPending).
+        Update("status", models.UpgradeStatusCancelled).Error
+}
+
+func (r *nolCardUpgradeRepositoryImpl) GetPendingUpgradeByCard(nolCardID int) (*models.NolCardUpgrade, error) {
+    var upgrade models.NolCardUpgrade
+    err := r.DB.Where("nol_card_id = ? AND status = ?", nolCardID, models.UpgradeStatusPending).
+        Order("created_at DESC").First(&upgrade).Error
+    if err != nil {
+        return nil, err
+    }
+    return &upgrade, nil
+}
+
+func (r *nolCardUpgradeRepositoryImpl) GetUpgradesByUserID(userID uint) ([]models.NolCardUpgrade, error) {
+    var upgrades []models.NolCardUpgrade
+    err := r.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&upgrades).Error
+    return upgrades, err
+}
+
+func (r *nolCardUpgradeRepositoryImpl) AttachPayment(upgradeID, paymentID uint, orderID string) error {
+    return r.DB.Model(&models.NolCardUpgrade{}).
+        Where("upgrade_id = ?", upgradeID).
+        Updates(map[string]interface{}{"payment_id": paymentID, "order_id": orderID}).Error
+}
+
+// CompleteUpgrade switches the card tier and settles the upgrade together. The
+// card type is only changed if it is still the tier the upgrade was priced from.
+func (r *nolCardUpgradeRepositoryImpl) CompleteUpgrade(upgradeID uint) error {
+    tx := r.DB.Begin()
+
+    var upgrade models.NolCardUpgrade
+    if err := tx.Where("upgrade_id = ?", upgradeID).First(&upgrade).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    now := time.Now()
+    result := tx.Model(&models.NolCardUpgrade{}).
+        Where("upgrade_id = ? AND status = ?", upgradeID, models.UpgradeStatusPending).
+        Updates(map[string]interface{}{"status": models.UpgradeStatusCompleted, "completed_at": now})
+    if result.Error != nil {
+        tx.Rollback()
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        tx.Rollback()
+        return ErrUpgradeNotApplicable
+    }
+
+    result = tx.Model(&models.NolCard{}).
+        Where("nol_card_id = ? AND card_type = ? AND status IN ?", upgrade.NolCardID, upgrade.FromCardType, models.NolCardUsableStatuses).
+        Update("card_type", upgrade.ToCardType)
+    if result.Error != nil {
+        tx.Rollback()
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        tx.Rollback()
+        return ErrUpgradeNotApplicable
+    }
+
+    return tx.Commit().Error
+}
+
+// GetUserSpendSince totals the base-currency value of the user's verified payments.
+func (r *nolCardUpgradeRepositoryImpl) GetUserSpendSince(userID uint, since time.Time) (float64, error) {
+    var total float64
+    err := r.DB.Table("payments").
+        Where("user_id = ? AND status IN ? AND created_at >= ?", userID, []string{models.PaymentStatusVerified}, since).
+        Select("COALESCE(SUM(COALESCE(NULLIF(settled_amount, 0), amount)), 0)").
+        Scan(&total).Error
+    return total, err
+}
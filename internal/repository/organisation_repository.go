@@ -0,0 +1,398 @@
+package repository
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// ErrOrganisationFundsExhausted is returned when a prepaid organisation's
+// balance, or a postpaid organisation's credit limit, cannot cover a charge.
+var ErrOrganisationFundsExhausted = errors.New("organisation balance or credit limit exhausted")
+
+// JourneyTotal is a member's journey count and fares over a period.
+type JourneyTotal struct {
+    UserID   uint
+    Journeys int
+    Fares    float64
+}
+
+type OrganisationRepository interface {
+    CreateOrganisation(organisation *models.Organisation, adminUserID uint) error
+    GetOrganisationByID(organisationID uint) (*models.Organisation, error)
+    GetOrganisations() ([]models.Organisation, error)
+    GetOrganisationByDomain(domain string) (*models.Organisation, error)
+    UpdateBilling(organisationID uint, billingMode string, creditLimit float64, billingEmail string) error
+    UpdateTaxDetails(organisationID uint, gstin, billingAddress, stateCode string) error
+    FundOrganisation(organisationID uint, amount float64) (*models.Organisation, error)
+
+    GetMember(organisationID, userID uint) (*models.OrganisationMember, error)
+    GetMembers(organisationID uint) ([]models.OrganisationMember, error)
+    GetMembershipsByUserID(userID uint) ([]models.OrganisationMember, error)
+    AddMember(member *models.OrganisationMember) error
+    RemoveMember(organisationID, userID uint) error
+
+    CreateInvite(invite *models.OrganisationInvite) error
+    GetInviteByToken(token string) (*models.OrganisationInvite, error)
+    GetInvites(organisationID uint) ([]models.OrganisationInvite, error)
+    AcceptInvite(invite *models.OrganisationInvite, member *models.OrganisationMember) error
+
+    FundSubscription(subscription *models.Subscription, charge *models.OrganisationCharge) error
+    FundTopup(topup *models.NolCardTopup, charge *models.OrganisationCharge) error
+    GetCharges(organisationID uint, from, to time.Time) ([]models.OrganisationCharge, error)
+    GetChargesByInvoiceID(invoiceID uint) ([]models.OrganisationCharge, error)
+    GetJourneyTotals(userIDs []uint, from, to time.Time) ([]JourneyTotal, error)
+
+    GetOrganisationsToInvoice(before time.Time) ([]models.Organisation, error)
+    GetUninvoicedCharges(organisationID uint, before time.Time) ([]models.OrganisationCharge, error)
+    CreateMonthlyInvoice(invoice *models.Invoice, chargeIDs []uint) error
+    GetInvoices(organisationID uint) ([]models.Invoice, error)
+    GetInvoice(organisationID, invoiceID uint) (*models.Invoice, error)
+    SettleInvoice(organisationID, invoiceID uint) error
+}
+
+type organisationRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewOrganisationRepository(db *gorm.DB) OrganisationRepository {
+    return &organisationRepositoryImpl{DB: db}
+}
+
+// CreateOrganisation creates the organisation with its first admin member.
+func (r *organisationRepositoryImpl) CreateOrganisation(organisation *models.Organisation, adminUserID uint) error {
+    tx := r.DB.Begin()
+    if err := tx.Create(organisation).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    member := models.OrganisationMember{
+        OrganisationID: organisation.OrganisationID,
+        UserID:         adminUserID,
+        Role:           models.OrganisationRoleAdmin,
+        Status:         models.MemberStatusActive,
+        JoinedVia:      "admin",
+    }
+    if err := tx.Create(&member).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+func (r *organisationRepositoryImpl) GetOrganisationByID(organisationID uint) (*models.Organisation, error) {
+    var organisation models.Organisation
+    if err := r.DB.Where("organisation_id = ?", organisationID).First(&organisation).Error; err != nil {
+        return nil, err
+    }
+    return &organisation, nil
+}
+
+func (r *organisationRepositoryImpl) GetOrganisations() ([]models.Organisation, error) {
+    var organisations []models.Organisation
+    err := r.DB.Order("name").Find(&organisations).Error
+    return organisations, err
+}
+
+func (r *organisationRepositoryImpl) GetOrganisationByDomain(domain string) (*models.Organisation, error) {
+    var organisation models.Organisation
+    if err := r.DB.Where("LOWER(email_domain) = LOWER(?)", domain).First(&organisation).Error; err != nil {
+        return nil, err
+    }
+    return &organisation, nil
+}
+
+func (r *organisationRepositoryImpl) UpdateBilling(organisationID uint, billingMode string, creditLimit float64, billingEmail string) error {
+    return r.DB.Model(&models.Organisation{}).Where("organisation_id = ?", organisationID).
+        Updates(map[string]interface{}{
+            "billing_mode":  billingMode,
+            "credit_limit":  creditLimit,
+            "billing_email": billingEmail,
+            "updated_at":    time.Now(),
+        }).Error
+}
+
+func (r *organisationRepositoryImpl) UpdateTaxDetails(organisationID uint, gstin, billingAddress, stateCode string) error {
+    return r.DB.Model(&models.Organisation{}).Where("organisation_id = ?", organisationID).
+        Updates(map[string]interface{}{
+            "gstin":           gstin,
+            "billing_address": billingAddress,
+            "state_code":      stateCode,
+            "updated_at":      time.Now(),
+        }).Error
+}
+
+// FundOrganisation adds money to a prepaid organisation's pooled balance.
+func (r *organisationRepositoryImpl) FundOrganisation(organisationID uint, amount float64) (*models.Organisation, error) {
+    result := r.DB.Model(&models.Organisation{}).Where("organisation_id = ?", organisationID).
+        Update("balance", gorm.Expr("balance + ?", amount))
+    if result.Error != nil {
+        return nil, result.Error
+    }
+    if result.RowsAffected == 0 {
+        return nil, gorm.ErrRecordNotFound
+    }
+    return r.GetOrganisationByID(organisationID)
+}
+
+func (r *organisationRepositoryImpl) GetMember(organisationID, userID uint) (*models.OrganisationMember, error) {
+    var member models.OrganisationMember
+    err := r.DB.Where("organisation_id = ? AND user_id = ? AND status = ?", organisationID, userID, models.MemberStatusActive).
+        First(&member).Error
+    if err != nil {
+        return nil, err
+    }
+    return &member, nil
+}
+
+func (r *organisationRepositoryImpl) GetMembers(organisationID uint) ([]models.OrganisationMember, error) {
+    var members []models.OrganisationMember
+    err := r.DB.Where("organisation_id = ? AND status = ?", organisationID, models.MemberStatusActive).
+        Order("created_at").Find(&members).Error
+    return members, err
+}
+
+func (r *organisationRepositoryImpl) GetMembershipsByUserID(userID uint) ([]models.OrganisationMember, error) {
+    var members []models.OrganisationMember
+    err := r.DB.Where("user_id = ? AND status = ?", userID, models.MemberStatusActive).Find(&members).Error
+    return members, err
+}
+
+// AddMember enrols a user, re-activating a previously removed membership.
+func (r *organisationRepositoryImpl) AddMember(member *models.OrganisationMember) error {
+    return addMemberTx(r.DB, member)
+}
+
+func addMemberTx(tx *gorm.DB, member *models.OrganisationMember) error {
+    member.Status = models.MemberStatusActive
+    return tx.Clauses(clause.OnConflict{
+        Columns:   []clause.Column{{Name: "organisation_id"}, {Name: "user_id"}},
+        DoUpdates: clause.AssignmentColumns([]string{"role", "status", "joined_via", "updated_at"}),
+    }).Create(member).Error
+}
+
+func (r *organisationRepositoryImpl) RemoveMember(organisationID, userID uint) error {
+    result := r.DB.Model(&models.OrganisationMember{}).
+        Where("organisation_id = ? AND user_id = ? AND status = ?", organisationID, userID, models.MemberStatusActive).
+        Updates(map[string]interface{}{"status": models.MemberStatusRemoved, "updated_at": time.Now()})
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
+
+func (r *organisationRepositoryImpl) CreateInvite(invite *models.OrganisationInvite) error {
+    return r.DB.Create(invite).Error
+}
+
+func (r *organisationRepositoryImpl) GetInviteByToken(token string) (*models.OrganisationInvite, error) {
+    var invite models.OrganisationInvite
+    if err := r.DB.Where("token = ?", token).First(&invite).Error; err != nil {
+        return nil, err
+    }
+    return &invite, nil
+}
+
+func (r *organisationRepositoryImpl) GetInvites(organisationID uint) ([]models.OrganisationInvite, error) {
+    var invites []models.OrganisationInvite
+    err := r.DB.Where("organisation_id = ?", organisationID).Order("created_at DESC").Find(&invites).Error
+    return invites, err
+}
+
+// AcceptInvite marks a pending invite accepted and enrols the member.
+func (r *organisationRepositoryImpl) AcceptInvite(invite *models.OrganisationInvite, member *models.OrganisationMember) error {
+    tx := r.DB.Begin()
+    result := tx.Model(&models.OrganisationInvite{}).
+        Where("invite_id = ? AND status = ?", invite.InviteID, models.InviteStatusPending).
+        Update("status", models.InviteStatusAccepted)
+    if result.Error != nil {
+        tx.Rollback()
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        tx.Rollback()
+        return fmt.Errorf("invite has already been used")
+    }
+    if err := addMemberTx(tx, member); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// reserveFundsTx takes amount from a prepaid organisation's balance, or adds it
+// to a postpaid organisation's outstanding amount within its credit limit.
+func reserveFundsTx(tx *gorm.DB, organisationID uint, amount float64) error {
+    var organisation models.Organisation
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("organisation_id = ?", organisationID).First(&organisation).Error; err != nil {
+        return err
+    }
+    column := "balance"
+    expr := gorm.Expr("balance - ?", amount)
+    if organisation.BillingMode == models.BillingModePostpaid {
+        if organisation.Outstanding+amount > organisation.CreditLimit {
+            return ErrOrganisationFundsExhausted
+        }
+        column = "outstanding"
+        expr = gorm.Expr("outstanding + ?", amount)
+    } else if organisation.Balance < amount {
+        return ErrOrganisationFundsExhausted
+    }
+    return tx.Model(&models.Organisation{}).Where("organisation_id = ?", organisationID).
+        Update(column, expr).Error
+}
+
+// FundSubscription pays for a member's subscription from the organisation.
+func (r *organisationRepositoryImpl) FundSubscription(subscription *models.Subscription, charge *models.OrganisationCharge) error {
+    tx := r.DB.Begin()
+    if err := reserveFundsTx(tx, charge.OrganisationID, charge.Amount); err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := tx.Create(subscription).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    charge.SubscriptionID = &subscription.SubscriptionID
+    if err := tx.Create(charge).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// FundTopup credits a member's card from the organisation.
+func (r *organisationRepositoryImpl) FundTopup(topup *models.NolCardTopup, charge *models.OrganisationCharge) error {
+    tx := r.DB.Begin()
+    if err := reserveFundsTx(tx, charge.OrganisationID, charge.Amount); err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := creditNolCardTx(tx, topup.NolCardID, topup.Amount); err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := tx.Create(topup).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    charge.TopupID = &topup.TopupID
+    if err := tx.Create(charge).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+func (r *organisationRepositoryImpl) GetCharges(organisationID uint, from, to time.Time) ([]models.OrganisationCharge, error) {
+    var charges []models.OrganisationCharge
+    err := r.DB.Where("organisation_id = ? AND created_at >= ? AND created_at < ?", organisationID, from, to).
+        Order("created_at").Find(&charges).Error
+    return charges, err
+}
+
+func (r *organisationRepositoryImpl) GetChargesByInvoiceID(invoiceID uint) ([]models.OrganisationCharge, error) {
+    var charges []models.OrganisationCharge
+    err := r.DB.Where("invoice_id = ?", invoiceID).Order("created_at").Find(&charges).Error
+    return charges, err
+}
+
+func (r *organisationRepositoryImpl) GetJourneyTotals(userIDs []uint, from, to time.Time) ([]JourneyTotal, error) {
+    var totals []JourneyTotal
+    if len(userIDs) == 0 {
+        return totals, nil
+    }
+    err := r.DB.Model(&models.NolCardJourney{}).
+        Select("user_id, COUNT(*) AS journeys, COALESCE(SUM(fare), 0) AS fares").
+        Where("user_id IN ? AND created_at >= ? AND created_at < ?", userIDs, from, to).
+        Group("user_id").Scan(&totals).Error
+    return totals, err
+}
+
+// GetOrganisationsToInvoice returns organisations with unbilled charges made
+// before the given time, including any left over from earlier months.
+func (r *organisationRepositoryImpl) GetOrganisationsToInvoice(before time.Time) ([]models.Organisation, error) {
+    var organisations []models.Organisation
+    err := r.DB.Where("organisation_id IN (?)",
+        r.DB.Model(&models.OrganisationCharge{}).Select("organisation_id").
+            Where("invoice_id IS NULL AND created_at < ?", before)).
+        Find(&organisations).Error
+    return organisations, err
+}
+
+func (r *organisationRepositoryImpl) GetUninvoicedCharges(organisationID uint, before time.Time) ([]models.OrganisationCharge, error) {
+    var charges []models.OrganisationCharge
+    err := r.DB.Where("organisation_id = ? AND invoice_id IS NULL AND created_at < ?", organisationID, before).
+        Order("charge_id").Find(&charges).Error
+    return charges, err
+}
+
+// CreateMonthlyInvoice saves the invoice and attaches the given charges to it.
+// It fails, saving nothing, if any of them was billed in the meantime.
+func (r *organisationRepositoryImpl) CreateMonthlyInvoice(invoice *models.Invoice, chargeIDs []uint) error {
+    tx := r.DB.Begin()
+    if err := createInvoiceTx(tx, invoice); err != nil {
+        tx.Rollback()
+        return err
+    }
+    result := tx.Model(&models.OrganisationCharge{}).
+        Where("charge_id IN ? AND invoice_id IS NULL", chargeIDs).
+        Update("invoice_id", invoice.InvoiceID)
+    if result.Error != nil {
+        tx.Rollback()
+        return result.Error
+    }
+    if result.RowsAffected != int64(len(chargeIDs)) {
+        tx.Rollback()
+        return fmt.Errorf("some charges were already invoiced")
+    }
+    return tx.Commit().Error
+}
+
+func (r *organisationRepositoryImpl) GetInvoices(organisationID uint) ([]models.Invoice, error) {
+    var invoices []models.Invoice
+    err := r.DB.Where("organisation_id = ?", organisationID).Order("invoice_date DESC").Find(&invoices).Error
+    return invoices, err
+}
+
+func (r *organisationRepositoryImpl) GetInvoice(organisationID, invoiceID uint) (*models.Invoice, error) {
+    var invoice models.Invoice
+    if err := r.DB.Where("organisation_id = ? AND invoice_id = ?", organisationID, invoiceID).First(&invoice).Error; err != nil {
+        return nil, err
+    }
+    return &invoice, nil
+}
+
+// SettleInvoice marks a due invoice paid and releases the credit it used.
+func (r *organisationRepositoryImpl) SettleInvoice(organisationID, invoiceID uint) error {
+    tx := r.DB.Begin()
+    var invoice models.Invoice
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("organisation_id = ? AND invoice_id = ?", organisationID, invoiceID).First(&invoice).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    if invoice.Status != models.InvoiceStatusDue {
+        tx.Rollback()
+        return fmt.Errorf("invoice is %s, only due invoices can be settled", invoice.Status)
+    }
+    if err := tx.Model(&models.Invoice{}).Where("invoice_id = ?", invoiceID).
+        Updates(map[string]interface{}{"status": models.InvoiceStatusPaid, "updated_at": time.Now()}).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := tx.Model(&models.Organisation{}).Where("organisation_id = ?", organisationID).
+        Update("outstanding", gorm.Expr("GREATEST(outstanding - ?, 0)", invoice.Amount)).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
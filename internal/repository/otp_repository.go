@@ -32,6 +32,12 @@ func MarkOTPAsUsed(otpID uint) error {
     return config.DB.Model(&models.OTP{}).Where("id = ?", otpID).Update("used", true).Error
 }
 
+// MarkEmailVerified records that the user proved they own their email address.
+func MarkEmailVerified(userID uint) error {
+    return config.DB.Model(&models.User{}).Where("user_id = ? AND email_verified_at IS NULL", userID).
+        Update("email_verified_at", time.Now()).Error
+}
+
 // DeleteExpiredOTPs removes expired OTPs from the database
 func DeleteExpiredOTPs() error {
     return config.DB.Where("expiry < ?", time.Now()).Delete(&models.OTP{}).Error
@@ -18,6 +18,7 @@ type RazorpayPaymentRepository interface {
     GetPaymentByID(paymentID uint) (*models.RazorpayPayment, error)
     GetPaymentsByBookingID(bookingID uint) ([]models.RazorpayPayment, error)
     AddTransaction(transaction *models.WalletTransaction) error 
+    RecordRefund(razorpayID string, amount float64) error
 }
 
 type razorpayPaymentRepositoryImpl struct {
@@ -82,8 +83,11 @@ func (r *razorpayPaymentRepositoryImpl) UpdatePaymentStatus(orderID, razorpayID,
     log.Printf("Fetched payment: %+v", payment)
     
     payment.RazorpayID = razorpayID
-    payment.Status = status
+    payment.Status = models.NormalizePaymentStatus(status)
     payment.UpdatedAt = time.Now()
+    if payment.Status == models.PaymentStatusVerified {
+        payment.PaymentDate = payment.UpdatedAt
+    }
     
     log.Printf("Updating payment with Razorpay ID: %s and Status: %s", razorpayID, status)
     if err := r.DB.Table("payments").Save(&payment).Error; err != nil {
@@ -115,4 +119,38 @@ func (r *razorpayPaymentRepositoryImpl) GetPaymentsByBookingID(bookingID uint) (
 
 func (r *razorpayPaymentRepositoryImpl) AddTransaction(transaction *models.WalletTransaction) error {
     return r.DB.Create(transaction).Error
-}
\ No newline at end of file
+}
+
+// RecordRefund adds a refund made through the gateway to the payment it was
+// made against. Refunds of payments that were never stored are ignored.
+func (r *razorpayPaymentRepositoryImpl) RecordRefund(razorpayID string, amount float64) error {
+    var payment models.RazorpayPayment
+    err := r.DB.Table("payments").Where("razorpay_id = ?", razorpayID).First(&payment).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    tx := r.DB.Begin()
+    if err := recordRefundTx(tx, payment.PaymentID, amount); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// recordRefundTx adds amount to what has been refunded on a payment, marking
+// it refunded once nothing is left, and takes back the matching share of the
+// loyalty points the payment earned.
+func recordRefundTx(tx *gorm.DB, paymentID uint, amount float64) error {
+    if err := tx.Table("payments").Where("payment_id = ?", paymentID).
+        Updates(map[string]interface{}{
+            "refunded_amount": gorm.Expr("LEAST(amount, refunded_amount + ?)", amount),
+            "status":          gorm.Expr("CASE WHEN refunded_amount + ? >= amount - 0.005 THEN ? ELSE status END", amount, models.PaymentStatusRefunded),
+            "updated_at":      time.Now(),
+        }).Error; err != nil {
+        return err
+    }
+    return clawBackPointsTx(tx, paymentID)
+}
@@ -0,0 +1,208 @@
+package repository
+
+import (
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+// ErrReferralNotEligible is returned when a referral is no longer waiting for
+// the state change being applied, e.g. it was already rewarded.
+var ErrReferralNotEligible = errors.New("referral is not eligible")
+
+type ReferralRepository interface {
+    GetProgramme() (*models.ReferralProgramme, error)
+    UpdateProgramme(programme *models.ReferralProgramme) error
+    GetCodeByUserID(userID uint) (*models.ReferralCode, error)
+    GetCodeByCode(code string) (*models.ReferralCode, error)
+    CreateCode(code *models.ReferralCode) error
+    CreateReferral(referral *models.Referral) error
+    GetReferralByRefereeID(refereeID uint) (*models.Referral, error)
+    GetReferralsByReferrerID(referrerID uint) ([]models.Referral, error)
+    GetReferrals(status string) ([]models.Referral, error)
+    CountReferralsByDevice(deviceID string) (int64, error)
+    CountReferralsByDomain(referrerID uint, domain string) (int64, error)
+    UpdateStatus(referralID uint, fromStatus, toStatus, reason string) error
+    MarkRewarded(referral *models.Referral, paymentID uint, referrerReward, refereeReward float64) error
+}
+
+type referralRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewReferralRepository(db *gorm.DB) ReferralRepository {
+    return &referralRepositoryImpl{DB: db}
+}
+
+// GetProgramme returns the programme rules, creating the default row on first use.
+func (r *referralRepositoryImpl) GetProgramme() (*models.ReferralProgramme, error) {
+    programme := models.ReferralProgramme{}
+    defaults := models.ReferralProgramme{
+        Active:                true,
+        ReferrerReward:        100,
+        RefereeReward:         50,
+        RewardWindowDays:      90,
+        MaxReferralsPerDevice: 1,
+        MaxReferralsPerDomain: 5,
+    }
+    err := r.DB.Where(models.ReferralProgramme{ProgrammeID: 1}).Attrs(defaults).FirstOrCreate(&programme).Error
+    if err != nil {
+        return nil, err
+    }
+    return &programme, nil
+}
+
+func (r *referralRepositoryImpl) UpdateProgramme(programme *models.ReferralProgramme) error {
+    programme.ProgrammeID = 1
+    return r.DB.Save(programme).Error
+}
+
+func (r *referralRepositoryImpl) GetCodeByUserID(userID uint) (*models.ReferralCode, error) {
+    var code models.ReferralCode
+    if err := r.DB.Where("user_id = ?", userID).First(&code).Error; err != nil {
+        return nil, err
+    }
+    return &code, nil
+}
+
+func (r *referralRepositoryImpl) GetCodeByCode(code string) (*models.ReferralCode, error) {
+    var referralCode models.ReferralCode
+    if err := r.DB.Where("code = ?", code).First(&referralCode).Error; err != nil {
+        return nil, err
+    }
+    return &referralCode, nil
+}
+
+func (r *referralRepositoryImpl) CreateCode(code *models.ReferralCode) error {
+    return r.DB.Create(code).Error
+}
+
+func (r *referralRepositoryImpl) CreateReferral(referral *models.Referral) error {
+    return r.DB.Create(referral).Error
+}
+
+func (r *referralRepositoryImpl) GetReferralByRefereeID(refereeID uint) (*models.Referral, error) {
+    var referral models.Referral
+    if err := r.DB.Where("referee_id = ?", refereeID).First(&referral).Error; err != nil {
+        return nil, err
+    }
+    return &referral, nil
+}
+
+func (r *referralRepositoryImpl) GetReferralsByReferrerID(referrerID uint) ([]models.Referral, error) {
+    var referrals []models.Referral
+    err := r.DB.Where("referrer_id = ?", referrerID).Order("created_at DESC").Find(&referrals).Error
+    return referrals, err
+}
+
+func (r *referralRepositoryImpl) GetReferrals(status string) ([]models.Referral, error) {
+    var referrals []models.Referral
+    query := r.DB.Order("created_at DESC")
+    if status != "" {
+        query = query.Where("status = ?", status)
+    }
+    err := query.Find(&referrals).Error
+    return referrals, err
+}
+
+// CountReferralsByDevice counts accepted referrals signed up from a device.
+func (r *referralRepositoryImpl) CountReferralsByDevice(deviceID string) (int64, error) {
+    var count int64
+    err := r.DB.Model(&models.Referral{}).
+        Where("device_id = ? AND status <> ?", deviceID, models.ReferralStatusRejected).
+        Count(&count).Error
+    return count, err
+}
+
+// CountReferralsByDomain counts a referrer's accepted referees from one email domain.
+func (r *referralRepositoryImpl) CountReferralsByDomain(referrerID uint, domain string) (int64, error) {
+    var count int64
+    err := r.DB.Model(&models.Referral{}).
+        Where("referrer_id = ? AND email_domain = ? AND status <> ?", referrerID, domain, models.ReferralStatusRejected).
+        Count(&count).Error
+    return count, err
+}
+
+// UpdateStatus moves a referral from fromStatus to toStatus, failing with
+// ErrReferralNotEligible if it is in any other state.
+func (r *referralRepositoryImpl) UpdateStatus(referralID uint, fromStatus, toStatus, reason string) error {
+    updates := map[string]interface{}{"status": toStatus}
+    if reason != "" {
+        updates["reject_reason"] = reason
+    }
+    if toStatus == models.ReferralStatusVerified {
+        updates["verified_at"] = time.Now()
+    }
+    result := r.DB.Model(&models.Referral{}).
+        Where("referral_id = ? AND status = ?", referralID, fromStatus).
+        Updates(updates)
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrReferralNotEligible
+    }
+    return nil
+}
+
+// MarkRewarded claims a verified referral for payment and credits both
+// wallets in the same transaction, opening a wallet for a user who has none.
+// Only one caller can succeed, so the wallets are credited at most once.
+func (r *referralRepositoryImpl) MarkRewarded(referral *models.Referral, paymentID uint, referrerReward, refereeReward float64) error {
+    tx := r.DB.Begin()
+    result := tx.Model(&models.Referral{}).
+        Where("referral_id = ? AND status = ?", referral.ReferralID, models.ReferralStatusVerified).
+        Updates(map[string]interface{}{
+            "status":          models.ReferralStatusRewarded,
+            "payment_id":      paymentID,
+            "referrer_reward": referrerReward,
+            "referee_reward":  refereeReward,
+            "rewarded_at":     time.Now(),
+        })
+    if result.Error != nil {
+        tx.Rollback()
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        tx.Rollback()
+        return ErrReferralNotEligible
+    }
+    if err := creditRewardTx(tx, referral.ReferrerID, referrerReward, fmt.Sprintf("Referral reward for inviting user %d", referral.RefereeID)); err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := creditRewardTx(tx, referral.RefereeID, refereeReward, "Referral reward for your first booking"); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// creditRewardTx tops up the user's wallet inside an open transaction,
+// opening one if they have none yet.
+func creditRewardTx(tx *gorm.DB, userID uint, amount float64, description string) error {
+    if amount <= 0 {
+        return nil
+    }
+    var wallet models.Wallet
+    err := tx.Where("user_id = ?", userID).First(&wallet).Error
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        wallet = models.Wallet{UserID: userID}
+        err = tx.Create(&wallet).Error
+    }
+    if err != nil {
+        return err
+    }
+    if err := creditWalletTx(tx, wallet.WalletID, amount); err != nil {
+        return err
+    }
+    return tx.Create(&models.WalletTransaction{
+        WalletID:        wallet.WalletID,
+        Amount:          amount,
+        TransactionType: models.WalletTransactionReferralReward,
+        Description:     description,
+    }).Error
+}
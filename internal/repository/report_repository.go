@@ -0,0 +1,155 @@
+package repository
+
+import (
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+type ReportRepository interface {
+    CreateSchedule(schedule *models.ReportSchedule) error
+    GetSchedules() ([]models.ReportSchedule, error)
+    GetScheduleByID(scheduleID uint) (*models.ReportSchedule, error)
+    UpdateSchedule(schedule *models.ReportSchedule) error
+    DeleteSchedule(scheduleID uint) error
+    GetDueSchedules(now time.Time) ([]models.ReportSchedule, error)
+    ClaimSchedule(scheduleID uint, due time.Time, next *time.Time, now time.Time) (bool, error)
+
+    CreateRun(run *models.ReportRun) error
+    UpdateRun(run *models.ReportRun) error
+    GetRuns(scheduleID uint, limit int) ([]models.ReportRun, error)
+    GetRunByID(runID uint) (*models.ReportRun, error)
+
+    GetRefunds(from, to time.Time) ([]models.RefundReportRow, error)
+    GetReconciliationExceptions(from, to time.Time) ([]models.ReconciliationException, error)
+    GetExpiringSubscriptions(from, to time.Time) ([]models.ExpiringSubscription, error)
+}
+
+type reportRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewReportRepository(db *gorm.DB) ReportRepository {
+    return &reportRepositoryImpl{DB: db}
+}
+
+func (r *reportRepositoryImpl) CreateSchedule(schedule *models.ReportSchedule) error {
+    return r.DB.Create(schedule).Error
+}
+
+func (r *reportRepositoryImpl) GetSchedules() ([]models.ReportSchedule, error) {
+    var schedules []models.ReportSchedule
+    err := r.DB.Order("schedule_id").Find(&schedules).Error
+    return schedules, err
+}
+
+func (r *reportRepositoryImpl) GetScheduleByID(scheduleID uint) (*models.ReportSchedule, error) {
+    var schedule models.ReportSchedule
+    if err := r.DB.Where("schedule_id = ?", scheduleID).First(&schedule).Error; err != nil {
+        return nil, err
+    }
+    return &schedule, nil
+}
+
+func (r *reportRepositoryImpl) UpdateSchedule(schedule *models.ReportSchedule) error {
+    return r.DB.Save(schedule).Error
+}
+
+// DeleteSchedule removes the schedule; its past runs are kept.
+func (r *reportRepositoryImpl) DeleteSchedule(scheduleID uint) error {
+    result := r.DB.Where("schedule_id = ?", scheduleID).Delete(&models.ReportSchedule{})
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
+
+func (r *reportRepositoryImpl) GetDueSchedules(now time.Time) ([]models.ReportSchedule, error) {
+    var schedules []models.ReportSchedule
+    err := r.DB.Where("active = ? AND next_run_at <= ?", true, now).Order("next_run_at").Find(&schedules).Error
+    return schedules, err
+}
+
+// ClaimSchedule moves a due schedule on to its next run. It reports false
+// when another worker has already claimed this run.
+func (r *reportRepositoryImpl) ClaimSchedule(scheduleID uint, due time.Time, next *time.Time, now time.Time) (bool, error) {
+    result := r.DB.Model(&models.ReportSchedule{}).
+        Where("schedule_id = ? AND next_run_at = ?", scheduleID, due).
+        Updates(map[string]interface{}{"next_run_at": next, "last_run_at": now, "updated_at": now})
+    return result.RowsAffected == 1, result.Error
+}
+
+func (r *reportRepositoryImpl) CreateRun(run *models.ReportRun) error {
+    return r.DB.Create(run).Error
+}
+
+func (r *reportRepositoryImpl) UpdateRun(run *models.ReportRun) error {
+    return r.DB.Save(run).Error
+}
+
+// GetRuns returns the latest runs, of one schedule when scheduleID is set.
+func (r *reportRepositoryImpl) GetRuns(scheduleID uint, limit int) ([]models.ReportRun, error) {
+    query := r.DB.Order("run_id DESC").Limit(limit)
+    if scheduleID != 0 {
+        query = query.Where("schedule_id = ?", scheduleID)
+    }
+    var runs []models.ReportRun
+    err := query.Find(&runs).Error
+    return runs, err
+}
+
+func (r *reportRepositoryImpl) GetRunByID(runID uint) (*models.ReportRun, error) {
+    var run models.ReportRun
+    if err := r.DB.Where("run_id = ?", runID).First(&run).Error; err != nil {
+        return nil, err
+    }
+    return &run, nil
+}
+
+// GetRefunds lists payments refunded, or left awaiting a refund, in [from, to).
+func (r *reportRepositoryImpl) GetRefunds(from, to time.Time) ([]models.RefundReportRow, error) {
+    var rows []models.RefundReportRow
+    err := r.DB.Table("payments").
+        Select("payment_id, order_id, user_id, payment_type, method, currency, amount, refunded_amount, status, updated_at").
+        Where("(refunded_amount > 0 OR status = ?) AND updated_at >= ? AND updated_at < ?", models.PaymentStatusRefundPending, from, to).
+        Order("updated_at").Scan(&rows).Error
+    return rows, err
+}
+
+// GetReconciliationExceptions checks payments made in [from, to) against
+// their invoices: settled payments need a finalised invoice for the same
+// amount, and unsettled ones should have none.
+func (r *reportRepositoryImpl) GetReconciliationExceptions(from, to time.Time) ([]models.ReconciliationException, error) {
+    paid := "COALESCE(NULLIF(p.settled_amount, 0), p.amount)"
+    var rows []models.ReconciliationException
+    err := r.DB.Table("payments AS p").
+        Select("p.payment_id, p.order_id, p.payment_type, p.status AS payment_status, "+paid+" AS payment_amount, "+
+            "COALESCE(i.invoice_number, '') AS invoice_number, COALESCE(i.settled_amount, 0) AS invoice_amount, "+
+            "CASE WHEN i.invoice_id IS NULL THEN 'no invoice' "+
+            "WHEN p.status NOT IN @settled THEN 'invoice for unsettled payment' "+
+            "ELSE 'amount mismatch' END AS issue", map[string]interface{}{"settled": models.RevenuePaymentStatuses}).
+        Joins("LEFT JOIN invoices i ON i.payment_id = p.payment_id AND i.document_type = ? AND i.status IN ?",
+            models.DocumentTypeInvoice, models.FinalisedInvoiceStatuses).
+        Where("p.payment_date >= ? AND p.payment_date < ?", from, to).
+        Where("(p.status IN ? AND i.invoice_id IS NULL) OR (p.status NOT IN ? AND i.invoice_id IS NOT NULL) OR "+
+            "(i.invoice_id IS NOT NULL AND ABS(i.settled_amount - "+paid+") > 0.01)",
+            models.RevenuePaymentStatuses, models.RevenuePaymentStatuses).
+        Order("p.payment_id").Scan(&rows).Error
+    return rows, err
+}
+
+// GetExpiringSubscriptions lists live subscriptions ending in [from, to).
+func (r *reportRepositoryImpl) GetExpiringSubscriptions(from, to time.Time) ([]models.ExpiringSubscription, error) {
+    var rows []models.ExpiringSubscription
+    err := r.DB.Table("subscriptions AS s").
+        Select("s.subscription_id, s.user_id, COALESCE(u.email, '') AS email, COALESCE(sp.plan_name, '') AS plan_name, s.card_type, s.end_date, s.auto_renew").
+        Joins("LEFT JOIN users u ON u.user_id = s.user_id").
+        Joins("LEFT JOIN subscription_plans sp ON sp.plan_id = s.plan_id").
+        Where("s.status IN ? AND s.end_date >= ? AND s.end_date < ?", models.SubscriptionLiveStatuses, from, to).
+        Order("s.end_date").Scan(&rows).Error
+    return rows, err
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+    "fmt"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+type RevenueRepository interface {
+    GetRevenue(filter models.RevenueFilter) ([]models.RevenueRow, error)
+}
+
+type revenueRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewRevenueRepository(db *gorm.DB) RevenueRepository {
+    return &revenueRepositoryImpl{DB: db}
+}
+
+// revenueGroupKeys are the SQL expressions each grouping buckets payments by.
+// Payments a grouping does not apply to, e.g. top-ups by route, fall under "none".
+var revenueGroupKeys = map[string]string{
+    models.RevenueByDay:         "TO_CHAR(DATE_TRUNC('day', p.payment_date), 'YYYY-MM-DD')",
+    models.RevenueByWeek:        "TO_CHAR(DATE_TRUNC('week', p.payment_date), 'YYYY-MM-DD')",
+    models.RevenueByMonth:       "TO_CHAR(DATE_TRUNC('month', p.payment_date), 'YYYY-MM')",
+    models.RevenueByPaymentType: "COALESCE(NULLIF(p.payment_type, ''), 'none')",
+    models.RevenueByCategory:    "COALESCE(bc.category_name, sc.category_name, 'none')",
+    models.RevenueByRoute:       "COALESCE(r.route_name, 'none')",
+    models.RevenueByCardType:    "COALESCE(NULLIF(b.card_type, ''), NULLIF(s.card_type, ''), NULLIF(n.card_type, ''), 'none')",
+    models.RevenueByCoupon:      "COALESCE(cp.code, NULLIF(p.coupon_code, ''), 'none')",
+}
+
+// GetRevenue sums payments that took money, in the base currency. Discounts
+// are the redeemed coupons and confirmed points on each payment; refunds are
+// what has since been given back. Payments made from the wallet are left out,
+// as the money was already counted when the wallet was topped up.
+func (r *revenueRepositoryImpl) GetRevenue(filter models.RevenueFilter) ([]models.RevenueRow, error) {
+    groupKey, ok := revenueGroupKeys[filter.GroupBy]
+    if !ok {
+        return nil, fmt.Errorf("unknown revenue grouping %q", filter.GroupBy)
+    }
+    charged := "COALESCE(NULLIF(p.settled_amount, 0), p.amount)"
+    discount := "COALESCE(cr.discount, 0) + COALESCE(lp.discount, 0)"
+    refund := "p.refunded_amount * COALESCE(NULLIF(p.exchange_rate, 0), 1)"
+
+    query := r.DB.Table("payments AS p").
+        Select(groupKey+" AS key, COUNT(*) AS payments, "+
+            "COALESCE(SUM("+charged+" + "+discount+"), 0) AS gross_amount, "+
+            "COALESCE(SUM("+discount+"), 0) AS discount_amount, "+
+            "COALESCE(SUM("+refund+"), 0) AS refund_amount, "+
+            "COALESCE(SUM("+charged+" - "+refund+"), 0) AS net_amount").
+        Joins("LEFT JOIN (SELECT payment_id, SUM(discount) AS discount FROM coupon_redemptions WHERE status = ? GROUP BY payment_id) cr ON cr.payment_id = p.payment_id",
+            models.RedemptionStatusRedeemed).
+        Joins("LEFT JOIN (SELECT payment_id, SUM(amount) AS discount FROM loyalty_ledger_entries WHERE type = ? AND status = ? GROUP BY payment_id) lp ON lp.payment_id = p.payment_id",
+            models.LoyaltyEntryRedeem, models.LoyaltyRedemptionConfirmed).
+        Joins("LEFT JOIN bookings b ON b.booking_id = p.booking_id").
+        Joins("LEFT JOIN routes r ON r.route_id = b.route_id").
+        Joins("LEFT JOIN categories bc ON bc.category_id = r.category_id").
+        Joins("LEFT JOIN subscriptions s ON s.subscription_id = p.subscription_id").
+        Joins("LEFT JOIN subscription_plans sp ON sp.plan_id = s.plan_id").
+        Joins("LEFT JOIN categories sc ON sc.category_id = sp.category_id").
+        Joins("LEFT JOIN nol_cards n ON n.nol_card_id = p.nol_card_id").
+        Joins("LEFT JOIN coupons cp ON cp.coupon_id = p.coupon_id").
+        Where("p.status IN ? AND p.payment_date >= ? AND p.payment_date < ?", models.RevenuePaymentStatuses, filter.From, filter.To).
+        Where("COALESCE(p.method, '') <> ?", "wallet")
+    if filter.PaymentType != "" {
+        query = query.Where("p.payment_type = ?", filter.PaymentType)
+    }
+
+    // Time series read oldest first; breakdowns largest first.
+    order := "net_amount DESC, 1"
+    switch filter.GroupBy {
+    case models.RevenueByDay, models.RevenueByWeek, models.RevenueByMonth:
+        order = "1"
+    }
+    var rows []models.RevenueRow
+    err := query.Group("1").Order(order).Scan(&rows).Error
+    return rows, err
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+    "database/sql"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+type RidershipRepository interface {
+    RefreshRollups(from, to time.Time) error
+    GetLatestRollupDate() (*time.Time, error)
+    GetEarliestActivity() (*time.Time, error)
+    GetStopHours(filter models.RidershipFilter) ([]models.StopHourRidership, error)
+    GetODPairs(filter models.RidershipFilter) ([]models.ODPair, error)
+    GetRouteStops(routeID int) ([]models.RouteLoadPoint, error)
+    GetHeatmap(filter models.RidershipFilter) ([]models.HeatmapCell, error)
+}
+
+type ridershipRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewRidershipRepository(db *gorm.DB) RidershipRepository {
+    return &ridershipRepositoryImpl{DB: db}
+}
+
+// ridershipTrips lists every trip in [from, to) with its route, stops and
+// source. Bookings count once paid, over the whole route.
+const ridershipTrips = `WITH trips AS (
+    SELECT j.created_at AS trip_at, j.route_id, j.from_stop_id, j.to_stop_id,
+        CASE WHEN j.subscription_id IS NULL THEN @nolcard ELSE @subscription END AS source
+    FROM nol_card_journeys j
+    WHERE j.created_at >= @from AND j.created_at < @to
+    UNION ALL
+    SELECT b.booking_date, b.route_id, r.start_stop_id, r.end_stop_id, @booking
+    FROM bookings b JOIN routes r ON r.route_id = b.route_id
+    WHERE b.booking_date >= @from AND b.booking_date < @to
+        AND EXISTS (SELECT 1 FROM payments p WHERE p.booking_id = b.booking_id AND p.status = @verified)
+)`
+
+// RefreshRollups rebuilds both rollups for the days in [from, to), which
+// should fall on day boundaries.
+func (r *ridershipRepositoryImpl) RefreshRollups(from, to time.Time) error {
+    args := map[string]interface{}{
+        "from":         from,
+        "to":           to,
+        "nolcard":      models.RidershipSourceNolCard,
+        "subscription": models.RidershipSourceSubscription,
+        "booking":      models.RidershipSourceBooking,
+        "verified":     models.PaymentStatusVerified,
+    }
+    return r.DB.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Where("date >= ? AND date < ?", from, to).Delete(&models.RidershipStopHour{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Where("date >= ? AND date < ?", from, to).Delete(&models.RidershipODDay{}).Error; err != nil {
+            return err
+        }
+        if err := tx.Exec(ridershipTrips+`
+            INSERT INTO ridership_od_days (date, route_id, from_stop_id, to_stop_id, source, trips, refreshed_at)
+            SELECT trip_at::date, route_id, from_stop_id, to_stop_id, source, COUNT(*), NOW()
+            FROM trips GROUP BY 1, 2, 3, 4, 5`, args).Error; err != nil {
+            return err
+        }
+        return tx.Exec(ridershipTrips+`, stop_events AS (
+                SELECT trip_at, route_id, from_stop_id AS stop_id, source, 1 AS boarding, 0 AS alighting FROM trips
+                UNION ALL
+                SELECT trip_at, route_id, to_stop_id, source, 0, 1 FROM trips
+            )
+            INSERT INTO ridership_stop_hours (date, hour, route_id, stop_id, source, boardings, alightings, refreshed_at)
+            SELECT trip_at::date, EXTRACT(HOUR FROM trip_at)::int, route_id, stop_id, source, SUM(boarding), SUM(alighting), NOW()
+            FROM stop_events GROUP BY 1, 2, 3, 4, 5`, args).Error
+    })
+}
+
+func (r *ridershipRepositoryImpl) GetLatestRollupDate() (*time.Time, error) {
+    var latest sql.NullTime
+    if err := r.DB.Model(&models.RidershipStopHour{}).Select("MAX(date)").Scan(&latest).Error; err != nil || !latest.Valid {
+        return nil, err
+    }
+    return &latest.Time, nil
+}
+
+// GetEarliestActivity returns when the first journey or booking was made, or
+// nil when there are none.
+func (r *ridershipRepositoryImpl) GetEarliestActivity() (*time.Time, error) {
+    var earliest sql.NullTime
+    err := r.DB.Raw(`SELECT LEAST((SELECT MIN(created_at) FROM nol_card_journeys), (SELECT MIN(booking_date) FROM bookings))`).
+        Scan(&earliest).Error
+    if err != nil || !earliest.Valid {
+        return nil, err
+    }
+    return &earliest.Time, nil
+}
+
+// filterRollup applies the filter to a query on either rollup table.
+func filterRollup(query *gorm.DB, table string, filter models.RidershipFilter) *gorm.DB {
+    query = query.Where(table+".date >= ? AND "+table+".date < ?", filter.From, filter.To)
+    if filter.RouteID != 0 {
+        query = query.Where(table+".route_id = ?", filter.RouteID)
+    }
+    if filter.Source != "" {
+        query = query.Where(table+".source = ?", filter.Source)
+    }
+    return query
+}
+
+func (r *ridershipRepositoryImpl) GetStopHours(filter models.RidershipFilter) ([]models.StopHourRidership, error) {
+    query := filterRollup(r.DB.Table("ridership_stop_hours AS h"), "h", filter).
+        Select("h.stop_id, COALESCE(s.stop_name, '') AS stop_name, h.hour, SUM(h.boardings) AS boardings, SUM(h.alightings) AS alightings").
+        Joins("LEFT JOIN stops s ON s.stop_id = h.stop_id")
+    if filter.StopID != 0 {
+        query = query.Where("h.stop_id = ?", filter.StopID)
+    }
+    var rows []models.StopHourRidership
+    err := query.Group("h.stop_id, s.stop_name, h.hour").Order("h.stop_id, h.hour").Scan(&rows).Error
+    return rows, err
+}
+
+func (r *ridershipRepositoryImpl) GetODPairs(filter models.RidershipFilter) ([]models.ODPair, error) {
+    query := filterRollup(r.DB.Table("ridership_od_days AS o"), "o", filter).
+        Select("o.from_stop_id, COALESCE(fs.stop_name, '') AS from_stop_name, o.to_stop_id, COALESCE(ts.stop_name, '') AS to_stop_name, SUM(o.trips) AS trips").
+        Joins("LEFT JOIN stops fs ON fs.stop_id = o.from_stop_id").
+        Joins("LEFT JOIN stops ts ON ts.stop_id = o.to_stop_id")
+    if filter.StopID != 0 {
+        query = query.Where("o.from_stop_id = ? OR o.to_stop_id = ?", filter.StopID, filter.StopID)
+    }
+    var rows []models.ODPair
+    err := query.Group("o.from_stop_id, fs.stop_name, o.to_stop_id, ts.stop_name").Order("trips DESC, o.from_stop_id, o.to_stop_id").Scan(&rows).Error
+    return rows, err
+}
+
+// GetRouteStops returns the route's stops in sequence with no ridership filled in.
+func (r *ridershipRepositoryImpl) GetRouteStops(routeID int) ([]models.RouteLoadPoint, error) {
+    var points []models.RouteLoadPoint
+    err := r.DB.Table("route_stops AS rs").
+        Select("rs.stop_sequence, rs.stop_id, COALESCE(s.stop_name, '') AS stop_name").
+        Joins("LEFT JOIN stops s ON s.stop_id = rs.stop_id").
+        Where("rs.route_id = ?", routeID).
+        Order("rs.stop_sequence").Scan(&points).Error
+    return points, err
+}
+
+func (r *ridershipRepositoryImpl) GetHeatmap(filter models.RidershipFilter) ([]models.HeatmapCell, error) {
+    query := filterRollup(r.DB.Table("ridership_stop_hours AS h"), "h", filter).
+        Select("EXTRACT(DOW FROM h.date)::int AS weekday, h.hour, SUM(h.boardings) AS boardings")
+    if filter.StopID != 0 {
+        query = query.Where("h.stop_id = ?", filter.StopID)
+    }
+    var cells []models.HeatmapCell
+    err := query.Group("1, 2").Order("1, 2").Scan(&cells).Error
+    return cells, err
+}
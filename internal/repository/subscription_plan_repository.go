@@ -12,6 +12,9 @@ type SubscriptionPlanRepository interface {
     UpdateSubscriptionPlan(plan *models.SubscriptionPlan) error
     DeleteSubscriptionPlan(id uint) error
     GetAllSubscriptionPlans() ([]models.SubscriptionPlan, error)
+    GetRouteCategoryID(routeID int) (int, error)
+    GetStopSequence(routeID, stopID int) (int, error)
+    GetStopIDAtSequence(routeID, sequence int) (int, error)
 }
 
 type SubscriptionPlanRepositoryImpl struct {
@@ -22,8 +25,19 @@ func NewSubscriptionPlanRepository(db *gorm.DB) SubscriptionPlanRepository {
     return &SubscriptionPlanRepositoryImpl{DB: db}
 }
 
+// CreateSubscriptionPlan stores the plan and the routes it is limited to in
+// one transaction.
 func (r *SubscriptionPlanRepositoryImpl) CreateSubscriptionPlan(plan *models.SubscriptionPlan) error {
-    return r.DB.Create(plan).Error
+    tx := r.DB.Begin()
+    if err := tx.Create(plan).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := setPlanRoutesTx(tx, plan.PlanID, plan.RouteIDs); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
 }
 
 func (r *SubscriptionPlanRepositoryImpl) GetSubscriptionPlanByID(id uint) (*models.SubscriptionPlan, error) {
@@ -33,16 +47,34 @@ func (r *SubscriptionPlanRepositoryImpl) GetSubscriptionPlanByID(id uint) (*mode
         fmt.Printf("Error fetching plan: %v\n", err) 
         return nil, fmt.Errorf("enter a valid plan ID")
     }
+    if plan.RouteIDs, err = r.getPlanRouteIDs(plan.PlanID); err != nil {
+        return nil, err
+    }
     return &plan, nil
 }
 
+// UpdateSubscriptionPlan updates the plan and replaces its routes in one transaction.
 func (r *SubscriptionPlanRepositoryImpl) UpdateSubscriptionPlan(plan *models.SubscriptionPlan) error {
+    tx := r.DB.Begin()
     // Updates` to make sure only specified fields are updated
-    return r.DB.Model(&models.SubscriptionPlan{PlanID: plan.PlanID}).Updates(map[string]interface{}{
+    if err := tx.Model(&models.SubscriptionPlan{PlanID: plan.PlanID}).Updates(map[string]interface{}{
         "PlanName":     plan.PlanName,
         "Price":        plan.Price,
         "DurationDays": plan.DurationDays,
-    }).Error
+        "MaxPauseDays": plan.MaxPauseDays,
+        "CategoryID":         plan.CategoryID,
+        "CorridorFromStopID": plan.CorridorFromStopID,
+        "CorridorToStopID":   plan.CorridorToStopID,
+        "RideAllowance":      plan.RideAllowance,
+    }).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := setPlanRoutesTx(tx, plan.PlanID, plan.RouteIDs); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
 }
 
 
@@ -52,6 +84,63 @@ func (r *SubscriptionPlanRepositoryImpl) DeleteSubscriptionPlan(id uint) error {
 
 func (r *SubscriptionPlanRepositoryImpl) GetAllSubscriptionPlans() ([]models.SubscriptionPlan, error) {
     var plans []models.SubscriptionPlan
-    err := r.DB.Find(&plans).Error
-    return plans, err
+    if err := r.DB.Find(&plans).Error; err != nil {
+        return nil, err
+    }
+    for i := range plans {
+        routeIDs, err := r.getPlanRouteIDs(plans[i].PlanID)
+        if err != nil {
+            return nil, err
+        }
+        plans[i].RouteIDs = routeIDs
+    }
+    return plans, nil
+}
+
+func (r *SubscriptionPlanRepositoryImpl) getPlanRouteIDs(planID uint) ([]int, error) {
+    var routeIDs []int
+    err := r.DB.Model(&models.SubscriptionPlanRoute{}).Where("plan_id = ?", planID).
+        Order("route_id").Pluck("route_id", &routeIDs).Error
+    return routeIDs, err
+}
+
+// setPlanRoutesTx replaces the routes a plan is limited to.
+func setPlanRoutesTx(tx *gorm.DB, planID uint, routeIDs []int) error {
+    if err := tx.Where("plan_id = ?", planID).Delete(&models.SubscriptionPlanRoute{}).Error; err != nil {
+        return err
+    }
+    if len(routeIDs) == 0 {
+        return nil
+    }
+    rows := make([]models.SubscriptionPlanRoute, 0, len(routeIDs))
+    for _, routeID := range routeIDs {
+        rows = append(rows, models.SubscriptionPlanRoute{PlanID: planID, RouteID: routeID})
+    }
+    return tx.Create(&rows).Error
+}
+
+func (r *SubscriptionPlanRepositoryImpl) GetRouteCategoryID(routeID int) (int, error) {
+    var route models.Route
+    if err := r.DB.Select("category_id").Where("route_id = ?", routeID).First(&route).Error; err != nil {
+        return 0, err
+    }
+    return route.CategoryID, nil
+}
+
+// GetStopSequence returns the position of a stop on a route.
+func (r *SubscriptionPlanRepositoryImpl) GetStopSequence(routeID, stopID int) (int, error) {
+    var routeStop models.RouteStop
+    if err := r.DB.Where("route_id = ? AND stop_id = ?", routeID, stopID).First(&routeStop).Error; err != nil {
+        return 0, err
+    }
+    return routeStop.StopSequence, nil
+}
+
+// GetStopIDAtSequence returns the stop at a position on a route.
+func (r *SubscriptionPlanRepositoryImpl) GetStopIDAtSequence(routeID, sequence int) (int, error) {
+    var routeStop models.RouteStop
+    if err := r.DB.Where("route_id = ? AND stop_sequence = ?", routeID, sequence).First(&routeStop).Error; err != nil {
+        return 0, err
+    }
+    return routeStop.StopID, nil
 }
@@ -0,0 +1,203 @@
+package repository
+
+import (
+    "errors"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+)
+
+// ErrSubscriptionNotRenewable is returned when a subscription was cancelled,
+// paused or expired before its renewal could be applied.
+var ErrSubscriptionNotRenewable = errors.New("subscription is no longer active")
+
+type SubscriptionRenewalRepository interface {
+    GetRenewalCandidates(renewBefore, retryBefore time.Time) ([]models.Subscription, error)
+    GetReminderCandidates(now, until time.Time) ([]models.Subscription, error)
+    GetLapsedSubscriptions(now time.Time) ([]models.Subscription, error)
+    GetGraceExpiredSubscriptions(now time.Time) ([]models.Subscription, error)
+    SetReminderSent(subscriptionID uint, days int) error
+    MarkGrace(subscriptionID uint, graceEndsAt time.Time) error
+    MarkExpired(subscriptionID uint) error
+    RecordAttempt(subscriptionID uint, attemptErr string) error
+    UpdateAutoRenew(subscriptionID uint, fields map[string]interface{}) error
+
+    CreateRenewal(renewal *models.SubscriptionRenewal) error
+    UpdateRenewal(renewal *models.SubscriptionRenewal) error
+    GetPendingRenewals() ([]models.SubscriptionRenewal, error)
+    GetRenewalsBySubscriptionID(subscriptionID uint) ([]models.SubscriptionRenewal, error)
+    RenewWithWallet(renewal *models.SubscriptionRenewal, walletID uint, transaction *models.WalletTransaction) error
+    ApplyRenewal(renewal *models.SubscriptionRenewal) error
+}
+
+type subscriptionRenewalRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewSubscriptionRenewalRepository(db *gorm.DB) SubscriptionRenewalRepository {
+    return &subscriptionRenewalRepositoryImpl{DB: db}
+}
+
+// GetRenewalCandidates returns auto-renewing subscriptions ending before
+// renewBefore that have no pending renewal and were not attempted since retryBefore.
+func (r *subscriptionRenewalRepositoryImpl) GetRenewalCandidates(renewBefore, retryBefore time.Time) ([]models.Subscription, error) {
+    var subscriptions []models.Subscription
+    err := r.DB.Where("auto_renew = ? AND status IN ? AND end_date <= ?", true,
+        []string{models.SubscriptionStatusActive, models.SubscriptionStatusGrace, ""}, renewBefore).
+        Where("last_renewal_attempt_at IS NULL OR last_renewal_attempt_at < ?", retryBefore).
+        Where("NOT EXISTS (SELECT 1 FROM subscription_renewals sr WHERE sr.subscription_id = subscriptions.subscription_id AND sr.status = ?)", models.RenewalStatusPending).
+        Find(&subscriptions).Error
+    return subscriptions, err
+}
+
+func (r *subscriptionRenewalRepositoryImpl) GetReminderCandidates(now, until time.Time) ([]models.Subscription, error) {
+    var subscriptions []models.Subscription
+    err := r.DB.Where("status IN ? AND end_date > ? AND end_date <= ?",
+        []string{models.SubscriptionStatusActive, ""}, now, until).
+        Find(&subscriptions).Error
+    return subscriptions, err
+}
+
+func (r *subscriptionRenewalRepositoryImpl) GetLapsedSubscriptions(now time.Time) ([]models.Subscription, error) {
+    var subscriptions []models.Subscription
+    err := r.DB.Where("status IN ? AND end_date <= ?", []string{models.SubscriptionStatusActive, ""}, now).
+        Find(&subscriptions).Error
+    return subscriptions, err
+}
+
+func (r *subscriptionRenewalRepositoryImpl) GetGraceExpiredSubscriptions(now time.Time) ([]models.Subscription, error) {
+    var subscriptions []models.Subscription
+    err := r.DB.Where("status = ? AND end_date <= ? AND (grace_ends_at IS NULL OR grace_ends_at <= ?)",
+        models.SubscriptionStatusGrace, now, now).
+        Find(&subscriptions).Error
+    return subscriptions, err
+}
+
+func (r *subscriptionRenewalRepositoryImpl) SetReminderSent(subscriptionID uint, days int) error {
+    return r.DB.Model(&models.Subscription{}).Where("subscription_id = ?", subscriptionID).
+        Update("last_reminder_days", days).Error
+}
+
+func (r *subscriptionRenewalRepositoryImpl) MarkGrace(subscriptionID uint, graceEndsAt time.Time) error {
+    return r.DB.Model(&models.Subscription{}).Where("subscription_id = ?", subscriptionID).
+        Updates(map[string]interface{}{
+            "status":        models.SubscriptionStatusGrace,
+            "grace_ends_at": graceEndsAt,
+            "updated_at":    time.Now(),
+        }).Error
+}
+
+func (r *subscriptionRenewalRepositoryImpl) MarkExpired(subscriptionID uint) error {
+    return r.DB.Model(&models.Subscription{}).Where("subscription_id = ?", subscriptionID).
+        Updates(map[string]interface{}{
+            "status":     models.SubscriptionStatusExpired,
+            "updated_at": time.Now(),
+        }).Error
+}
+
+func (r *subscriptionRenewalRepositoryImpl) RecordAttempt(subscriptionID uint, attemptErr string) error {
+    return r.DB.Model(&models.Subscription{}).Where("subscription_id = ?", subscriptionID).
+        Updates(map[string]interface{}{
+            "last_renewal_attempt_at": time.Now(),
+            "last_renewal_error":      attemptErr,
+        }).Error
+}
+
+func (r *subscriptionRenewalRepositoryImpl) UpdateAutoRenew(subscriptionID uint, fields map[string]interface{}) error {
+    fields["updated_at"] = time.Now()
+    return r.DB.Model(&models.Subscription{}).Where("subscription_id = ?", subscriptionID).Updates(fields).Error
+}
+
+func (r *subscriptionRenewalRepositoryImpl) CreateRenewal(renewal *models.SubscriptionRenewal) error {
+    return r.DB.Create(renewal).Error
+}
+
+func (r *subscriptionRenewalRepositoryImpl) UpdateRenewal(renewal *models.SubscriptionRenewal) error {
+    return r.DB.Save(renewal).Error
+}
+
+func (r *subscriptionRenewalRepositoryImpl) GetPendingRenewals() ([]models.SubscriptionRenewal, error) {
+    var renewals []models.SubscriptionRenewal
+    err := r.DB.Where("status = ?", models.RenewalStatusPending).Order("created_at ASC").Find(&renewals).Error
+    return renewals, err
+}
+
+func (r *subscriptionRenewalRepositoryImpl) GetRenewalsBySubscriptionID(subscriptionID uint) ([]models.SubscriptionRenewal, error) {
+    var renewals []models.SubscriptionRenewal
+    err := r.DB.Where("subscription_id = ?", subscriptionID).Order("created_at DESC").Find(&renewals).Error
+    return renewals, err
+}
+
+// RenewWithWallet debits the wallet, records the linked wallet transaction and
+// extends the subscription in one transaction. An insufficient balance, or a
+// subscription that is no longer live, leaves both untouched.
+func (r *subscriptionRenewalRepositoryImpl) RenewWithWallet(renewal *models.SubscriptionRenewal, walletID uint, transaction *models.WalletTransaction) error {
+    tx := r.DB.Begin()
+    if err := debitWalletTx(tx, walletID, renewal.Amount); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    transaction.WalletID = walletID
+    transaction.SubscriptionID = &renewal.SubscriptionID
+    if err := tx.Create(transaction).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    renewal.Status = models.RenewalStatusSuccess
+    if err := tx.Create(renewal).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := extendSubscriptionTx(tx, renewal); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// ApplyRenewal marks a settled gateway renewal successful and extends the
+// subscription. It returns ErrSubscriptionNotRenewable, changing nothing, when
+// the subscription is no longer live.
+func (r *subscriptionRenewalRepositoryImpl) ApplyRenewal(renewal *models.SubscriptionRenewal) error {
+    tx := r.DB.Begin()
+    renewal.Status = models.RenewalStatusSuccess
+    if err := tx.Save(renewal).Error; err != nil {
+        tx.Rollback()
+        renewal.Status = models.RenewalStatusPending
+        return err
+    }
+    if err := extendSubscriptionTx(tx, renewal); err != nil {
+        tx.Rollback()
+        renewal.Status = models.RenewalStatusPending
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// extendSubscriptionTx extends a live subscription to the renewal's end date.
+// Cancelled, paused and expired subscriptions are left alone.
+func extendSubscriptionTx(tx *gorm.DB, renewal *models.SubscriptionRenewal) error {
+    result := tx.Model(&models.Subscription{}).
+        Where("subscription_id = ? AND status IN ?", renewal.SubscriptionID, models.SubscriptionLiveStatuses).
+        Updates(map[string]interface{}{
+            "end_date":                renewal.NewEndDate,
+            "price":                   renewal.Amount,
+            "status":                  models.SubscriptionStatusActive,
+            "grace_ends_at":           nil,
+            "rides_used":              0,
+            "last_reminder_days":      0,
+            "last_renewal_attempt_at": time.Now(),
+            "last_renewal_error":      "",
+            "updated_at":              time.Now(),
+        })
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return ErrSubscriptionNotRenewable
+    }
+    return nil
+}
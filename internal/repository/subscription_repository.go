@@ -1,6 +1,7 @@
 package repository
 
 import (
+    "errors"
     "time"
     "fmt"
     "log"
@@ -19,6 +20,18 @@ type SubscriptionRepository interface {
     GetSubscriptionPlan(planID uint) (*models.SubscriptionPlan, error)
     GetSubscriptionByUserAndCard(userID uint, nolCardID uint) (*models.Subscription, error)
     GetPaymentIDByOrderID(orderID string) (string, error)
+
+    PauseSubscription(subscriptionID uint, pausedAt time.Time) error
+    ResumeSubscription(subscriptionID uint, days int, adjustment *models.SubscriptionAdjustment) error
+    GetPausedSubscriptions() ([]models.Subscription, error)
+    CancelSubscription(subscriptionID uint, adjustment *models.SubscriptionAdjustment, walletID *uint) error
+    ChangePlan(subscription *models.Subscription, adjustment *models.SubscriptionAdjustment, walletID uint) error
+    CreateAdjustment(adjustment *models.SubscriptionAdjustment) error
+    GetAdjustments(subscriptionID uint) ([]models.SubscriptionAdjustment, error)
+    UpdateAdjustmentRefundStatus(adjustmentID uint, status string) error
+    GetLatestGatewayPayment(subscriptionID uint) (*models.RazorpayPayment, error)
+    SumWalletDebits(subscriptionID uint) (float64, error)
+    CreateSubscriptionWithWallet(subscription *models.Subscription, walletID uint, invoice *models.Invoice) error
 }
 
 type subscriptionRepository struct {
@@ -44,7 +57,7 @@ func (r *subscriptionRepository) GetSubscriptionByID(subscriptionID uint) (*mode
 
 func (r *subscriptionRepository) GetActiveSubscriptionByNolCardID(nolCardID uint) (*models.Subscription, error) {
     var subscription models.Subscription
-    result := r.db.Where("nol_card_id = ? AND end_date > ?", nolCardID, time.Now()).First(&subscription)
+    result := activeSubscriptions(r.db, time.Now()).Where("nol_card_id = ?", nolCardID).First(&subscription)
     if result.Error != nil {
         if result.Error == gorm.ErrRecordNotFound {
             return nil, nil 
@@ -84,6 +97,7 @@ func (r *subscriptionRepository) UpdateSubscription(subscription *models.Subscri
             "price":          subscription.Price,
             "end_date":      subscription.EndDate,
             "duration_days": subscription.DurationDays,
+            "status":        subscription.Status,
             "updated_at":    time.Now(),
         })
 
@@ -118,7 +132,7 @@ func (r *subscriptionRepository) GetSubscriptionPlan(planID uint) (*models.Subsc
 
 func (r *subscriptionRepository) GetSubscriptionByUserAndCard(userID uint, nolCardID uint) (*models.Subscription, error) {
     var subscription models.Subscription
-    err := r.db.Where("user_id = ? AND nol_card_id = ? AND end_date > ?", userID, nolCardID, time.Now()).First(&subscription).Error
+    err := activeSubscriptions(r.db, time.Now()).Where("user_id = ? AND nol_card_id = ?", userID, nolCardID).First(&subscription).Error
     if err != nil {
         return nil, err
     }
@@ -134,6 +148,274 @@ func (r *subscriptionRepository) GetPaymentIDByOrderID(orderID string) (string,
     return payment.RazorpayID, nil
 }
 
+// activeSubscriptions limits a query to subscriptions that currently cover travel,
+// including ones still inside their renewal grace period.
+func activeSubscriptions(db *gorm.DB, now time.Time) *gorm.DB {
+    return db.Where("status IN ? AND (end_date > ? OR (status = ? AND grace_ends_at > ?))",
+        models.SubscriptionLiveStatuses, now, models.SubscriptionStatusGrace, now)
+}
+
+func (r *subscriptionRepository) PauseSubscription(subscriptionID uint, pausedAt time.Time) error {
+    result := r.db.Model(&models.Subscription{}).
+        Where("subscription_id = ? AND status IN ?", subscriptionID, []string{models.SubscriptionStatusActive, ""}).
+        Updates(map[string]interface{}{
+            "status":     models.SubscriptionStatusPaused,
+            "paused_at":  pausedAt,
+            "updated_at": time.Now(),
+        })
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        return fmt.Errorf("only active subscriptions can be paused")
+    }
+    return nil
+}
+
+// ResumeSubscription reactivates a paused subscription and pushes EndDate out
+// by the days it was paused.
+func (r *subscriptionRepository) ResumeSubscription(subscriptionID uint, days int, adjustment *models.SubscriptionAdjustment) error {
+    tx := r.db.Begin()
+    result := tx.Model(&models.Subscription{}).
+        Where("subscription_id = ? AND status = ?", subscriptionID, models.SubscriptionStatusPaused).
+        Updates(map[string]interface{}{
+            "status":      models.SubscriptionStatusActive,
+            "end_date":    gorm.Expr("end_date + make_interval(days => ?)", days),
+            "paused_days": gorm.Expr("paused_days + ?", days),
+            "paused_at":   nil,
+            "updated_at":  time.Now(),
+        })
+    if result.Error != nil {
+        tx.Rollback()
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        tx.Rollback()
+        return fmt.Errorf("subscription is not paused")
+    }
+    if err := tx.Create(adjustment).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+func (r *subscriptionRepository) GetPausedSubscriptions() ([]models.Subscription, error) {
+    var subscriptions []models.Subscription
+    err := r.db.Where("status = ?", models.SubscriptionStatusPaused).Find(&subscriptions).Error
+    return subscriptions, err
+}
+
+// CancelSubscription ends the subscription now. When walletID is set the
+// adjustment's refund is credited to that wallet in the same transaction.
+func (r *subscriptionRepository) CancelSubscription(subscriptionID uint, adjustment *models.SubscriptionAdjustment, walletID *uint) error {
+    tx := r.db.Begin()
+    now := time.Now()
+    result := tx.Model(&models.Subscription{}).
+        Where("subscription_id = ? AND status IN ?", subscriptionID,
+            []string{models.SubscriptionStatusActive, models.SubscriptionStatusGrace, models.SubscriptionStatusPaused, ""}).
+        Updates(map[string]interface{}{
+            "status":       models.SubscriptionStatusCancelled,
+            "end_date":     now,
+            "auto_renew":   false,
+            "cancelled_at": now,
+            "paused_at":    nil,
+            "updated_at":   now,
+        })
+    if result.Error != nil {
+        tx.Rollback()
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        tx.Rollback()
+        return fmt.Errorf("subscription cannot be cancelled in its current state")
+    }
+
+    if walletID != nil && adjustment.Amount < 0 {
+        if err := creditWalletTx(tx, *walletID, -adjustment.Amount); err != nil {
+            tx.Rollback()
+            return err
+        }
+        if err := tx.Create(&models.WalletTransaction{
+            WalletID:        *walletID,
+            Amount:          -adjustment.Amount,
+            TransactionType: "subscription_refund",
+            Description:     fmt.Sprintf("Pro-rata refund for cancelled subscription %d", subscriptionID),
+            SubscriptionID:  &subscriptionID,
+        }).Error; err != nil {
+            tx.Rollback()
+            return err
+        }
+        var payment models.RazorpayPayment
+        err := tx.Table("payments").
+            Where("subscription_id = ? AND method = ? AND status = ?", subscriptionID, "wallet", models.PaymentStatusVerified).
+            Order("created_at DESC").First(&payment).Error
+        if err == nil {
+            err = recordRefundTx(tx, payment.PaymentID, -adjustment.Amount)
+        }
+        if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+            tx.Rollback()
+            return err
+        }
+    }
+    if err := tx.Create(adjustment).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
+
+// ChangePlan moves the subscription to a new plan, debiting or crediting the
+// wallet with the adjustment amount in the same transaction.
+func (r *subscriptionRepository) ChangePlan(subscription *models.Subscription, adjustment *models.SubscriptionAdjustment, walletID uint) error {
+    tx := r.db.Begin()
+    transaction := &models.WalletTransaction{
+        WalletID:       walletID,
+        SubscriptionID: &subscription.SubscriptionID,
+    }
+    switch {
+    case adjustment.Amount > 0:
+        if err := debitWalletTx(tx, walletID, adjustment.Amount); err != nil {
+            tx.Rollback()
+            return err
+        }
+        transaction.Amount = adjustment.Amount
+        transaction.TransactionType = "subscription_plan_change"
+        transaction.Description = fmt.Sprintf("Plan change charge for subscription %d", subscription.SubscriptionID)
+    case adjustment.Amount < 0:
+        if err := creditWalletTx(tx, walletID, -adjustment.Amount); err != nil {
+            tx.Rollback()
+            return err
+        }
+        transaction.Amount = -adjustment.Amount
+        transaction.TransactionType = "subscription_plan_change_credit"
+        transaction.Description = fmt.Sprintf("Plan change credit for subscription %d", subscription.SubscriptionID)
+    }
+    if transaction.Amount > 0 {
+        if err := tx.Create(transaction).Error; err != nil {
+            tx.Rollback()
+            return err
+        }
+    }
 
+    result := tx.Model(&models.Subscription{}).
+        Where("subscription_id = ? AND plan_id = ? AND status IN ?", subscription.SubscriptionID, adjustment.FromPlanID,
+            []string{models.SubscriptionStatusActive, ""}).
+        Updates(map[string]interface{}{
+            "plan_id":            subscription.PlanID,
+            "service_type":       subscription.ServiceType,
+            "price":              subscription.Price,
+            "duration_days":      subscription.DurationDays,
+            "start_date":         subscription.StartDate,
+            "end_date":           subscription.EndDate,
+            "paused_days":        0,
+            "ride_allowance":     subscription.RideAllowance,
+            "rides_used":         0,
+            "last_reminder_days": 0,
+            "updated_at":         time.Now(),
+        })
+    if result.Error != nil {
+        tx.Rollback()
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        tx.Rollback()
+        return fmt.Errorf("subscription changed while switching plans, please retry")
+    }
+    if err := tx.Create(adjustment).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
 
+func (r *subscriptionRepository) CreateAdjustment(adjustment *models.SubscriptionAdjustment) error {
+    return r.db.Create(adjustment).Error
+}
 
+func (r *subscriptionRepository) GetAdjustments(subscriptionID uint) ([]models.SubscriptionAdjustment, error) {
+    var adjustments []models.SubscriptionAdjustment
+    err := r.db.Where("subscription_id = ?", subscriptionID).Order("created_at DESC").Find(&adjustments).Error
+    return adjustments, err
+}
+
+func (r *subscriptionRepository) UpdateAdjustmentRefundStatus(adjustmentID uint, status string) error {
+    return r.db.Model(&models.SubscriptionAdjustment{}).Where("adjustment_id = ?", adjustmentID).
+        Update("refund_status", status).Error
+}
+
+// GetLatestGatewayPayment returns the most recent settled Razorpay payment for the subscription.
+func (r *subscriptionRepository) GetLatestGatewayPayment(subscriptionID uint) (*models.RazorpayPayment, error) {
+    var payment models.RazorpayPayment
+    err := r.db.Table("payments").
+        Where("subscription_id = ? AND status IN ? AND razorpay_id <> ''", subscriptionID, []string{models.PaymentStatusVerified}).
+        Order("created_at DESC").First(&payment).Error
+    if err != nil {
+        return nil, err
+    }
+    return &payment, nil
+}
+
+// SumWalletDebits is the net amount the wallet has paid towards the subscription.
+func (r *subscriptionRepository) SumWalletDebits(subscriptionID uint) (float64, error) {
+    var total float64
+    err := r.db.Model(&models.WalletTransaction{}).
+        Where("subscription_id = ? AND transaction_type IN ?", subscriptionID,
+            []string{"subscription", "subscription_renewal", "subscription_plan_change"}).
+        Select("COALESCE(SUM(amount), 0)").Scan(&total).Error
+    return total, err
+}
+
+// CreateSubscriptionWithWallet debits the wallet and creates the subscription,
+// its linked wallet transaction, a wallet payment record and the invoice in one
+// transaction. An insufficient balance leaves nothing behind.
+func (r *subscriptionRepository) CreateSubscriptionWithWallet(subscription *models.Subscription, walletID uint, invoice *models.Invoice) error {
+    tx := r.db.Begin()
+    if err := debitWalletTx(tx, walletID, subscription.Price); err != nil {
+        tx.Rollback()
+        return err
+    }
+    if err := tx.Create(subscription).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    transaction := &models.WalletTransaction{
+        WalletID:        walletID,
+        Amount:          subscription.Price,
+        TransactionType: "subscription",
+        Description:     fmt.Sprintf("Subscription %d: %s", subscription.SubscriptionID, subscription.ServiceType),
+        SubscriptionID:  &subscription.SubscriptionID,
+    }
+    if err := tx.Create(transaction).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    now := time.Now()
+    payment := &models.RazorpayPayment{
+        UserID:          subscription.UserID,
+        Amount:          subscription.Price,
+        Currency:        models.BaseCurrency,
+        SettledAmount:   subscription.Price,
+        SettledCurrency: models.BaseCurrency,
+        ExchangeRate:    1,
+        Status:          models.PaymentStatusVerified,
+        Method:          "wallet",
+        WalletID:        &walletID,
+        SubscriptionID:  &subscription.SubscriptionID,
+        PaymentType:     "subscription",
+        PaymentDate:     now,
+    }
+    if err := tx.Table("payments").Create(payment).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    invoice.PaymentID = payment.PaymentID
+    if err := createInvoiceTx(tx, invoice); err != nil {
+        tx.Rollback()
+        return err
+    }
+    return tx.Commit().Error
+}
@@ -3,16 +3,19 @@ package repository
 import (
     "github.com/Prototype-1/xtrace/internal/models"
     "gorm.io/gorm"
-	"fmt"
+	"errors"
 	"log"
 )
 
+// ErrInsufficientBalance is returned when a debit would take a balance below zero.
+var ErrInsufficientBalance = errors.New("insufficient balance")
+
 type WalletRepository interface {
     CreateWallet(userID uint) (*models.Wallet, error)                
     GetWalletByUserID(userID uint) (*models.Wallet, error)  
     GetWalletByID(walletID uint) (*models.Wallet, error)          
-    UpdateWalletBalance(walletID uint, newBalance float64) error       
-    DeductWalletBalance(walletID uint, amount float64) error          
+    CreditWallet(walletID uint, amount float64, transaction *models.WalletTransaction) (*models.Wallet, error)
+    DebitWallet(walletID uint, amount float64, transaction *models.WalletTransaction) (*models.Wallet, error)
 }
 
 type WalletTransactionRepository interface {
@@ -70,22 +73,83 @@ func (r *walletRepositoryImpl) GetWalletByID(walletID uint) (*models.Wallet, err
     return &wallet, nil
 }
 
-func (r *walletRepositoryImpl) UpdateWalletBalance(walletID uint, newBalance float64) error {
-    return r.DB.Model(&models.Wallet{}).Where("wallet_id = ?", walletID).Update("balance", newBalance).Error
+// CreditWallet adds amount to the balance and records the transaction in the
+// same database transaction, so a failed insert never leaves a silent credit.
+func (r *walletRepositoryImpl) CreditWallet(walletID uint, amount float64, transaction *models.WalletTransaction) (*models.Wallet, error) {
+    tx := r.DB.Begin()
+    if err := creditWalletTx(tx, walletID, amount); err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+    return r.recordAndCommit(tx, walletID, transaction)
 }
 
-func (r *walletRepositoryImpl) DeductWalletBalance(walletID uint, amount float64) error {
-    var wallet models.Wallet
-    if err := r.DB.Where("wallet_id = ?", walletID).First(&wallet).Error; err != nil {
-        return err
+// DebitWallet subtracts amount only if the balance covers it. The check and the
+// write are a single conditional UPDATE, so concurrent debits cannot overspend.
+func (r *walletRepositoryImpl) DebitWallet(walletID uint, amount float64, transaction *models.WalletTransaction) (*models.Wallet, error) {
+    tx := r.DB.Begin()
+    if err := debitWalletTx(tx, walletID, amount); err != nil {
+        tx.Rollback()
+        return nil, err
     }
+    return r.recordAndCommit(tx, walletID, transaction)
+}
 
-    if wallet.Balance < amount {
-        return fmt.Errorf("insufficient balance")
+// creditWalletTx increments a wallet balance inside an open transaction.
+func creditWalletTx(tx *gorm.DB, walletID uint, amount float64) error {
+    result := tx.Model(&models.Wallet{}).
+        Where("wallet_id = ?", walletID).
+        Update("balance", gorm.Expr("balance + ?", amount))
+    if result.Error != nil {
+        return result.Error
     }
+    if result.RowsAffected == 0 {
+        return gorm.ErrRecordNotFound
+    }
+    return nil
+}
 
-    wallet.Balance -= amount
-    return r.DB.Save(&wallet).Error
+// debitWalletTx decrements a wallet balance inside an open transaction and
+// returns ErrInsufficientBalance when the balance does not cover amount.
+func debitWalletTx(tx *gorm.DB, walletID uint, amount float64) error {
+    result := tx.Model(&models.Wallet{}).
+        Where("wallet_id = ? AND balance >= ?", walletID, amount).
+        Update("balance", gorm.Expr("balance - ?", amount))
+    if result.Error != nil {
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        var count int64
+        if err := tx.Model(&models.Wallet{}).Where("wallet_id = ?", walletID).Count(&count).Error; err != nil {
+            return err
+        }
+        if count == 0 {
+            return gorm.ErrRecordNotFound
+        }
+        return ErrInsufficientBalance
+    }
+    return nil
+}
+
+func (r *walletRepositoryImpl) recordAndCommit(tx *gorm.DB, walletID uint, transaction *models.WalletTransaction) (*models.Wallet, error) {
+    if transaction != nil {
+        transaction.WalletID = walletID
+        if err := tx.Create(transaction).Error; err != nil {
+            tx.Rollback()
+            return nil, err
+        }
+    }
+
+    var wallet models.Wallet
+    if err := tx.Where("wallet_id = ?", walletID).First(&wallet).Error; err != nil {
+        tx.Rollback()
+        return nil, err
+    }
+
+    if err := tx.Commit().Error; err != nil {
+        return nil, err
+    }
+    return &wallet, nil
 }
 
 func (r *walletTransactionRepositoryImpl) CreateTransaction(transaction *models.WalletTransaction) error {
@@ -0,0 +1,184 @@
+package repository
+
+import (
+    "errors"
+    "math/rand"
+    "os"
+    "sync"
+    "testing"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "gorm.io/gorm/logger"
+)
+
+// testDB connects to the Postgres database in TEST_DATABASE_URL, skipping the
+// test when it is not set. The balance checks rely on Postgres row locking, so
+// they are not run against anything else.
+func testDB(t *testing.T) *gorm.DB {
+    t.Helper()
+    dsn := os.Getenv("TEST_DATABASE_URL")
+    if dsn == "" {
+        t.Skip("TEST_DATABASE_URL is not set")
+    }
+    db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+    if err != nil {
+        t.Fatalf("connecting to test database: %v", err)
+    }
+    if err := db.AutoMigrate(&models.Wallet{}, &models.WalletTransaction{}); err != nil {
+        t.Fatalf("migrating test database: %v", err)
+    }
+    return db
+}
+
+// newTestWallet creates a wallet holding balance and removes it, with its
+// transactions, when the test ends.
+func newTestWallet(t *testing.T, db *gorm.DB, balance float64) *models.Wallet {
+    t.Helper()
+    wallet := &models.Wallet{UserID: uint(rand.Int31()), Balance: balance}
+    if err := db.Create(wallet).Error; err != nil {
+        t.Fatalf("creating wallet: %v", err)
+    }
+    t.Cleanup(func() {
+        db.Where("wallet_id = ?", wallet.WalletID).Delete(&models.WalletTransaction{})
+        db.Where("wallet_id = ?", wallet.WalletID).Delete(&models.Wallet{})
+    })
+    return wallet
+}
+
+func walletState(t *testing.T, db *gorm.DB, walletID uint) (float64, int64) {
+    t.Helper()
+    var wallet models.Wallet
+    if err := db.Where("wallet_id = ?", walletID).First(&wallet).Error; err != nil {
+        t.Fatalf("reading wallet: %v", err)
+    }
+    var count int64
+    if err := db.Model(&models.WalletTransaction{}).Where("wallet_id = ?", walletID).Count(&count).Error; err != nil {
+        t.Fatalf("counting transactions: %v", err)
+    }
+    return wallet.Balance, count
+}
+
+func TestDebitWalletConcurrentDebitsNeverOverspend(t *testing.T) {
+    db := testDB(t)
+    repo := NewWalletRepository(db)
+    wallet := newTestWallet(t, db, 100)
+
+    const workers = 50
+    var (
+        wg        sync.WaitGroup
+        mu        sync.Mutex
+        succeeded int
+        negative  bool
+    )
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            updated, err := repo.DebitWallet(wallet.WalletID, 10, &models.WalletTransaction{Amount: 10, TransactionType: "payment"})
+            mu.Lock()
+            defer mu.Unlock()
+            switch {
+            case err == nil:
+                succeeded++
+                negative = negative || updated.Balance < 0
+            case !errors.Is(err, ErrInsufficientBalance):
+                t.Errorf("unexpected debit error: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    balance, transactions := walletState(t, db, wallet.WalletID)
+    if succeeded != 10 {
+        t.Errorf("succeeded debits = %d, want 10", succeeded)
+    }
+    if negative || balance != 0 {
+        t.Errorf("balance = %.2f (negative seen: %v), want 0", balance, negative)
+    }
+    if transactions != int64(succeeded) {
+        t.Errorf("transactions = %d, want %d", transactions, succeeded)
+    }
+}
+
+func TestCreditWalletConcurrentCreditsAreNotLost(t *testing.T) {
+    db := testDB(t)
+    repo := NewWalletRepository(db)
+    wallet := newTestWallet(t, db, 0)
+
+    const workers = 50
+    var wg sync.WaitGroup
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if _, err := repo.CreditWallet(wallet.WalletID, 5, &models.WalletTransaction{Amount: 5, TransactionType: "top-up"}); err != nil {
+                t.Errorf("unexpected credit error: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    balance, transactions := walletState(t, db, wallet.WalletID)
+    if balance != workers*5 {
+        t.Errorf("balance = %.2f, want %d", balance, workers*5)
+    }
+    if transactions != workers {
+        t.Errorf("transactions = %d, want %d", transactions, workers)
+    }
+}
+
+func TestWalletConcurrentDebitsAndCredits(t *testing.T) {
+    db := testDB(t)
+    repo := NewWalletRepository(db)
+    wallet := newTestWallet(t, db, 100)
+
+    const workers = 40
+    var (
+        wg       sync.WaitGroup
+        mu       sync.Mutex
+        debited  int
+        negative bool
+    )
+    for i := 0; i < workers; i++ {
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            updated, err := repo.CreditWallet(wallet.WalletID, 10, &models.WalletTransaction{Amount: 10, TransactionType: "top-up"})
+            if err != nil {
+                t.Errorf("unexpected credit error: %v", err)
+                return
+            }
+            mu.Lock()
+            negative = negative || updated.Balance < 0
+            mu.Unlock()
+        }()
+        go func() {
+            defer wg.Done()
+            updated, err := repo.DebitWallet(wallet.WalletID, 25, &models.WalletTransaction{Amount: 25, TransactionType: "payment"})
+            mu.Lock()
+            defer mu.Unlock()
+            switch {
+            case err == nil:
+                debited++
+                negative = negative || updated.Balance < 0
+            case !errors.Is(err, ErrInsufficientBalance):
+                t.Errorf("unexpected debit error: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    balance, transactions := walletState(t, db, wallet.WalletID)
+    want := 100 + workers*10 - float64(debited)*25
+    if negative || balance < 0 {
+        t.Errorf("balance went negative")
+    }
+    if balance != want {
+        t.Errorf("balance = %.2f, want %.2f after %d debits", balance, want, debited)
+    }
+    if transactions != int64(workers+debited) {
+        t.Errorf("transactions = %d, want %d", transactions, workers+debited)
+    }
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+    "errors"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+)
+
+// ErrTransferNotPending is returned when a transfer was already completed or
+// cancelled by a concurrent request.
+var ErrTransferNotPending = errors.New("transfer is no longer pending")
+
+// ErrDailyTransferLimit is returned when a new transfer would take the sender
+// past their daily limit.
+var ErrDailyTransferLimit = errors.New("daily transfer limit exceeded")
+
+type WalletTransferRepository interface {
+    CreateTransferWithinLimit(transfer *models.WalletTransfer, since time.Time, dailyLimit float64) (float64, error)
+    GetTransferByID(transferID uint) (*models.WalletTransfer, error)
+    GetTransfersByUserID(userID uint) ([]models.WalletTransfer, error)
+    IncrementOTPAttempts(transferID uint) error
+    CancelTransfer(transferID uint) error
+    CompleteTransfer(transfer *models.WalletTransfer) error
+}
+
+type walletTransferRepositoryImpl struct {
+    DB *gorm.DB
+}
+
+func NewWalletTransferRepository(db *gorm.DB) WalletTransferRepository {
+    return &walletTransferRepositoryImpl{DB: db}
+}
+
+func (r *walletTransferRepositoryImpl) GetTransferByID(transferID uint) (*models.WalletTransfer, error) {
+    var transfer models.WalletTransfer
+    if err := r.DB.Where("transfer_id = ?", transferID).First(&transfer).Error; err != nil {
+        return nil, err
+    }
+    return &transfer, nil
+}
+
+func (r *walletTransferRepositoryImpl) GetTransfersByUserID(userID uint) ([]models.WalletTransfer, error) {
+    var transfers []models.WalletTransfer
+    err := r.DB.Where("sender_user_id = ?", userID).Order("created_at DESC").Find(&transfers).Error
+    return transfers, err
+}
+
+// CreateTransferWithinLimit stores a pending transfer unless it would take the
+// sender's transfers of its kind since the given time past dailyLimit. The
+// sender's wallet row is locked while the day's total is read and the transfer
+// inserted, so concurrent initiations are checked one after another. It
+// returns the amount already used.
+func (r *walletTransferRepositoryImpl) CreateTransferWithinLimit(transfer *models.WalletTransfer, since time.Time, dailyLimit float64) (float64, error) {
+    tx := r.DB.Begin()
+
+    var wallet models.Wallet
+    if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Where("wallet_id = ?", transfer.SenderWalletID).First(&wallet).Error; err != nil {
+        tx.Rollback()
+        return 0, err
+    }
+
+    sentToday, err := sumTransfersSinceTx(tx, transfer.SenderUserID, transfer.RecipientType, since)
+    if err != nil {
+        tx.Rollback()
+        return 0, err
+    }
+    if sentToday+transfer.Amount > dailyLimit {
+        tx.Rollback()
+        return sentToday, ErrDailyTransferLimit
+    }
+
+    if err := tx.Create(transfer).Error; err != nil {
+        tx.Rollback()
+        return sentToday, err
+    }
+    return sentToday, tx.Commit().Error
+}
+
+// sumTransfersSinceTx totals completed and still-pending transfers, so a user
+// cannot exceed the daily limit by opening several transfers before confirming.
+func sumTransfersSinceTx(tx *gorm.DB, userID uint, recipientType string, since time.Time) (float64, error) {
+    var total float64
+    err := tx.Model(&models.WalletTransfer{}).
+        Where("sender_user_id = ? AND recipient_type = ? AND created_at >= ? AND status IN ?",
+            userID, recipientType, since, []string{models.TransferStatusPending, models.TransferStatusCompleted}).
+        Select("COALESCE(SUM(amount), 0)").
+        Scan(&total).Error
+    return total, err
+}
+
+func (r *walletTransferRepositoryImpl) IncrementOTPAttempts(transferID uint) error {
+    return r.DB.Model(&models.WalletTransfer{}).
+        Where("transfer_id = ?", transferID).
+        Update("otp_attempts", gorm.Expr("otp_attempts + 1")).Error
+}
+
+func (r *walletTransferRepositoryImpl) CancelTransfer(transferID uint) error {
+    return r.DB.Model(&models.WalletTransfer{}).
+        Where("transfer_id = ? AND status = ?", transferID, models.TransferStatusPending).
+        Update("status", models.TransferStatusCancelled).Error
+}
+
+// CompleteTransfer debits the sender, credits the recipient wallet or card and
+// writes the paired ledger rows in one database transaction.
+func (r *walletTransferRepositoryImpl) CompleteTransfer(transfer *models.WalletTransfer) error {
+    tx := r.DB.Begin()
+
+    now := time.Now()
+    result := tx.Model(&models.WalletTransfer{}).
+        Where("transfer_id = ? AND status = ?", transfer.TransferID, models.TransferStatusPending).
+        Updates(map[string]interface{}{"status": models.TransferStatusCompleted, "completed_at": now})
+    if result.Error != nil {
+        tx.Rollback()
+        return result.Error
+    }
+    if result.RowsAffected == 0 {
+        tx.Rollback()
+        return ErrTransferNotPending
+    }
+
+    if err := debitWalletTx(tx, transfer.SenderWalletID, transfer.Amount); err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    transferID := transfer.TransferID
+    debit := models.WalletTransaction{
+        WalletID:        transfer.SenderWalletID,
+        Amount:          transfer.Amount,
+        TransferID:      &transferID,
+    }
+
+    switch transfer.RecipientType {
+    case models.TransferToWallet:
+        if transfer.RecipientWalletID == nil {
+            tx.Rollback()
+            return errors.New("transfer has no recipient wallet")
+        }
+        if err := creditWalletTx(tx, *transfer.RecipientWalletID, transfer.Amount); err != nil {
+            tx.Rollback()
+            return err
+        }
+        debit.TransactionType = "transfer-out"
+        debit.Description = "Transfer to another wallet"
+        credit := models.WalletTransaction{
+            WalletID:        *transfer.RecipientWalletID,
+            Amount:          transfer.Amount,
+            TransactionType: "transfer-in",
+            Description:     "Transfer received from another wallet",
+            TransferID:      &transferID,
+        }
+        if err := tx.Create(&credit).Error; err != nil {
+            tx.Rollback()
+            return err
+        }
+    case models.TransferToNolCard:
+        if transfer.RecipientNolCardID == nil {
+            tx.Rollback()
+            return errors.New("transfer has no recipient card")
+        }
+        if err := creditNolCardTx(tx, *transfer.RecipientNolCardID, transfer.Amount); err != nil {
+            tx.Rollback()
+            return err
+        }
+        debit.TransactionType = "nol-card-transfer"
+        debit.Description = "Transfer to NolCard"
+        topup := models.NolCardTopup{
+            NolCardID:  *transfer.RecipientNolCardID,
+            Amount:     transfer.Amount,
+            TopupDate:  now,
+            TransferID: &transferID,
+        }
+        if err := tx.Create(&topup).Error; err != nil {
+            tx.Rollback()
+            return err
+        }
+    default:
+        tx.Rollback()
+        return errors.New("unknown transfer recipient type")
+    }
+
+    if err := tx.Create(&debit).Error; err != nil {
+        tx.Rollback()
+        return err
+    }
+
+    if err := tx.Commit().Error; err != nil {
+        return err
+    }
+    transfer.Status = models.TransferStatusCompleted
+    transfer.CompletedAt = &now
+    return nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+    "errors"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+)
+
+// TestWalletTransferConcurrentInitiationsStayWithinDailyLimit opens transfers
+// from one sender in parallel and checks that the pending total never goes
+// past the daily limit.
+func TestWalletTransferConcurrentInitiationsStayWithinDailyLimit(t *testing.T) {
+    db := testDB(t)
+    if err := db.AutoMigrate(&models.WalletTransfer{}); err != nil {
+        t.Fatalf("migrating test database: %v", err)
+    }
+    repo := NewWalletTransferRepository(db)
+    wallet := newTestWallet(t, db, 1000)
+    t.Cleanup(func() {
+        db.Where("sender_wallet_id = ?", wallet.WalletID).Delete(&models.WalletTransfer{})
+    })
+
+    const (
+        workers    = 30
+        dailyLimit = 100.0
+    )
+    startOfDay := time.Now().Truncate(24 * time.Hour)
+    var (
+        wg      sync.WaitGroup
+        mu      sync.Mutex
+        created int
+    )
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            transfer := &models.WalletTransfer{
+                SenderUserID:   wallet.UserID,
+                SenderWalletID: wallet.WalletID,
+                RecipientType:  models.TransferToWallet,
+                Amount:         10,
+                Status:         models.TransferStatusPending,
+                OTPExpiry:      time.Now().Add(time.Minute),
+            }
+            _, err := repo.CreateTransferWithinLimit(transfer, startOfDay, dailyLimit)
+            mu.Lock()
+            defer mu.Unlock()
+            switch {
+            case err == nil:
+                created++
+            case !errors.Is(err, ErrDailyTransferLimit):
+                t.Errorf("unexpected transfer error: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    var total float64
+    if err := db.Model(&models.WalletTransfer{}).Where("sender_wallet_id = ?", wallet.WalletID).
+        Select("COALESCE(SUM(amount), 0)").Scan(&total).Error; err != nil {
+        t.Fatalf("summing transfers: %v", err)
+    }
+    if created != 10 || total != dailyLimit {
+        t.Errorf("created = %d, total = %.2f; want 10 transfers totalling %.2f", created, total, dailyLimit)
+    }
+}
@@ -0,0 +1,427 @@
+package usecase
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "github.com/Prototype-1/xtrace/pkg/utils"
+    "github.com/razorpay/razorpay-go"
+    "gorm.io/gorm"
+)
+
+// MandateSettlementTimeout is how long a mandate charge may stay uncaptured
+// before the reload is given up as failed.
+const MandateSettlementTimeout = 72 * time.Hour
+
+type AutoReloadUsecase interface {
+    CreateRule(rule *models.AutoReloadRule) error
+    UpdateRule(userID uint, rule *models.AutoReloadRule) error
+    DeleteRule(userID, ruleID uint) error
+    GetRulesByUserID(userID uint) ([]models.AutoReloadRule, error)
+    GetEventsByRuleID(userID, ruleID uint) ([]models.AutoReloadEvent, error)
+
+    // CheckWallet and CheckNolCard evaluate the rules for one target right
+    // after its balance has gone down.
+    CheckWallet(walletID uint)
+    CheckNolCard(nolCardID int)
+    // RunAutoReloads settles pending mandate charges and evaluates every rule.
+    RunAutoReloads() error
+}
+
+type autoReloadUsecaseImpl struct {
+    autoReloadRepo      repository.AutoReloadRepository
+    walletRepo          repository.WalletRepository
+    userRepo            repository.UserRepository
+    walletUsecase       WalletUsecase
+    nolCardTopupUsecase NolCardTopupUsecase
+    paymentUsecase      RazorpayPaymentUsecase
+    client              *razorpay.Client
+}
+
+func NewAutoReloadUsecase(autoReloadRepo repository.AutoReloadRepository, walletRepo repository.WalletRepository, userRepo repository.UserRepository, walletUsecase WalletUsecase, nolCardTopupUsecase NolCardTopupUsecase, paymentUsecase RazorpayPaymentUsecase, client *razorpay.Client) AutoReloadUsecase {
+    return &autoReloadUsecaseImpl{
+        autoReloadRepo:      autoReloadRepo,
+        walletRepo:          walletRepo,
+        userRepo:            userRepo,
+        walletUsecase:       walletUsecase,
+        nolCardTopupUsecase: nolCardTopupUsecase,
+        paymentUsecase:      paymentUsecase,
+        client:              client,
+    }
+}
+
+func (u *autoReloadUsecaseImpl) CreateRule(rule *models.AutoReloadRule) error {
+    switch rule.TargetType {
+    case models.AutoReloadTargetWallet:
+        wallet, err := u.walletRepo.GetWalletByUserID(rule.UserID)
+        if err != nil {
+            return fmt.Errorf("wallet not found for user")
+        }
+        rule.WalletID = &wallet.WalletID
+        rule.NolCardID = nil
+    case models.AutoReloadTargetNolCard:
+        if rule.NolCardID == nil {
+            return fmt.Errorf("nol_card_id is required for a NolCard rule")
+        }
+        nolCard, err := u.nolCardTopupUsecase.GetNolCardByID(*rule.NolCardID)
+        if err != nil || uint(nolCard.UserID) != rule.UserID {
+            return fmt.Errorf("NolCard not found")
+        }
+        rule.WalletID = nil
+    default:
+        return fmt.Errorf("target_type must be %q or %q", models.AutoReloadTargetWallet, models.AutoReloadTargetNolCard)
+    }
+
+    if err := validateAutoReloadRule(rule); err != nil {
+        return err
+    }
+    rule.Enabled = true
+    rule.InProgress = false
+    return u.autoReloadRepo.CreateRule(rule)
+}
+
+func (u *autoReloadUsecaseImpl) UpdateRule(userID uint, rule *models.AutoReloadRule) error {
+    existing, err := u.autoReloadRepo.GetRuleByID(rule.AutoReloadRuleID)
+    if err != nil || existing.UserID != userID {
+        return fmt.Errorf("auto-reload rule not found")
+    }
+    rule.UserID = existing.UserID
+    rule.TargetType = existing.TargetType
+    if err := validateAutoReloadRule(rule); err != nil {
+        return err
+    }
+    return u.autoReloadRepo.UpdateRule(rule)
+}
+
+func validateAutoReloadRule(rule *models.AutoReloadRule) error {
+    if rule.Threshold < 0 {
+        return fmt.Errorf("threshold cannot be negative")
+    }
+    if rule.ReloadAmount <= 0 {
+        return fmt.Errorf("reload_amount must be greater than zero")
+    }
+    if rule.MonthlyCap < rule.ReloadAmount {
+        return fmt.Errorf("monthly_cap must be at least the reload amount")
+    }
+    switch rule.FundingSource {
+    case models.AutoReloadFundingWallet:
+        if rule.TargetType == models.AutoReloadTargetWallet {
+            return fmt.Errorf("a wallet cannot be reloaded from itself")
+        }
+    case models.AutoReloadFundingMandate:
+        if rule.MandateCustomerID == "" || rule.MandateTokenID == "" {
+            return fmt.Errorf("mandate_customer_id and mandate_token_id are required for mandate funding")
+        }
+    default:
+        return fmt.Errorf("funding_source must be %q or %q", models.AutoReloadFundingWallet, models.AutoReloadFundingMandate)
+    }
+    return nil
+}
+
+func (u *autoReloadUsecaseImpl) DeleteRule(userID, ruleID uint) error {
+    return u.autoReloadRepo.DeleteRule(ruleID, userID)
+}
+
+func (u *autoReloadUsecaseImpl) GetRulesByUserID(userID uint) ([]models.AutoReloadRule, error) {
+    return u.autoReloadRepo.GetRulesByUserID(userID)
+}
+
+func (u *autoReloadUsecaseImpl) GetEventsByRuleID(userID, ruleID uint) ([]models.AutoReloadEvent, error) {
+    rule, err := u.autoReloadRepo.GetRuleByID(ruleID)
+    if err != nil || rule.UserID != userID {
+        return nil, fmt.Errorf("auto-reload rule not found")
+    }
+    return u.autoReloadRepo.GetEventsByRuleID(ruleID)
+}
+
+func (u *autoReloadUsecaseImpl) CheckWallet(walletID uint) {
+    rules, err := u.autoReloadRepo.GetEnabledRulesForTarget(models.AutoReloadTargetWallet, walletID)
+    if err != nil {
+        log.Printf("Error loading auto-reload rules for wallet %d: %v", walletID, err)
+        return
+    }
+    for i := range rules {
+        u.evaluateRule(&rules[i])
+    }
+}
+
+func (u *autoReloadUsecaseImpl) CheckNolCard(nolCardID int) {
+    rules, err := u.autoReloadRepo.GetEnabledRulesForTarget(models.AutoReloadTargetNolCard, uint(nolCardID))
+    if err != nil {
+        log.Printf("Error loading auto-reload rules for NolCard %d: %v", nolCardID, err)
+        return
+    }
+    for i := range rules {
+        u.evaluateRule(&rules[i])
+    }
+}
+
+func (u *autoReloadUsecaseImpl) RunAutoReloads() error {
+    u.settlePendingMandateCharges()
+
+    rules, err := u.autoReloadRepo.GetEnabledRules()
+    if err != nil {
+        return fmt.Errorf("failed to load auto-reload rules: %v", err)
+    }
+    for i := range rules {
+        u.evaluateRule(&rules[i])
+    }
+    return nil
+}
+
+// evaluateRule reloads the rule's target if its balance is under the threshold
+// and the monthly cap allows it. Mandate charges keep the rule claimed until
+// settlePendingMandateCharges resolves them.
+func (u *autoReloadUsecaseImpl) evaluateRule(rule *models.AutoReloadRule) {
+    balance, err := u.targetBalance(rule)
+    if err != nil {
+        log.Printf("Auto-reload rule %d: failed to read balance: %v", rule.AutoReloadRuleID, err)
+        return
+    }
+    if balance >= rule.Threshold {
+        return
+    }
+
+    claimed, err := u.autoReloadRepo.ClaimRule(rule.AutoReloadRuleID)
+    if err != nil || !claimed {
+        return
+    }
+
+    now := time.Now()
+    startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+    reloaded, err := u.autoReloadRepo.SumReloadsSince(rule.AutoReloadRuleID, startOfMonth)
+    if err != nil {
+        log.Printf("Auto-reload rule %d: failed to check monthly cap: %v", rule.AutoReloadRuleID, err)
+        u.release(rule.AutoReloadRuleID, false)
+        return
+    }
+    if reloaded+rule.ReloadAmount > rule.MonthlyCap {
+        log.Printf("Auto-reload rule %d: monthly cap %.2f reached", rule.AutoReloadRuleID, rule.MonthlyCap)
+        u.release(rule.AutoReloadRuleID, false)
+        return
+    }
+
+    event := &models.AutoReloadEvent{
+        AutoReloadRuleID: rule.AutoReloadRuleID,
+        UserID:           rule.UserID,
+        Amount:           rule.ReloadAmount,
+        BalanceBefore:    balance,
+        FundingSource:    rule.FundingSource,
+        Status:           models.AutoReloadStatusPending,
+    }
+    if err := u.autoReloadRepo.CreateEvent(event); err != nil {
+        log.Printf("Auto-reload rule %d: failed to record event: %v", rule.AutoReloadRuleID, err)
+        u.release(rule.AutoReloadRuleID, false)
+        return
+    }
+
+    if rule.FundingSource == models.AutoReloadFundingMandate {
+        if err := u.chargeMandate(rule, event); err != nil {
+            u.finishEvent(rule, event, err)
+        }
+        // A successful charge stays pending until the gateway captures it.
+        return
+    }
+
+    u.finishEvent(rule, event, u.reloadFromWallet(rule))
+}
+
+func (u *autoReloadUsecaseImpl) targetBalance(rule *models.AutoReloadRule) (float64, error) {
+    if rule.TargetType == models.AutoReloadTargetWallet {
+        if rule.WalletID == nil {
+            return 0, errors.New("rule has no wallet")
+        }
+        wallet, err := u.walletRepo.GetWalletByID(*rule.WalletID)
+        if err != nil {
+            return 0, err
+        }
+        return wallet.Balance, nil
+    }
+    if rule.NolCardID == nil {
+        return 0, errors.New("rule has no NolCard")
+    }
+    nolCard, err := u.nolCardTopupUsecase.GetNolCardByID(*rule.NolCardID)
+    if err != nil {
+        return 0, err
+    }
+    return nolCard.Balance, nil
+}
+
+// reloadFromWallet moves the reload amount from the user's wallet onto the
+// NolCard, crediting the wallet back if the card top-up fails.
+func (u *autoReloadUsecaseImpl) reloadFromWallet(rule *models.AutoReloadRule) error {
+    wallet, err := u.walletRepo.GetWalletByUserID(rule.UserID)
+    if err != nil {
+        return fmt.Errorf("wallet not found for user")
+    }
+    if err := u.walletUsecase.MakePayment(wallet.WalletID, rule.ReloadAmount, "auto-reload"); err != nil {
+        return err
+    }
+
+    topup := models.NolCardTopup{NolCardID: *rule.NolCardID, Amount: rule.ReloadAmount}
+    if err := u.nolCardTopupUsecase.AddTopupAndUpdateBalance(topup); err != nil {
+        if refundErr := u.walletUsecase.TopUpWallet(&wallet.WalletID, nil, rule.ReloadAmount, "Auto-reload reversal", "auto-reload-reversal"); refundErr != nil {
+            log.Printf("Auto-reload rule %d: failed to reverse wallet debit: %v", rule.AutoReloadRuleID, refundErr)
+        }
+        return err
+    }
+    return nil
+}
+
+// chargeMandate creates an order and a recurring payment against the saved
+// mandate token. The target is credited once the charge is captured.
+func (u *autoReloadUsecaseImpl) chargeMandate(rule *models.AutoReloadRule, event *models.AutoReloadEvent) error {
+    user, err := u.userRepo.GetUserByID(rule.UserID)
+    if err != nil || user == nil {
+        return fmt.Errorf("user not found")
+    }
+
+    orderID, err := u.paymentUsecase.CreateRazorpayOrder(rule.ReloadAmount, models.BaseCurrency, uint64(rule.UserID))
+    if err != nil {
+        return fmt.Errorf("failed to create order: %v", err)
+    }
+
+    var walletID, nolCardID *uint
+    paymentType := "wallet_topup"
+    if rule.TargetType == models.AutoReloadTargetNolCard {
+        id := uint(*rule.NolCardID)
+        nolCardID = &id
+        paymentType = "nol_card_topup"
+    } else {
+        walletID = rule.WalletID
+    }
+    payment, err := u.paymentUsecase.CreatePayment(rule.UserID, rule.ReloadAmount, models.BaseCurrency, "", paymentType, walletID, nolCardID, nil, nil, orderID)
+    if err != nil {
+        return fmt.Errorf("failed to record payment: %v", err)
+    }
+    event.PaymentID = &payment.PaymentID
+    event.OrderID = orderID
+
+    result, err := u.client.Payment.CreateRecurringPayment(map[string]interface{}{
+        "email":       user.Email,
+        "contact":     user.Phone,
+        "amount":      int(roundAmount(rule.ReloadAmount) * 100),
+        "currency":    models.BaseCurrency,
+        "order_id":    orderID,
+        "customer_id": rule.MandateCustomerID,
+        "token":       rule.MandateTokenID,
+        "recurring":   "1",
+        "description": "Auto-reload",
+    }, nil)
+    if err != nil {
+        return fmt.Errorf("mandate charge failed: %v", err)
+    }
+    if id, ok := result["razorpay_payment_id"].(string); ok {
+        event.RazorpayPaymentID = id
+    }
+    if err := u.autoReloadRepo.UpdateEvent(event); err != nil {
+        log.Printf("Auto-reload event %d: failed to save charge details: %v", event.AutoReloadEventID, err)
+    }
+    return nil
+}
+
+// settlePendingMandateCharges credits the targets of captured mandate charges
+// and fails the ones the gateway rejected or never captured.
+func (u *autoReloadUsecaseImpl) settlePendingMandateCharges() {
+    events, err := u.autoReloadRepo.GetPendingEvents()
+    if err != nil {
+        log.Printf("Error loading pending auto-reloads: %v", err)
+        return
+    }
+
+    for i := range events {
+        event := &events[i]
+        if event.FundingSource != models.AutoReloadFundingMandate {
+            continue
+        }
+        rule, err := u.autoReloadRepo.GetRuleByID(event.AutoReloadRuleID)
+        if err != nil {
+            if errors.Is(err, gorm.ErrRecordNotFound) {
+                event.Status = models.AutoReloadStatusFailed
+                event.Reason = "rule deleted before settlement"
+                _ = u.autoReloadRepo.UpdateEvent(event)
+            }
+            continue
+        }
+
+        status := ""
+        if event.RazorpayPaymentID != "" {
+            payment, err := u.client.Payment.Fetch(event.RazorpayPaymentID, nil, nil)
+            if err != nil {
+                log.Printf("Auto-reload event %d: failed to fetch payment: %v", event.AutoReloadEventID, err)
+                continue
+            }
+            status, _ = payment["status"].(string)
+        }
+
+        switch {
+        case status == "captured":
+            if err := u.paymentUsecase.UpdatePaymentStatus(event.OrderID, event.RazorpayPaymentID, models.PaymentStatusVerified); err != nil {
+                log.Printf("Auto-reload event %d: failed to update payment: %v", event.AutoReloadEventID, err)
+            }
+            u.finishEvent(rule, event, u.creditTarget(rule, event.Amount))
+        case status == "failed":
+            if err := u.paymentUsecase.UpdatePaymentStatus(event.OrderID, event.RazorpayPaymentID, models.PaymentStatusFailed); err != nil {
+                log.Printf("Auto-reload event %d: failed to update payment: %v", event.AutoReloadEventID, err)
+            }
+            u.finishEvent(rule, event, errors.New("mandate charge failed at the gateway"))
+        case time.Since(event.CreatedAt) > MandateSettlementTimeout:
+            u.finishEvent(rule, event, errors.New("mandate charge was not captured in time"))
+        }
+    }
+}
+
+func (u *autoReloadUsecaseImpl) creditTarget(rule *models.AutoReloadRule, amount float64) error {
+    if rule.TargetType == models.AutoReloadTargetWallet {
+        return u.walletUsecase.TopUpWallet(rule.WalletID, nil, amount, "Auto-reload via saved mandate", "auto-reload")
+    }
+    return u.nolCardTopupUsecase.AddTopupAndUpdateBalance(models.NolCardTopup{NolCardID: *rule.NolCardID, Amount: amount})
+}
+
+// finishEvent stores the outcome of a reload, releases the rule and emails the user.
+func (u *autoReloadUsecaseImpl) finishEvent(rule *models.AutoReloadRule, event *models.AutoReloadEvent, reloadErr error) {
+    event.Status = models.AutoReloadStatusSuccess
+    if reloadErr != nil {
+        event.Status = models.AutoReloadStatusFailed
+        event.Reason = reloadErr.Error()
+        log.Printf("Auto-reload rule %d failed: %v", rule.AutoReloadRuleID, reloadErr)
+    }
+    if err := u.autoReloadRepo.UpdateEvent(event); err != nil {
+        log.Printf("Auto-reload event %d: failed to save outcome: %v", event.AutoReloadEventID, err)
+    }
+    u.release(rule.AutoReloadRuleID, reloadErr == nil)
+    u.notify(rule, event)
+}
+
+func (u *autoReloadUsecaseImpl) release(ruleID uint, reloaded bool) {
+    if err := u.autoReloadRepo.ReleaseRule(ruleID, reloaded); err != nil {
+        log.Printf("Auto-reload rule %d: failed to release: %v", ruleID, err)
+    }
+}
+
+func (u *autoReloadUsecaseImpl) notify(rule *models.AutoReloadRule, event *models.AutoReloadEvent) {
+    user, err := u.userRepo.GetUserByID(rule.UserID)
+    if err != nil || user == nil {
+        return
+    }
+
+    target := "wallet"
+    if rule.TargetType == models.AutoReloadTargetNolCard {
+        target = "NolCard"
+    }
+    subject := "Auto-reload successful"
+    body := fmt.Sprintf("Your %s balance fell below %.2f %s and was automatically reloaded with %.2f %s.",
+        target, rule.Threshold, models.BaseCurrency, event.Amount, models.BaseCurrency)
+    if event.Status == models.AutoReloadStatusFailed {
+        subject = "Auto-reload failed"
+        body = fmt.Sprintf("We could not auto-reload your %s with %.2f %s: %s. Please top up manually.",
+            target, event.Amount, models.BaseCurrency, event.Reason)
+    }
+    if err := utils.SendEmail(user.Email, subject, body); err != nil {
+        log.Printf("Failed to send auto-reload notification to user %d: %v", user.ID, err)
+    }
+}
@@ -0,0 +1,253 @@
+package usecase
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "math"
+    "math/big"
+    "strconv"
+    "strings"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+)
+
+const (
+    // MaxCampaignCodesPerRequest caps one generation run.
+    MaxCampaignCodesPerRequest = 50000
+    // DefaultCampaignCodePattern is used when no pattern is given.
+    DefaultCampaignCodePattern = "********"
+)
+
+var ErrCampaignNotFound = errors.New("campaign not found")
+
+// Placeholders in a code pattern; any other character is copied as is.
+const (
+    codePatternDigit   = '#'
+    codePatternLetter  = '?'
+    codePatternAnyChar = '*'
+    codePatternDigits  = "0123456789"
+    codePatternLetters = "ABCDEFGHJKLMNPQRSTUVWXYZ"
+)
+
+type CampaignUsecase interface {
+    CreateCampaign(campaign *models.Campaign) error
+    GetCampaign(campaignID uint) (*models.Campaign, error)
+    GetCampaigns() ([]models.Campaign, error)
+    SetActive(campaignID uint, active bool) error
+    GenerateCodes(campaignID uint, count int, pattern string, template models.Coupon) (int, error)
+    GetCodes(campaignID uint) ([]models.CampaignCode, error)
+    RenderCodesCSV(codes []models.CampaignCode) ([]byte, error)
+    GetStats(campaignID uint) (*models.CampaignStats, error)
+}
+
+type campaignUsecaseImpl struct {
+    campaignRepo repository.CampaignRepository
+}
+
+func NewCampaignUsecase(campaignRepo repository.CampaignRepository) CampaignUsecase {
+    return &campaignUsecaseImpl{campaignRepo: campaignRepo}
+}
+
+func (u *campaignUsecaseImpl) CreateCampaign(campaign *models.Campaign) error {
+    campaign.Name = strings.TrimSpace(campaign.Name)
+    if campaign.Name == "" {
+        return fmt.Errorf("campaign name is required")
+    }
+    if !campaign.EndDate.After(campaign.StartDate) {
+        return fmt.Errorf("end date must be after start date")
+    }
+    campaign.Active = true
+    return u.campaignRepo.CreateCampaign(campaign)
+}
+
+func (u *campaignUsecaseImpl) GetCampaign(campaignID uint) (*models.Campaign, error) {
+    campaign, err := u.campaignRepo.GetCampaignByID(campaignID)
+    if err != nil {
+        return nil, ErrCampaignNotFound
+    }
+    return campaign, nil
+}
+
+func (u *campaignUsecaseImpl) GetCampaigns() ([]models.Campaign, error) {
+    return u.campaignRepo.GetCampaigns()
+}
+
+func (u *campaignUsecaseImpl) SetActive(campaignID uint, active bool) error {
+    if err := u.campaignRepo.SetActive(campaignID, active); err != nil {
+        return ErrCampaignNotFound
+    }
+    return nil
+}
+
+// GenerateCodes creates count single-use coupons for the campaign from
+// pattern, where '#' is a digit, '?' a letter and '*' either. The discount
+// rules are copied from template; its dates come from the campaign.
+func (u *campaignUsecaseImpl) GenerateCodes(campaignID uint, count int, pattern string, template models.Coupon) (int, error) {
+    campaign, err := u.GetCampaign(campaignID)
+    if err != nil {
+        return 0, err
+    }
+    if count <= 0 || count > MaxCampaignCodesPerRequest {
+        return 0, fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidCoupon, MaxCampaignCodesPerRequest)
+    }
+    pattern = strings.ToUpper(strings.TrimSpace(pattern))
+    if pattern == "" {
+        pattern = DefaultCampaignCodePattern
+    }
+    // Keep random collisions rare: the pattern must allow far more codes than requested.
+    if codePatternCombinations(pattern) < float64(count)*1000 {
+        return 0, fmt.Errorf("%w: pattern %q has too few random characters for %d codes", ErrInvalidCoupon, pattern, count)
+    }
+
+    template.StartDate = campaign.StartDate
+    template.EndDate = campaign.EndDate
+    if template.UsageLimit == 0 {
+        template.UsageLimit = 1
+    }
+    if template.PerUserLimit == 0 {
+        template.PerUserLimit = 1
+    }
+    if err := validateCoupon(&template); err != nil {
+        return 0, err
+    }
+
+    // The codes are checked before the insert, but another batch or a manual
+    // coupon can still take one in between; the unique index then rejects the
+    // insert and a fresh set is drawn.
+    for attempt := 0; attempt < 3; attempt++ {
+        codes, err := u.unusedCodes(pattern, count)
+        if err != nil {
+            return 0, err
+        }
+        coupons := make([]models.Coupon, 0, count)
+        for code := range codes {
+            coupon := template
+            coupon.CouponID = 0
+            coupon.Code = code
+            coupon.CampaignID = &campaign.CampaignID
+            coupons = append(coupons, coupon)
+        }
+        err = u.campaignRepo.CreateCoupons(coupons)
+        if errors.Is(err, repository.ErrDuplicateCouponCode) {
+            continue
+        }
+        if err != nil {
+            return 0, err
+        }
+        return len(coupons), nil
+    }
+    return 0, fmt.Errorf("could not generate %d unique codes, try a longer pattern", count)
+}
+
+// unusedCodes draws count distinct codes from pattern that no coupon has yet.
+func (u *campaignUsecaseImpl) unusedCodes(pattern string, count int) (map[string]bool, error) {
+    codes := make(map[string]bool, count)
+    for attempt := 0; attempt < 5 && len(codes) < count; attempt++ {
+        batch := make([]string, 0, count-len(codes))
+        for len(codes)+len(batch) < count {
+            code, err := generateCode(pattern)
+            if err != nil {
+                return nil, err
+            }
+            if !codes[code] {
+                codes[code] = true
+                batch = append(batch, code)
+            }
+        }
+        existing, err := u.campaignRepo.ExistingCodes(batch)
+        if err != nil {
+            return nil, err
+        }
+        for code := range existing {
+            delete(codes, code)
+        }
+    }
+    if len(codes) < count {
+        return nil, fmt.Errorf("could not generate %d unique codes, try a longer pattern", count)
+    }
+    return codes, nil
+}
+
+func (u *campaignUsecaseImpl) GetCodes(campaignID uint) ([]models.CampaignCode, error) {
+    if _, err := u.GetCampaign(campaignID); err != nil {
+        return nil, err
+    }
+    return u.campaignRepo.GetCampaignCodes(campaignID)
+}
+
+func (u *campaignUsecaseImpl) RenderCodesCSV(codes []models.CampaignCode) ([]byte, error) {
+    var buf bytes.Buffer
+    writer := csv.NewWriter(&buf)
+    rows := [][]string{{"Code", "Usage Limit", "Redemptions", "Valid Until"}}
+    for _, code := range codes {
+        rows = append(rows, []string{
+            code.Code,
+            strconv.Itoa(code.UsageLimit),
+            strconv.FormatInt(code.Redemptions, 10),
+            code.EndDate.Format("2006-01-02"),
+        })
+    }
+    if err := writer.WriteAll(rows); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+func (u *campaignUsecaseImpl) GetStats(campaignID uint) (*models.CampaignStats, error) {
+    if _, err := u.GetCampaign(campaignID); err != nil {
+        return nil, err
+    }
+    stats, err := u.campaignRepo.GetCampaignStats(campaignID)
+    if err != nil {
+        return nil, err
+    }
+    stats.DiscountGiven = roundAmount(stats.DiscountGiven)
+    stats.GrossRevenue = roundAmount(stats.GrossRevenue)
+    stats.NetRevenue = roundAmount(stats.NetRevenue)
+    stats.RedemptionRate = math.Round(stats.RedemptionRate*10000) / 10000
+    return stats, nil
+}
+
+// codePatternCombinations is the number of distinct codes pattern can produce.
+func codePatternCombinations(pattern string) float64 {
+    combinations := 1.0
+    for _, r := range pattern {
+        switch r {
+        case codePatternDigit:
+            combinations *= float64(len(codePatternDigits))
+        case codePatternLetter:
+            combinations *= float64(len(codePatternLetters))
+        case codePatternAnyChar:
+            combinations *= float64(len(referralCodeAlphabet))
+        }
+    }
+    return combinations
+}
+
+func generateCode(pattern string) (string, error) {
+    var code strings.Builder
+    for _, r := range pattern {
+        var alphabet string
+        switch r {
+        case codePatternDigit:
+            alphabet = codePatternDigits
+        case codePatternLetter:
+            alphabet = codePatternLetters
+        case codePatternAnyChar:
+            alphabet = referralCodeAlphabet
+        default:
+            code.WriteRune(r)
+            continue
+        }
+        n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+        if err != nil {
+            return "", err
+        }
+        code.WriteByte(alphabet[n.Int64()])
+    }
+    return code.String(), nil
+}
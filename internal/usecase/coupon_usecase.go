@@ -1,16 +1,23 @@
 package usecase
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/Prototype-1/xtrace/internal/models"
 	"github.com/Prototype-1/xtrace/internal/repository"
 )
 
+// ErrInvalidCoupon wraps validation failures on coupon rules.
+var ErrInvalidCoupon = errors.New("invalid coupon")
+
 type CouponUsecase interface {
     CreateCoupon(coupon models.Coupon) error
     UpdateCoupon(coupon models.Coupon) error
     DeleteCoupon(id int) error
     GetCouponByID(id int) (models.Coupon, error)
     GetAllCoupons() ([]models.Coupon, error)
+    GetRedemptions(couponID int) ([]models.CouponRedemption, error)
 }
 
 type CouponUsecaseImpl struct {
@@ -22,13 +29,56 @@ func NewCouponUsecase(repo repository.CouponRepository) CouponUsecase {
 }
 
 func (uc *CouponUsecaseImpl) CreateCoupon(coupon models.Coupon) error {
+    if err := validateCoupon(&coupon); err != nil {
+        return err
+    }
     return uc.repo.CreateCoupon(coupon)
 }
 
 func (uc *CouponUsecaseImpl) UpdateCoupon(coupon models.Coupon) error {
+    if err := validateCoupon(&coupon); err != nil {
+        return err
+    }
+    // A generated code stays with its campaign.
+    if existing, err := uc.repo.GetCouponByID(coupon.CouponID); err == nil {
+        coupon.CampaignID = existing.CampaignID
+    }
     return uc.repo.UpdateCoupon(coupon)
 }
 
+func validateCoupon(coupon *models.Coupon) error {
+    switch coupon.DiscountType {
+    case models.DiscountTypeFixed:
+    case models.DiscountTypePercentage:
+        if coupon.DiscountAmount > 100 {
+            return fmt.Errorf("%w: percentage discount cannot exceed 100", ErrInvalidCoupon)
+        }
+    default:
+        return fmt.Errorf("%w: discount type must be fixed or percentage", ErrInvalidCoupon)
+    }
+    if coupon.DiscountAmount <= 0 {
+        return fmt.Errorf("%w: discount amount must be greater than zero", ErrInvalidCoupon)
+    }
+    if !coupon.EndDate.After(coupon.StartDate) {
+        return fmt.Errorf("%w: end date must be after start date", ErrInvalidCoupon)
+    }
+    if coupon.UsageLimit < 0 || coupon.PerUserLimit < 0 || coupon.MinOrderAmount < 0 || coupon.MaxDiscount < 0 {
+        return fmt.Errorf("%w: limits and amounts cannot be negative", ErrInvalidCoupon)
+    }
+    if coupon.CardType != "" {
+        cardType, ok := models.NormalizeCardType(coupon.CardType)
+        if !ok {
+            return fmt.Errorf("%w: unknown card type %s", ErrInvalidCoupon, coupon.CardType)
+        }
+        coupon.CardType = cardType
+    }
+    return nil
+}
+
+func (uc *CouponUsecaseImpl) GetRedemptions(couponID int) ([]models.CouponRedemption, error) {
+    return uc.repo.GetRedemptions(couponID)
+}
+
 func (uc *CouponUsecaseImpl) DeleteCoupon(id int) error {
     return uc.repo.DeleteCoupon(id)
 }
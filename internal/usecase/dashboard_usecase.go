@@ -0,0 +1,182 @@
+package usecase
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "golang.org/x/sync/singleflight"
+)
+
+// DashboardCacheTTL is how long dashboard figures are served from memory
+// before the database is queried again.
+const DashboardCacheTTL = time.Minute
+
+// ActiveUserDays is how recently a user must have signed in to count as active.
+const ActiveUserDays = 30
+
+// MaxTimeSeriesPoints caps the periods in one time series.
+const MaxTimeSeriesPoints = 400
+
+type DashboardUsecase interface {
+    GetSummary() (*models.DashboardSummary, error)
+    GetTimeSeries(metric, interval string, from, to time.Time) (*models.TimeSeries, error)
+}
+
+type dashboardCacheEntry struct {
+    value     interface{}
+    expiresAt time.Time
+}
+
+type dashboardUsecaseImpl struct {
+    dashboardRepo repository.DashboardRepository
+    loads         singleflight.Group
+    mu            sync.Mutex
+    cache         map[string]dashboardCacheEntry
+}
+
+func NewDashboardUsecase(dashboardRepo repository.DashboardRepository) DashboardUsecase {
+    return &dashboardUsecaseImpl{
+        dashboardRepo: dashboardRepo,
+        cache:         make(map[string]dashboardCacheEntry),
+    }
+}
+
+// cached returns the value stored under key, loading it when missing or
+// expired. Concurrent requests for the same key share one load, and the lock
+// is only held to read and swap the cached value, so a slow load does not
+// hold up requests for other figures.
+func (u *dashboardUsecaseImpl) cached(key string, load func(now time.Time) (interface{}, error)) (interface{}, error) {
+    u.mu.Lock()
+    entry, ok := u.cache[key]
+    u.mu.Unlock()
+    if ok && time.Now().Before(entry.expiresAt) {
+        return entry.value, nil
+    }
+
+    value, err, _ := u.loads.Do(key, func() (interface{}, error) {
+        now := time.Now()
+        value, err := load(now)
+        if err != nil {
+            return nil, err
+        }
+        u.mu.Lock()
+        defer u.mu.Unlock()
+        for k, entry := range u.cache {
+            if !now.Before(entry.expiresAt) {
+                delete(u.cache, k)
+            }
+        }
+        u.cache[key] = dashboardCacheEntry{value: value, expiresAt: now.Add(DashboardCacheTTL)}
+        return value, nil
+    })
+    return value, err
+}
+
+func (u *dashboardUsecaseImpl) GetSummary() (*models.DashboardSummary, error) {
+    value, err := u.cached("summary", func(now time.Time) (interface{}, error) {
+        summary, err := u.dashboardRepo.GetSummary(now, now.AddDate(0, 0, -ActiveUserDays))
+        if err != nil {
+            return nil, err
+        }
+        summary.WalletFloat = roundAmount(summary.WalletFloat)
+        summary.NolCardFloat = roundAmount(summary.NolCardFloat)
+        summary.OpenRefundAmount = roundAmount(summary.OpenRefundAmount)
+        return summary, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return value.(*models.DashboardSummary), nil
+}
+
+// periodStart returns the start of the interval containing t.
+func periodStart(t time.Time, interval string) time.Time {
+    day := startOfDay(t)
+    switch interval {
+    case models.IntervalWeek:
+        return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+    case models.IntervalMonth:
+        return day.AddDate(0, 0, 1-day.Day())
+    }
+    return day
+}
+
+func nextPeriod(t time.Time, interval string) time.Time {
+    switch interval {
+    case models.IntervalWeek:
+        return t.AddDate(0, 0, 7)
+    case models.IntervalMonth:
+        return t.AddDate(0, 1, 0)
+    }
+    return t.AddDate(0, 0, 1)
+}
+
+// GetTimeSeries returns one point per period in [from, to), with zero for
+// periods that had nothing in them. A to part-way through a day is taken as
+// the end of that day, so "so far" ranges share a cache entry.
+func (u *dashboardUsecaseImpl) GetTimeSeries(metric, interval string, from, to time.Time) (*models.TimeSeries, error) {
+    valid := false
+    for _, m := range models.DashboardMetrics {
+        if metric == m {
+            valid = true
+            break
+        }
+    }
+    if !valid {
+        return nil, fmt.Errorf("metric must be one of %s", strings.Join(models.DashboardMetrics, ", "))
+    }
+    if interval != models.IntervalDay && interval != models.IntervalWeek && interval != models.IntervalMonth {
+        return nil, errors.New("interval must be day, week or month")
+    }
+    if !to.After(from) {
+        return nil, errors.New("to must be after from")
+    }
+    if day := startOfDay(to); !day.Equal(to) {
+        to = day.AddDate(0, 0, 1)
+    }
+    var periods []time.Time
+    for start := periodStart(from, interval); start.Before(to); start = nextPeriod(start, interval) {
+        if len(periods) == MaxTimeSeriesPoints {
+            return nil, fmt.Errorf("time series cannot exceed %d points; use a longer interval", MaxTimeSeriesPoints)
+        }
+        periods = append(periods, start)
+    }
+
+    key := fmt.Sprintf("series:%s:%s:%d:%d", metric, interval, from.Unix(), to.Unix())
+    value, err := u.cached(key, func(now time.Time) (interface{}, error) {
+        points, err := u.dashboardRepo.GetTimeSeries(metric, interval, from, to)
+        if err != nil {
+            return nil, err
+        }
+        values := make(map[string]float64, len(points))
+        for _, point := range points {
+            values[point.Period] = point.Value
+        }
+        layout := "2006-01-02"
+        if interval == models.IntervalMonth {
+            layout = "2006-01"
+        }
+        series := &models.TimeSeries{
+            Metric:      metric,
+            Interval:    interval,
+            From:        from,
+            To:          to,
+            Points:      make([]models.TimeSeriesPoint, 0, len(periods)),
+            GeneratedAt: now,
+        }
+        for _, start := range periods {
+            period := start.Format(layout)
+            series.Points = append(series.Points, models.TimeSeriesPoint{Period: period, Value: roundAmount(values[period])})
+        }
+        return series, nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return value.(*models.TimeSeries), nil
+}
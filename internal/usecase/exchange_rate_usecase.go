@@ -0,0 +1,119 @@
+package usecase
+
+import (
+    "errors"
+    "fmt"
+    "math"
+    "strings"
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+)
+
+type ExchangeRateUsecase interface {
+    CreateExchangeRate(currency string, rateToBase float64) (*models.ExchangeRate, error)
+    UpdateExchangeRate(id uint, currency string, rateToBase float64) error
+    DeleteExchangeRate(id uint) error
+    GetAllExchangeRates() ([]models.ExchangeRate, error)
+    GetRate(currency string) (float64, error)
+    ConvertToBase(amount float64, currency string) (float64, float64, error)
+    ConvertFromBase(amount float64, currency string) (float64, float64, error)
+}
+
+type exchangeRateUsecaseImpl struct {
+    repo repository.ExchangeRateRepository
+}
+
+func NewExchangeRateUsecase(repo repository.ExchangeRateRepository) ExchangeRateUsecase {
+    return &exchangeRateUsecaseImpl{repo: repo}
+}
+
+func (u *exchangeRateUsecaseImpl) CreateExchangeRate(currency string, rateToBase float64) (*models.ExchangeRate, error) {
+    currency, err := validateExchangeRate(currency, rateToBase)
+    if err != nil {
+        return nil, err
+    }
+    existing, err := u.repo.GetExchangeRateByCurrency(currency)
+    if err != nil {
+        return nil, err
+    }
+    if existing != nil {
+        return nil, fmt.Errorf("an exchange rate for %s already exists", currency)
+    }
+    rate := &models.ExchangeRate{Currency: currency, RateToBase: rateToBase}
+    if err := u.repo.CreateExchangeRate(rate); err != nil {
+        return nil, err
+    }
+    return rate, nil
+}
+
+func (u *exchangeRateUsecaseImpl) UpdateExchangeRate(id uint, currency string, rateToBase float64) error {
+    currency, err := validateExchangeRate(currency, rateToBase)
+    if err != nil {
+        return err
+    }
+    return u.repo.UpdateExchangeRate(&models.ExchangeRate{ExchangeRateID: id, Currency: currency, RateToBase: rateToBase})
+}
+
+func (u *exchangeRateUsecaseImpl) DeleteExchangeRate(id uint) error {
+    return u.repo.DeleteExchangeRate(id)
+}
+
+func (u *exchangeRateUsecaseImpl) GetAllExchangeRates() ([]models.ExchangeRate, error) {
+    return u.repo.GetAllExchangeRates()
+}
+
+func (u *exchangeRateUsecaseImpl) GetRate(currency string) (float64, error) {
+    return lookupExchangeRate(u.repo, currency)
+}
+
+// ConvertToBase converts an amount in currency to BaseCurrency and returns the rate used.
+func (u *exchangeRateUsecaseImpl) ConvertToBase(amount float64, currency string) (float64, float64, error) {
+    rate, err := lookupExchangeRate(u.repo, currency)
+    if err != nil {
+        return 0, 0, err
+    }
+    return roundAmount(amount * rate), rate, nil
+}
+
+// ConvertFromBase converts a BaseCurrency amount to currency and returns the rate used.
+func (u *exchangeRateUsecaseImpl) ConvertFromBase(amount float64, currency string) (float64, float64, error) {
+    rate, err := lookupExchangeRate(u.repo, currency)
+    if err != nil {
+        return 0, 0, err
+    }
+    return roundAmount(amount / rate), rate, nil
+}
+
+func validateExchangeRate(currency string, rateToBase float64) (string, error) {
+    currency = strings.ToUpper(strings.TrimSpace(currency))
+    if len(currency) != 3 {
+        return "", errors.New("currency must be a 3-letter ISO code")
+    }
+    if currency == models.BaseCurrency {
+        return "", fmt.Errorf("%s is the base currency and has a fixed rate of 1", models.BaseCurrency)
+    }
+    if rateToBase <= 0 {
+        return "", errors.New("rate must be greater than zero")
+    }
+    return currency, nil
+}
+
+// lookupExchangeRate is shared by the usecases that need to price in a foreign currency.
+func lookupExchangeRate(repo repository.ExchangeRateRepository, currency string) (float64, error) {
+    currency = strings.ToUpper(strings.TrimSpace(currency))
+    if currency == "" || currency == models.BaseCurrency {
+        return 1, nil
+    }
+    rate, err := repo.GetExchangeRateByCurrency(currency)
+    if err != nil {
+        return 0, err
+    }
+    if rate == nil {
+        return 0, fmt.Errorf("currency %s is not supported", currency)
+    }
+    return rate.RateToBase, nil
+}
+
+func roundAmount(amount float64) float64 {
+    return math.Round(amount*100) / 100
+}
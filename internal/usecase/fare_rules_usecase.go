@@ -1,12 +1,20 @@
 package usecase
 
 import (
+    "errors"
+    "math"
     "github.com/Prototype-1/xtrace/internal/models"
     "github.com/Prototype-1/xtrace/internal/domain"
     "github.com/Prototype-1/xtrace/internal/repository"
     "fmt"
 )
 
+var (
+    ErrFareRuleNotFound = errors.New("fare rule not found")
+    ErrStopNotFound     = errors.New("stop not found")
+    ErrInvalidCardType  = errors.New("invalid card type")
+)
+
 type FareRuleUsecase interface {
     CreateFareRule(fareRule models.FareRule) error
     UpdateFareRule(fareRule models.FareRule) error
@@ -14,6 +22,7 @@ type FareRuleUsecase interface {
     GetFareRuleByID(id int) (models.FareRule, error)
     GetAllFareRules() ([]models.FareRule, error)
     GetFareRuleByRouteID(routeID int) (models.FareRule, error)
+    CalculateFare(routeID, startStopID, endStopID int, cardType string) (float64, error)
     GetTravelTimeByCoordinates(fromLat, fromLon, toLat, toLon float64) (int, error) 
     CreateStopDuration(stopDuration models.StopDuration) error
     UpdateStopDuration(stopDuration models.StopDuration) error
@@ -57,6 +66,76 @@ func (u *FareRuleUsecaseImpl) GetFareRuleByRouteID(routeID int) (models.FareRule
     return u.repo.GetFareRuleByRouteID(routeID)
 }
 
+// CalculateFare prices a journey between two stops on a route for a card type:
+// the card's base fare plus distance and stop supplements beyond the rule's
+// base allowance, never below the ordinary fare.
+func (u *FareRuleUsecaseImpl) CalculateFare(routeID, startStopID, endStopID int, cardType string) (float64, error) {
+    fareRule, err := u.repo.GetFareRuleByRouteID(routeID)
+    if err != nil {
+        return 0, ErrFareRuleNotFound
+    }
+
+    startStop, err := u.repo.GetStopByID(startStopID)
+    if err != nil {
+        return 0, fmt.Errorf("start %w", ErrStopNotFound)
+    }
+    endStop, err := u.repo.GetStopByID(endStopID)
+    if err != nil {
+        return 0, fmt.Errorf("end %w", ErrStopNotFound)
+    }
+    traveledKm := Haversine(startStop.Latitude, startStop.Longitude, endStop.Latitude, endStop.Longitude)
+
+    numberOfStops := int(math.Abs(float64(endStopID - startStopID)))
+    additionalStops := numberOfStops - fareRule.BaseStops
+
+    var baseFare float64
+    switch cardType {
+    case models.CardTypeOrdinary:
+        baseFare = fareRule.OrdinaryFare
+    case models.CardTypeSilver:
+        baseFare = fareRule.SilverFare
+    case models.CardTypeGold:
+        baseFare = fareRule.GoldFare
+    default:
+        return 0, ErrInvalidCardType
+    }
+
+    totalFare := baseFare
+
+    additionalKm := traveledKm - fareRule.BaseKm
+    if additionalKm > 0 {
+        totalFare += additionalKm * fareRule.FarePerKm
+    }
+    if additionalStops > 0 {
+        totalFare += float64(additionalStops) * fareRule.FarePerStop
+    }
+    if totalFare < fareRule.OrdinaryFare {
+        totalFare = fareRule.OrdinaryFare
+    }
+    return totalFare, nil
+}
+
+//Haversine equation
+func Haversine(lat1, lon1, lat2, lon2 float64) float64 {
+    const R = 6371 
+    lat1Rad := lat1 * math.Pi / 180
+    lon1Rad := lon1 * math.Pi / 180
+    lat2Rad := lat2 * math.Pi / 180
+    lon2Rad := lon2 * math.Pi / 180
+
+    dlat := lat2Rad - lat1Rad
+    dlon := lon2Rad - lon1Rad
+
+    a := math.Sin(dlat/2)*math.Sin(dlat/2) +
+        math.Cos(lat1Rad)*math.Cos(lat2Rad)*
+            math.Sin(dlon/2)*math.Sin(dlon/2)
+
+    c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+
+    distance := R * c
+    return distance
+}
+
 func (u *FareRuleUsecaseImpl) GetTravelTimeByCoordinates(fromLat, fromLon, toLat, toLon float64) (int, error) {
     duration, _, err := u.osrmService.GetTravelTime(fromLat, fromLon, toLat, toLon)
     if err != nil {
@@ -1,54 +1,653 @@
 package usecase
 
 import (
+    "bytes"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "strings"
+    "time"
+
     "github.com/Prototype-1/xtrace/internal/models"
     "github.com/Prototype-1/xtrace/internal/repository"
-    "time"
-	"fmt"
-	"log"
+    "github.com/Prototype-1/xtrace/pkg/utils"
+    "github.com/jung-kurt/gofpdf"
+    "gorm.io/gorm"
+)
+
+// MaxGSTRate is the highest GST slab a tax rule may use.
+const MaxGSTRate = 28
+
+// Invoice list page sizes.
+const (
+    DefaultInvoicePageSize = 20
+    MaxInvoicePageSize     = 100
 )
 
+var ErrInvoiceNotFound = errors.New("invoice not found")
+
 type InvoiceUsecase interface {
-    CreateInvoice(userID uint, paymentID uint, amount float64, paymentType string, discountedAmount float64) (*models.Invoice, error)
+    // CreateDraftInvoice records the proforma for a checkout order. It returns
+    // the payment's existing invoice if one was already created.
+    CreateDraftInvoice(userID uint, paymentID uint, amount float64, paymentType string, discountedAmount float64, currency string, exchangeRate float64) (*models.Invoice, error)
+    // FinalizeForPayment numbers the payment's draft and marks it paid once
+    // the capture is confirmed.
+    FinalizeForPayment(paymentID uint) (*models.Invoice, error)
+    VoidForOrder(orderID string) error
+    VoidStaleDrafts(createdBefore time.Time) (int64, error)
+    GetTaxSummary(from, to time.Time) (*models.InvoiceTaxSummary, error)
+    // PrepareInvoice fills in seller and buyer details and the GST split.
+    // Without lines the invoice gets one line for its payment type.
+    PrepareInvoice(invoice *models.Invoice, lines []models.InvoiceLine) error
+    GetInvoice(invoiceID uint) (*models.Invoice, error)
+    RenderPDF(invoice *models.Invoice) ([]byte, error)
+    // EmailInvoice sends the invoice PDF as an attachment.
+    EmailInvoice(invoice *models.Invoice, to, subject, body string) error
+
+    // User operations; documents of other users are reported as not found.
+    GetUserInvoices(userID uint, page, pageSize int) ([]models.Invoice, int64, error)
+    GetUserInvoice(userID, invoiceID uint) (*models.Invoice, error)
+    ResendInvoice(userID, invoiceID uint) error
+
+    // IssueCreditNote credits amount back against an invoice, splitting it
+    // across the invoice's lines at their original GST rates.
+    IssueCreditNote(invoiceID uint, amount float64, reason string) (*models.Invoice, error)
+    // IssueSubscriptionCreditNote credits a refund against the subscription's latest invoice.
+    IssueSubscriptionCreditNote(subscriptionID uint, amount float64, reason string) (*models.Invoice, error)
+    // IssueSubscriptionWalletCreditNote credits a wallet refund, in the base
+    // currency, against the subscription's latest invoice.
+    IssueSubscriptionWalletCreditNote(subscriptionID uint, amount float64, reason string) (*models.Invoice, error)
+    // IssuePaymentCreditNote credits a gateway refund, in the payment's
+    // currency, against the payment's invoice.
+    IssuePaymentCreditNote(paymentID uint, amount float64, reason string) (*models.Invoice, error)
+    // IssueNolCardCreditNote credits a refund to the card, in the base
+    // currency, against the card's latest top-up invoice.
+    IssueNolCardCreditNote(nolCardID int, amount float64, reason string) (*models.Invoice, error)
+
+    // Tax rules
+    EnsureDefaultTaxRules() error
+    GetTaxRules() ([]models.TaxRule, error)
+    SetTaxRule(rule *models.TaxRule) error
+}
+
+// invoiceSeller is the issuing business, read from the environment once.
+type invoiceSeller struct {
+    name      string
+    gstin     string
+    address   string
+    stateCode string
 }
 
 type invoiceUsecaseImpl struct {
     invoiceRepo repository.InvoiceRepository
+    userRepo    repository.UserRepository
+    seller      invoiceSeller
 }
 
-func NewInvoiceUsecase(invoiceRepo repository.InvoiceRepository) InvoiceUsecase {
+func NewInvoiceUsecase(invoiceRepo repository.InvoiceRepository, userRepo repository.UserRepository) InvoiceUsecase {
+    seller := invoiceSeller{
+        name:      os.Getenv("INVOICE_SELLER_NAME"),
+        gstin:     strings.ToUpper(strings.TrimSpace(os.Getenv("INVOICE_SELLER_GSTIN"))),
+        address:   os.Getenv("INVOICE_SELLER_ADDRESS"),
+        stateCode: os.Getenv("INVOICE_SELLER_STATE_CODE"),
+    }
+    if seller.name == "" {
+        seller.name = "XTrace"
+    }
+    if seller.gstin != "" {
+        if err := utils.ValidateGSTIN(seller.gstin); err != nil {
+            log.Printf("INVOICE_SELLER_GSTIN %q is not a valid GSTIN", seller.gstin)
+        }
+        if seller.stateCode == "" {
+            seller.stateCode = utils.GSTINStateCode(seller.gstin)
+        }
+    }
     return &invoiceUsecaseImpl{
         invoiceRepo: invoiceRepo,
+        userRepo:    userRepo,
+        seller:      seller,
     }
 }
 
-func (u *invoiceUsecaseImpl) CreateInvoice(userID uint, paymentID uint, amount float64, paymentType string, discountedAmount float64) (*models.Invoice, error) {
+// CreateDraftInvoice records amounts in the checkout currency along with their base-currency settlement.
+func (u *invoiceUsecaseImpl) CreateDraftInvoice(userID uint, paymentID uint, amount float64, paymentType string, discountedAmount float64, currency string, exchangeRate float64) (*models.Invoice, error) {
     if amount <= 0 {
         return nil, fmt.Errorf("invalid amount: must be greater than 0")
     }
     if paymentType == "" {
         return nil, fmt.Errorf("payment type is required")
     }
+    if currency == "" {
+        currency = models.BaseCurrency
+    }
+    if exchangeRate <= 0 {
+        exchangeRate = 1
+    }
+    if paymentID != 0 {
+        if existing, err := u.invoiceRepo.GetInvoiceByPaymentID(paymentID); err == nil {
+            return existing, nil
+        }
+    }
 
     invoice := &models.Invoice{
         UserID:         userID,
-        PaymentID:      paymentID, 
+        PaymentID:      paymentID,
         OriginalAmount: amount,
         DiscountAmount: discountedAmount,
         Amount:         amount - discountedAmount,
+        Currency:        currency,
+        SettledAmount:   roundAmount((amount - discountedAmount) * exchangeRate),
+        SettledCurrency: models.BaseCurrency,
+        ExchangeRate:    exchangeRate,
         PaymentType:    paymentType,
-        Status:         "Paid", 
         InvoiceDate:    time.Now(),
     }
+    if err := u.PrepareInvoice(invoice, nil); err != nil {
+        return nil, err
+    }
 
-    createdInvoice, err := u.invoiceRepo.Create(invoice)
-    if err != nil {
+    if err := u.invoiceRepo.CreateDraft(invoice); err != nil {
         log.Printf("Failed to create invoice: %v, Invoice: %+v", err, invoice)
         return nil, err
     }
 
-    return createdInvoice, nil
+    return invoice, nil
+}
+
+func (u *invoiceUsecaseImpl) FinalizeForPayment(paymentID uint) (*models.Invoice, error) {
+    invoice, err := u.invoiceRepo.FinalizeForPayment(paymentID)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, ErrInvoiceNotFound
+    }
+    return invoice, err
+}
+
+func (u *invoiceUsecaseImpl) VoidForOrder(orderID string) error {
+    return u.invoiceRepo.VoidForOrder(orderID)
+}
+
+func (u *invoiceUsecaseImpl) VoidStaleDrafts(createdBefore time.Time) (int64, error) {
+    return u.invoiceRepo.VoidStaleDrafts(createdBefore)
+}
+
+func (u *invoiceUsecaseImpl) GetTaxSummary(from, to time.Time) (*models.InvoiceTaxSummary, error) {
+    if !to.After(from) {
+        return nil, fmt.Errorf("to must be after from")
+    }
+    summary, err := u.invoiceRepo.GetTaxSummary(from, to)
+    if err != nil {
+        return nil, err
+    }
+    summary.TaxableAmount = roundAmount(summary.TaxableAmount)
+    summary.CGSTAmount = roundAmount(summary.CGSTAmount)
+    summary.SGSTAmount = roundAmount(summary.SGSTAmount)
+    summary.IGSTAmount = roundAmount(summary.IGSTAmount)
+    summary.TaxAmount = roundAmount(summary.TaxAmount)
+    summary.TotalAmount = roundAmount(summary.TotalAmount)
+    return summary, nil
+}
+
+func (u *invoiceUsecaseImpl) PrepareInvoice(invoice *models.Invoice, lines []models.InvoiceLine) error {
+    invoice.SellerName = u.seller.name
+    invoice.SellerGSTIN = u.seller.gstin
+    invoice.SellerAddress = u.seller.address
+    invoice.SellerStateCode = u.seller.stateCode
+
+    // Organisation invoices arrive with the buyer filled in.
+    if invoice.BuyerName == "" && invoice.UserID != 0 {
+        if user, err := u.userRepo.GetUserByID(invoice.UserID); err == nil && user != nil {
+            invoice.BuyerName = strings.TrimSpace(user.FirstName + " " + user.LastName)
+            invoice.BuyerEmail = user.Email
+        }
+    }
+    if invoice.BuyerStateCode == "" && invoice.BuyerGSTIN != "" {
+        invoice.BuyerStateCode = utils.GSTINStateCode(invoice.BuyerGSTIN)
+    }
+
+    if len(lines) == 0 {
+        lines = []models.InvoiceLine{{
+            ItemType:  invoice.PaymentType,
+            Quantity:  1,
+            UnitPrice: invoice.OriginalAmount,
+            Discount:  invoice.DiscountAmount,
+        }}
+    }
+    intraState := invoice.BuyerStateCode == "" || invoice.SellerStateCode == "" || invoice.BuyerStateCode == invoice.SellerStateCode
+
+    for i := range lines {
+        line := &lines[i]
+        if line.Quantity <= 0 {
+            line.Quantity = 1
+        }
+        line.Total = roundAmount(line.UnitPrice*float64(line.Quantity) - line.Discount)
+        if line.Total < 0 {
+            return fmt.Errorf("invoice line %q has a negative total", line.ItemType)
+        }
+        // A type without a rule is billed without GST.
+        if rule, err := u.invoiceRepo.GetTaxRule(line.ItemType); err == nil {
+            line.GSTRate = rule.GSTRate
+            line.HSNSAC = rule.HSNSAC
+            if line.Description == "" {
+                line.Description = rule.Description
+            }
+        }
+        if line.Description == "" {
+            line.Description = line.ItemType
+        }
+        applyLineTax(line, intraState)
+    }
+    setInvoiceLines(invoice, lines)
+    return nil
+}
+
+// applyLineTax carves GST out of the line total, which includes it.
+func applyLineTax(line *models.InvoiceLine, intraState bool) {
+    line.TaxableAmount = roundAmount(line.Total / (1 + line.GSTRate/100))
+    tax := roundAmount(line.Total - line.TaxableAmount)
+    line.CGSTAmount, line.SGSTAmount, line.IGSTAmount = 0, 0, 0
+    if intraState {
+        line.CGSTAmount = roundAmount(tax / 2)
+        line.SGSTAmount = roundAmount(tax - line.CGSTAmount)
+    } else {
+        line.IGSTAmount = tax
+    }
+}
+
+// setInvoiceLines attaches lines and totals their tax onto the invoice.
+func setInvoiceLines(invoice *models.Invoice, lines []models.InvoiceLine) {
+    invoice.TaxableAmount, invoice.CGSTAmount, invoice.SGSTAmount, invoice.IGSTAmount = 0, 0, 0, 0
+    for _, line := range lines {
+        invoice.TaxableAmount += line.TaxableAmount
+        invoice.CGSTAmount += line.CGSTAmount
+        invoice.SGSTAmount += line.SGSTAmount
+        invoice.IGSTAmount += line.IGSTAmount
+    }
+    invoice.TaxableAmount = roundAmount(invoice.TaxableAmount)
+    invoice.CGSTAmount = roundAmount(invoice.CGSTAmount)
+    invoice.SGSTAmount = roundAmount(invoice.SGSTAmount)
+    invoice.IGSTAmount = roundAmount(invoice.IGSTAmount)
+    invoice.TaxAmount = roundAmount(invoice.CGSTAmount + invoice.SGSTAmount + invoice.IGSTAmount)
+    invoice.Lines = lines
+}
+
+func (u *invoiceUsecaseImpl) GetInvoice(invoiceID uint) (*models.Invoice, error) {
+    invoice, err := u.invoiceRepo.GetInvoiceByID(invoiceID)
+    if err != nil {
+        return nil, ErrInvoiceNotFound
+    }
+    return invoice, nil
+}
+
+func (u *invoiceUsecaseImpl) GetUserInvoices(userID uint, page, pageSize int) ([]models.Invoice, int64, error) {
+    if page < 1 {
+        page = 1
+    }
+    if pageSize < 1 {
+        pageSize = DefaultInvoicePageSize
+    }
+    if pageSize > MaxInvoicePageSize {
+        pageSize = MaxInvoicePageSize
+    }
+    return u.invoiceRepo.GetInvoicesByUserID(userID, (page-1)*pageSize, pageSize)
+}
+
+func (u *invoiceUsecaseImpl) GetUserInvoice(userID, invoiceID uint) (*models.Invoice, error) {
+    invoice, err := u.GetInvoice(invoiceID)
+    if err != nil {
+        return nil, err
+    }
+    if invoice.UserID != userID {
+        return nil, ErrInvoiceNotFound
+    }
+    return invoice, nil
+}
+
+// ResendInvoice emails the document to the user's registered address.
+func (u *invoiceUsecaseImpl) ResendInvoice(userID, invoiceID uint) error {
+    invoice, err := u.GetUserInvoice(userID, invoiceID)
+    if err != nil {
+        return err
+    }
+    user, err := u.userRepo.GetUserByID(userID)
+    if err != nil || user == nil || user.Email == "" {
+        return fmt.Errorf("no email address on the account")
+    }
+    title := "invoice"
+    if invoice.DocumentType == models.DocumentTypeCreditNote {
+        title = "credit note"
+    }
+    subject := fmt.Sprintf("Your %s %s", title, invoiceNumber(invoice))
+    body := fmt.Sprintf("Dear %s,\n\nPlease find attached your %s %s dated %s.\n",
+        user.FirstName, title, invoiceNumber(invoice), invoice.InvoiceDate.Format("02 Jan 2006"))
+    return u.EmailInvoice(invoice, user.Email, subject, body)
 }
 
+func (u *invoiceUsecaseImpl) IssueCreditNote(invoiceID uint, amount float64, reason string) (*models.Invoice, error) {
+    original, err := u.GetInvoice(invoiceID)
+    if err != nil {
+        return nil, err
+    }
+    if original.DocumentType == models.DocumentTypeCreditNote {
+        return nil, fmt.Errorf("a credit note cannot be credited")
+    }
+    amount = roundAmount(amount)
+    if amount <= 0 {
+        return nil, fmt.Errorf("credit amount must be greater than zero")
+    }
+    if amount > original.Amount {
+        return nil, repository.ErrCreditExceedsInvoice
+    }
 
+    note := &models.Invoice{
+        DocumentType:      models.DocumentTypeCreditNote,
+        OriginalInvoiceID: &original.InvoiceID,
+        Reason:            strings.TrimSpace(reason),
+        UserID:            original.UserID,
+        PaymentID:         original.PaymentID,
+        InvoiceDate:       time.Now(),
+        OriginalAmount:    amount,
+        Amount:            amount,
+        Currency:          original.Currency,
+        SettledAmount:     roundAmount(amount * original.ExchangeRate),
+        SettledCurrency:   original.SettledCurrency,
+        ExchangeRate:      original.ExchangeRate,
+        Status:            models.InvoiceStatusIssued,
+        PaymentType:       original.PaymentType,
+        SellerName:        original.SellerName,
+        SellerGSTIN:       original.SellerGSTIN,
+        SellerAddress:     original.SellerAddress,
+        SellerStateCode:   original.SellerStateCode,
+        BuyerName:         original.BuyerName,
+        BuyerEmail:        original.BuyerEmail,
+        BuyerGSTIN:        original.BuyerGSTIN,
+        BuyerAddress:      original.BuyerAddress,
+        BuyerStateCode:    original.BuyerStateCode,
+    }
 
+    // Invoices from before line items get one untaxed line.
+    originalLines := original.Lines
+    if len(originalLines) == 0 {
+        originalLines = []models.InvoiceLine{{ItemType: original.PaymentType, Description: original.PaymentType, Total: original.Amount}}
+    }
+    intraState := original.IGSTAmount == 0
+    lines := make([]models.InvoiceLine, 0, len(originalLines))
+    remaining := amount
+    for i, originalLine := range originalLines {
+        share := remaining
+        if i < len(originalLines)-1 && original.Amount > 0 {
+            share = roundAmount(amount * originalLine.Total / original.Amount)
+        }
+        remaining = roundAmount(remaining - share)
+        line := models.InvoiceLine{
+            ItemType:    originalLine.ItemType,
+            Description: originalLine.Description,
+            HSNSAC:      originalLine.HSNSAC,
+            Quantity:    1,
+            UnitPrice:   share,
+            GSTRate:     originalLine.GSTRate,
+            Total:       share,
+        }
+        applyLineTax(&line, intraState)
+        lines = append(lines, line)
+    }
+    setInvoiceLines(note, lines)
+
+    if err := u.invoiceRepo.CreateCreditNote(note); err != nil {
+        return nil, err
+    }
+    return note, nil
+}
+
+func (u *invoiceUsecaseImpl) IssueSubscriptionCreditNote(subscriptionID uint, amount float64, reason string) (*models.Invoice, error) {
+    invoice, err := u.invoiceRepo.GetLatestSubscriptionInvoice(subscriptionID)
+    if err != nil {
+        return nil, ErrInvoiceNotFound
+    }
+    return u.IssueCreditNote(invoice.InvoiceID, amount, reason)
+}
+
+func (u *invoiceUsecaseImpl) IssueSubscriptionWalletCreditNote(subscriptionID uint, amount float64, reason string) (*models.Invoice, error) {
+    invoice, err := u.invoiceRepo.GetLatestSubscriptionInvoice(subscriptionID)
+    if err != nil {
+        return nil, ErrInvoiceNotFound
+    }
+    return u.IssueCreditNote(invoice.InvoiceID, fromBaseAmount(invoice, amount), reason)
+}
+
+// IssuePaymentCreditNote reports ErrInvoiceNotFound when the payment has no
+// finalised invoice, e.g. a payment refunded because verification failed.
+func (u *invoiceUsecaseImpl) IssuePaymentCreditNote(paymentID uint, amount float64, reason string) (*models.Invoice, error) {
+    invoice, err := u.invoiceRepo.GetInvoiceByPaymentID(paymentID)
+    if err != nil || (invoice.Status != models.InvoiceStatusPaid && invoice.Status != models.InvoiceStatusDue) {
+        return nil, ErrInvoiceNotFound
+    }
+    return u.IssueCreditNote(invoice.InvoiceID, amount, reason)
+}
+
+func (u *invoiceUsecaseImpl) IssueNolCardCreditNote(nolCardID int, amount float64, reason string) (*models.Invoice, error) {
+    invoice, err := u.invoiceRepo.GetLatestNolCardInvoice(nolCardID)
+    if err != nil {
+        return nil, ErrInvoiceNotFound
+    }
+    return u.IssueCreditNote(invoice.InvoiceID, fromBaseAmount(invoice, amount), reason)
+}
+
+// fromBaseAmount converts a base-currency amount into the invoice's currency
+// at the rate the invoice was raised at.
+func fromBaseAmount(invoice *models.Invoice, amount float64) float64 {
+    if invoice.ExchangeRate <= 0 {
+        return amount
+    }
+    return roundAmount(amount / invoice.ExchangeRate)
+}
+
+// invoiceNumber is the document's number, or its ID for invoices issued
+// before numbering existed.
+func invoiceNumber(invoice *models.Invoice) string {
+    if invoice.InvoiceNumber != nil {
+        return *invoice.InvoiceNumber
+    }
+    return fmt.Sprintf("%d", invoice.InvoiceID)
+}
+
+// RenderPDF lays the invoice out as a GST tax invoice and returns the document bytes.
+func (u *invoiceUsecaseImpl) RenderPDF(invoice *models.Invoice) ([]byte, error) {
+    pdf := gofpdf.New("P", "mm", "A4", "")
+    pdf.SetMargins(15, 15, 15)
+    pdf.AddPage()
+
+    title := "TAX INVOICE"
+    switch {
+    case invoice.DocumentType == models.DocumentTypeCreditNote:
+        title = "CREDIT NOTE"
+    case invoice.Status == models.InvoiceStatusDraft:
+        title = "PROFORMA INVOICE"
+    case invoice.Status == models.InvoiceStatusVoid:
+        title = "VOID - NOT A TAX INVOICE"
+    }
+    pdf.SetFont("Arial", "B", 18)
+    pdf.CellFormat(0, 10, title, "", 1, "C", false, 0, "")
+    pdf.Ln(2)
+
+    number := invoiceNumber(invoice)
+    placeOfSupply := invoice.BuyerStateCode
+    if placeOfSupply == "" {
+        placeOfSupply = invoice.SellerStateCode
+    }
+
+    // Seller on the left, invoice details on the right.
+    top := pdf.GetY()
+    pdf.SetFont("Arial", "B", 11)
+    pdf.CellFormat(100, 6, invoice.SellerName, "", 2, "L", false, 0, "")
+    pdf.SetFont("Arial", "", 9)
+    if invoice.SellerAddress != "" {
+        pdf.MultiCell(100, 5, invoice.SellerAddress, "", "L", false)
+    }
+    if invoice.SellerGSTIN != "" {
+        pdf.CellFormat(100, 5, "GSTIN: "+invoice.SellerGSTIN, "", 2, "L", false, 0, "")
+    }
+    sellerBottom := pdf.GetY()
+
+    pdf.SetXY(120, top)
+    details := [][2]string{
+        {"Number", number},
+        {"Date", invoice.InvoiceDate.Format("02 Jan 2006")},
+        {"Place of Supply", placeOfSupply},
+        {"Status", invoice.Status},
+    }
+    if invoice.OriginalInvoiceID != nil {
+        against := fmt.Sprintf("%d", *invoice.OriginalInvoiceID)
+        if original, err := u.invoiceRepo.GetInvoiceByID(*invoice.OriginalInvoiceID); err == nil {
+            against = invoiceNumber(original)
+        }
+        details = append(details, [2]string{"Against Invoice", against})
+    }
+    for _, row := range details {
+        pdf.SetX(120)
+        pdf.SetFont("Arial", "B", 9)
+        pdf.CellFormat(30, 5, row[0], "", 0, "L", false, 0, "")
+        pdf.SetFont("Arial", "", 9)
+        pdf.CellFormat(45, 5, row[1], "", 1, "L", false, 0, "")
+    }
+    if pdf.GetY() < sellerBottom {
+        pdf.SetY(sellerBottom)
+    }
+    pdf.Ln(4)
+
+    pdf.SetFont("Arial", "B", 10)
+    pdf.CellFormat(0, 6, "Bill To", "B", 1, "L", false, 0, "")
+    pdf.SetFont("Arial", "", 9)
+    pdf.CellFormat(0, 5, invoice.BuyerName, "", 1, "L", false, 0, "")
+    if invoice.BuyerAddress != "" {
+        pdf.MultiCell(0, 5, invoice.BuyerAddress, "", "L", false)
+    }
+    if invoice.BuyerEmail != "" {
+        pdf.CellFormat(0, 5, invoice.BuyerEmail, "", 1, "L", false, 0, "")
+    }
+    if invoice.BuyerGSTIN != "" {
+        pdf.CellFormat(0, 5, "GSTIN: "+invoice.BuyerGSTIN, "", 1, "L", false, 0, "")
+    }
+    if invoice.Period != "" {
+        pdf.CellFormat(0, 5, "Billing period: "+invoice.Period, "", 1, "L", false, 0, "")
+    }
+    if invoice.Reason != "" {
+        pdf.CellFormat(0, 5, "Reason: "+invoice.Reason, "", 1, "L", false, 0, "")
+    }
+    pdf.Ln(4)
+
+    igst := invoice.IGSTAmount > 0
+    headers := []string{"#", "Description", "HSN/SAC", "Qty", "Rate", "Discount", "Taxable", "GST %", "Total"}
+    widths := []float64{8, 52, 20, 10, 20, 18, 20, 12, 20}
+    pdf.SetFont("Arial", "B", 8)
+    pdf.SetFillColor(230, 230, 230)
+    for i, header := range headers {
+        pdf.CellFormat(widths[i], 7, header, "1", 0, "C", true, 0, "")
+    }
+    pdf.Ln(-1)
+    pdf.SetFont("Arial", "", 8)
+    for i, line := range invoice.Lines {
+        cells := []string{
+            fmt.Sprintf("%d", i+1),
+            line.Description,
+            line.HSNSAC,
+            fmt.Sprintf("%d", line.Quantity),
+            fmt.Sprintf("%.2f", line.UnitPrice),
+            fmt.Sprintf("%.2f", line.Discount),
+            fmt.Sprintf("%.2f", line.TaxableAmount),
+            fmt.Sprintf("%g", line.GSTRate),
+            fmt.Sprintf("%.2f", line.Total),
+        }
+        for j, cell := range cells {
+            align := "R"
+            if j == 1 || j == 2 {
+                align = "L"
+            }
+            pdf.CellFormat(widths[j], 6, cell, "1", 0, align, false, 0, "")
+        }
+        pdf.Ln(-1)
+    }
+    pdf.Ln(3)
+
+    totals := [][2]string{{"Taxable Amount", fmt.Sprintf("%.2f", invoice.TaxableAmount)}}
+    if igst {
+        totals = append(totals, [2]string{"IGST", fmt.Sprintf("%.2f", invoice.IGSTAmount)})
+    } else {
+        totals = append(totals,
+            [2]string{"CGST", fmt.Sprintf("%.2f", invoice.CGSTAmount)},
+            [2]string{"SGST", fmt.Sprintf("%.2f", invoice.SGSTAmount)})
+    }
+    totals = append(totals, [2]string{"Total (" + invoice.Currency + ")", fmt.Sprintf("%.2f", invoice.Amount)})
+    for i, row := range totals {
+        if i == len(totals)-1 {
+            pdf.SetFont("Arial", "B", 10)
+        }
+        pdf.SetX(125)
+        pdf.CellFormat(40, 6, row[0], "", 0, "R", false, 0, "")
+        pdf.CellFormat(30, 6, row[1], "", 1, "R", false, 0, "")
+    }
+    if invoice.Currency != invoice.SettledCurrency && invoice.SettledCurrency != "" {
+        pdf.SetFont("Arial", "", 8)
+        pdf.SetX(125)
+        pdf.CellFormat(70, 5, fmt.Sprintf("Settled as %.2f %s at %.4f", invoice.SettledAmount, invoice.SettledCurrency, invoice.ExchangeRate), "", 1, "R", false, 0, "")
+    }
+
+    pdf.Ln(8)
+    pdf.SetFont("Arial", "I", 8)
+    pdf.MultiCell(0, 4, "Prices are inclusive of GST. This is a computer generated invoice and does not require a signature.", "", "L", false)
+
+    var buf bytes.Buffer
+    if err := pdf.Output(&buf); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+func (u *invoiceUsecaseImpl) EmailInvoice(invoice *models.Invoice, to, subject, body string) error {
+    content, err := u.RenderPDF(invoice)
+    if err != nil {
+        return err
+    }
+
+    file, err := os.CreateTemp("", fmt.Sprintf("invoice_%d_*.pdf", invoice.InvoiceID))
+    if err != nil {
+        return err
+    }
+    defer os.Remove(file.Name())
+    if _, err := file.Write(content); err != nil {
+        file.Close()
+        return err
+    }
+    if err := file.Close(); err != nil {
+        return err
+    }
+    return utils.SendEmailWithAttachment(to, subject, body, file.Name())
+}
+
+func (u *invoiceUsecaseImpl) EnsureDefaultTaxRules() error {
+    return u.invoiceRepo.EnsureDefaultTaxRules()
+}
+
+func (u *invoiceUsecaseImpl) GetTaxRules() ([]models.TaxRule, error) {
+    return u.invoiceRepo.GetTaxRules()
+}
+
+func (u *invoiceUsecaseImpl) SetTaxRule(rule *models.TaxRule) error {
+    rule.ItemType = strings.TrimSpace(rule.ItemType)
+    rule.HSNSAC = strings.TrimSpace(rule.HSNSAC)
+    if rule.ItemType == "" {
+        return fmt.Errorf("item type is required")
+    }
+    if rule.GSTRate < 0 || rule.GSTRate > MaxGSTRate {
+        return fmt.Errorf("GST rate must be between 0 and %d", MaxGSTRate)
+    }
+    if rule.GSTRate > 0 && rule.HSNSAC == "" {
+        return fmt.Errorf("an HSN/SAC code is required for taxed items")
+    }
+    for _, r := range rule.HSNSAC {
+        if r < '0' || r > '9' {
+            return fmt.Errorf("HSN/SAC code must be numeric")
+        }
+    }
+    return u.invoiceRepo.SaveTaxRule(rule)
+}
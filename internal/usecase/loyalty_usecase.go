@@ -0,0 +1,298 @@
+package usecase
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "gorm.io/gorm"
+)
+
+// Points count as expiring soon in the balance within this window.
+const LoyaltyExpiryNotice = 30 * 24 * time.Hour
+
+var ErrPointsNotRedeemable = errors.New("loyalty points cannot be used for this payment")
+
+// Stored-value purchases cannot be paid with points, or points could be
+// turned into wallet or NolCard balance.
+var loyaltyRedeemablePaymentTypes = map[string]bool{
+    "booking":                    true,
+    "subscription":               true,
+    models.PaymentTypeCardUpgrade: true,
+}
+
+type LoyaltyUsecase interface {
+    EarnForPayment(payment *models.RazorpayPayment, cardType string) (*models.LoyaltyLedgerEntry, error)
+    EarnForJourney(journey *models.NolCardJourney) (*models.LoyaltyLedgerEntry, error)
+    RedeemPoints(userID uint, points int, paymentType string, orderAmount float64) (*models.LoyaltyLedgerEntry, error)
+    AttachRedemption(entryID, paymentID uint, orderID string) error
+    ConfirmRedemptionForOrder(orderID string) error
+    ReverseRedemption(entryID uint) error
+    ReverseRedemptionForOrder(orderID string) error
+    ReleaseStaleRedemptions(olderThan time.Time) (int, error)
+    ExpirePoints(now time.Time) (int, error)
+    GetBalance(userID uint) (*models.LoyaltyBalance, error)
+    GetHistory(userID uint) ([]models.LoyaltyLedgerEntry, error)
+    GetProgramme() (*models.LoyaltyProgramme, error)
+    UpdateProgramme(programme *models.LoyaltyProgramme) error
+    GetEarnRates() ([]models.LoyaltyEarnRate, error)
+    SetEarnRate(rate *models.LoyaltyEarnRate) error
+}
+
+type loyaltyUsecaseImpl struct {
+    loyaltyRepo repository.LoyaltyRepository
+}
+
+func NewLoyaltyUsecase(loyaltyRepo repository.LoyaltyRepository) LoyaltyUsecase {
+    return &loyaltyUsecaseImpl{loyaltyRepo: loyaltyRepo}
+}
+
+// earn credits points for amount at the card type's rate for source. It
+// returns nil when nothing is earned or reference was already rewarded.
+func (u *loyaltyUsecaseImpl) earn(userID uint, source, cardType, reference string, amount float64, description string) (*models.LoyaltyLedgerEntry, error) {
+    if userID == 0 || amount <= 0 {
+        return nil, nil
+    }
+    normalized, ok := models.NormalizeCardType(cardType)
+    if !ok {
+        normalized = models.CardTypeOrdinary
+    }
+    rate, err := u.loyaltyRepo.GetEarnRate(normalized)
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, nil
+        }
+        return nil, err
+    }
+    var perUnit float64
+    switch source {
+    case models.LoyaltySourceBooking:
+        perUnit = rate.BookingRate
+    case models.LoyaltySourceSubscription:
+        perUnit = rate.SubscriptionRate
+    case models.LoyaltySourceJourney:
+        perUnit = rate.JourneyRate
+    }
+    points := int(math.Floor(amount * perUnit))
+    if points <= 0 {
+        return nil, nil
+    }
+
+    programme, err := u.loyaltyRepo.GetProgramme()
+    if err != nil {
+        return nil, err
+    }
+    entry := &models.LoyaltyLedgerEntry{
+        UserID:      userID,
+        Source:      source,
+        Reference:   reference,
+        Points:      points,
+        Amount:      roundAmount(amount),
+        Description: description,
+    }
+    if programme.ExpiryMonths > 0 {
+        expiresAt := time.Now().AddDate(0, programme.ExpiryMonths, 0)
+        entry.ExpiresAt = &expiresAt
+    }
+    created, err := u.loyaltyRepo.Earn(entry)
+    if err != nil || !created {
+        return nil, err
+    }
+    return entry, nil
+}
+
+// EarnForPayment credits points for a verified booking or subscription
+// payment, on the amount actually settled.
+func (u *loyaltyUsecaseImpl) EarnForPayment(payment *models.RazorpayPayment, cardType string) (*models.LoyaltyLedgerEntry, error) {
+    if payment.Status != models.PaymentStatusVerified {
+        return nil, nil
+    }
+    var source string
+    switch payment.PaymentType {
+    case "booking":
+        source = models.LoyaltySourceBooking
+    case "subscription":
+        source = models.LoyaltySourceSubscription
+    default:
+        return nil, nil
+    }
+    amount := payment.SettledAmount
+    if amount == 0 {
+        amount = payment.Amount
+    }
+    return u.earn(payment.UserID, source, cardType, fmt.Sprintf("payment:%d", payment.PaymentID), amount,
+        fmt.Sprintf("Earned on %s payment %d", payment.PaymentType, payment.PaymentID))
+}
+
+// EarnForJourney credits points for the fare charged to the card. Journeys
+// covered by a subscription earn nothing, as the subscription already did.
+func (u *loyaltyUsecaseImpl) EarnForJourney(journey *models.NolCardJourney) (*models.LoyaltyLedgerEntry, error) {
+    return u.earn(uint(journey.UserID), models.LoyaltySourceJourney, journey.CardType, fmt.Sprintf("journey:%d", journey.JourneyID), journey.Fare,
+        fmt.Sprintf("Earned on journey %d", journey.JourneyID))
+}
+
+// RedeemPoints holds up to points against an order of orderAmount and returns
+// the pending redeem entry, whose Amount is the discount. Fewer points are
+// used when the programme's cap would otherwise be exceeded.
+func (u *loyaltyUsecaseImpl) RedeemPoints(userID uint, points int, paymentType string, orderAmount float64) (*models.LoyaltyLedgerEntry, error) {
+    if !loyaltyRedeemablePaymentTypes[paymentType] {
+        return nil, ErrPointsNotRedeemable
+    }
+    if points <= 0 || orderAmount <= 0 {
+        return nil, fmt.Errorf("points and order amount must be greater than zero")
+    }
+    programme, err := u.loyaltyRepo.GetProgramme()
+    if err != nil {
+        return nil, err
+    }
+    if programme.PointValue <= 0 {
+        return nil, ErrPointsNotRedeemable
+    }
+    if points < programme.MinRedeemPoints {
+        return nil, fmt.Errorf("at least %d points must be redeemed", programme.MinRedeemPoints)
+    }
+    maxDiscount := orderAmount * programme.MaxRedeemPercent / 100
+    if maxPoints := int(math.Floor(maxDiscount / programme.PointValue)); points > maxPoints {
+        points = maxPoints
+    }
+    if points <= 0 {
+        return nil, ErrPointsNotRedeemable
+    }
+
+    entry := &models.LoyaltyLedgerEntry{
+        UserID:      userID,
+        Points:      points,
+        Amount:      roundAmount(float64(points) * programme.PointValue),
+        Description: fmt.Sprintf("Redeemed at checkout for a %s payment", paymentType),
+    }
+    if err := u.loyaltyRepo.Redeem(entry); err != nil {
+        return nil, err
+    }
+    return entry, nil
+}
+
+func (u *loyaltyUsecaseImpl) AttachRedemption(entryID, paymentID uint, orderID string) error {
+    return u.loyaltyRepo.AttachRedemption(entryID, paymentID, orderID)
+}
+
+func (u *loyaltyUsecaseImpl) ConfirmRedemptionForOrder(orderID string) error {
+    return u.loyaltyRepo.ConfirmRedemptionByOrderID(orderID)
+}
+
+func (u *loyaltyUsecaseImpl) ReverseRedemption(entryID uint) error {
+    return u.loyaltyRepo.ReverseRedemption(entryID)
+}
+
+// ReverseRedemptionForOrder returns the points held by an order, if any.
+func (u *loyaltyUsecaseImpl) ReverseRedemptionForOrder(orderID string) error {
+    entry, err := u.loyaltyRepo.GetPendingRedemptionByOrderID(orderID)
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil
+        }
+        return err
+    }
+    return u.loyaltyRepo.ReverseRedemption(entry.EntryID)
+}
+
+// ReleaseStaleRedemptions returns points held by payments that were never
+// completed, and reports how many redemptions were reversed.
+func (u *loyaltyUsecaseImpl) ReleaseStaleRedemptions(olderThan time.Time) (int, error) {
+    ids, err := u.loyaltyRepo.GetStaleRedemptionIDs(olderThan)
+    if err != nil {
+        return 0, err
+    }
+    released := 0
+    for _, id := range ids {
+        if err := u.loyaltyRepo.ReverseRedemption(id); err != nil {
+            log.Printf("Failed to reverse loyalty redemption %d: %v", id, err)
+            continue
+        }
+        released++
+    }
+    return released, nil
+}
+
+// ExpirePoints writes off every batch past its expiry and returns the number
+// of points expired.
+func (u *loyaltyUsecaseImpl) ExpirePoints(now time.Time) (int, error) {
+    ids, err := u.loyaltyRepo.GetExpiredEntryIDs(now)
+    if err != nil {
+        return 0, err
+    }
+    expired := 0
+    for _, id := range ids {
+        points, err := u.loyaltyRepo.ExpireEntry(id, now)
+        if err != nil {
+            log.Printf("Failed to expire loyalty entry %d: %v", id, err)
+            continue
+        }
+        expired += points
+    }
+    return expired, nil
+}
+
+func (u *loyaltyUsecaseImpl) GetBalance(userID uint) (*models.LoyaltyBalance, error) {
+    now := time.Now()
+    entries, err := u.loyaltyRepo.GetAvailableEntries(userID, now)
+    if err != nil {
+        return nil, err
+    }
+    programme, err := u.loyaltyRepo.GetProgramme()
+    if err != nil {
+        return nil, err
+    }
+    balance := &models.LoyaltyBalance{}
+    for _, entry := range entries {
+        balance.Points += entry.Remaining
+        if entry.ExpiresAt == nil {
+            continue
+        }
+        if balance.NextExpiry == nil {
+            balance.NextExpiry = entry.ExpiresAt
+        }
+        if entry.ExpiresAt.Sub(now) <= LoyaltyExpiryNotice {
+            balance.ExpiringPoints += entry.Remaining
+        }
+    }
+    balance.Value = roundAmount(float64(balance.Points) * programme.PointValue)
+    return balance, nil
+}
+
+func (u *loyaltyUsecaseImpl) GetHistory(userID uint) ([]models.LoyaltyLedgerEntry, error) {
+    return u.loyaltyRepo.GetLedger(userID)
+}
+
+func (u *loyaltyUsecaseImpl) GetProgramme() (*models.LoyaltyProgramme, error) {
+    return u.loyaltyRepo.GetProgramme()
+}
+
+func (u *loyaltyUsecaseImpl) UpdateProgramme(programme *models.LoyaltyProgramme) error {
+    if programme.PointValue < 0 || programme.ExpiryMonths < 0 || programme.MinRedeemPoints < 0 {
+        return fmt.Errorf("point value, expiry and minimum points cannot be negative")
+    }
+    if programme.MaxRedeemPercent < 0 || programme.MaxRedeemPercent >= 100 {
+        return fmt.Errorf("max redeem percent must be between 0 and 100")
+    }
+    return u.loyaltyRepo.UpdateProgramme(programme)
+}
+
+func (u *loyaltyUsecaseImpl) GetEarnRates() ([]models.LoyaltyEarnRate, error) {
+    return u.loyaltyRepo.GetEarnRates()
+}
+
+func (u *loyaltyUsecaseImpl) SetEarnRate(rate *models.LoyaltyEarnRate) error {
+    cardType, ok := models.NormalizeCardType(rate.CardType)
+    if !ok {
+        return fmt.Errorf("invalid card type: %s", rate.CardType)
+    }
+    if rate.BookingRate < 0 || rate.SubscriptionRate < 0 || rate.JourneyRate < 0 {
+        return fmt.Errorf("earn rates cannot be negative")
+    }
+    rate.CardType = cardType
+    return u.loyaltyRepo.SaveEarnRate(rate)
+}
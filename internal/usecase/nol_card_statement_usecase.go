@@ -0,0 +1,427 @@
+package usecase
+
+import (
+    "bytes"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "github.com/Prototype-1/xtrace/pkg/utils"
+    "github.com/jung-kurt/gofpdf"
+)
+
+// MaxStatementRangeDays caps how much history one statement may cover.
+const MaxStatementRangeDays = 366
+
+var ErrNolCardNotFound = errors.New("NolCard not found")
+
+type NolCardStatementUsecase interface {
+    ChargeJourney(userID uint, nolCardID, routeID, fromStopID, toStopID int) (*models.NolCardJourney, error)
+    RefundToCard(adminID uint, nolCardID int, journeyID *uint, amount float64, reason string) (*models.NolCardRefund, error)
+    GetStatement(userID uint, nolCardID int, from, to time.Time) (*models.NolCardStatement, error)
+    RenderCSV(statement *models.NolCardStatement) ([]byte, error)
+    RenderPDF(statement *models.NolCardStatement) ([]byte, error)
+    SendMonthlyStatements(now time.Time) (int, error)
+}
+
+type nolCardStatementUsecaseImpl struct {
+    statementRepo   repository.NolCardStatementRepository
+    nolCardRepo     repository.NolCardRepository
+    userRepo        repository.UserRepository
+    fareRuleUsecase FareRuleUsecase
+    subscriptionUsecase SubscriptionUsecase
+    loyaltyUsecase  LoyaltyUsecase
+    autoReloadUsecase AutoReloadUsecase
+    invoiceUsecase  InvoiceUsecase
+}
+
+func NewNolCardStatementUsecase(statementRepo repository.NolCardStatementRepository, nolCardRepo repository.NolCardRepository, userRepo repository.UserRepository, fareRuleUsecase FareRuleUsecase, subscriptionUsecase SubscriptionUsecase, loyaltyUsecase LoyaltyUsecase, autoReloadUsecase AutoReloadUsecase, invoiceUsecase InvoiceUsecase) NolCardStatementUsecase {
+    return &nolCardStatementUsecaseImpl{
+        statementRepo:   statementRepo,
+        nolCardRepo:     nolCardRepo,
+        userRepo:        userRepo,
+        fareRuleUsecase: fareRuleUsecase,
+        subscriptionUsecase: subscriptionUsecase,
+        loyaltyUsecase:  loyaltyUsecase,
+        autoReloadUsecase: autoReloadUsecase,
+        invoiceUsecase:  invoiceUsecase,
+    }
+}
+
+// cardForUser loads a card and, when userID is set, checks it belongs to that user.
+func (u *nolCardStatementUsecaseImpl) cardForUser(userID uint, nolCardID int) (models.NolCard, error) {
+    nolCard, err := u.nolCardRepo.GetNolCardByID(nolCardID)
+    if err != nil || (userID != 0 && uint(nolCard.UserID) != userID) {
+        return models.NolCard{}, ErrNolCardNotFound
+    }
+    return nolCard, nil
+}
+
+// ChargeJourney prices the journey for the card's type and deducts the fare,
+// unless the card's subscription covers the journey. Gates and validators pass
+// a zero userID, as they charge any card presented to them.
+func (u *nolCardStatementUsecaseImpl) ChargeJourney(userID uint, nolCardID, routeID, fromStopID, toStopID int) (*models.NolCardJourney, error) {
+    nolCard, err := u.cardForUser(userID, nolCardID)
+    if err != nil {
+        return nil, err
+    }
+    if !nolCard.IsUsable() {
+        return nil, repository.ErrNolCardNotUsable
+    }
+    cardType, ok := models.NormalizeCardType(nolCard.CardType)
+    if !ok {
+        cardType = models.CardTypeOrdinary
+    }
+
+    fare, err := u.fareRuleUsecase.CalculateFare(routeID, fromStopID, toStopID, cardType)
+    if err != nil {
+        return nil, err
+    }
+
+    journey := &models.NolCardJourney{
+        NolCardID:  nolCard.NolCardID,
+        UserID:     nolCard.UserID,
+        RouteID:    routeID,
+        FromStopID: fromStopID,
+        ToStopID:   toStopID,
+        CardType:   cardType,
+        Fare:       roundAmount(fare),
+        FullFare:   roundAmount(fare),
+    }
+    subscription, err := u.subscriptionUsecase.FindCoveringSubscription(uint(nolCard.NolCardID), routeID, fromStopID, toStopID)
+    if err != nil {
+        return nil, err
+    }
+    if subscription != nil {
+        journey.SubscriptionID = &subscription.SubscriptionID
+        journey.Fare = 0
+    }
+    if err := u.statementRepo.ChargeJourney(journey); err != nil {
+        return nil, err
+    }
+    if journey.Fare > 0 {
+        go u.autoReloadUsecase.CheckNolCard(journey.NolCardID)
+    }
+    // The fare is already charged; a failure to award points must not undo it.
+    if _, err := u.loyaltyUsecase.EarnForJourney(journey); err != nil {
+        log.Printf("Failed to award loyalty points for journey %d: %v", journey.JourneyID, err)
+    }
+    return journey, nil
+}
+
+// RefundToCard credits a card. When a journey is given the refund defaults to
+// its fare and may not exceed it.
+func (u *nolCardStatementUsecaseImpl) RefundToCard(adminID uint, nolCardID int, journeyID *uint, amount float64, reason string) (*models.NolCardRefund, error) {
+    if _, err := u.cardForUser(0, nolCardID); err != nil {
+        return nil, err
+    }
+    if journeyID != nil {
+        journey, err := u.statementRepo.GetJourneyByID(*journeyID)
+        if err != nil || journey.NolCardID != nolCardID {
+            return nil, fmt.Errorf("journey not found for this NolCard")
+        }
+        if amount == 0 {
+            amount = journey.Fare
+        }
+        if amount > journey.Fare {
+            return nil, fmt.Errorf("refund cannot exceed the journey fare of %.2f", journey.Fare)
+        }
+    }
+    if amount <= 0 {
+        return nil, fmt.Errorf("refund amount must be greater than zero")
+    }
+
+    refund := &models.NolCardRefund{
+        NolCardID: nolCardID,
+        JourneyID: journeyID,
+        Amount:    roundAmount(amount),
+        Reason:    reason,
+        AdminID:   adminID,
+    }
+    if err := u.statementRepo.RefundToCard(refund); err != nil {
+        return nil, err
+    }
+    // The refund is already on the card; a missing credit note is logged.
+    creditReason := reason
+    if creditReason == "" {
+        creditReason = "Refund credited to NolCard"
+    }
+    if _, err := u.invoiceUsecase.IssueNolCardCreditNote(nolCardID, refund.Amount, creditReason); err != nil {
+        log.Printf("Refunded %.2f to NolCard %d but no credit note was issued: %v", refund.Amount, nolCardID, err)
+    }
+    return refund, nil
+}
+
+// GetStatement merges top-ups, journeys, subscription activations, refunds and
+// balance moved by card replacement in [from, to) with running balances. The opening balance is derived from the
+// current balance minus everything recorded since from.
+func (u *nolCardStatementUsecaseImpl) GetStatement(userID uint, nolCardID int, from, to time.Time) (*models.NolCardStatement, error) {
+    if !to.After(from) {
+        return nil, fmt.Errorf("statement end date must be after the start date")
+    }
+    if to.Sub(from) > MaxStatementRangeDays*24*time.Hour {
+        return nil, fmt.Errorf("statement range cannot exceed %d days", MaxStatementRangeDays)
+    }
+    nolCard, err := u.cardForUser(userID, nolCardID)
+    if err != nil {
+        return nil, err
+    }
+
+    topups, err := u.statementRepo.GetTopupsByCardID(nolCardID, from, to)
+    if err != nil {
+        return nil, err
+    }
+    journeys, err := u.statementRepo.GetJourneysByCardID(nolCardID, from, to)
+    if err != nil {
+        return nil, err
+    }
+    refunds, err := u.statementRepo.GetRefundsByCardID(nolCardID, from, to)
+    if err != nil {
+        return nil, err
+    }
+    subscriptions, err := u.statementRepo.GetSubscriptionsByCardID(nolCardID, from, to)
+    if err != nil {
+        return nil, err
+    }
+    moves, err := u.statementRepo.GetBalanceMovesByCardID(nolCardID, from, to)
+    if err != nil {
+        return nil, err
+    }
+    netSinceFrom, err := u.statementRepo.NetMovementSince(nolCardID, from)
+    if err != nil {
+        return nil, err
+    }
+
+    entries := make([]models.StatementEntry, 0, len(topups)+len(journeys)+len(refunds)+len(subscriptions)+len(moves))
+    for _, topup := range topups {
+        description := "Top-up"
+        if topup.TransferID != nil {
+            description = "Transfer from wallet"
+        }
+        entries = append(entries, models.StatementEntry{
+            Date:        topup.CreatedAt,
+            Type:        models.StatementEntryTopup,
+            Reference:   fmt.Sprintf("TOP-%d", topup.TopupID),
+            Description: description,
+            Credit:      topup.Amount,
+        })
+    }
+    for _, journey := range journeys {
+        description := fmt.Sprintf("Journey on route %d, stop %d to %d", journey.RouteID, journey.FromStopID, journey.ToStopID)
+        if journey.SubscriptionID != nil {
+            description += fmt.Sprintf(" (covered by subscription %d)", *journey.SubscriptionID)
+        }
+        entries = append(entries, models.StatementEntry{
+            Date:        journey.CreatedAt,
+            Type:        models.StatementEntryJourney,
+            Reference:   fmt.Sprintf("JRN-%d", journey.JourneyID),
+            Description: description,
+            Debit:       journey.Fare,
+        })
+    }
+    for _, refund := range refunds {
+        description := "Refund"
+        if refund.Reason != "" {
+            description = "Refund: " + refund.Reason
+        }
+        entries = append(entries, models.StatementEntry{
+            Date:        refund.CreatedAt,
+            Type:        models.StatementEntryRefund,
+            Reference:   fmt.Sprintf("REF-%d", refund.RefundID),
+            Description: description,
+            Credit:      refund.Amount,
+        })
+    }
+    for _, subscription := range subscriptions {
+        entries = append(entries, models.StatementEntry{
+            Date:        subscription.CreatedAt,
+            Type:        models.StatementEntrySubscription,
+            Reference:   fmt.Sprintf("SUB-%d", subscription.SubscriptionID),
+            Description: fmt.Sprintf("Subscription activated (%.2f paid, valid until %s)", subscription.Price, subscription.EndDate.Format("2006-01-02")),
+        })
+    }
+    for _, move := range moves {
+        entry := models.StatementEntry{
+            Date:        move.CreatedAt,
+            Type:        models.StatementEntryTransfer,
+            Reference:   fmt.Sprintf("EVT-%d", move.EventID),
+            Description: "Balance transfer: " + move.Reason,
+        }
+        if move.BalanceMoved > 0 {
+            entry.Credit = move.BalanceMoved
+        } else {
+            entry.Debit = -move.BalanceMoved
+        }
+        entries = append(entries, entry)
+    }
+    sort.SliceStable(entries, func(i, j int) bool {
+        return entries[i].Date.Before(entries[j].Date)
+    })
+
+    statement := &models.NolCardStatement{
+        NolCardID:      nolCard.NolCardID,
+        CardNumber:     nolCard.CardNumber,
+        CardType:       nolCard.CardType,
+        From:           from,
+        To:             to,
+        OpeningBalance: roundAmount(nolCard.Balance - netSinceFrom),
+        Entries:        entries,
+    }
+    balance := statement.OpeningBalance
+    for i := range statement.Entries {
+        balance = roundAmount(balance + statement.Entries[i].Credit - statement.Entries[i].Debit)
+        statement.Entries[i].Balance = balance
+        statement.TotalCredits += statement.Entries[i].Credit
+        statement.TotalDebits += statement.Entries[i].Debit
+    }
+    statement.TotalCredits = roundAmount(statement.TotalCredits)
+    statement.TotalDebits = roundAmount(statement.TotalDebits)
+    statement.ClosingBalance = balance
+    return statement, nil
+}
+
+func (u *nolCardStatementUsecaseImpl) RenderCSV(statement *models.NolCardStatement) ([]byte, error) {
+    var buf bytes.Buffer
+    writer := csv.NewWriter(&buf)
+    rows := [][]string{
+        {"Card Number", statement.CardNumber},
+        {"Card Type", statement.CardType},
+        {"Period", statementPeriodLabel(statement)},
+        {"Opening Balance", fmt.Sprintf("%.2f", statement.OpeningBalance)},
+        {},
+        {"Date", "Type", "Reference", "Description", "Credit", "Debit", "Balance"},
+    }
+    for _, entry := range statement.Entries {
+        rows = append(rows, []string{
+            entry.Date.Format("2006-01-02 15:04"),
+            entry.Type,
+            entry.Reference,
+            entry.Description,
+            fmt.Sprintf("%.2f", entry.Credit),
+            fmt.Sprintf("%.2f", entry.Debit),
+            fmt.Sprintf("%.2f", entry.Balance),
+        })
+    }
+    rows = append(rows,
+        []string{},
+        []string{"Total Credits", fmt.Sprintf("%.2f", statement.TotalCredits)},
+        []string{"Total Debits", fmt.Sprintf("%.2f", statement.TotalDebits)},
+        []string{"Closing Balance", fmt.Sprintf("%.2f", statement.ClosingBalance)},
+    )
+    if err := writer.WriteAll(rows); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+func (u *nolCardStatementUsecaseImpl) RenderPDF(statement *models.NolCardStatement) ([]byte, error) {
+    pdf := gofpdf.New("P", "mm", "A4", "")
+    pdf.AddPage()
+    pdf.SetFont("Arial", "B", 16)
+    pdf.Cell(40, 10, "NolCard Statement")
+    pdf.Ln(12)
+
+    pdf.SetFont("Arial", "", 11)
+    pdf.Cell(40, 7, fmt.Sprintf("Card Number: %s (%s)", statement.CardNumber, statement.CardType))
+    pdf.Ln(7)
+    pdf.Cell(40, 7, fmt.Sprintf("Period: %s", statementPeriodLabel(statement)))
+    pdf.Ln(7)
+    pdf.Cell(40, 7, fmt.Sprintf("Opening Balance: %.2f", statement.OpeningBalance))
+    pdf.Ln(10)
+
+    widths := []float64{28, 22, 72, 22, 22, 24}
+    pdf.SetFont("Arial", "B", 9)
+    for i, header := range []string{"Date", "Reference", "Description", "Credit", "Debit", "Balance"} {
+        pdf.CellFormat(widths[i], 7, header, "1", 0, "C", false, 0, "")
+    }
+    pdf.Ln(-1)
+
+    pdf.SetFont("Arial", "", 8)
+    for _, entry := range statement.Entries {
+        description := entry.Description
+        if len(description) > 48 {
+            description = description[:45] + "..."
+        }
+        pdf.CellFormat(widths[0], 6, entry.Date.Format("2006-01-02 15:04"), "1", 0, "", false, 0, "")
+        pdf.CellFormat(widths[1], 6, entry.Reference, "1", 0, "", false, 0, "")
+        pdf.CellFormat(widths[2], 6, description, "1", 0, "", false, 0, "")
+        pdf.CellFormat(widths[3], 6, fmt.Sprintf("%.2f", entry.Credit), "1", 0, "R", false, 0, "")
+        pdf.CellFormat(widths[4], 6, fmt.Sprintf("%.2f", entry.Debit), "1", 0, "R", false, 0, "")
+        pdf.CellFormat(widths[5], 6, fmt.Sprintf("%.2f", entry.Balance), "1", 0, "R", false, 0, "")
+        pdf.Ln(-1)
+    }
+
+    pdf.Ln(4)
+    pdf.SetFont("Arial", "", 11)
+    pdf.Cell(40, 7, fmt.Sprintf("Total Credits: %.2f", statement.TotalCredits))
+    pdf.Ln(7)
+    pdf.Cell(40, 7, fmt.Sprintf("Total Debits: %.2f", statement.TotalDebits))
+    pdf.Ln(7)
+    pdf.Cell(40, 7, fmt.Sprintf("Closing Balance: %.2f", statement.ClosingBalance))
+
+    var buf bytes.Buffer
+    if err := pdf.Output(&buf); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// SendMonthlyStatements emails last month's PDF statement for every card with
+// activity in that month. Cards already sent for the period are skipped.
+func (u *nolCardStatementUsecaseImpl) SendMonthlyStatements(now time.Time) (int, error) {
+    to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+    from := to.AddDate(0, -1, 0)
+    period := from.Format("2006-01")
+
+    nolCards, err := u.statementRepo.GetCardsWithActivity(from, to)
+    if err != nil {
+        return 0, err
+    }
+
+    sent := 0
+    for _, nolCard := range nolCards {
+        if done, err := u.statementRepo.HasDispatch(nolCard.NolCardID, period); err != nil || done {
+            continue
+        }
+        if err := u.emailStatement(nolCard, from, to, period); err != nil {
+            log.Printf("Failed to send %s statement for NolCard %d: %v", period, nolCard.NolCardID, err)
+            continue
+        }
+        if err := u.statementRepo.RecordDispatch(nolCard.NolCardID, period); err != nil {
+            log.Printf("Failed to record %s statement for NolCard %d: %v", period, nolCard.NolCardID, err)
+        }
+        sent++
+    }
+    return sent, nil
+}
+
+func (u *nolCardStatementUsecaseImpl) emailStatement(nolCard models.NolCard, from, to time.Time, period string) error {
+    user, err := u.userRepo.GetUserByID(uint(nolCard.UserID))
+    if err != nil || user == nil || user.Email == "" {
+        return fmt.Errorf("no email address for user %d", nolCard.UserID)
+    }
+    statement, err := u.GetStatement(0, nolCard.NolCardID, from, to)
+    if err != nil {
+        return err
+    }
+    content, err := u.RenderPDF(statement)
+    if err != nil {
+        return err
+    }
+
+    subject := fmt.Sprintf("Your NolCard statement for %s", from.Format("January 2006"))
+    body := fmt.Sprintf("Dear %s,\n\nPlease find attached the statement for your NolCard %s for %s.\nClosing balance: %.2f\n",
+        user.FirstName, nolCard.CardNumber, from.Format("January 2006"), statement.ClosingBalance)
+    filename := fmt.Sprintf("statement_%s_%s.pdf", nolCard.CardNumber, period)
+    return utils.SendEmailWithAttachmentData(user.Email, subject, body, filename, content)
+}
+
+// statementPeriodLabel prints the inclusive date range of a statement.
+func statementPeriodLabel(statement *models.NolCardStatement) string {
+    return fmt.Sprintf("%s to %s", statement.From.Format("2006-01-02"), statement.To.Add(-time.Nanosecond).Format("2006-01-02"))
+}
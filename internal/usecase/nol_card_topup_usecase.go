@@ -36,7 +36,8 @@ func (u *NolCardTopupUsecaseImpl) AddTopupAndUpdateBalance(topup models.NolCardT
     }
 
     // Minimum top-up amount check
-    minTopup := map[string]float64{"gold": 100, "silver": 50, "ordinary": 20}[cardType]
+    normalizedType, _ := models.NormalizeCardType(cardType)
+    minTopup := map[string]float64{models.CardTypeGold: 100, models.CardTypeSilver: 50, models.CardTypeOrdinary: 20}[normalizedType]
     if topup.Amount < minTopup {
         return fmt.Errorf("minimum top-up for %s card is %.2f", cardType, minTopup)
     }
@@ -46,6 +47,9 @@ func (u *NolCardTopupUsecaseImpl) AddTopupAndUpdateBalance(topup models.NolCardT
     if err != nil {
         return err
     }
+    if !nolCard.IsUsable() {
+        return repository.ErrNolCardNotUsable
+    }
 
     // Add top-up to the database and update balance
     err = u.NolCardTopupRepo.AddTopupAndUpdateBalance(topup, nolCard)
@@ -53,9 +57,6 @@ func (u *NolCardTopupUsecaseImpl) AddTopupAndUpdateBalance(topup models.NolCardT
         return err
     }
 
-    // Update the balance in the NolCard table after successful top-up record
-    nolCard.Balance += topup.Amount // This logic is correctly placed here
-
     return nil
 }
 
@@ -0,0 +1,252 @@
+package usecase
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "gorm.io/gorm"
+)
+
+// ErrUpgradeNotPending is returned when a card upgrade payment arrives for an
+// upgrade that was cancelled or replaced while the rider was paying.
+var ErrUpgradeNotPending = errors.New("card upgrade is no longer pending")
+
+// UpgradeOption describes one tier a card can move to and whether the
+// cardholder currently qualifies for it.
+type UpgradeOption struct {
+    Rule     models.NolCardUpgradeRule `json:"rule"`
+    Eligible bool                      `json:"eligible"`
+    Reason   string                    `json:"reason,omitempty"`
+    Spend    float64                   `json:"spend"`
+}
+
+type NolCardUpgradeUsecase interface {
+    CreateRule(rule *models.NolCardUpgradeRule) error
+    UpdateRule(rule *models.NolCardUpgradeRule) error
+    DeleteRule(ruleID uint) error
+    GetAllRules() ([]models.NolCardUpgradeRule, error)
+
+    GetUpgradeOptions(userID uint, nolCardID int) ([]UpgradeOption, error)
+    RequestUpgrade(userID uint, nolCardID int, toCardType string) (*models.NolCardUpgrade, error)
+    GetPendingUpgrade(userID uint, nolCardID int) (*models.NolCardUpgrade, error)
+    AttachPayment(upgradeID, paymentID uint, orderID string) error
+    CompleteUpgradeForPayment(payment *models.RazorpayPayment) error
+    GetUpgradesByUserID(userID uint) ([]models.NolCardUpgrade, error)
+}
+
+type nolCardUpgradeUsecaseImpl struct {
+    upgradeRepo repository.NolCardUpgradeRepository
+    nolCardRepo repository.NolCardRepository
+}
+
+func NewNolCardUpgradeUsecase(upgradeRepo repository.NolCardUpgradeRepository, nolCardRepo repository.NolCardRepository) NolCardUpgradeUsecase {
+    return &nolCardUpgradeUsecaseImpl{upgradeRepo: upgradeRepo, nolCardRepo: nolCardRepo}
+}
+
+func (u *nolCardUpgradeUsecaseImpl) CreateRule(rule *models.NolCardUpgradeRule) error {
+    from, ok := models.NormalizeCardType(rule.FromCardType)
+    if !ok {
+        return fmt.Errorf("invalid from_card_type")
+    }
+    to, ok := models.NormalizeCardType(rule.ToCardType)
+    if !ok {
+        return fmt.Errorf("invalid to_card_type")
+    }
+    if models.CardTierRank[to] <= models.CardTierRank[from] {
+        return fmt.Errorf("upgrades must move to a higher tier")
+    }
+    rule.FromCardType, rule.ToCardType = from, to
+    if err := validateUpgradeRule(rule); err != nil {
+        return err
+    }
+    return u.upgradeRepo.CreateRule(rule)
+}
+
+func (u *nolCardUpgradeUsecaseImpl) UpdateRule(rule *models.NolCardUpgradeRule) error {
+    if _, err := u.upgradeRepo.GetRuleByID(rule.UpgradeRuleID); err != nil {
+        return fmt.Errorf("upgrade rule not found")
+    }
+    if err := validateUpgradeRule(rule); err != nil {
+        return err
+    }
+    return u.upgradeRepo.UpdateRule(rule)
+}
+
+func validateUpgradeRule(rule *models.NolCardUpgradeRule) error {
+    if rule.Price < 0 || rule.MinSpend < 0 || rule.MinCardAgeDays < 0 {
+        return fmt.Errorf("price, min_spend and min_card_age_days cannot be negative")
+    }
+    if rule.SpendWindowDays <= 0 {
+        rule.SpendWindowDays = 90
+    }
+    return nil
+}
+
+func (u *nolCardUpgradeUsecaseImpl) DeleteRule(ruleID uint) error {
+    return u.upgradeRepo.DeleteRule(ruleID)
+}
+
+func (u *nolCardUpgradeUsecaseImpl) GetAllRules() ([]models.NolCardUpgradeRule, error) {
+    return u.upgradeRepo.GetAllRules()
+}
+
+func (u *nolCardUpgradeUsecaseImpl) ownedUsableCard(userID uint, nolCardID int) (*models.NolCard, error) {
+    nolCard, err := u.nolCardRepo.GetNolCardByID(nolCardID)
+    if err != nil || uint(nolCard.UserID) != userID {
+        return nil, fmt.Errorf("NolCard not found")
+    }
+    if !nolCard.IsUsable() {
+        return nil, repository.ErrNolCardNotUsable
+    }
+    return &nolCard, nil
+}
+
+func (u *nolCardUpgradeUsecaseImpl) GetUpgradeOptions(userID uint, nolCardID int) ([]UpgradeOption, error) {
+    nolCard, err := u.ownedUsableCard(userID, nolCardID)
+    if err != nil {
+        return nil, err
+    }
+    currentType, ok := models.NormalizeCardType(nolCard.CardType)
+    if !ok {
+        return nil, fmt.Errorf("card has an unknown card type %q", nolCard.CardType)
+    }
+
+    rules, err := u.upgradeRepo.GetEnabledRulesFrom(currentType)
+    if err != nil {
+        return nil, err
+    }
+    options := make([]UpgradeOption, 0, len(rules))
+    for _, rule := range rules {
+        option, err := u.checkEligibility(userID, nolCard, rule)
+        if err != nil {
+            return nil, err
+        }
+        options = append(options, option)
+    }
+    return options, nil
+}
+
+func (u *nolCardUpgradeUsecaseImpl) checkEligibility(userID uint, nolCard *models.NolCard, rule models.NolCardUpgradeRule) (UpgradeOption, error) {
+    option := UpgradeOption{Rule: rule, Eligible: true}
+
+    window := rule.SpendWindowDays
+    if window <= 0 {
+        window = 90
+    }
+    spend, err := u.upgradeRepo.GetUserSpendSince(userID, time.Now().AddDate(0, 0, -window))
+    if err != nil {
+        return option, fmt.Errorf("failed to calculate spend: %v", err)
+    }
+    option.Spend = spend
+
+    if spend < rule.MinSpend {
+        option.Eligible = false
+        option.Reason = fmt.Sprintf("requires %.2f %s spend in the last %d days, you have %.2f", rule.MinSpend, models.BaseCurrency, window, spend)
+        return option, nil
+    }
+    if rule.MinCardAgeDays > 0 && time.Since(nolCard.CreatedAt) < time.Duration(rule.MinCardAgeDays)*24*time.Hour {
+        option.Eligible = false
+        option.Reason = fmt.Sprintf("card must be at least %d days old", rule.MinCardAgeDays)
+    }
+    return option, nil
+}
+
+// RequestUpgrade prices an upgrade for the card. Any earlier unpaid request
+// for the same card is cancelled so only one can be paid; a payment that still
+// arrives for a cancelled request is refunded when it is verified.
+func (u *nolCardUpgradeUsecaseImpl) RequestUpgrade(userID uint, nolCardID int, toCardType string) (*models.NolCardUpgrade, error) {
+    to, ok := models.NormalizeCardType(toCardType)
+    if !ok {
+        return nil, fmt.Errorf("invalid card type - Ordinary/Silver/Gold")
+    }
+
+    options, err := u.GetUpgradeOptions(userID, nolCardID)
+    if err != nil {
+        return nil, err
+    }
+    var option *UpgradeOption
+    for i := range options {
+        if options[i].Rule.ToCardType == to {
+            option = &options[i]
+            break
+        }
+    }
+    if option == nil {
+        return nil, fmt.Errorf("no upgrade to %s is offered for this card", to)
+    }
+    if !option.Eligible {
+        return nil, fmt.Errorf("not eligible for %s: %s", to, option.Reason)
+    }
+
+    nolCard, err := u.nolCardRepo.GetNolCardByID(nolCardID)
+    if err != nil {
+        return nil, err
+    }
+    if err := u.upgradeRepo.CancelPendingUpgrades(nolCardID); err != nil {
+        return nil, err
+    }
+
+    upgrade := &models.NolCardUpgrade{
+        NolCardID:     nolCardID,
+        UserID:        userID,
+        UpgradeRuleID: option.Rule.UpgradeRuleID,
+        FromCardType:  nolCard.CardType,
+        ToCardType:    to,
+        Price:         option.Rule.Price,
+        Status:        models.UpgradeStatusPending,
+    }
+    if err := u.upgradeRepo.CreateUpgrade(upgrade); err != nil {
+        return nil, err
+    }
+    return upgrade, nil
+}
+
+func (u *nolCardUpgradeUsecaseImpl) GetPendingUpgrade(userID uint, nolCardID int) (*models.NolCardUpgrade, error) {
+    upgrade, err := u.upgradeRepo.GetPendingUpgradeByCard(nolCardID)
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil, fmt.Errorf("no pending upgrade for this card, request one first")
+        }
+        return nil, err
+    }
+    if upgrade.UserID != userID {
+        return nil, fmt.Errorf("no pending upgrade for this card, request one first")
+    }
+    return upgrade, nil
+}
+
+func (u *nolCardUpgradeUsecaseImpl) AttachPayment(upgradeID, paymentID uint, orderID string) error {
+    return u.upgradeRepo.AttachPayment(upgradeID, paymentID, orderID)
+}
+
+// CompleteUpgradeForPayment applies the upgrade paid for by a verified
+// card_upgrade payment. It returns ErrUpgradeNotPending when the payment's
+// upgrade is no longer the card's pending one, so the caller can refund it.
+func (u *nolCardUpgradeUsecaseImpl) CompleteUpgradeForPayment(payment *models.RazorpayPayment) error {
+    if payment.NolCardID == nil {
+        return fmt.Errorf("nol_card_id is nil")
+    }
+    upgrade, err := u.upgradeRepo.GetPendingUpgradeByCard(int(*payment.NolCardID))
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return fmt.Errorf("%w: no pending upgrade for card %d", ErrUpgradeNotPending, *payment.NolCardID)
+    }
+    if err != nil {
+        return fmt.Errorf("no pending upgrade for card %d: %w", *payment.NolCardID, err)
+    }
+    if upgrade.OrderID != payment.OrderID {
+        return fmt.Errorf("%w: payment %s does not belong to the pending upgrade", ErrUpgradeNotPending, payment.OrderID)
+    }
+    if err := u.upgradeRepo.CompleteUpgrade(upgrade.UpgradeID); err != nil {
+        return err
+    }
+    log.Printf("Nol card %d upgraded from %s to %s", upgrade.NolCardID, upgrade.FromCardType, upgrade.ToCardType)
+    return nil
+}
+
+func (u *nolCardUpgradeUsecaseImpl) GetUpgradesByUserID(userID uint) ([]models.NolCardUpgrade, error) {
+    return u.upgradeRepo.GetUpgradesByUserID(userID)
+}
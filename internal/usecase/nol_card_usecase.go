@@ -1,13 +1,35 @@
 package usecase
 
 import (
+    "fmt"
+    "strings"
+    "time"
+
     "github.com/Prototype-1/xtrace/internal/models"
     "github.com/Prototype-1/xtrace/internal/repository"
 )
 
+// NolCardValidityYears is how long a newly issued card stays valid.
+const NolCardValidityYears = 5
+
 type NolCardUsecase interface {
     GetNolCardByID(nolCardID int) (models.NolCard, error)
-    AddNolCard(nolCard models.NolCard) error
+    AddNolCard(nolCard models.NolCard) (*models.NolCard, error)
+    GetNolCardsByUserID(userID int) ([]models.NolCard, error)
+    GetNolCardHistory(nolCardID int) ([]models.NolCardStatusEvent, error)
+
+    ReportLost(userID uint, nolCardID int, reason string) error
+    ActivateNolCard(adminID uint, nolCardID int) error
+    BlockNolCard(adminID uint, nolCardID int, reason string) error
+    UnblockNolCard(adminID uint, nolCardID int) error
+    HotlistNolCard(adminID uint, nolCardID int, reason string) error
+    ReplaceNolCard(adminID uint, oldCardID int, reason string) (*models.NolCard, error)
+    ExpireNolCards() (int64, error)
+
+    GetCardNumberSeries() ([]models.CardNumberSeries, error)
+    SetCardNumberPrefix(cardType, prefix string) error
+    ReportInvalidCardNumbers() ([]models.NolCard, error)
+    ReissueCardNumber(adminID uint, nolCardID int, reason string) (*models.NolCard, error)
 }
 
 type NolCardUsecaseImpl struct {
@@ -22,6 +44,166 @@ func (u *NolCardUsecaseImpl) GetNolCardByID(nolCardID int) (models.NolCard, erro
     return u.NolCardRepo.GetNolCardByID(nolCardID)
 }
 
-func (u *NolCardUsecaseImpl) AddNolCard(nolCard models.NolCard) error {
-    return u.NolCardRepo.AddNolCard(nolCard)
-}
\ No newline at end of file
+// AddNolCard issues the card with a generated number; any number sent by the
+// caller is ignored.
+func (u *NolCardUsecaseImpl) AddNolCard(nolCard models.NolCard) (*models.NolCard, error) {
+    cardType, ok := models.NormalizeCardType(nolCard.CardType)
+    if !ok {
+        return nil, fmt.Errorf("card type must be Ordinary, Silver or Gold")
+    }
+    nolCard.CardType = cardType
+
+    cardNumber, err := u.NolCardRepo.GenerateCardNumber(cardType)
+    if err != nil {
+        return nil, err
+    }
+    nolCard.CardNumber = cardNumber
+
+    if nolCard.Status == "" {
+        nolCard.Status = models.NolCardStatusActive
+    }
+    if nolCard.ExpiresAt == nil {
+        expiresAt := time.Now().AddDate(NolCardValidityYears, 0, 0)
+        nolCard.ExpiresAt = &expiresAt
+    }
+    if err := u.NolCardRepo.AddNolCard(&nolCard); err != nil {
+        return nil, err
+    }
+    return &nolCard, nil
+}
+
+func (u *NolCardUsecaseImpl) GetNolCardsByUserID(userID int) ([]models.NolCard, error) {
+    return u.NolCardRepo.GetNolCardsByUserID(userID)
+}
+
+func (u *NolCardUsecaseImpl) GetNolCardHistory(nolCardID int) ([]models.NolCardStatusEvent, error) {
+    return u.NolCardRepo.GetStatusEvents(nolCardID)
+}
+
+// ReportLost hotlists the caller's own card straight away so nobody can
+// travel or pay with it while a replacement is arranged.
+func (u *NolCardUsecaseImpl) ReportLost(userID uint, nolCardID int, reason string) error {
+    nolCard, err := u.NolCardRepo.GetNolCardByID(nolCardID)
+    if err != nil || uint(nolCard.UserID) != userID {
+        return fmt.Errorf("NolCard not found")
+    }
+    if strings.TrimSpace(reason) == "" {
+        reason = "reported lost by cardholder"
+    }
+    return u.NolCardRepo.UpdateNolCardStatus(nolCardID,
+        []string{models.NolCardStatusIssued, models.NolCardStatusActive, models.NolCardStatusBlocked, ""},
+        models.NolCardStatusHotlisted, reason, userID, "user")
+}
+
+func (u *NolCardUsecaseImpl) ActivateNolCard(adminID uint, nolCardID int) error {
+    return u.NolCardRepo.UpdateNolCardStatus(nolCardID,
+        []string{models.NolCardStatusIssued},
+        models.NolCardStatusActive, "activated", adminID, "admin")
+}
+
+func (u *NolCardUsecaseImpl) BlockNolCard(adminID uint, nolCardID int, reason string) error {
+    return u.NolCardRepo.UpdateNolCardStatus(nolCardID,
+        []string{models.NolCardStatusIssued, models.NolCardStatusActive, ""},
+        models.NolCardStatusBlocked, reason, adminID, "admin")
+}
+
+func (u *NolCardUsecaseImpl) UnblockNolCard(adminID uint, nolCardID int) error {
+    return u.NolCardRepo.UpdateNolCardStatus(nolCardID,
+        []string{models.NolCardStatusBlocked},
+        models.NolCardStatusActive, "unblocked", adminID, "admin")
+}
+
+func (u *NolCardUsecaseImpl) HotlistNolCard(adminID uint, nolCardID int, reason string) error {
+    return u.NolCardRepo.UpdateNolCardStatus(nolCardID,
+        []string{models.NolCardStatusIssued, models.NolCardStatusActive, models.NolCardStatusBlocked, ""},
+        models.NolCardStatusHotlisted, reason, adminID, "admin")
+}
+
+func (u *NolCardUsecaseImpl) ReplaceNolCard(adminID uint, oldCardID int, reason string) (*models.NolCard, error) {
+    oldCard, err := u.NolCardRepo.GetNolCardByID(oldCardID)
+    if err != nil {
+        return nil, err
+    }
+    cardType, ok := models.NormalizeCardType(oldCard.CardType)
+    if !ok {
+        cardType = models.CardTypeOrdinary
+    }
+    newCardNumber, err := u.NolCardRepo.GenerateCardNumber(cardType)
+    if err != nil {
+        return nil, err
+    }
+    if strings.TrimSpace(reason) == "" {
+        reason = "card replaced"
+    }
+
+    expiresAt := time.Now().AddDate(NolCardValidityYears, 0, 0)
+    newCard := &models.NolCard{
+        CardNumber: newCardNumber,
+        CardType:   cardType,
+        ExpiresAt:  &expiresAt,
+    }
+    if err := u.NolCardRepo.ReplaceNolCard(oldCardID, newCard, reason, adminID); err != nil {
+        return nil, err
+    }
+    return newCard, nil
+}
+
+func (u *NolCardUsecaseImpl) ExpireNolCards() (int64, error) {
+    return u.NolCardRepo.ExpireNolCards()
+}
+
+func (u *NolCardUsecaseImpl) GetCardNumberSeries() ([]models.CardNumberSeries, error) {
+    return u.NolCardRepo.GetCardNumberSeries()
+}
+
+// SetCardNumberPrefix changes the prefix used for future numbers of a card type.
+// Cards already issued keep their numbers.
+func (u *NolCardUsecaseImpl) SetCardNumberPrefix(cardType, prefix string) error {
+    canonical, ok := models.NormalizeCardType(cardType)
+    if !ok {
+        return fmt.Errorf("card type must be Ordinary, Silver or Gold")
+    }
+    prefix = strings.TrimSpace(prefix)
+    if len(prefix) < 2 || len(prefix) > 8 {
+        return fmt.Errorf("prefix must be between 2 and 8 digits")
+    }
+    for _, r := range prefix {
+        if r < '0' || r > '9' {
+            return fmt.Errorf("prefix must contain digits only")
+        }
+    }
+    return u.NolCardRepo.SetCardNumberPrefix(canonical, prefix)
+}
+
+func (u *NolCardUsecaseImpl) ReportInvalidCardNumbers() ([]models.NolCard, error) {
+    return u.NolCardRepo.GetNolCardsWithInvalidNumbers()
+}
+
+// ReissueCardNumber gives one card with an invalid number a generated number of
+// its type. The old number keeps working for lookups.
+func (u *NolCardUsecaseImpl) ReissueCardNumber(adminID uint, nolCardID int, reason string) (*models.NolCard, error) {
+    nolCard, err := u.NolCardRepo.GetNolCardByID(nolCardID)
+    if err != nil {
+        return nil, err
+    }
+    cardType, ok := models.NormalizeCardType(nolCard.CardType)
+    if !ok {
+        cardType = models.CardTypeOrdinary
+    }
+    cardNumber, err := u.NolCardRepo.GenerateCardNumber(cardType)
+    if err != nil {
+        return nil, err
+    }
+    if strings.TrimSpace(reason) == "" {
+        reason = "card number reissued"
+    }
+    if err := u.NolCardRepo.ReissueCardNumber(nolCardID, cardNumber, reason, adminID); err != nil {
+        return nil, err
+    }
+
+    reissued, err := u.NolCardRepo.GetNolCardByID(nolCardID)
+    if err != nil {
+        return nil, err
+    }
+    return &reissued, nil
+}
@@ -0,0 +1,684 @@
+package usecase
+
+import (
+    "bytes"
+    "crypto/rand"
+    "encoding/csv"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "github.com/Prototype-1/xtrace/pkg/utils"
+    "gorm.io/gorm"
+)
+
+var (
+    ErrOrganisationNotFound  = errors.New("organisation not found")
+    ErrNotOrganisationAdmin  = errors.New("only organisation admins can do this")
+    ErrNotOrganisationMember = errors.New("user is not a member of this organisation")
+    ErrEmailNotVerified      = errors.New("verify your email address before joining by domain")
+)
+
+// OrganisationInviteValidity is how long an emailed invite can be accepted.
+const OrganisationInviteValidity = 14 * 24 * time.Hour
+
+type OrganisationUsecase interface {
+    // Platform admin operations.
+    CreateOrganisation(organisation *models.Organisation, adminUserID uint) error
+    GetOrganisations() ([]models.Organisation, error)
+    GetOrganisation(organisationID uint) (*models.Organisation, error)
+    UpdateBilling(organisationID uint, billingMode string, creditLimit float64, billingEmail string) error
+    UpdateTaxDetails(organisationID uint, gstin, billingAddress, stateCode string) error
+    FundOrganisation(organisationID uint, amount float64) (*models.Organisation, error)
+    SettleInvoice(organisationID, invoiceID uint) error
+
+    // Member operations. actorID is the authenticated user.
+    GetMemberships(userID uint) ([]models.OrganisationMember, error)
+    GetOrganisationForAdmin(actorID, organisationID uint) (*models.Organisation, error)
+    GetMembers(actorID, organisationID uint) ([]models.OrganisationMember, error)
+    InviteMember(actorID, organisationID uint, email, role string) (*models.OrganisationInvite, error)
+    GetInvites(actorID, organisationID uint) ([]models.OrganisationInvite, error)
+    AcceptInvite(userID uint, token string) (*models.OrganisationMember, error)
+    JoinByDomain(userID uint) (*models.OrganisationMember, error)
+    RemoveMember(actorID, organisationID, memberUserID uint) error
+    FundMemberSubscription(actorID, organisationID, memberUserID, planID uint, nolCardID int) (*models.Subscription, error)
+    FundMemberTopup(actorID, organisationID, memberUserID uint, nolCardID int, amount float64) (*models.NolCardTopup, error)
+    GetUsageReport(actorID, organisationID uint, from, to time.Time) ([]models.OrganisationUsage, error)
+    RenderUsageCSV(usage []models.OrganisationUsage) ([]byte, error)
+    GetInvoices(actorID, organisationID uint) ([]models.Invoice, error)
+    GetInvoice(actorID, organisationID, invoiceID uint) (*models.Invoice, []models.OrganisationCharge, error)
+
+    // GenerateMonthlyInvoices bills every organisation's charges for the month before now.
+    GenerateMonthlyInvoices(now time.Time) (int, error)
+}
+
+type organisationUsecaseImpl struct {
+    organisationRepo repository.OrganisationRepository
+    userRepo         repository.UserRepository
+    nolCardRepo      repository.NolCardRepository
+    subscriptionRepo repository.SubscriptionRepository
+    invoiceUsecase   InvoiceUsecase
+}
+
+func NewOrganisationUsecase(organisationRepo repository.OrganisationRepository, userRepo repository.UserRepository, nolCardRepo repository.NolCardRepository, subscriptionRepo repository.SubscriptionRepository, invoiceUsecase InvoiceUsecase) OrganisationUsecase {
+    return &organisationUsecaseImpl{
+        organisationRepo: organisationRepo,
+        userRepo:         userRepo,
+        nolCardRepo:      nolCardRepo,
+        subscriptionRepo: subscriptionRepo,
+        invoiceUsecase:   invoiceUsecase,
+    }
+}
+
+func normalizeBillingMode(mode string) (string, error) {
+    switch strings.ToLower(strings.TrimSpace(mode)) {
+    case "", models.BillingModePrepaid:
+        return models.BillingModePrepaid, nil
+    case models.BillingModePostpaid:
+        return models.BillingModePostpaid, nil
+    }
+    return "", fmt.Errorf("billing mode must be prepaid or postpaid")
+}
+
+func emailDomain(email string) string {
+    at := strings.LastIndex(email, "@")
+    if at < 0 {
+        return ""
+    }
+    return strings.ToLower(strings.TrimSpace(email[at+1:]))
+}
+
+func (u *organisationUsecaseImpl) CreateOrganisation(organisation *models.Organisation, adminUserID uint) error {
+    if strings.TrimSpace(organisation.Name) == "" {
+        return fmt.Errorf("organisation name is required")
+    }
+    mode, err := normalizeBillingMode(organisation.BillingMode)
+    if err != nil {
+        return err
+    }
+    if organisation.CreditLimit < 0 {
+        return fmt.Errorf("credit limit cannot be negative")
+    }
+    if _, err := u.userRepo.GetUserByID(adminUserID); err != nil {
+        return fmt.Errorf("admin user not found")
+    }
+    organisation.BillingMode = mode
+    organisation.EmailDomain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(organisation.EmailDomain), "@"))
+    if organisation.EmailDomain != "" {
+        if _, err := u.organisationRepo.GetOrganisationByDomain(organisation.EmailDomain); err == nil {
+            return fmt.Errorf("another organisation already uses the domain %s", organisation.EmailDomain)
+        }
+    }
+    organisation.Balance = 0
+    organisation.Outstanding = 0
+    return u.organisationRepo.CreateOrganisation(organisation, adminUserID)
+}
+
+func (u *organisationUsecaseImpl) GetOrganisations() ([]models.Organisation, error) {
+    return u.organisationRepo.GetOrganisations()
+}
+
+func (u *organisationUsecaseImpl) GetOrganisation(organisationID uint) (*models.Organisation, error) {
+    organisation, err := u.organisationRepo.GetOrganisationByID(organisationID)
+    if err != nil {
+        return nil, ErrOrganisationNotFound
+    }
+    return organisation, nil
+}
+
+func (u *organisationUsecaseImpl) UpdateBilling(organisationID uint, billingMode string, creditLimit float64, billingEmail string) error {
+    organisation, err := u.GetOrganisation(organisationID)
+    if err != nil {
+        return err
+    }
+    mode, err := normalizeBillingMode(billingMode)
+    if err != nil {
+        return err
+    }
+    if creditLimit < 0 {
+        return fmt.Errorf("credit limit cannot be negative")
+    }
+    if mode == models.BillingModePostpaid && creditLimit < organisation.Outstanding {
+        return fmt.Errorf("credit limit cannot be below the outstanding amount of %.2f", organisation.Outstanding)
+    }
+    if mode != organisation.BillingMode && organisation.Outstanding > 0 {
+        return fmt.Errorf("settle the outstanding amount of %.2f before changing billing mode", organisation.Outstanding)
+    }
+    return u.organisationRepo.UpdateBilling(organisationID, mode, creditLimit, billingEmail)
+}
+
+// UpdateTaxDetails sets the GST details printed on the organisation's invoices.
+func (u *organisationUsecaseImpl) UpdateTaxDetails(organisationID uint, gstin, billingAddress, stateCode string) error {
+    if _, err := u.GetOrganisation(organisationID); err != nil {
+        return err
+    }
+    gstin = strings.ToUpper(strings.TrimSpace(gstin))
+    stateCode = strings.TrimSpace(stateCode)
+    if gstin != "" {
+        if err := utils.ValidateGSTIN(gstin); err != nil {
+            return err
+        }
+        if stateCode == "" {
+            stateCode = utils.GSTINStateCode(gstin)
+        } else if stateCode != utils.GSTINStateCode(gstin) {
+            return fmt.Errorf("state code %s does not match the GSTIN", stateCode)
+        }
+    }
+    if stateCode != "" && (len(stateCode) != 2 || strings.Trim(stateCode, "0123456789") != "") {
+        return fmt.Errorf("state code must be two digits")
+    }
+    return u.organisationRepo.UpdateTaxDetails(organisationID, gstin, strings.TrimSpace(billingAddress), stateCode)
+}
+
+func (u *organisationUsecaseImpl) FundOrganisation(organisationID uint, amount float64) (*models.Organisation, error) {
+    if amount <= 0 {
+        return nil, fmt.Errorf("amount must be greater than zero")
+    }
+    organisation, err := u.GetOrganisation(organisationID)
+    if err != nil {
+        return nil, err
+    }
+    if organisation.BillingMode != models.BillingModePrepaid {
+        return nil, fmt.Errorf("only prepaid organisations hold a pooled balance")
+    }
+    return u.organisationRepo.FundOrganisation(organisationID, roundAmount(amount))
+}
+
+func (u *organisationUsecaseImpl) SettleInvoice(organisationID, invoiceID uint) error {
+    if _, err := u.GetOrganisation(organisationID); err != nil {
+        return err
+    }
+    return u.organisationRepo.SettleInvoice(organisationID, invoiceID)
+}
+
+func (u *organisationUsecaseImpl) GetMemberships(userID uint) ([]models.OrganisationMember, error) {
+    return u.organisationRepo.GetMembershipsByUserID(userID)
+}
+
+// requireAdmin checks the actor administers the organisation.
+func (u *organisationUsecaseImpl) requireAdmin(actorID, organisationID uint) (*models.Organisation, error) {
+    organisation, err := u.GetOrganisation(organisationID)
+    if err != nil {
+        return nil, err
+    }
+    member, err := u.organisationRepo.GetMember(organisationID, actorID)
+    if err != nil || member.Role != models.OrganisationRoleAdmin {
+        return nil, ErrNotOrganisationAdmin
+    }
+    return organisation, nil
+}
+
+func (u *organisationUsecaseImpl) GetOrganisationForAdmin(actorID, organisationID uint) (*models.Organisation, error) {
+    return u.requireAdmin(actorID, organisationID)
+}
+
+func (u *organisationUsecaseImpl) GetMembers(actorID, organisationID uint) ([]models.OrganisationMember, error) {
+    if _, err := u.requireAdmin(actorID, organisationID); err != nil {
+        return nil, err
+    }
+    return u.organisationRepo.GetMembers(organisationID)
+}
+
+// InviteMember emails a one-time token the invitee uses to join.
+func (u *organisationUsecaseImpl) InviteMember(actorID, organisationID uint, email, role string) (*models.OrganisationInvite, error) {
+    organisation, err := u.requireAdmin(actorID, organisationID)
+    if err != nil {
+        return nil, err
+    }
+    email = strings.ToLower(strings.TrimSpace(email))
+    if emailDomain(email) == "" {
+        return nil, fmt.Errorf("a valid email address is required")
+    }
+    if role == "" {
+        role = models.OrganisationRoleEmployee
+    }
+    if role != models.OrganisationRoleEmployee && role != models.OrganisationRoleAdmin {
+        return nil, fmt.Errorf("role must be employee or admin")
+    }
+
+    tokenBytes := make([]byte, 24)
+    if _, err := rand.Read(tokenBytes); err != nil {
+        return nil, err
+    }
+    invite := &models.OrganisationInvite{
+        OrganisationID: organisationID,
+        Email:          email,
+        Token:          hex.EncodeToString(tokenBytes),
+        Role:           role,
+        Status:         models.InviteStatusPending,
+        InvitedBy:      actorID,
+        ExpiresAt:      time.Now().Add(OrganisationInviteValidity),
+    }
+    if err := u.organisationRepo.CreateInvite(invite); err != nil {
+        return nil, err
+    }
+
+    body := fmt.Sprintf("You have been invited to join %s on xtrace so your travel can be paid by your employer.\n\n"+
+        "Sign in with %s and accept the invite using this code:\n\n%s\n\nThe invite expires on %s.",
+        organisation.Name, email, invite.Token, invite.ExpiresAt.Format("02 Jan 2006"))
+    if err := utils.SendEmail(email, "Invitation to join "+organisation.Name, body); err != nil {
+        log.Printf("Failed to email invite %d to %s: %v", invite.InviteID, email, err)
+    }
+    return invite, nil
+}
+
+func (u *organisationUsecaseImpl) GetInvites(actorID, organisationID uint) ([]models.OrganisationInvite, error) {
+    if _, err := u.requireAdmin(actorID, organisationID); err != nil {
+        return nil, err
+    }
+    return u.organisationRepo.GetInvites(organisationID)
+}
+
+// AcceptInvite enrols the user if the invite was sent to their email address.
+func (u *organisationUsecaseImpl) AcceptInvite(userID uint, token string) (*models.OrganisationMember, error) {
+    invite, err := u.organisationRepo.GetInviteByToken(strings.TrimSpace(token))
+    if err != nil {
+        return nil, fmt.Errorf("invite not found")
+    }
+    if invite.Status != models.InviteStatusPending {
+        return nil, fmt.Errorf("invite is %s", invite.Status)
+    }
+    if time.Now().After(invite.ExpiresAt) {
+        return nil, fmt.Errorf("invite has expired")
+    }
+    user, err := u.userRepo.GetUserByID(userID)
+    if err != nil || user == nil {
+        return nil, fmt.Errorf("user not found")
+    }
+    if !strings.EqualFold(user.Email, invite.Email) {
+        return nil, fmt.Errorf("this invite was sent to a different email address")
+    }
+
+    member := &models.OrganisationMember{
+        OrganisationID: invite.OrganisationID,
+        UserID:         userID,
+        Role:           invite.Role,
+        JoinedVia:      "invite",
+    }
+    if err := u.organisationRepo.AcceptInvite(invite, member); err != nil {
+        return nil, err
+    }
+    return member, nil
+}
+
+// JoinByDomain enrols the user in the organisation that owns their email
+// domain. Only a verified address proves the user belongs to that domain.
+func (u *organisationUsecaseImpl) JoinByDomain(userID uint) (*models.OrganisationMember, error) {
+    user, err := u.userRepo.GetUserByID(userID)
+    if err != nil || user == nil {
+        return nil, fmt.Errorf("user not found")
+    }
+    if user.EmailVerifiedAt == nil {
+        return nil, ErrEmailNotVerified
+    }
+    domain := emailDomain(user.Email)
+    if domain == "" {
+        return nil, ErrOrganisationNotFound
+    }
+    organisation, err := u.organisationRepo.GetOrganisationByDomain(domain)
+    if err != nil {
+        return nil, ErrOrganisationNotFound
+    }
+    if member, err := u.organisationRepo.GetMember(organisation.OrganisationID, userID); err == nil {
+        return member, nil
+    }
+    member := &models.OrganisationMember{
+        OrganisationID: organisation.OrganisationID,
+        UserID:         userID,
+        Role:           models.OrganisationRoleEmployee,
+        JoinedVia:      "domain",
+    }
+    if err := u.organisationRepo.AddMember(member); err != nil {
+        return nil, err
+    }
+    return member, nil
+}
+
+func (u *organisationUsecaseImpl) RemoveMember(actorID, organisationID, memberUserID uint) error {
+    if _, err := u.requireAdmin(actorID, organisationID); err != nil {
+        return err
+    }
+    if actorID == memberUserID {
+        return fmt.Errorf("admins cannot remove themselves")
+    }
+    if err := u.organisationRepo.RemoveMember(organisationID, memberUserID); err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return ErrNotOrganisationMember
+        }
+        return err
+    }
+    return nil
+}
+
+// memberCard checks the member belongs to the organisation and owns a usable card.
+func (u *organisationUsecaseImpl) memberCard(organisationID, memberUserID uint, nolCardID int) (models.NolCard, error) {
+    if _, err := u.organisationRepo.GetMember(organisationID, memberUserID); err != nil {
+        return models.NolCard{}, ErrNotOrganisationMember
+    }
+    nolCard, err := u.nolCardRepo.GetNolCardByID(nolCardID)
+    if err != nil || uint(nolCard.UserID) != memberUserID {
+        return models.NolCard{}, ErrNolCardNotFound
+    }
+    if !nolCard.IsUsable() {
+        return models.NolCard{}, repository.ErrNolCardNotUsable
+    }
+    return nolCard, nil
+}
+
+// FundMemberSubscription buys a plan for a member's card on the organisation's account.
+func (u *organisationUsecaseImpl) FundMemberSubscription(actorID, organisationID, memberUserID, planID uint, nolCardID int) (*models.Subscription, error) {
+    if _, err := u.requireAdmin(actorID, organisationID); err != nil {
+        return nil, err
+    }
+    nolCard, err := u.memberCard(organisationID, memberUserID, nolCardID)
+    if err != nil {
+        return nil, err
+    }
+    plan, err := u.subscriptionRepo.GetSubscriptionPlan(planID)
+    if err != nil {
+        return nil, fmt.Errorf("invalid plan ID")
+    }
+    if cardType, ok := models.NormalizeCardType(nolCard.CardType); !ok || cardType != plan.CardType {
+        return nil, fmt.Errorf("plan is for %s cards, this card is %s", plan.CardType, nolCard.CardType)
+    }
+    active, err := u.subscriptionRepo.GetActiveSubscriptionByNolCardID(uint(nolCardID))
+    if err != nil {
+        return nil, err
+    }
+    if active != nil {
+        return nil, fmt.Errorf("this NolCard already has an active subscription")
+    }
+
+    now := time.Now()
+    subscription := &models.Subscription{
+        UserID:        memberUserID,
+        PlanID:        plan.PlanID,
+        NolCardID:     uint(nolCardID),
+        ServiceType:   plan.PlanName,
+        StartDate:     now,
+        EndDate:       now.AddDate(0, 0, plan.DurationDays),
+        Price:         plan.Price,
+        DurationDays:  plan.DurationDays,
+        CardType:      plan.CardType,
+        Status:        models.SubscriptionStatusActive,
+        RideAllowance: plan.RideAllowance,
+    }
+    charge := &models.OrganisationCharge{
+        OrganisationID: organisationID,
+        UserID:         memberUserID,
+        Type:           models.OrganisationChargeSubscription,
+        Amount:         plan.Price,
+        NolCardID:      nolCardID,
+        ActorID:        actorID,
+    }
+    if err := u.organisationRepo.FundSubscription(subscription, charge); err != nil {
+        return nil, err
+    }
+    return subscription, nil
+}
+
+// FundMemberTopup tops up a member's card on the organisation's account.
+func (u *organisationUsecaseImpl) FundMemberTopup(actorID, organisationID, memberUserID uint, nolCardID int, amount float64) (*models.NolCardTopup, error) {
+    if _, err := u.requireAdmin(actorID, organisationID); err != nil {
+        return nil, err
+    }
+    if amount <= 0 {
+        return nil, fmt.Errorf("amount must be greater than zero")
+    }
+    if _, err := u.memberCard(organisationID, memberUserID, nolCardID); err != nil {
+        return nil, err
+    }
+
+    amount = roundAmount(amount)
+    topup := &models.NolCardTopup{
+        NolCardID: nolCardID,
+        Amount:    amount,
+        TopupDate: time.Now(),
+    }
+    charge := &models.OrganisationCharge{
+        OrganisationID: organisationID,
+        UserID:         memberUserID,
+        Type:           models.OrganisationChargeTopup,
+        Amount:         amount,
+        NolCardID:      nolCardID,
+        ActorID:        actorID,
+    }
+    if err := u.organisationRepo.FundTopup(topup, charge); err != nil {
+        return nil, err
+    }
+    return topup, nil
+}
+
+// GetUsageReport totals what the organisation paid for each current member in
+// [from, to), along with the journeys they made.
+func (u *organisationUsecaseImpl) GetUsageReport(actorID, organisationID uint, from, to time.Time) ([]models.OrganisationUsage, error) {
+    if _, err := u.requireAdmin(actorID, organisationID); err != nil {
+        return nil, err
+    }
+    if !to.After(from) {
+        return nil, fmt.Errorf("to must be after from")
+    }
+    members, err := u.organisationRepo.GetMembers(organisationID)
+    if err != nil {
+        return nil, err
+    }
+    charges, err := u.organisationRepo.GetCharges(organisationID, from, to)
+    if err != nil {
+        return nil, err
+    }
+
+    usage := make(map[uint]*models.OrganisationUsage)
+    userIDs := make([]uint, 0, len(members))
+    line := func(userID uint) *models.OrganisationUsage {
+        if entry, ok := usage[userID]; ok {
+            return entry
+        }
+        entry := &models.OrganisationUsage{UserID: userID}
+        if user, err := u.userRepo.GetUserByID(userID); err == nil && user != nil {
+            entry.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+            entry.Email = user.Email
+        }
+        usage[userID] = entry
+        userIDs = append(userIDs, userID)
+        return entry
+    }
+    for _, member := range members {
+        line(member.UserID)
+    }
+    for _, charge := range charges {
+        entry := line(charge.UserID)
+        switch charge.Type {
+        case models.OrganisationChargeSubscription:
+            entry.Subscriptions++
+            entry.SubscriptionSpend = roundAmount(entry.SubscriptionSpend + charge.Amount)
+        case models.OrganisationChargeTopup:
+            entry.Topups++
+            entry.TopupSpend = roundAmount(entry.TopupSpend + charge.Amount)
+        }
+        entry.Total = roundAmount(entry.Total + charge.Amount)
+    }
+
+    totals, err := u.organisationRepo.GetJourneyTotals(userIDs, from, to)
+    if err != nil {
+        return nil, err
+    }
+    for _, total := range totals {
+        if entry, ok := usage[total.UserID]; ok {
+            entry.Journeys = total.Journeys
+            entry.JourneyFares = roundAmount(total.Fares)
+        }
+    }
+
+    report := make([]models.OrganisationUsage, 0, len(usage))
+    for _, entry := range usage {
+        report = append(report, *entry)
+    }
+    sort.Slice(report, func(i, j int) bool {
+        if report[i].Total != report[j].Total {
+            return report[i].Total > report[j].Total
+        }
+        return report[i].UserID < report[j].UserID
+    })
+    return report, nil
+}
+
+func (u *organisationUsecaseImpl) RenderUsageCSV(usage []models.OrganisationUsage) ([]byte, error) {
+    var buf bytes.Buffer
+    writer := csv.NewWriter(&buf)
+    rows := [][]string{{"User ID", "Name", "Email", "Subscriptions", "Subscription Spend", "Top-ups", "Top-up Spend", "Journeys", "Journey Fares", "Total"}}
+    for _, entry := range usage {
+        rows = append(rows, []string{
+            fmt.Sprintf("%d", entry.UserID),
+            entry.Name,
+            entry.Email,
+            fmt.Sprintf("%d", entry.Subscriptions),
+            fmt.Sprintf("%.2f", entry.SubscriptionSpend),
+            fmt.Sprintf("%d", entry.Topups),
+            fmt.Sprintf("%.2f", entry.TopupSpend),
+            fmt.Sprintf("%d", entry.Journeys),
+            fmt.Sprintf("%.2f", entry.JourneyFares),
+            fmt.Sprintf("%.2f", entry.Total),
+        })
+    }
+    if err := writer.WriteAll(rows); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+func (u *organisationUsecaseImpl) GetInvoices(actorID, organisationID uint) ([]models.Invoice, error) {
+    if _, err := u.requireAdmin(actorID, organisationID); err != nil {
+        return nil, err
+    }
+    return u.organisationRepo.GetInvoices(organisationID)
+}
+
+func (u *organisationUsecaseImpl) GetInvoice(actorID, organisationID, invoiceID uint) (*models.Invoice, []models.OrganisationCharge, error) {
+    if _, err := u.requireAdmin(actorID, organisationID); err != nil {
+        return nil, nil, err
+    }
+    invoice, err := u.organisationRepo.GetInvoice(organisationID, invoiceID)
+    if err != nil {
+        return nil, nil, fmt.Errorf("invoice not found")
+    }
+    charges, err := u.organisationRepo.GetChargesByInvoiceID(invoiceID)
+    if err != nil {
+        return nil, nil, err
+    }
+    return invoice, charges, nil
+}
+
+// GenerateMonthlyInvoices issues one consolidated invoice per organisation for
+// the previous calendar month. It bills every charge not yet on an invoice,
+// so charges recorded after an earlier run are picked up by the next one.
+// Prepaid spend was already taken from the pooled balance, so those invoices
+// are issued as paid; postpaid invoices are due.
+func (u *organisationUsecaseImpl) GenerateMonthlyInvoices(now time.Time) (int, error) {
+    to := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+    from := to.AddDate(0, -1, 0)
+    period := from.Format("2006-01")
+
+    organisations, err := u.organisationRepo.GetOrganisationsToInvoice(to)
+    if err != nil {
+        return 0, err
+    }
+
+    issued := 0
+    for _, organisation := range organisations {
+        charges, err := u.organisationRepo.GetUninvoicedCharges(organisation.OrganisationID, to)
+        if err != nil {
+            log.Printf("Organisation %d: failed to load %s charges: %v", organisation.OrganisationID, period, err)
+            continue
+        }
+        total := 0.0
+        byType := make(map[string]float64)
+        chargeIDs := make([]uint, 0, len(charges))
+        for _, charge := range charges {
+            total += charge.Amount
+            byType[charge.Type] += charge.Amount
+            chargeIDs = append(chargeIDs, charge.ChargeID)
+        }
+        if total <= 0 {
+            continue
+        }
+
+        members, err := u.organisationRepo.GetMembers(organisation.OrganisationID)
+        if err != nil {
+            log.Printf("Organisation %d: failed to load members: %v", organisation.OrganisationID, err)
+            continue
+        }
+        var billedUserID uint
+        for _, member := range members {
+            if member.Role == models.OrganisationRoleAdmin {
+                billedUserID = member.UserID
+                break
+            }
+        }
+
+        status := models.InvoiceStatusPaid
+        if organisation.BillingMode == models.BillingModePostpaid {
+            status = models.InvoiceStatusDue
+        }
+        organisationID := organisation.OrganisationID
+        invoice := &models.Invoice{
+            UserID:          billedUserID,
+            InvoiceDate:     now,
+            OriginalAmount:  roundAmount(total),
+            Amount:          roundAmount(total),
+            Currency:        models.BaseCurrency,
+            SettledAmount:   roundAmount(total),
+            SettledCurrency: models.BaseCurrency,
+            ExchangeRate:    1,
+            Status:          status,
+            PaymentType:     "organisation",
+            OrganisationID:  &organisationID,
+            Period:          period,
+        }
+        // The organisation, not the admin who receives it, is billed.
+        invoice.BuyerName = organisation.Name
+        invoice.BuyerEmail = organisation.BillingEmail
+        invoice.BuyerGSTIN = organisation.GSTIN
+        invoice.BuyerAddress = organisation.BillingAddress
+        invoice.BuyerStateCode = organisation.StateCode
+        var lines []models.InvoiceLine
+        for _, chargeType := range []string{models.OrganisationChargeSubscription, models.OrganisationChargeTopup} {
+            if amount := roundAmount(byType[chargeType]); amount > 0 {
+                lines = append(lines, models.InvoiceLine{ItemType: chargeType, Quantity: 1, UnitPrice: amount})
+            }
+        }
+        if err := u.invoiceUsecase.PrepareInvoice(invoice, lines); err != nil {
+            log.Printf("Organisation %d: failed to prepare %s invoice: %v", organisation.OrganisationID, period, err)
+            continue
+        }
+        if err := u.organisationRepo.CreateMonthlyInvoice(invoice, chargeIDs); err != nil {
+            log.Printf("Organisation %d: failed to create %s invoice: %v", organisation.OrganisationID, period, err)
+            continue
+        }
+        issued++
+        u.emailMonthlyInvoice(organisation, invoice, billedUserID)
+    }
+    return issued, nil
+}
+
+func (u *organisationUsecaseImpl) emailMonthlyInvoice(organisation models.Organisation, invoice *models.Invoice, billedUserID uint) {
+    recipient := organisation.BillingEmail
+    if recipient == "" && billedUserID != 0 {
+        if user, err := u.userRepo.GetUserByID(billedUserID); err == nil && user != nil {
+            recipient = user.Email
+        }
+    }
+    if recipient == "" {
+        return
+    }
+    body := fmt.Sprintf("Invoice %s for %s covers travel purchased for your members in %s.\n\nAmount: %.2f %s\nStatus: %s",
+        *invoice.InvoiceNumber, organisation.Name, invoice.Period, invoice.Amount, invoice.Currency, invoice.Status)
+    if err := u.invoiceUsecase.EmailInvoice(invoice, recipient, fmt.Sprintf("%s invoice for %s", organisation.Name, invoice.Period), body); err != nil {
+        log.Printf("Organisation %d: failed to email invoice %d: %v", organisation.OrganisationID, invoice.InvoiceID, err)
+    }
+}
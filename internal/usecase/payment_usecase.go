@@ -17,6 +17,9 @@ import (
 	"github.com/razorpay/razorpay-go"
 )
 
+// ErrRefundNotEligible is returned when the gateway holds no captured money to refund.
+var ErrRefundNotEligible = errors.New("payment is not eligible for refund")
+
 type RazorpayPaymentUsecase interface {
     CreatePayment(userID uint, amount float64, currency string, couponCode string, paymentType string, walletID *uint, nolCardID *uint, subscriptionID *uint, bookingID *uint, orderID string) (*models.RazorpayPayment, error)
 
@@ -30,11 +33,17 @@ type RazorpayPaymentUsecase interface {
     GetPaymentByRazorpayID(razorpayID string) (*models.RazorpayPayment, error)
     GetExistingPayment(userID uint, paymentType string, walletID, nolCardID, subscriptionID, bookingID *uint) (*models.RazorpayPayment, error)
 
-    ApplyCoupon(couponCode string, amount float64) (float64, error)
+    ApplyCoupon(couponCode string, amount float64, target models.CouponTarget) (float64, error)
     fetchCoupon(couponCode string) (*models.Coupon, error)
     GetApplicableCoupons(paymentType string) ([]*models.Coupon, error)
+    ReserveCoupon(couponCode string, amount float64, target models.CouponTarget) (*models.CouponRedemption, error)
+    AttachCouponReservation(redemptionID, paymentID uint, orderID string) error
+    ReleaseCouponReservation(redemptionID uint) error
+    ReleaseCouponForOrder(orderID string) error
+    ReleaseStaleCouponReservations(reservedBefore time.Time) (int64, error)
     
     ProcessRefund(paymentID string) error 
+    ProcessPartialRefund(paymentID string, amount float64) error
 }
 
 type razorpayPaymentUsecaseImpl struct {
@@ -42,14 +51,20 @@ type razorpayPaymentUsecaseImpl struct {
     client       *razorpay.Client
     keySecret    string 
     couponRepo   repository.CouponRepository
+    exchangeRateRepo repository.ExchangeRateRepository
+    loyaltyRepo      repository.LoyaltyRepository
+    invoiceUsecase   InvoiceUsecase
 }
 
-func NewRazorpayPaymentUsecase(razorpayRepo repository.RazorpayPaymentRepository, client *razorpay.Client, couponRepo repository.CouponRepository) RazorpayPaymentUsecase {
+func NewRazorpayPaymentUsecase(razorpayRepo repository.RazorpayPaymentRepository, client *razorpay.Client, couponRepo repository.CouponRepository, exchangeRateRepo repository.ExchangeRateRepository, loyaltyRepo repository.LoyaltyRepository, invoiceUsecase InvoiceUsecase) RazorpayPaymentUsecase {
     return &razorpayPaymentUsecaseImpl{
         razorpayRepo: razorpayRepo, 
         client:       client,
         keySecret:    os.Getenv("RAZORPAY_KEY_SECRET"), 
         couponRepo:   couponRepo,
+        exchangeRateRepo: exchangeRateRepo,
+        loyaltyRepo:      loyaltyRepo,
+        invoiceUsecase:   invoiceUsecase,
     }
 }
 
@@ -68,27 +83,21 @@ func (u *razorpayPaymentUsecaseImpl) CreatePayment(userID uint, amount float64,
         return nil, errors.New("order ID cannot be empty")
     }
 
+    // The amount already has the coupon discount applied; only link the coupon here.
+    var couponID *uint
     if couponCode != "" {
-        isValid, err := u.couponRepo.IsCouponValid(couponCode)
-        if err != nil {
-            return nil, fmt.Errorf("error validating coupon: %v", err)
-        }
-        if !isValid {
-            return nil, errors.New("invalid or expired coupon")
-        }
-
         coupon, err := u.couponRepo.GetCouponByCode(couponCode)
         if err != nil {
             return nil, fmt.Errorf("error fetching coupon details: %v", err)
         }
+        id := uint(coupon.CouponID)
+        couponID = &id
+    }
 
-        discount := (coupon.DiscountAmount / 100) * amount
-        amount -= discount 
-        if amount < 0 {
-            amount = 0
-        }
-
-        log.Printf("Discount applied: %.2f, Final amount: %.2f", discount, amount)
+    // Amounts are charged in the checkout currency but settled and credited in the base currency.
+    exchangeRate, err := lookupExchangeRate(u.exchangeRateRepo, currency)
+    if err != nil {
+        return nil, err
     }
 
     var walletIDPtr, nolCardIDPtr, subscriptionIDPtr, bookingIDPtr *uint
@@ -112,10 +121,14 @@ func (u *razorpayPaymentUsecaseImpl) CreatePayment(userID uint, amount float64,
         OrderID:        orderID,
         Amount:         amount,
         Currency:       currency,
-        Status:         "created",
+        SettledAmount:   roundAmount(amount * exchangeRate),
+        SettledCurrency: models.BaseCurrency,
+        ExchangeRate:    exchangeRate,
+        Status:         models.PaymentStatusCreated,
         Method:         "razorpay",
         PaymentType:    paymentType,
         CouponCode:     couponCode,
+        CouponID:       couponID,
         WalletID:       walletIDPtr,
         NolCardID:      nolCardIDPtr,
         SubscriptionID: subscriptionIDPtr,
@@ -127,7 +140,7 @@ func (u *razorpayPaymentUsecaseImpl) CreatePayment(userID uint, amount float64,
 
     log.Printf("Saving payment to DB: %v", payment)
 
-    err := u.razorpayRepo.CreatePayment(payment)
+    err = u.razorpayRepo.CreatePayment(payment)
     if err != nil {
         log.Printf("Error saving payment to database: %v", err)
         return nil, err
@@ -162,9 +175,26 @@ log.Printf("Payment details from Razorpay: %v", paymentDetails)
     if paymentDetails["status"] != "captured" {
         return errors.New("payment not captured")
     }
+    // Points returned as stale while the customer was paying are held again;
+    // if they have been spent since, the discounted payment is rejected.
+    if err := u.loyaltyRepo.RestoreRedemptionByOrderID(razorpayOrderID); err != nil {
+        if errors.Is(err, repository.ErrInsufficientPoints) {
+            return fmt.Errorf("loyalty points can no longer be applied: %w", err)
+        }
+        log.Printf("Error restoring loyalty redemption for order ID %s: %v", razorpayOrderID, err)
+    }
+    // A coupon released as stale while the customer was paying may have been
+    // used up since; the discounted payment is rejected rather than going
+    // over the coupon's limit.
+    if err := u.couponRepo.ConfirmRedemption(razorpayOrderID); err != nil {
+        if errors.Is(err, repository.ErrCouponLimitReached) || errors.Is(err, repository.ErrCouponUserLimitReached) {
+            return fmt.Errorf("coupon can no longer be applied: %w", err)
+        }
+        log.Printf("Error confirming coupon redemption for order ID %s: %v", razorpayOrderID, err)
+    }
     log.Printf("Updating payment status in database for Order ID: %s and Payment ID: %s", razorpayOrderID, razorpayPaymentID)
  
-    err = u.razorpayRepo.UpdatePaymentStatus(paymentDetails["order_id"].(string), razorpayPaymentID, "verified") 
+    err = u.razorpayRepo.UpdatePaymentStatus(paymentDetails["order_id"].(string), razorpayPaymentID, models.PaymentStatusVerified) 
 if err != nil {
     log.Printf("Error updating payment status for order ID %s: %v", paymentDetails["order_id"].(string), err)
     return err
@@ -260,27 +290,145 @@ func (u *razorpayPaymentUsecaseImpl) CreateRazorpayOrder(amount float64, currenc
     return razorpayOrderID, nil
 }
 
-func (u *razorpayPaymentUsecaseImpl) ApplyCoupon(couponCode string, amount float64) (float64, error) {
+// ApplyCoupon checks the coupon's dates and eligibility rules for the purchase
+// and returns the discount. Usage limits are counted here for a quick answer
+// but only enforced atomically by ReserveCoupon.
+func (u *razorpayPaymentUsecaseImpl) ApplyCoupon(couponCode string, amount float64, target models.CouponTarget) (float64, error) {
     coupon, err := u.fetchCoupon(couponCode)
     if err != nil {
-        return 0, err 
+        return 0, errors.New("invalid or expired coupon")
+    }
+    if err := u.checkCouponEligibility(coupon, amount, target); err != nil {
+        return 0, err
     }
+    return couponDiscount(coupon, amount), nil
+}
 
+func (u *razorpayPaymentUsecaseImpl) checkCouponEligibility(coupon *models.Coupon, amount float64, target models.CouponTarget) error {
     if !isCouponValid(coupon) {
-        return 0, errors.New("invalid or expired coupon")
+        return errors.New("invalid or expired coupon")
+    }
+    if coupon.CampaignID != nil {
+        active, err := u.couponRepo.IsCampaignActive(*coupon.CampaignID)
+        if err != nil || !active {
+            return errors.New("invalid or expired coupon")
+        }
+    }
+    if coupon.PaymentType != "" && target.PaymentType != "" && coupon.PaymentType != target.PaymentType {
+        return fmt.Errorf("coupon is only valid for %s payments", coupon.PaymentType)
+    }
+    if coupon.MinOrderAmount > 0 && amount < coupon.MinOrderAmount {
+        return fmt.Errorf("coupon requires a minimum order of %.2f", coupon.MinOrderAmount)
+    }
+    if coupon.CardType != "" {
+        cardType, _ := models.NormalizeCardType(target.CardType)
+        if cardType != coupon.CardType {
+            return fmt.Errorf("coupon is only valid for %s cards", coupon.CardType)
+        }
     }
+    if coupon.CategoryID != nil {
+        categoryID := target.CategoryID
+        if categoryID == nil && target.RouteID != 0 {
+            if id, err := u.couponRepo.GetRouteCategoryID(target.RouteID); err == nil {
+                categoryID = &id
+            }
+        }
+        if categoryID == nil || *categoryID != *coupon.CategoryID {
+            return errors.New("coupon is not valid for this route category")
+        }
+    }
+
+    if coupon.UsageLimit > 0 {
+        used, err := u.couponRepo.CountRedemptions(coupon.CouponID, 0)
+        if err != nil {
+            return err
+        }
+        if used >= int64(coupon.UsageLimit) {
+            return repository.ErrCouponLimitReached
+        }
+    }
+    if target.UserID == 0 {
+        return nil
+    }
+    if coupon.PerUserLimit > 0 {
+        used, err := u.couponRepo.CountRedemptions(coupon.CouponID, target.UserID)
+        if err != nil {
+            return err
+        }
+        if used >= int64(coupon.PerUserLimit) {
+            return repository.ErrCouponUserLimitReached
+        }
+    }
+    if coupon.FirstRideOnly {
+        travelled, err := u.couponRepo.HasTravelled(target.UserID)
+        if err != nil {
+            return err
+        }
+        if travelled {
+            return errors.New("coupon is only valid on your first ride")
+        }
+    }
+    return nil
+}
+
+// couponDiscount applies the coupon's type and cap, never exceeding the amount.
+func couponDiscount(coupon *models.Coupon, amount float64) float64 {
     var discount float64
-    if coupon.DiscountType == "fixed" {
+    switch coupon.DiscountType {
+    case models.DiscountTypeFixed:
         discount = coupon.DiscountAmount
-    } else if coupon.DiscountType == "percentage" {
+    case models.DiscountTypePercentage:
         discount = (amount * coupon.DiscountAmount) / 100
     }
-
+    if coupon.MaxDiscount > 0 && discount > coupon.MaxDiscount {
+        discount = coupon.MaxDiscount
+    }
     if discount > amount {
         discount = amount
     }
+    return roundAmount(discount)
+}
 
-    return discount, nil
+// ReserveCoupon re-checks eligibility and holds a redemption slot for the
+// payment being created. The caller attaches the payment once it exists and
+// releases the slot if creating it fails.
+func (u *razorpayPaymentUsecaseImpl) ReserveCoupon(couponCode string, amount float64, target models.CouponTarget) (*models.CouponRedemption, error) {
+    if target.UserID == 0 {
+        return nil, errors.New("invalid user ID")
+    }
+    coupon, err := u.fetchCoupon(couponCode)
+    if err != nil {
+        return nil, errors.New("invalid or expired coupon")
+    }
+    if err := u.checkCouponEligibility(coupon, amount, target); err != nil {
+        return nil, err
+    }
+    redemption := &models.CouponRedemption{
+        CouponID:    coupon.CouponID,
+        UserID:      target.UserID,
+        OrderAmount: amount,
+        Discount:    couponDiscount(coupon, amount),
+    }
+    if err := u.couponRepo.ReserveRedemption(redemption); err != nil {
+        return nil, err
+    }
+    return redemption, nil
+}
+
+func (u *razorpayPaymentUsecaseImpl) AttachCouponReservation(redemptionID, paymentID uint, orderID string) error {
+    return u.couponRepo.AttachRedemption(redemptionID, paymentID, orderID)
+}
+
+func (u *razorpayPaymentUsecaseImpl) ReleaseCouponReservation(redemptionID uint) error {
+    return u.couponRepo.ReleaseRedemption(redemptionID)
+}
+
+func (u *razorpayPaymentUsecaseImpl) ReleaseCouponForOrder(orderID string) error {
+    return u.couponRepo.ReleaseRedemptionByOrderID(orderID)
+}
+
+func (u *razorpayPaymentUsecaseImpl) ReleaseStaleCouponReservations(reservedBefore time.Time) (int64, error) {
+    return u.couponRepo.ReleaseStaleRedemptions(reservedBefore)
 }
 
 func (u *razorpayPaymentUsecaseImpl) fetchCoupon(couponCode string) (*models.Coupon, error) {
@@ -303,7 +451,7 @@ func (u *razorpayPaymentUsecaseImpl) ProcessRefund(paymentID string) error {
     }
 
     if paymentDetails["status"] != "captured" {
-        return errors.New("payment is not eligible for refund")
+        return ErrRefundNotEligible
     }
 
     refundRequest := map[string]interface{}{
@@ -315,5 +463,50 @@ func (u *razorpayPaymentUsecaseImpl) ProcessRefund(paymentID string) error {
         return fmt.Errorf("failed to create refund: %v", err)
     }
 
+    payment, err := u.razorpayRepo.GetPaymentByRazorpayID(paymentID)
+    if err != nil || payment == nil {
+        log.Printf("Refunded payment %s but failed to record the refund: %v", paymentID, err)
+        return nil
+    }
+    refunded := payment.Amount - payment.RefundedAmount
+    if err := u.razorpayRepo.RecordRefund(paymentID, refunded); err != nil {
+        log.Printf("Refunded payment %s but failed to record the refund: %v", paymentID, err)
+    }
+    if refunded > 0 {
+        if _, err := u.invoiceUsecase.IssuePaymentCreditNote(payment.PaymentID, refunded, "Payment refunded"); err != nil && !errors.Is(err, ErrInvoiceNotFound) {
+            log.Printf("Refunded payment %s but no credit note was issued: %v", paymentID, err)
+        }
+    }
+    return nil
+}
+
+// ProcessPartialRefund refunds part of a captured payment, e.g. the unused
+// days of a cancelled subscription.
+func (u *razorpayPaymentUsecaseImpl) ProcessPartialRefund(paymentID string, amount float64) error {
+    if amount <= 0 {
+        return errors.New("refund amount must be greater than zero")
+    }
+    paymentDetails, err := u.client.Payment.Fetch(paymentID, nil, nil)
+    if err != nil {
+        return fmt.Errorf("failed to fetch payment details: %v", err)
+    }
+
+    if paymentDetails["status"] != "captured" {
+        return ErrRefundNotEligible
+    }
+
+    refundRequest := map[string]interface{}{
+        "payment_id": paymentID,
+        "amount":     int(amount * 100),
+    }
+
+    _, err = u.client.Refund.Create(refundRequest, nil)
+    if err != nil {
+        return fmt.Errorf("failed to create refund: %v", err)
+    }
+
+    if err := u.razorpayRepo.RecordRefund(paymentID, amount); err != nil {
+        log.Printf("Refunded %.2f on payment %s but failed to record the refund: %v", amount, paymentID, err)
+    }
     return nil
 }
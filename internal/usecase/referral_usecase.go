@@ -0,0 +1,329 @@
+package usecase
+
+import (
+    "crypto/rand"
+    "errors"
+    "fmt"
+    "math/big"
+    "strings"
+    "time"
+    "unicode"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "gorm.io/gorm"
+)
+
+var (
+    ErrInvalidReferralCode       = errors.New("invalid referral code")
+    ErrAlreadyReferred           = errors.New("user has already been referred")
+    ErrReferralProgrammeInactive = errors.New("referral programme is not active")
+)
+
+// Unambiguous characters for the random part of a referral code.
+const referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Free mail providers are exempt from the per-domain limit; for them the
+// device limit applies instead.
+var publicEmailDomains = map[string]bool{
+    "gmail.com":      true,
+    "googlemail.com": true,
+    "yahoo.com":      true,
+    "outlook.com":    true,
+    "hotmail.com":    true,
+    "live.com":       true,
+    "icloud.com":     true,
+    "proton.me":      true,
+}
+
+type ReferralUsecase interface {
+    GetOrCreateCode(userID uint) (*models.ReferralCode, error)
+    AttributeSignup(referee *models.User, code, deviceID string) (*models.Referral, error)
+    ActivateReferral(refereeID uint) error
+    RewardFirstBooking(payment *models.RazorpayPayment) error
+    RejectReferral(referralID uint, reason string) error
+    GetReferralsByReferrer(referrerID uint) ([]models.Referral, error)
+    GetReferrals(status string) ([]models.Referral, error)
+    GetProgramme() (*models.ReferralProgramme, error)
+    UpdateProgramme(programme *models.ReferralProgramme) error
+}
+
+type referralUsecaseImpl struct {
+    referralRepo repository.ReferralRepository
+    userRepo     repository.UserRepository
+}
+
+func NewReferralUsecase(referralRepo repository.ReferralRepository, userRepo repository.UserRepository) ReferralUsecase {
+    return &referralUsecaseImpl{
+        referralRepo: referralRepo,
+        userRepo:     userRepo,
+    }
+}
+
+func (u *referralUsecaseImpl) GetOrCreateCode(userID uint) (*models.ReferralCode, error) {
+    existing, err := u.referralRepo.GetCodeByUserID(userID)
+    if err == nil {
+        return existing, nil
+    }
+    if !errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, err
+    }
+    user, err := u.userRepo.GetUserByID(userID)
+    if err != nil {
+        return nil, fmt.Errorf("user not found")
+    }
+
+    prefix := referralCodePrefix(user.FirstName)
+    for attempt := 0; attempt < 5; attempt++ {
+        suffix, err := randomReferralSuffix(6)
+        if err != nil {
+            return nil, err
+        }
+        candidate := prefix + suffix
+        if _, err := u.referralRepo.GetCodeByCode(candidate); err == nil {
+            continue
+        }
+        code := &models.ReferralCode{UserID: userID, Code: candidate}
+        if err := u.referralRepo.CreateCode(code); err != nil {
+            return nil, err
+        }
+        return code, nil
+    }
+    return nil, fmt.Errorf("could not generate a unique referral code")
+}
+
+// AttributeSignup records that referee signed up with code. A referral that
+// trips a fraud guard is still stored, as rejected with the reason, so it
+// can be reviewed; only lookup failures are returned as errors.
+func (u *referralUsecaseImpl) AttributeSignup(referee *models.User, code, deviceID string) (*models.Referral, error) {
+    code = strings.ToUpper(strings.TrimSpace(code))
+    if code == "" {
+        return nil, ErrInvalidReferralCode
+    }
+    if _, err := u.referralRepo.GetReferralByRefereeID(referee.ID); err == nil {
+        return nil, ErrAlreadyReferred
+    }
+    programme, err := u.referralRepo.GetProgramme()
+    if err != nil {
+        return nil, err
+    }
+    if !programme.Active {
+        return nil, ErrReferralProgrammeInactive
+    }
+    referralCode, err := u.referralRepo.GetCodeByCode(code)
+    if err != nil {
+        return nil, ErrInvalidReferralCode
+    }
+    referrer, err := u.userRepo.GetUserByID(referralCode.UserID)
+    if err != nil {
+        return nil, ErrInvalidReferralCode
+    }
+
+    referral := &models.Referral{
+        ReferrerID:  referrer.ID,
+        RefereeID:   referee.ID,
+        Code:        code,
+        Status:      models.ReferralStatusPending,
+        DeviceID:    strings.TrimSpace(deviceID),
+        EmailDomain: emailDomain(referee.Email),
+    }
+    reason, err := u.fraudCheck(programme, referrer, referee, referral)
+    if err != nil {
+        return nil, err
+    }
+    if reason != "" {
+        referral.Status = models.ReferralStatusRejected
+        referral.RejectReason = reason
+    }
+    if err := u.referralRepo.CreateReferral(referral); err != nil {
+        return nil, err
+    }
+    return referral, nil
+}
+
+// fraudCheck returns why the referral must be rejected, or "" if it may proceed.
+func (u *referralUsecaseImpl) fraudCheck(programme *models.ReferralProgramme, referrer, referee *models.User, referral *models.Referral) (string, error) {
+    if referrer.ID == referee.ID || normalizeEmail(referrer.Email) == normalizeEmail(referee.Email) {
+        return "self-referral", nil
+    }
+    if referral.DeviceID != "" {
+        // The referrer's own signup device, if they were referred themselves.
+        if own, err := u.referralRepo.GetReferralByRefereeID(referrer.ID); err == nil && own.DeviceID == referral.DeviceID {
+            return "self-referral: same device as referrer", nil
+        }
+        if programme.MaxReferralsPerDevice > 0 {
+            count, err := u.referralRepo.CountReferralsByDevice(referral.DeviceID)
+            if err != nil {
+                return "", err
+            }
+            if count >= int64(programme.MaxReferralsPerDevice) {
+                return "device referral limit reached", nil
+            }
+        }
+    }
+    if programme.MaxReferralsPerDomain > 0 && referral.EmailDomain != "" && !publicEmailDomains[referral.EmailDomain] {
+        count, err := u.referralRepo.CountReferralsByDomain(referrer.ID, referral.EmailDomain)
+        if err != nil {
+            return "", err
+        }
+        if count >= int64(programme.MaxReferralsPerDomain) {
+            return "email domain referral limit reached", nil
+        }
+    }
+    return "", nil
+}
+
+// ActivateReferral marks the referee's pending referral as verified once they
+// confirm their OTP. Users without a referral are ignored.
+func (u *referralUsecaseImpl) ActivateReferral(refereeID uint) error {
+    referral, err := u.referralRepo.GetReferralByRefereeID(refereeID)
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil
+        }
+        return err
+    }
+    if referral.Status != models.ReferralStatusPending {
+        return nil
+    }
+    err = u.referralRepo.UpdateStatus(referral.ReferralID, models.ReferralStatusPending, models.ReferralStatusVerified, "")
+    if errors.Is(err, repository.ErrReferralNotEligible) {
+        return nil
+    }
+    return err
+}
+
+// RewardFirstBooking credits both wallets when the referee's first qualifying
+// booking payment is verified. It is a no-op for every other payment.
+func (u *referralUsecaseImpl) RewardFirstBooking(payment *models.RazorpayPayment) error {
+    if payment.PaymentType != "booking" || payment.Status != models.PaymentStatusVerified {
+        return nil
+    }
+    referral, err := u.referralRepo.GetReferralByRefereeID(payment.UserID)
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return nil
+        }
+        return err
+    }
+    if referral.Status != models.ReferralStatusVerified {
+        return nil
+    }
+    programme, err := u.referralRepo.GetProgramme()
+    if err != nil {
+        return err
+    }
+    // The minimum is in the base currency; older payments have no settled amount.
+    settled := payment.SettledAmount
+    if settled <= 0 {
+        settled = payment.Amount
+    }
+    if !programme.Active || settled < programme.MinBookingAmount {
+        return nil
+    }
+    if programme.RewardWindowDays > 0 && time.Since(referral.CreatedAt) > time.Duration(programme.RewardWindowDays)*24*time.Hour {
+        err := u.referralRepo.UpdateStatus(referral.ReferralID, models.ReferralStatusVerified, models.ReferralStatusRejected, "reward window expired")
+        if errors.Is(err, repository.ErrReferralNotEligible) {
+            return nil
+        }
+        return err
+    }
+
+    referrerReward := roundAmount(programme.ReferrerReward)
+    refereeReward := roundAmount(programme.RefereeReward)
+    if err := u.referralRepo.MarkRewarded(referral, payment.PaymentID, referrerReward, refereeReward); err != nil {
+        if errors.Is(err, repository.ErrReferralNotEligible) {
+            return nil
+        }
+        return err
+    }
+    return nil
+}
+
+// RejectReferral lets an admin stop a referral that has not been rewarded yet.
+func (u *referralUsecaseImpl) RejectReferral(referralID uint, reason string) error {
+    if strings.TrimSpace(reason) == "" {
+        reason = "rejected by admin"
+    }
+    for _, status := range []string{models.ReferralStatusPending, models.ReferralStatusVerified} {
+        err := u.referralRepo.UpdateStatus(referralID, status, models.ReferralStatusRejected, reason)
+        if err == nil {
+            return nil
+        }
+        if !errors.Is(err, repository.ErrReferralNotEligible) {
+            return err
+        }
+    }
+    return repository.ErrReferralNotEligible
+}
+
+func (u *referralUsecaseImpl) GetReferralsByReferrer(referrerID uint) ([]models.Referral, error) {
+    return u.referralRepo.GetReferralsByReferrerID(referrerID)
+}
+
+func (u *referralUsecaseImpl) GetReferrals(status string) ([]models.Referral, error) {
+    return u.referralRepo.GetReferrals(status)
+}
+
+func (u *referralUsecaseImpl) GetProgramme() (*models.ReferralProgramme, error) {
+    return u.referralRepo.GetProgramme()
+}
+
+func (u *referralUsecaseImpl) UpdateProgramme(programme *models.ReferralProgramme) error {
+    if programme.ReferrerReward < 0 || programme.RefereeReward < 0 || programme.MinBookingAmount < 0 {
+        return fmt.Errorf("rewards and minimum booking amount cannot be negative")
+    }
+    if programme.RewardWindowDays < 0 || programme.MaxReferralsPerDevice < 0 || programme.MaxReferralsPerDomain < 0 {
+        return fmt.Errorf("limits cannot be negative")
+    }
+    return u.referralRepo.UpdateProgramme(programme)
+}
+
+// referralCodePrefix takes up to four letters of the first name, e.g. "ANNA".
+func referralCodePrefix(firstName string) string {
+    var prefix []rune
+    for _, r := range strings.ToUpper(firstName) {
+        if r < unicode.MaxASCII && unicode.IsLetter(r) {
+            prefix = append(prefix, r)
+        }
+        if len(prefix) == 4 {
+            break
+        }
+    }
+    if len(prefix) == 0 {
+        return "XT"
+    }
+    return string(prefix)
+}
+
+func randomReferralSuffix(length int) (string, error) {
+    suffix := make([]byte, length)
+    max := big.NewInt(int64(len(referralCodeAlphabet)))
+    for i := range suffix {
+        n, err := rand.Int(rand.Reader, max)
+        if err != nil {
+            return "", err
+        }
+        suffix[i] = referralCodeAlphabet[n.Int64()]
+    }
+    return string(suffix), nil
+}
+
+// normalizeEmail maps aliases of one mailbox to the same address: it drops
+// "+tag" suffixes and, for Gmail, dots in the local part.
+func normalizeEmail(email string) string {
+    email = strings.ToLower(strings.TrimSpace(email))
+    at := strings.LastIndex(email, "@")
+    if at < 0 {
+        return email
+    }
+    local, domain := email[:at], email[at+1:]
+    if plus := strings.Index(local, "+"); plus >= 0 {
+        local = local[:plus]
+    }
+    if domain == "gmail.com" || domain == "googlemail.com" {
+        local = strings.ReplaceAll(local, ".", "")
+        domain = "gmail.com"
+    }
+    return local + "@" + domain
+}
@@ -0,0 +1,513 @@
+package usecase
+
+import (
+    "bytes"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "log"
+    "net/mail"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "github.com/Prototype-1/xtrace/pkg/utils"
+    "github.com/jung-kurt/gofpdf"
+)
+
+// ExpiringSubscriptionDays is how far ahead the expiring subscriptions report looks.
+const ExpiringSubscriptionDays = 7
+
+// MaxReportRuns caps the runs returned in one list.
+const MaxReportRuns = 200
+
+var ErrReportOutputMissing = errors.New("report output is not available")
+
+type ReportUsecase interface {
+    CreateSchedule(schedule *models.ReportSchedule) error
+    GetSchedules() ([]models.ReportSchedule, error)
+    UpdateSchedule(schedule *models.ReportSchedule) error
+    DeleteSchedule(scheduleID uint) error
+
+    // RunSchedule generates and emails a schedule's report now, without
+    // moving its next run.
+    RunSchedule(scheduleID uint) (*models.ReportRun, error)
+    // RunDueSchedules runs every active schedule whose time has come and
+    // returns how many ran.
+    RunDueSchedules(now time.Time) (int, error)
+
+    GetRuns(scheduleID uint, limit int) ([]models.ReportRun, error)
+    // GetRunFile returns the path of a run's csv or pdf output.
+    GetRunFile(runID uint, format string) (string, error)
+}
+
+type reportUsecaseImpl struct {
+    reportRepo       repository.ReportRepository
+    userRepo         repository.UserRepository
+    revenueUsecase   RevenueUsecase
+    ridershipUsecase RidershipUsecase
+    outputDir        string
+}
+
+func NewReportUsecase(reportRepo repository.ReportRepository, userRepo repository.UserRepository, revenueUsecase RevenueUsecase, ridershipUsecase RidershipUsecase) ReportUsecase {
+    outputDir := os.Getenv("REPORT_OUTPUT_DIR")
+    if outputDir == "" {
+        outputDir = "reports"
+    }
+    return &reportUsecaseImpl{
+        reportRepo:       reportRepo,
+        userRepo:         userRepo,
+        revenueUsecase:   revenueUsecase,
+        ridershipUsecase: ridershipUsecase,
+        outputDir:        outputDir,
+    }
+}
+
+// prepareSchedule validates a schedule, tidies its recipients (defaulting to
+// the admin's own address) and sets its next run.
+func (u *reportUsecaseImpl) prepareSchedule(schedule *models.ReportSchedule, now time.Time) error {
+    valid := false
+    for _, reportType := range models.ReportTypes {
+        if schedule.ReportType == reportType {
+            valid = true
+            break
+        }
+    }
+    if !valid {
+        return fmt.Errorf("report type must be one of %s", strings.Join(models.ReportTypes, ", "))
+    }
+    if schedule.Period == "" {
+        schedule.Period = models.ReportPeriodDay
+    }
+    if schedule.Period != models.ReportPeriodDay && schedule.Period != models.ReportPeriodWeek && schedule.Period != models.ReportPeriodMonth {
+        return errors.New("period must be day, week or month")
+    }
+    cron, err := utils.ParseCron(schedule.Cron)
+    if err != nil {
+        return err
+    }
+    next := cron.Next(now)
+    if next.IsZero() {
+        return errors.New("cron expression never fires")
+    }
+    schedule.Cron = strings.TrimSpace(schedule.Cron)
+
+    var recipients []string
+    for _, address := range strings.Split(schedule.Recipients, ",") {
+        address = strings.TrimSpace(address)
+        if address == "" {
+            continue
+        }
+        if _, err := mail.ParseAddress(address); err != nil {
+            return fmt.Errorf("invalid recipient %q", address)
+        }
+        recipients = append(recipients, address)
+    }
+    if len(recipients) == 0 {
+        admin, err := u.userRepo.GetUserByID(schedule.AdminID)
+        if err != nil {
+            return errors.New("recipients are required")
+        }
+        recipients = append(recipients, admin.Email)
+    }
+    schedule.Recipients = strings.Join(recipients, ",")
+    schedule.NextRunAt = &next
+    return nil
+}
+
+func (u *reportUsecaseImpl) CreateSchedule(schedule *models.ReportSchedule) error {
+    if err := u.prepareSchedule(schedule, time.Now()); err != nil {
+        return err
+    }
+    return u.reportRepo.CreateSchedule(schedule)
+}
+
+func (u *reportUsecaseImpl) GetSchedules() ([]models.ReportSchedule, error) {
+    return u.reportRepo.GetSchedules()
+}
+
+// UpdateSchedule replaces the editable fields of an existing schedule.
+func (u *reportUsecaseImpl) UpdateSchedule(schedule *models.ReportSchedule) error {
+    existing, err := u.reportRepo.GetScheduleByID(schedule.ScheduleID)
+    if err != nil {
+        return err
+    }
+    existing.ReportType = schedule.ReportType
+    existing.Cron = schedule.Cron
+    existing.Period = schedule.Period
+    existing.Recipients = schedule.Recipients
+    existing.Active = schedule.Active
+    if err := u.prepareSchedule(existing, time.Now()); err != nil {
+        return err
+    }
+    if err := u.reportRepo.UpdateSchedule(existing); err != nil {
+        return err
+    }
+    *schedule = *existing
+    return nil
+}
+
+func (u *reportUsecaseImpl) DeleteSchedule(scheduleID uint) error {
+    return u.reportRepo.DeleteSchedule(scheduleID)
+}
+
+func (u *reportUsecaseImpl) RunSchedule(scheduleID uint) (*models.ReportRun, error) {
+    schedule, err := u.reportRepo.GetScheduleByID(scheduleID)
+    if err != nil {
+        return nil, err
+    }
+    return u.run(schedule, time.Now())
+}
+
+func (u *reportUsecaseImpl) RunDueSchedules(now time.Time) (int, error) {
+    schedules, err := u.reportRepo.GetDueSchedules(now)
+    if err != nil {
+        return 0, err
+    }
+    ran := 0
+    for i := range schedules {
+        schedule := &schedules[i]
+        var next *time.Time
+        if cron, err := utils.ParseCron(schedule.Cron); err == nil {
+            if t := cron.Next(now); !t.IsZero() {
+                next = &t
+            }
+        }
+        claimed, err := u.reportRepo.ClaimSchedule(schedule.ScheduleID, *schedule.NextRunAt, next, now)
+        if err != nil {
+            return ran, err
+        }
+        if !claimed {
+            continue
+        }
+        if run, err := u.run(schedule, now); err != nil {
+            log.Printf("Report schedule %d failed to start: %v", schedule.ScheduleID, err)
+        } else if run.Status == models.ReportRunFailed {
+            log.Printf("Report schedule %d run %d failed: %s", schedule.ScheduleID, run.RunID, run.Error)
+        }
+        ran++
+    }
+    return ran, nil
+}
+
+// reportPeriod returns the last complete day, week or month before now.
+func reportPeriod(period string, now time.Time) (time.Time, time.Time) {
+    interval := models.IntervalDay
+    switch period {
+    case models.ReportPeriodWeek:
+        interval = models.IntervalWeek
+    case models.ReportPeriodMonth:
+        interval = models.IntervalMonth
+    }
+    to := periodStart(now, interval)
+    switch interval {
+    case models.IntervalWeek:
+        return to.AddDate(0, 0, -7), to
+    case models.IntervalMonth:
+        return to.AddDate(0, -1, 0), to
+    }
+    return to.AddDate(0, 0, -1), to
+}
+
+// run builds the schedule's report, writes it as CSV and PDF and emails both
+// to the recipients. The run is recorded before any work starts, so a failure
+// part-way is kept with its error.
+func (u *reportUsecaseImpl) run(schedule *models.ReportSchedule, now time.Time) (*models.ReportRun, error) {
+    from, to := reportPeriod(schedule.Period, now)
+    if schedule.ReportType == models.ReportTypeExpiringSubscriptions {
+        from, to = now, now.AddDate(0, 0, ExpiringSubscriptionDays)
+    }
+    run := &models.ReportRun{
+        ScheduleID: &schedule.ScheduleID,
+        ReportType: schedule.ReportType,
+        PeriodFrom: from,
+        PeriodTo:   to,
+        Status:     models.ReportRunRunning,
+        Recipients: schedule.Recipients,
+        StartedAt:  time.Now(),
+    }
+    if err := u.reportRepo.CreateRun(run); err != nil {
+        return nil, err
+    }
+
+    if err := u.generate(run); err != nil {
+        run.Status = models.ReportRunFailed
+        run.Error = err.Error()
+        if len(run.Error) > 500 {
+            run.Error = run.Error[:500]
+        }
+    } else {
+        run.Status = models.ReportRunSuccess
+    }
+    finished := time.Now()
+    run.FinishedAt = &finished
+    if err := u.reportRepo.UpdateRun(run); err != nil {
+        return nil, err
+    }
+    return run, nil
+}
+
+func (u *reportUsecaseImpl) generate(run *models.ReportRun) error {
+    table, err := u.buildTable(run.ReportType, run.PeriodFrom, run.PeriodTo)
+    if err != nil {
+        return fmt.Errorf("building report: %w", err)
+    }
+    run.Rows = len(table.Rows)
+
+    csvContent, err := renderReportCSV(table)
+    if err != nil {
+        return err
+    }
+    pdfContent, err := renderReportPDF(table)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(u.outputDir, 0o755); err != nil {
+        return err
+    }
+    base := filepath.Join(u.outputDir, fmt.Sprintf("%s_%d", run.ReportType, run.RunID))
+    if err := os.WriteFile(base+".csv", csvContent, 0o644); err != nil {
+        return err
+    }
+    run.CSVFile = base + ".csv"
+    if err := os.WriteFile(base+".pdf", pdfContent, 0o644); err != nil {
+        return err
+    }
+    run.PDFFile = base + ".pdf"
+
+    subject := table.Title
+    body := fmt.Sprintf("Please find attached the %s report for %s, with %d rows.", reportTypeLabel(run.ReportType), reportPeriodLabel(run.PeriodFrom, run.PeriodTo), run.Rows)
+    var failed []string
+    for _, to := range strings.Split(run.Recipients, ",") {
+        if err := utils.SendEmailWithAttachment(to, subject, body, run.CSVFile, run.PDFFile); err != nil {
+            log.Printf("Failed to email report run %d to %s: %v", run.RunID, to, err)
+            failed = append(failed, to)
+        }
+    }
+    if len(failed) > 0 {
+        return fmt.Errorf("email failed for %s", strings.Join(failed, ", "))
+    }
+    return nil
+}
+
+func reportTypeLabel(reportType string) string {
+    return strings.ReplaceAll(reportType, "_", " ")
+}
+
+// reportPeriodLabel formats [from, to) with to shown inclusively.
+func reportPeriodLabel(from, to time.Time) string {
+    last := to.Add(-time.Nanosecond)
+    if startOfDay(from).Equal(startOfDay(last)) {
+        return from.Format("2006-01-02")
+    }
+    return from.Format("2006-01-02") + " to " + last.Format("2006-01-02")
+}
+
+func reportAmount(amount float64) string {
+    return strconv.FormatFloat(roundAmount(amount), 'f', 2, 64)
+}
+
+func (u *reportUsecaseImpl) buildTable(reportType string, from, to time.Time) (*models.ReportTable, error) {
+    table := &models.ReportTable{
+        Title: fmt.Sprintf("XTrace %s report, %s", reportTypeLabel(reportType), reportPeriodLabel(from, to)),
+    }
+    switch reportType {
+    case models.ReportTypeRevenue:
+        report, err := u.revenueUsecase.GetRevenue(models.RevenueFilter{From: from, To: to, GroupBy: models.RevenueByDay})
+        if err != nil {
+            return nil, err
+        }
+        table.Columns = []string{"Day", "Payments", "Gross", "Discount", "Refund", "Net"}
+        for _, row := range append(report.Rows, report.Total) {
+            table.Rows = append(table.Rows, []string{
+                row.Key, strconv.FormatInt(row.Payments, 10), reportAmount(row.GrossAmount),
+                reportAmount(row.DiscountAmount), reportAmount(row.RefundAmount), reportAmount(row.NetAmount),
+            })
+        }
+        table.Rows[len(table.Rows)-1][0] = "Total"
+
+    case models.ReportTypeRefunds:
+        rows, err := u.reportRepo.GetRefunds(from, to)
+        if err != nil {
+            return nil, err
+        }
+        table.Columns = []string{"Payment ID", "Order ID", "User ID", "Type", "Method", "Currency", "Amount", "Refunded", "Status", "Updated"}
+        for _, row := range rows {
+            table.Rows = append(table.Rows, []string{
+                strconv.FormatUint(uint64(row.PaymentID), 10), row.OrderID, strconv.FormatUint(uint64(row.UserID), 10),
+                row.PaymentType, row.Method, row.Currency, reportAmount(row.Amount), reportAmount(row.RefundedAmount),
+                row.Status, row.UpdatedAt.Format("2006-01-02 15:04"),
+            })
+        }
+
+    case models.ReportTypeReconciliation:
+        rows, err := u.reportRepo.GetReconciliationExceptions(from, to)
+        if err != nil {
+            return nil, err
+        }
+        table.Columns = []string{"Payment ID", "Order ID", "Type", "Payment Status", "Paid", "Invoice", "Invoiced", "Issue"}
+        for _, row := range rows {
+            table.Rows = append(table.Rows, []string{
+                strconv.FormatUint(uint64(row.PaymentID), 10), row.OrderID, row.PaymentType, row.PaymentStatus,
+                reportAmount(row.PaymentAmount), row.InvoiceNumber, reportAmount(row.InvoiceAmount), row.Issue,
+            })
+        }
+
+    case models.ReportTypeRidership:
+        hours, err := u.ridershipUsecase.GetStopHours(models.RidershipFilter{From: from, To: to})
+        if err != nil {
+            return nil, err
+        }
+        type stopTotal struct {
+            name                  string
+            boardings, alightings int64
+        }
+        totals := make(map[int]*stopTotal)
+        var stopIDs []int
+        for _, hour := range hours {
+            total, ok := totals[hour.StopID]
+            if !ok {
+                total = &stopTotal{name: hour.StopName}
+                totals[hour.StopID] = total
+                stopIDs = append(stopIDs, hour.StopID)
+            }
+            total.boardings += hour.Boardings
+            total.alightings += hour.Alightings
+        }
+        sort.Ints(stopIDs)
+        table.Columns = []string{"Stop ID", "Stop", "Boardings", "Alightings"}
+        for _, stopID := range stopIDs {
+            total := totals[stopID]
+            table.Rows = append(table.Rows, []string{
+                strconv.Itoa(stopID), total.name, strconv.FormatInt(total.boardings, 10), strconv.FormatInt(total.alightings, 10),
+            })
+        }
+
+    case models.ReportTypeExpiringSubscriptions:
+        rows, err := u.reportRepo.GetExpiringSubscriptions(from, to)
+        if err != nil {
+            return nil, err
+        }
+        table.Columns = []string{"Subscription ID", "User ID", "Email", "Plan", "Card Type", "Ends", "Auto Renew"}
+        for _, row := range rows {
+            autoRenew := "no"
+            if row.AutoRenew {
+                autoRenew = "yes"
+            }
+            table.Rows = append(table.Rows, []string{
+                strconv.FormatUint(uint64(row.SubscriptionID), 10), strconv.FormatUint(uint64(row.UserID), 10),
+                row.Email, row.PlanName, row.CardType, row.EndDate.Format("2006-01-02 15:04"), autoRenew,
+            })
+        }
+
+    default:
+        return nil, fmt.Errorf("unknown report type %q", reportType)
+    }
+    return table, nil
+}
+
+func renderReportCSV(table *models.ReportTable) ([]byte, error) {
+    var buf bytes.Buffer
+    writer := csv.NewWriter(&buf)
+    if err := writer.Write(table.Columns); err != nil {
+        return nil, err
+    }
+    if err := writer.WriteAll(table.Rows); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// renderReportPDF lays the table out on landscape A4 pages, repeating the
+// header row on each page. Cells too wide for their column are cut short.
+func renderReportPDF(table *models.ReportTable) ([]byte, error) {
+    pdf := gofpdf.New("L", "mm", "A4", "")
+    pdf.SetMargins(10, 10, 10)
+    pdf.SetAutoPageBreak(false, 10)
+    pdf.AddPage()
+
+    pdf.SetFont("Arial", "B", 14)
+    pdf.CellFormat(0, 8, table.Title, "", 1, "L", false, 0, "")
+    pdf.SetFont("Arial", "", 8)
+    pdf.CellFormat(0, 5, "Generated "+time.Now().Format("2006-01-02 15:04"), "", 1, "L", false, 0, "")
+    pdf.Ln(3)
+
+    pageWidth, pageHeight := pdf.GetPageSize()
+    left, _, right, bottom := pdf.GetMargins()
+    width := (pageWidth - left - right) / float64(len(table.Columns))
+    const rowHeight = 6
+
+    fit := func(text string) string {
+        if pdf.GetStringWidth(text) <= width-2 {
+            return text
+        }
+        for len(text) > 0 && pdf.GetStringWidth(text+"..") > width-2 {
+            text = text[:len(text)-1]
+        }
+        return text + ".."
+    }
+    header := func() {
+        pdf.SetFont("Arial", "B", 8)
+        pdf.SetFillColor(230, 230, 230)
+        for _, column := range table.Columns {
+            pdf.CellFormat(width, rowHeight, fit(column), "1", 0, "L", true, 0, "")
+        }
+        pdf.Ln(-1)
+        pdf.SetFont("Arial", "", 8)
+    }
+
+    header()
+    if len(table.Rows) == 0 {
+        pdf.CellFormat(0, rowHeight, "No data for this period.", "1", 1, "L", false, 0, "")
+    }
+    for _, row := range table.Rows {
+        if pdf.GetY()+rowHeight > pageHeight-bottom {
+            pdf.AddPage()
+            header()
+        }
+        for _, cell := range row {
+            pdf.CellFormat(width, rowHeight, fit(cell), "1", 0, "L", false, 0, "")
+        }
+        pdf.Ln(-1)
+    }
+
+    var buf bytes.Buffer
+    if err := pdf.Output(&buf); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+func (u *reportUsecaseImpl) GetRuns(scheduleID uint, limit int) ([]models.ReportRun, error) {
+    if limit <= 0 || limit > MaxReportRuns {
+        limit = MaxReportRuns
+    }
+    return u.reportRepo.GetRuns(scheduleID, limit)
+}
+
+func (u *reportUsecaseImpl) GetRunFile(runID uint, format string) (string, error) {
+    run, err := u.reportRepo.GetRunByID(runID)
+    if err != nil {
+        return "", err
+    }
+    path := run.CSVFile
+    switch format {
+    case "csv":
+    case "pdf":
+        path = run.PDFFile
+    default:
+        return "", errors.New("format must be csv or pdf")
+    }
+    if path == "" {
+        return "", ErrReportOutputMissing
+    }
+    if _, err := os.Stat(path); err != nil {
+        return "", ErrReportOutputMissing
+    }
+    return path, nil
+}
@@ -0,0 +1,103 @@
+package usecase
+
+import (
+    "bytes"
+    "encoding/csv"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+)
+
+// MaxRevenueDays caps the range of one revenue report.
+const MaxRevenueDays = 366
+
+type RevenueUsecase interface {
+    GetRevenue(filter models.RevenueFilter) (*models.RevenueReport, error)
+    RenderCSV(report *models.RevenueReport) ([]byte, error)
+}
+
+type revenueUsecaseImpl struct {
+    revenueRepo repository.RevenueRepository
+}
+
+func NewRevenueUsecase(revenueRepo repository.RevenueRepository) RevenueUsecase {
+    return &revenueUsecaseImpl{revenueRepo: revenueRepo}
+}
+
+func (u *revenueUsecaseImpl) GetRevenue(filter models.RevenueFilter) (*models.RevenueReport, error) {
+    if filter.GroupBy == "" {
+        filter.GroupBy = models.RevenueByDay
+    }
+    valid := false
+    for _, groupBy := range models.RevenueGroupings {
+        if filter.GroupBy == groupBy {
+            valid = true
+            break
+        }
+    }
+    if !valid {
+        return nil, fmt.Errorf("group_by must be one of %s", strings.Join(models.RevenueGroupings, ", "))
+    }
+    if !filter.To.After(filter.From) {
+        return nil, errors.New("to must be after from")
+    }
+    if filter.To.Sub(filter.From).Hours() > MaxRevenueDays*24 {
+        return nil, fmt.Errorf("date range cannot exceed %d days", MaxRevenueDays)
+    }
+
+    rows, err := u.revenueRepo.GetRevenue(filter)
+    if err != nil {
+        return nil, err
+    }
+    report := &models.RevenueReport{
+        From:        filter.From,
+        To:          filter.To,
+        GroupBy:     filter.GroupBy,
+        PaymentType: filter.PaymentType,
+        Rows:        make([]models.RevenueRow, 0, len(rows)),
+        Total:       models.RevenueRow{Key: "total"},
+    }
+    for _, row := range rows {
+        report.Total.Payments += row.Payments
+        report.Total.GrossAmount += row.GrossAmount
+        report.Total.DiscountAmount += row.DiscountAmount
+        report.Total.RefundAmount += row.RefundAmount
+        report.Total.NetAmount += row.NetAmount
+        report.Rows = append(report.Rows, roundRevenueRow(row))
+    }
+    report.Total = roundRevenueRow(report.Total)
+    return report, nil
+}
+
+func roundRevenueRow(row models.RevenueRow) models.RevenueRow {
+    row.GrossAmount = roundAmount(row.GrossAmount)
+    row.DiscountAmount = roundAmount(row.DiscountAmount)
+    row.RefundAmount = roundAmount(row.RefundAmount)
+    row.NetAmount = roundAmount(row.NetAmount)
+    return row
+}
+
+// RenderCSV writes one line per group followed by the total.
+func (u *revenueUsecaseImpl) RenderCSV(report *models.RevenueReport) ([]byte, error) {
+    var buf bytes.Buffer
+    writer := csv.NewWriter(&buf)
+    rows := [][]string{{report.GroupBy, "Payments", "Gross", "Discounts", "Refunds", "Net"}}
+    for _, row := range append(report.Rows, report.Total) {
+        rows = append(rows, []string{
+            row.Key,
+            strconv.FormatInt(row.Payments, 10),
+            strconv.FormatFloat(row.GrossAmount, 'f', 2, 64),
+            strconv.FormatFloat(row.DiscountAmount, 'f', 2, 64),
+            strconv.FormatFloat(row.RefundAmount, 'f', 2, 64),
+            strconv.FormatFloat(row.NetAmount, 'f', 2, 64),
+        })
+    }
+    if err := writer.WriteAll(rows); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
@@ -0,0 +1,183 @@
+package usecase
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+)
+
+// RidershipRefreshDays is how many days, counting today, the ridership job
+// rebuilds on each run so journeys recorded late are picked up.
+const RidershipRefreshDays = 2
+
+// ridershipRebuildChunkDays bounds the days rebuilt in one transaction.
+const ridershipRebuildChunkDays = 31
+
+type RidershipUsecase interface {
+    RefreshRollups(now time.Time) error
+    Rebuild(from, to time.Time) error
+    GetStopHours(filter models.RidershipFilter) ([]models.StopHourRidership, error)
+    GetODMatrix(filter models.RidershipFilter) ([]models.ODPair, error)
+    GetLoadProfiles(filter models.RidershipFilter) ([]models.RouteLoadProfile, error)
+    GetHeatmap(filter models.RidershipFilter) ([]models.HeatmapCell, error)
+}
+
+type ridershipUsecaseImpl struct {
+    ridershipRepo repository.RidershipRepository
+}
+
+func NewRidershipUsecase(ridershipRepo repository.RidershipRepository) RidershipUsecase {
+    return &ridershipUsecaseImpl{ridershipRepo: ridershipRepo}
+}
+
+func startOfDay(t time.Time) time.Time {
+    return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// RefreshRollups rebuilds the last RidershipRefreshDays days. On first run,
+// or after the job has been down, it catches up from where it stopped.
+func (u *ridershipUsecaseImpl) RefreshRollups(now time.Time) error {
+    from := startOfDay(now).AddDate(0, 0, 1-RidershipRefreshDays)
+    latest, err := u.ridershipRepo.GetLatestRollupDate()
+    if err != nil {
+        return err
+    }
+    if latest == nil {
+        earliest, err := u.ridershipRepo.GetEarliestActivity()
+        if err != nil || earliest == nil {
+            return err
+        }
+        from = startOfDay(earliest.In(now.Location()))
+    } else if day := time.Date(latest.Year(), latest.Month(), latest.Day(), 0, 0, 0, 0, now.Location()); day.Before(from) {
+        from = day
+    }
+    return u.Rebuild(from, now)
+}
+
+// Rebuild recomputes the rollups for every day touched by [from, to).
+func (u *ridershipUsecaseImpl) Rebuild(from, to time.Time) error {
+    from = startOfDay(from)
+    if day := startOfDay(to); !day.Equal(to) {
+        to = day.AddDate(0, 0, 1)
+    }
+    if !to.After(from) {
+        return errors.New("to must be after from")
+    }
+    for start := from; start.Before(to); start = start.AddDate(0, 0, ridershipRebuildChunkDays) {
+        end := start.AddDate(0, 0, ridershipRebuildChunkDays)
+        if end.After(to) {
+            end = to
+        }
+        if err := u.ridershipRepo.RefreshRollups(start, end); err != nil {
+            return fmt.Errorf("rebuilding ridership from %s: %v", start.Format("2006-01-02"), err)
+        }
+    }
+    return nil
+}
+
+func validateRidershipFilter(filter models.RidershipFilter) error {
+    if !filter.To.After(filter.From) {
+        return errors.New("to must be after from")
+    }
+    if filter.Source == "" {
+        return nil
+    }
+    for _, source := range models.RidershipSources {
+        if filter.Source == source {
+            return nil
+        }
+    }
+    return fmt.Errorf("source must be one of %s", strings.Join(models.RidershipSources, ", "))
+}
+
+func (u *ridershipUsecaseImpl) GetStopHours(filter models.RidershipFilter) ([]models.StopHourRidership, error) {
+    if err := validateRidershipFilter(filter); err != nil {
+        return nil, err
+    }
+    return u.ridershipRepo.GetStopHours(filter)
+}
+
+func (u *ridershipUsecaseImpl) GetODMatrix(filter models.RidershipFilter) ([]models.ODPair, error) {
+    if err := validateRidershipFilter(filter); err != nil {
+        return nil, err
+    }
+    return u.ridershipRepo.GetODPairs(filter)
+}
+
+// GetLoadProfiles returns the route's load at each stop in both directions.
+// The load leaving a stop is everyone who boarded before or at it, less
+// everyone who has alighted.
+func (u *ridershipUsecaseImpl) GetLoadProfiles(filter models.RidershipFilter) ([]models.RouteLoadProfile, error) {
+    if filter.RouteID == 0 {
+        return nil, errors.New("route_id is required")
+    }
+    if err := validateRidershipFilter(filter); err != nil {
+        return nil, err
+    }
+    stops, err := u.ridershipRepo.GetRouteStops(filter.RouteID)
+    if err != nil {
+        return nil, err
+    }
+    if len(stops) == 0 {
+        return nil, errors.New("route has no stops")
+    }
+    filter.StopID = 0
+    pairs, err := u.ridershipRepo.GetODPairs(filter)
+    if err != nil {
+        return nil, err
+    }
+
+    n := len(stops)
+    position := make(map[int]int, n)
+    for i, stop := range stops {
+        position[stop.StopID] = i
+    }
+    outbound := make([]models.RouteLoadPoint, n)
+    inbound := make([]models.RouteLoadPoint, n)
+    for i, stop := range stops {
+        outbound[i] = stop
+        inbound[n-1-i] = stop
+    }
+    for _, pair := range pairs {
+        from, okFrom := position[pair.FromStopID]
+        to, okTo := position[pair.ToStopID]
+        if !okFrom || !okTo || from == to {
+            continue
+        }
+        if from < to {
+            outbound[from].Boardings += pair.Trips
+            outbound[to].Alightings += pair.Trips
+        } else {
+            inbound[n-1-from].Boardings += pair.Trips
+            inbound[n-1-to].Alightings += pair.Trips
+        }
+    }
+
+    profiles := []models.RouteLoadProfile{
+        {RouteID: filter.RouteID, Direction: models.DirectionOutbound, Points: outbound},
+        {RouteID: filter.RouteID, Direction: models.DirectionInbound, Points: inbound},
+    }
+    for i := range profiles {
+        var load int64
+        for j := range profiles[i].Points {
+            point := &profiles[i].Points[j]
+            load += point.Boardings - point.Alightings
+            point.Load = load
+            if load > profiles[i].PeakLoad {
+                profiles[i].PeakLoad = load
+            }
+        }
+    }
+    return profiles, nil
+}
+
+func (u *ridershipUsecaseImpl) GetHeatmap(filter models.RidershipFilter) ([]models.HeatmapCell, error) {
+    if err := validateRidershipFilter(filter); err != nil {
+        return nil, err
+    }
+    return u.ridershipRepo.GetHeatmap(filter)
+}
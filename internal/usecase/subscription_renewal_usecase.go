@@ -0,0 +1,425 @@
+package usecase
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "github.com/Prototype-1/xtrace/pkg/utils"
+    "github.com/razorpay/razorpay-go"
+)
+
+const (
+    // SubscriptionRenewalLeadDays is how many days before EndDate an
+    // auto-renewing subscription is charged.
+    SubscriptionRenewalLeadDays = 3
+    // SubscriptionGracePeriodDays keeps a subscription usable after EndDate
+    // while a failed renewal is retried.
+    SubscriptionGracePeriodDays = 3
+    // SubscriptionRenewalRetryInterval spaces out retries of a failed renewal.
+    SubscriptionRenewalRetryInterval = 24 * time.Hour
+)
+
+// SubscriptionReminderDays are the days before expiry on which reminders go out.
+var SubscriptionReminderDays = []int{7, 3, 1}
+
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+type SubscriptionRenewalUsecase interface {
+    SetAutoRenew(userID, subscriptionID uint, enabled bool, method, mandateCustomerID, mandateTokenID string) (*models.Subscription, error)
+    GetRenewals(userID, subscriptionID uint) ([]models.SubscriptionRenewal, error)
+    // RunRenewals settles pending gateway renewals, charges due renewals,
+    // moves lapsed subscriptions to grace or expired and sends reminders.
+    RunRenewals(now time.Time) error
+}
+
+type subscriptionRenewalUsecaseImpl struct {
+    renewalRepo      repository.SubscriptionRenewalRepository
+    subscriptionRepo repository.SubscriptionRepository
+    walletRepo       repository.WalletRepository
+    userRepo         repository.UserRepository
+    paymentUsecase   RazorpayPaymentUsecase
+    client           *razorpay.Client
+}
+
+func NewSubscriptionRenewalUsecase(renewalRepo repository.SubscriptionRenewalRepository, subscriptionRepo repository.SubscriptionRepository, walletRepo repository.WalletRepository, userRepo repository.UserRepository, paymentUsecase RazorpayPaymentUsecase, client *razorpay.Client) SubscriptionRenewalUsecase {
+    return &subscriptionRenewalUsecaseImpl{
+        renewalRepo:      renewalRepo,
+        subscriptionRepo: subscriptionRepo,
+        walletRepo:       walletRepo,
+        userRepo:         userRepo,
+        paymentUsecase:   paymentUsecase,
+        client:           client,
+    }
+}
+
+func (u *subscriptionRenewalUsecaseImpl) ownSubscription(userID, subscriptionID uint) (*models.Subscription, error) {
+    subscription, err := u.subscriptionRepo.GetSubscriptionByID(subscriptionID)
+    if err != nil || subscription.UserID != userID {
+        return nil, ErrSubscriptionNotFound
+    }
+    return subscription, nil
+}
+
+func (u *subscriptionRenewalUsecaseImpl) SetAutoRenew(userID, subscriptionID uint, enabled bool, method, mandateCustomerID, mandateTokenID string) (*models.Subscription, error) {
+    subscription, err := u.ownSubscription(userID, subscriptionID)
+    if err != nil {
+        return nil, err
+    }
+    if subscription.Status == models.SubscriptionStatusExpired {
+        return nil, fmt.Errorf("expired subscriptions cannot be renewed automatically")
+    }
+
+    fields := map[string]interface{}{"auto_renew": enabled}
+    if enabled {
+        switch method {
+        case models.RenewalMethodWallet:
+            if _, err := u.walletRepo.GetWalletByUserID(userID); err != nil {
+                return nil, fmt.Errorf("wallet not found for user")
+            }
+        case models.RenewalMethodMandate:
+            if mandateCustomerID == "" || mandateTokenID == "" {
+                return nil, fmt.Errorf("mandate_customer_id and mandate_token_id are required for mandate renewals")
+            }
+            fields["mandate_customer_id"] = mandateCustomerID
+            fields["mandate_token_id"] = mandateTokenID
+        default:
+            return nil, fmt.Errorf("renewal method must be wallet or mandate")
+        }
+        fields["renewal_method"] = method
+        fields["last_renewal_error"] = ""
+    }
+    if err := u.renewalRepo.UpdateAutoRenew(subscriptionID, fields); err != nil {
+        return nil, err
+    }
+    return u.subscriptionRepo.GetSubscriptionByID(subscriptionID)
+}
+
+func (u *subscriptionRenewalUsecaseImpl) GetRenewals(userID, subscriptionID uint) ([]models.SubscriptionRenewal, error) {
+    if _, err := u.ownSubscription(userID, subscriptionID); err != nil {
+        return nil, err
+    }
+    return u.renewalRepo.GetRenewalsBySubscriptionID(subscriptionID)
+}
+
+func (u *subscriptionRenewalUsecaseImpl) RunRenewals(now time.Time) error {
+    u.settlePendingRenewals(now)
+
+    candidates, err := u.renewalRepo.GetRenewalCandidates(
+        now.AddDate(0, 0, SubscriptionRenewalLeadDays), now.Add(-SubscriptionRenewalRetryInterval))
+    if err != nil {
+        return err
+    }
+    for i := range candidates {
+        u.renew(&candidates[i])
+    }
+
+    u.handleLapsed(now)
+    u.sendReminders(now)
+    return nil
+}
+
+// renew charges one renewal at the plan's current price and duration.
+func (u *subscriptionRenewalUsecaseImpl) renew(subscription *models.Subscription) {
+    plan, err := u.subscriptionRepo.GetSubscriptionPlan(subscription.PlanID)
+    if err != nil {
+        u.failAttempt(subscription, fmt.Errorf("plan %d is no longer available", subscription.PlanID))
+        return
+    }
+
+    renewal := &models.SubscriptionRenewal{
+        SubscriptionID:  subscription.SubscriptionID,
+        UserID:          subscription.UserID,
+        PlanID:          plan.PlanID,
+        Amount:          roundAmount(plan.Price),
+        Method:          subscription.RenewalMethod,
+        Status:          models.RenewalStatusPending,
+        PreviousEndDate: subscription.EndDate,
+        NewEndDate:      subscription.EndDate.AddDate(0, 0, plan.DurationDays),
+    }
+
+    switch subscription.RenewalMethod {
+    case models.RenewalMethodWallet:
+        err = u.renewFromWallet(subscription, renewal)
+    case models.RenewalMethodMandate:
+        err = u.chargeMandate(subscription, renewal)
+    default:
+        err = fmt.Errorf("no renewal method configured")
+    }
+    if err != nil {
+        renewal.Status = models.RenewalStatusFailed
+        renewal.Reason = err.Error()
+        if saveErr := u.renewalRepo.UpdateRenewal(renewal); saveErr != nil {
+            log.Printf("Subscription %d: failed to save renewal outcome: %v", subscription.SubscriptionID, saveErr)
+        }
+        u.failAttempt(subscription, err)
+        return
+    }
+    if renewal.Status == models.RenewalStatusSuccess {
+        u.notifyRenewed(subscription, renewal)
+    }
+}
+
+func (u *subscriptionRenewalUsecaseImpl) renewFromWallet(subscription *models.Subscription, renewal *models.SubscriptionRenewal) error {
+    wallet, err := u.walletRepo.GetWalletByUserID(subscription.UserID)
+    if err != nil {
+        return fmt.Errorf("wallet not found for user")
+    }
+    transaction := &models.WalletTransaction{
+        Amount:          renewal.Amount,
+        TransactionType: "subscription_renewal",
+        Description:     fmt.Sprintf("Renewal of subscription %d", subscription.SubscriptionID),
+    }
+    if err := u.renewalRepo.RenewWithWallet(renewal, wallet.WalletID, transaction); err != nil {
+        if errors.Is(err, repository.ErrInsufficientBalance) {
+            return fmt.Errorf("insufficient wallet balance")
+        }
+        return err
+    }
+    return nil
+}
+
+// chargeMandate creates an order and a recurring payment against the saved
+// mandate. The subscription is extended once the charge is captured.
+func (u *subscriptionRenewalUsecaseImpl) chargeMandate(subscription *models.Subscription, renewal *models.SubscriptionRenewal) error {
+    user, err := u.userRepo.GetUserByID(subscription.UserID)
+    if err != nil || user == nil {
+        return fmt.Errorf("user not found")
+    }
+    if err := u.renewalRepo.CreateRenewal(renewal); err != nil {
+        return err
+    }
+
+    orderID, err := u.paymentUsecase.CreateRazorpayOrder(renewal.Amount, models.BaseCurrency, uint64(subscription.UserID))
+    if err != nil {
+        return fmt.Errorf("failed to create order: %v", err)
+    }
+    payment, err := u.paymentUsecase.CreatePayment(subscription.UserID, renewal.Amount, models.BaseCurrency, "", "subscription", nil, nil, &subscription.SubscriptionID, nil, orderID)
+    if err != nil {
+        return fmt.Errorf("failed to record payment: %v", err)
+    }
+    renewal.PaymentID = &payment.PaymentID
+    renewal.OrderID = orderID
+
+    result, err := u.client.Payment.CreateRecurringPayment(map[string]interface{}{
+        "email":       user.Email,
+        "contact":     user.Phone,
+        "amount":      int(renewal.Amount * 100),
+        "currency":    models.BaseCurrency,
+        "order_id":    orderID,
+        "customer_id": subscription.MandateCustomerID,
+        "token":       subscription.MandateTokenID,
+        "recurring":   "1",
+        "description": fmt.Sprintf("Subscription %d renewal", subscription.SubscriptionID),
+    }, nil)
+    if err != nil {
+        return fmt.Errorf("mandate charge failed: %v", err)
+    }
+    if id, ok := result["razorpay_payment_id"].(string); ok {
+        renewal.RazorpayPaymentID = id
+    }
+    if err := u.renewalRepo.UpdateRenewal(renewal); err != nil {
+        log.Printf("Subscription renewal %d: failed to save charge details: %v", renewal.RenewalID, err)
+    }
+    if err := u.renewalRepo.RecordAttempt(subscription.SubscriptionID, ""); err != nil {
+        log.Printf("Subscription %d: failed to record renewal attempt: %v", subscription.SubscriptionID, err)
+    }
+    return nil
+}
+
+// settlePendingRenewals extends subscriptions whose mandate charge was
+// captured and fails the ones the gateway rejected or never captured.
+func (u *subscriptionRenewalUsecaseImpl) settlePendingRenewals(now time.Time) {
+    renewals, err := u.renewalRepo.GetPendingRenewals()
+    if err != nil {
+        log.Printf("Error loading pending subscription renewals: %v", err)
+        return
+    }
+
+    for i := range renewals {
+        renewal := &renewals[i]
+        subscription, err := u.subscriptionRepo.GetSubscriptionByID(renewal.SubscriptionID)
+        if err != nil {
+            continue
+        }
+
+        status := ""
+        if renewal.RazorpayPaymentID != "" {
+            payment, err := u.client.Payment.Fetch(renewal.RazorpayPaymentID, nil, nil)
+            if err != nil {
+                log.Printf("Subscription renewal %d: failed to fetch payment: %v", renewal.RenewalID, err)
+                continue
+            }
+            status, _ = payment["status"].(string)
+        }
+
+        var renewErr error
+        switch {
+        case status == "captured":
+            if err := u.paymentUsecase.UpdatePaymentStatus(renewal.OrderID, renewal.RazorpayPaymentID, models.PaymentStatusVerified); err != nil {
+                log.Printf("Subscription renewal %d: failed to update payment: %v", renewal.RenewalID, err)
+            }
+            err := u.renewalRepo.ApplyRenewal(renewal)
+            if errors.Is(err, repository.ErrSubscriptionNotRenewable) {
+                u.refundRenewal(subscription, renewal)
+                continue
+            }
+            if err != nil {
+                log.Printf("Subscription renewal %d: failed to extend subscription: %v", renewal.RenewalID, err)
+                continue
+            }
+            u.notifyRenewed(subscription, renewal)
+            continue
+        case status == "failed":
+            if err := u.paymentUsecase.UpdatePaymentStatus(renewal.OrderID, renewal.RazorpayPaymentID, models.PaymentStatusFailed); err != nil {
+                log.Printf("Subscription renewal %d: failed to update payment: %v", renewal.RenewalID, err)
+            }
+            renewErr = errors.New("mandate charge failed at the gateway")
+        case now.Sub(renewal.CreatedAt) > MandateSettlementTimeout:
+            renewErr = errors.New("mandate charge was not captured in time")
+        default:
+            continue
+        }
+
+        renewal.Status = models.RenewalStatusFailed
+        renewal.Reason = renewErr.Error()
+        if err := u.renewalRepo.UpdateRenewal(renewal); err != nil {
+            log.Printf("Subscription renewal %d: failed to save outcome: %v", renewal.RenewalID, err)
+        }
+        u.failAttempt(subscription, renewErr)
+    }
+}
+
+// refundRenewal refunds a captured mandate charge for a subscription that was
+// cancelled, paused or expired before the charge settled. The renewal stays
+// pending when the refund fails so the next run retries it.
+func (u *subscriptionRenewalUsecaseImpl) refundRenewal(subscription *models.Subscription, renewal *models.SubscriptionRenewal) {
+    if err := u.paymentUsecase.ProcessRefund(renewal.RazorpayPaymentID); err != nil {
+        log.Printf("Subscription renewal %d: failed to refund charge for a subscription that is no longer active: %v", renewal.RenewalID, err)
+        return
+    }
+    renewal.Status = models.RenewalStatusFailed
+    renewal.Reason = "subscription was no longer active when the charge settled; charge refunded"
+    if err := u.renewalRepo.UpdateRenewal(renewal); err != nil {
+        log.Printf("Subscription renewal %d: failed to save outcome: %v", renewal.RenewalID, err)
+    }
+    u.notify(subscription.UserID, "Subscription renewal refunded",
+        fmt.Sprintf("Your subscription %d was no longer active when its renewal charge of %.2f %s went through, so we have refunded it.",
+            subscription.SubscriptionID, renewal.Amount, models.BaseCurrency))
+}
+
+// handleLapsed moves subscriptions past EndDate into grace when they renew
+// automatically, and expires the rest along with grace periods that ran out.
+func (u *subscriptionRenewalUsecaseImpl) handleLapsed(now time.Time) {
+    lapsed, err := u.renewalRepo.GetLapsedSubscriptions(now)
+    if err != nil {
+        log.Printf("Error loading lapsed subscriptions: %v", err)
+    }
+    for i := range lapsed {
+        subscription := &lapsed[i]
+        if subscription.AutoRenew {
+            graceEndsAt := subscription.EndDate.AddDate(0, 0, SubscriptionGracePeriodDays)
+            if err := u.renewalRepo.MarkGrace(subscription.SubscriptionID, graceEndsAt); err != nil {
+                log.Printf("Subscription %d: failed to start grace period: %v", subscription.SubscriptionID, err)
+                continue
+            }
+            u.notify(subscription.UserID, "Subscription renewal pending",
+                fmt.Sprintf("We could not renew your subscription %d yet. It stays usable until %s while we retry. Please make sure your %s has enough funds.",
+                    subscription.SubscriptionID, graceEndsAt.Format("02 Jan 2006"), renewalSourceName(subscription.RenewalMethod)))
+            continue
+        }
+        u.expire(subscription)
+    }
+
+    graceExpired, err := u.renewalRepo.GetGraceExpiredSubscriptions(now)
+    if err != nil {
+        log.Printf("Error loading subscriptions past their grace period: %v", err)
+    }
+    for i := range graceExpired {
+        u.expire(&graceExpired[i])
+    }
+}
+
+func (u *subscriptionRenewalUsecaseImpl) expire(subscription *models.Subscription) {
+    if err := u.renewalRepo.MarkExpired(subscription.SubscriptionID); err != nil {
+        log.Printf("Subscription %d: failed to expire: %v", subscription.SubscriptionID, err)
+        return
+    }
+    u.notify(subscription.UserID, "Subscription expired",
+        fmt.Sprintf("Your subscription %d (%s) has expired. Subscribe again to keep travelling with your pass.",
+            subscription.SubscriptionID, subscription.ServiceType))
+}
+
+// sendReminders emails each subscription once per reminder day as expiry
+// approaches, skipping thresholds already passed by the time it is picked up.
+func (u *subscriptionRenewalUsecaseImpl) sendReminders(now time.Time) {
+    furthest := SubscriptionReminderDays[0]
+    subscriptions, err := u.renewalRepo.GetReminderCandidates(now, now.AddDate(0, 0, furthest))
+    if err != nil {
+        log.Printf("Error loading subscriptions for reminders: %v", err)
+        return
+    }
+
+    for i := range subscriptions {
+        subscription := &subscriptions[i]
+        daysLeft := int(subscription.EndDate.Sub(now).Hours()/24) + 1
+        due := 0
+        for _, days := range SubscriptionReminderDays {
+            if daysLeft <= days && (subscription.LastReminderDays == 0 || subscription.LastReminderDays > days) {
+                due = days
+            }
+        }
+        if due == 0 {
+            continue
+        }
+
+        body := fmt.Sprintf("Your subscription %d (%s) expires on %s.", subscription.SubscriptionID,
+            subscription.ServiceType, subscription.EndDate.Format("02 Jan 2006"))
+        if subscription.AutoRenew {
+            body += fmt.Sprintf(" It will be renewed automatically from your %s.", renewalSourceName(subscription.RenewalMethod))
+        } else {
+            body += " Renew it or turn on auto-renew to avoid losing your pass."
+        }
+        u.notify(subscription.UserID, fmt.Sprintf("Your subscription expires in %d day(s)", due), body)
+
+        if err := u.renewalRepo.SetReminderSent(subscription.SubscriptionID, due); err != nil {
+            log.Printf("Subscription %d: failed to record reminder: %v", subscription.SubscriptionID, err)
+        }
+    }
+}
+
+func (u *subscriptionRenewalUsecaseImpl) failAttempt(subscription *models.Subscription, renewErr error) {
+    log.Printf("Subscription %d renewal failed: %v", subscription.SubscriptionID, renewErr)
+    if err := u.renewalRepo.RecordAttempt(subscription.SubscriptionID, renewErr.Error()); err != nil {
+        log.Printf("Subscription %d: failed to record renewal attempt: %v", subscription.SubscriptionID, err)
+    }
+    u.notify(subscription.UserID, "Subscription renewal failed",
+        fmt.Sprintf("We could not renew your subscription %d: %s. We will try again in 24 hours.",
+            subscription.SubscriptionID, renewErr.Error()))
+}
+
+func (u *subscriptionRenewalUsecaseImpl) notifyRenewed(subscription *models.Subscription, renewal *models.SubscriptionRenewal) {
+    u.notify(subscription.UserID, "Subscription renewed",
+        fmt.Sprintf("Your subscription %d was renewed for %.2f %s and is now valid until %s.",
+            subscription.SubscriptionID, renewal.Amount, models.BaseCurrency, renewal.NewEndDate.Format("02 Jan 2006")))
+}
+
+func (u *subscriptionRenewalUsecaseImpl) notify(userID uint, subject, body string) {
+    user, err := u.userRepo.GetUserByID(userID)
+    if err != nil || user == nil {
+        return
+    }
+    if err := utils.SendEmail(user.Email, subject, body); err != nil {
+        log.Printf("Failed to send subscription email to user %d: %v", userID, err)
+    }
+}
+
+func renewalSourceName(method string) string {
+    if method == models.RenewalMethodMandate {
+        return "saved payment method"
+    }
+    return "wallet"
+}
@@ -1,16 +1,21 @@
 package usecase
 
 import (
+    "errors"
+    "log"
+    "math"
     "time"
     "fmt"
     "github.com/Prototype-1/xtrace/internal/models"
     "github.com/Prototype-1/xtrace/internal/repository"
     "github.com/razorpay/razorpay-go" 
+    "gorm.io/gorm"
 )
 
 type SubscriptionUsecase interface {
     // Subscription methods
     CreateSubscription(userID uint, planID uint, serviceType string, durationDays int, cardType string, nolCardID uint) error
+    SubscribeWithWallet(userID, planID, nolCardID uint) (*models.Subscription, *models.Invoice, error)
     GetUserSubscriptions(userID uint) ([]models.Subscription, error)
     ExtendSubscription(subscriptionID uint, planID uint) error
     UpdateSubscription(subscriptionID uint, price float64, durationDays int) error
@@ -25,21 +30,45 @@ type SubscriptionUsecase interface {
     HasActiveSubscription(userID uint) (bool, error)
     GetSubscriptionByID(subscriptionID uint) (*models.Subscription, error)
     GetPaymentIDByOrderID(orderID string) (string, error)
+
+    // Lifecycle changes requested by the subscriber.
+    PauseSubscription(userID, subscriptionID uint) (*models.Subscription, error)
+    ResumeSubscription(userID, subscriptionID uint) (*models.Subscription, error)
+    CancelSubscription(userID, subscriptionID uint, reason string) (*models.SubscriptionAdjustment, error)
+    ChangePlan(userID, subscriptionID, newPlanID uint) (*models.SubscriptionAdjustment, error)
+    GetAdjustments(userID, subscriptionID uint) ([]models.SubscriptionAdjustment, error)
+    // ResumeOverduePauses resumes pauses that have used up the plan's allowance.
+    ResumeOverduePauses(now time.Time) (int, error)
+    // FindCoveringSubscription returns the card's subscription if its plan
+    // scope covers the journey, or nil when the journey must be paid for.
+    FindCoveringSubscription(nolCardID uint, routeID, fromStopID, toStopID int) (*models.Subscription, error)
+    // FindCoveringSubscriptionBySequence is FindCoveringSubscription for a
+    // journey given by the stops' positions on the route.
+    FindCoveringSubscriptionBySequence(nolCardID uint, routeID, fromSequence, toSequence int) (*models.Subscription, error)
 }
 
+// ErrInvalidPlanScope is returned when a plan's scope fields don't make sense together.
+var ErrInvalidPlanScope = errors.New("invalid plan scope")
+
 
 
 type subscriptionUsecase struct {
     subscriptionRepo repository.SubscriptionRepository
     planRepo          repository.SubscriptionPlanRepository
     razorpayClient   *razorpay.Client 
+    walletRepo       repository.WalletRepository
+    paymentUsecase   RazorpayPaymentUsecase
+    invoiceUsecase   InvoiceUsecase
 }
 
-func NewSubscriptionUsecase(subscriptionRepo repository.SubscriptionRepository, planRepo repository.SubscriptionPlanRepository, razorpayClient *razorpay.Client) SubscriptionUsecase {
+func NewSubscriptionUsecase(subscriptionRepo repository.SubscriptionRepository, planRepo repository.SubscriptionPlanRepository, razorpayClient *razorpay.Client, walletRepo repository.WalletRepository, paymentUsecase RazorpayPaymentUsecase, invoiceUsecase InvoiceUsecase) SubscriptionUsecase {
     return &subscriptionUsecase{
         subscriptionRepo: subscriptionRepo,
         planRepo:         planRepo,
         razorpayClient:   razorpayClient, 
+        walletRepo:       walletRepo,
+        paymentUsecase:   paymentUsecase,
+        invoiceUsecase:   invoiceUsecase,
     }
 }
 
@@ -72,11 +101,71 @@ func (u *subscriptionUsecase) CreateSubscription(userID, planID uint, serviceTyp
         Price:        plan.Price,
         DurationDays: plan.DurationDays,
         CardType:     cardType,
+        Status:       models.SubscriptionStatusActive,
+        RideAllowance: plan.RideAllowance,
     }
 
     return u.subscriptionRepo.CreateSubscription(newSubscription)
 }
 
+// SubscribeWithWallet pays for a new subscription from the user's wallet and
+// issues its invoice.
+func (u *subscriptionUsecase) SubscribeWithWallet(userID, planID, nolCardID uint) (*models.Subscription, *models.Invoice, error) {
+    activeSubscription, err := u.subscriptionRepo.GetActiveSubscriptionByNolCardID(nolCardID)
+    if err != nil {
+        return nil, nil, err
+    }
+    if activeSubscription != nil {
+        return nil, nil, fmt.Errorf("this NolCard already has an active subscription")
+    }
+    plan, err := u.subscriptionRepo.GetSubscriptionPlan(planID)
+    if err != nil {
+        return nil, nil, fmt.Errorf("invalid plan ID: %v", err)
+    }
+    if plan.Price <= 0 {
+        return nil, nil, fmt.Errorf("plan has no price to charge")
+    }
+    wallet, err := u.walletRepo.GetWalletByUserID(userID)
+    if err != nil {
+        return nil, nil, fmt.Errorf("wallet not found for user")
+    }
+
+    now := time.Now()
+    subscription := &models.Subscription{
+        UserID:        userID,
+        PlanID:        planID,
+        NolCardID:     nolCardID,
+        ServiceType:   plan.PlanName,
+        StartDate:     now,
+        EndDate:       now.AddDate(0, 0, plan.DurationDays),
+        Price:         plan.Price,
+        DurationDays:  plan.DurationDays,
+        CardType:      plan.CardType,
+        Status:        models.SubscriptionStatusActive,
+        RenewalMethod: models.RenewalMethodWallet,
+        RideAllowance: plan.RideAllowance,
+    }
+    invoice := &models.Invoice{
+        UserID:          userID,
+        InvoiceDate:     now,
+        OriginalAmount:  plan.Price,
+        Amount:          plan.Price,
+        Currency:        models.BaseCurrency,
+        SettledAmount:   plan.Price,
+        SettledCurrency: models.BaseCurrency,
+        ExchangeRate:    1,
+        Status:          models.InvoiceStatusPaid,
+        PaymentType:     "subscription",
+    }
+    if err := u.invoiceUsecase.PrepareInvoice(invoice, nil); err != nil {
+        return nil, nil, err
+    }
+    if err := u.subscriptionRepo.CreateSubscriptionWithWallet(subscription, wallet.WalletID, invoice); err != nil {
+        return nil, nil, err
+    }
+    return subscription, invoice, nil
+}
+
 func (u *subscriptionUsecase) ExtendSubscription(subscriptionID uint, planID uint) error {
     // Retrieve the subscription by ID
     subscription, err := u.subscriptionRepo.GetSubscriptionByID(subscriptionID)
@@ -95,6 +184,9 @@ func (u *subscriptionUsecase) ExtendSubscription(subscriptionID uint, planID uin
     subscription.Price = plan.Price
     subscription.DurationDays = plan.DurationDays
     subscription.EndDate = subscription.EndDate.AddDate(0, 0, plan.DurationDays)
+    if subscription.EndDate.After(time.Now()) {
+        subscription.Status = models.SubscriptionStatusActive
+    }
 
     // Update the subscription in the repository
     err = u.subscriptionRepo.UpdateSubscription(subscription)
@@ -127,6 +219,9 @@ func (u *subscriptionUsecase) GetUserSubscriptions(userID uint) ([]models.Subscr
 
 // Subscription Plan methods
 func (u *subscriptionUsecase) CreateSubscriptionPlan(plan *models.SubscriptionPlan) error {
+    if err := u.validatePlanScope(plan); err != nil {
+        return err
+    }
     return u.planRepo.CreateSubscriptionPlan(plan)
 }
 
@@ -135,9 +230,44 @@ func (u *subscriptionUsecase) GetSubscriptionPlan(planID uint) (*models.Subscrip
 }
 
 func (u *subscriptionUsecase) UpdateSubscriptionPlan(plan *models.SubscriptionPlan) error {
+    if err := u.validatePlanScope(plan); err != nil {
+        return err
+    }
     return u.planRepo.UpdateSubscriptionPlan(plan)
 }
 
+// validatePlanScope checks the listed routes exist and that a corridor names
+// two different stops.
+func (u *subscriptionUsecase) validatePlanScope(plan *models.SubscriptionPlan) error {
+    if plan.RideAllowance < 0 {
+        return fmt.Errorf("%w: ride allowance cannot be negative", ErrInvalidPlanScope)
+    }
+    if (plan.CorridorFromStopID == nil) != (plan.CorridorToStopID == nil) {
+        return fmt.Errorf("%w: a corridor needs both a from and a to stop", ErrInvalidPlanScope)
+    }
+    if plan.CorridorFromStopID != nil && *plan.CorridorFromStopID == *plan.CorridorToStopID {
+        return fmt.Errorf("%w: corridor stops must be different", ErrInvalidPlanScope)
+    }
+    seen := make(map[int]bool, len(plan.RouteIDs))
+    routeIDs := make([]int, 0, len(plan.RouteIDs))
+    for _, routeID := range plan.RouteIDs {
+        if seen[routeID] {
+            continue
+        }
+        seen[routeID] = true
+        categoryID, err := u.planRepo.GetRouteCategoryID(routeID)
+        if err != nil {
+            return fmt.Errorf("%w: route %d not found", ErrInvalidPlanScope, routeID)
+        }
+        if plan.CategoryID != nil && categoryID != *plan.CategoryID {
+            return fmt.Errorf("%w: route %d is not in category %d", ErrInvalidPlanScope, routeID, *plan.CategoryID)
+        }
+        routeIDs = append(routeIDs, routeID)
+    }
+    plan.RouteIDs = routeIDs
+    return nil
+}
+
 func (u *subscriptionUsecase) DeleteSubscriptionPlan(planID uint) error {
     return u.planRepo.DeleteSubscriptionPlan(planID)
 }
@@ -156,8 +286,9 @@ func (u *subscriptionUsecase) HasActiveSubscription(userID uint) (bool, error) {
         return false, err
     }
 
+    now := time.Now()
     for _, subscription := range subscriptions {
-        if subscription.EndDate.After(time.Now()) {
+        if subscription.IsActive(now) {
             return true, nil 
         }
     }
@@ -173,6 +304,389 @@ func (u *subscriptionUsecase) GetPaymentIDByOrderID(orderID string) (string, err
     return paymentID, nil
 }
 
+func (u *subscriptionUsecase) ownSubscription(userID, subscriptionID uint) (*models.Subscription, error) {
+    subscription, err := u.subscriptionRepo.GetSubscriptionByID(subscriptionID)
+    if err != nil || subscription.UserID != userID {
+        return nil, ErrSubscriptionNotFound
+    }
+    return subscription, nil
+}
 
+// maxPauseDays is the pause allowance of the subscription's plan.
+func (u *subscriptionUsecase) maxPauseDays(subscription *models.Subscription) int {
+    plan, err := u.subscriptionRepo.GetSubscriptionPlan(subscription.PlanID)
+    if err != nil {
+        return 0
+    }
+    return plan.MaxPauseDays
+}
 
+func (u *subscriptionUsecase) PauseSubscription(userID, subscriptionID uint) (*models.Subscription, error) {
+    subscription, err := u.ownSubscription(userID, subscriptionID)
+    if err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    if !subscription.EndDate.After(now) {
+        return nil, fmt.Errorf("subscription has already ended")
+    }
+    if subscription.PausedDays >= u.maxPauseDays(subscription) {
+        return nil, fmt.Errorf("this subscription has used its pause allowance")
+    }
+    if err := u.subscriptionRepo.PauseSubscription(subscriptionID, now); err != nil {
+        return nil, err
+    }
+    if err := u.subscriptionRepo.CreateAdjustment(&models.SubscriptionAdjustment{
+        SubscriptionID: subscriptionID,
+        UserID:         userID,
+        Type:           models.AdjustmentPause,
+    }); err != nil {
+        log.Printf("Subscription %d: failed to record pause: %v", subscriptionID, err)
+    }
+    return u.subscriptionRepo.GetSubscriptionByID(subscriptionID)
+}
+
+func (u *subscriptionUsecase) ResumeSubscription(userID, subscriptionID uint) (*models.Subscription, error) {
+    subscription, err := u.ownSubscription(userID, subscriptionID)
+    if err != nil {
+        return nil, err
+    }
+    if err := u.resume(subscription, time.Now()); err != nil {
+        return nil, err
+    }
+    return u.subscriptionRepo.GetSubscriptionByID(subscriptionID)
+}
+
+// resume shifts EndDate by the whole days paused, capped at the remaining allowance.
+func (u *subscriptionUsecase) resume(subscription *models.Subscription, now time.Time) error {
+    if subscription.Status != models.SubscriptionStatusPaused || subscription.PausedAt == nil {
+        return fmt.Errorf("subscription is not paused")
+    }
+    days := int(math.Ceil(now.Sub(*subscription.PausedAt).Hours() / 24))
+    if allowance := u.maxPauseDays(subscription) - subscription.PausedDays; days > allowance {
+        days = allowance
+    }
+    if days < 0 {
+        days = 0
+    }
+    return u.subscriptionRepo.ResumeSubscription(subscription.SubscriptionID, days, &models.SubscriptionAdjustment{
+        SubscriptionID: subscription.SubscriptionID,
+        UserID:         subscription.UserID,
+        Type:           models.AdjustmentResume,
+        Days:           days,
+        Details:        fmt.Sprintf("end date moved by %d day(s)", days),
+    })
+}
+
+func (u *subscriptionUsecase) ResumeOverduePauses(now time.Time) (int, error) {
+    subscriptions, err := u.subscriptionRepo.GetPausedSubscriptions()
+    if err != nil {
+        return 0, err
+    }
+    resumed := 0
+    for i := range subscriptions {
+        subscription := &subscriptions[i]
+        if subscription.PausedAt == nil {
+            continue
+        }
+        allowance := u.maxPauseDays(subscription) - subscription.PausedDays
+        if subscription.PausedAt.AddDate(0, 0, allowance).After(now) {
+            continue
+        }
+        if err := u.resume(subscription, now); err != nil {
+            log.Printf("Subscription %d: failed to auto-resume: %v", subscription.SubscriptionID, err)
+            continue
+        }
+        resumed++
+    }
+    return resumed, nil
+}
+
+// unusedShare is the fraction of the term left on the subscription and the
+// number of days it covers. A paused subscription's remaining days are counted
+// from when it was paused.
+func unusedShare(subscription *models.Subscription, now time.Time) (float64, int) {
+    from := now
+    if subscription.Status == models.SubscriptionStatusPaused && subscription.PausedAt != nil {
+        from = *subscription.PausedAt
+    }
+    remainingDays := int(math.Floor(subscription.EndDate.Sub(from).Hours() / 24))
+    if remainingDays <= 0 || subscription.DurationDays <= 0 {
+        return 0, 0
+    }
+    if remainingDays > subscription.DurationDays {
+        remainingDays = subscription.DurationDays
+    }
+    return float64(remainingDays) / float64(subscription.DurationDays), remainingDays
+}
+
+// unusedValue is the pro-rata value of the days left at the subscription's price.
+func unusedValue(subscription *models.Subscription, now time.Time) (float64, int) {
+    share, remainingDays := unusedShare(subscription, now)
+    return roundAmount(subscription.Price * share), remainingDays
+}
 
+// CancelSubscription ends the subscription and refunds the unused share of
+// what was actually paid, after coupons and points, the way it was paid: a
+// partial gateway refund, or a credit back to the wallet. A gateway refund is
+// requested only after the cancellation is stored, marked refund_pending, and
+// the outcome is recorded on the adjustment.
+func (u *subscriptionUsecase) CancelSubscription(userID, subscriptionID uint, reason string) (*models.SubscriptionAdjustment, error) {
+    subscription, err := u.ownSubscription(userID, subscriptionID)
+    if err != nil {
+        return nil, err
+    }
+    if subscription.Status == models.SubscriptionStatusCancelled || subscription.Status == models.SubscriptionStatusExpired {
+        return nil, fmt.Errorf("subscription is already %s", subscription.Status)
+    }
+
+    share, remainingDays := unusedShare(subscription, time.Now())
+    adjustment := &models.SubscriptionAdjustment{
+        SubscriptionID: subscriptionID,
+        UserID:         userID,
+        Type:           models.AdjustmentCancel,
+        FromPlanID:     subscription.PlanID,
+        Days:           remainingDays,
+        Details:        reason,
+    }
+
+    var walletID *uint
+    var payment *models.RazorpayPayment
+    var gatewayRefund float64
+    if share > 0 {
+        payment, err = u.subscriptionRepo.GetLatestGatewayPayment(subscriptionID)
+        switch {
+        case err == nil:
+            rate := payment.ExchangeRate
+            if rate <= 0 {
+                rate = 1
+            }
+            // SettledAmount is the base-currency value of what the gateway
+            // took, already net of coupon and points discounts.
+            settled := payment.SettledAmount
+            if settled == 0 {
+                settled = roundAmount(payment.Amount * rate)
+            }
+            refund := roundAmount(settled * share)
+            // payment.Amount is in the checkout currency, so the gateway
+            // refund is converted back at the rate the payment was taken at.
+            gatewayRefund = roundAmount(refund / rate)
+            if left := roundAmount(payment.Amount - payment.RefundedAmount); gatewayRefund > left {
+                gatewayRefund = left
+                refund = roundAmount(left * rate)
+            }
+            if gatewayRefund > 0 {
+                adjustment.Amount = -refund
+                adjustment.Method = "razorpay"
+                adjustment.Reference = payment.RazorpayID
+                adjustment.RefundStatus = models.AdjustmentRefundPending
+            }
+        default:
+            paid, err := u.subscriptionRepo.SumWalletDebits(subscriptionID)
+            if err != nil {
+                return nil, err
+            }
+            if paid > 0 {
+                wallet, err := u.walletRepo.GetWalletByUserID(userID)
+                if err != nil {
+                    return nil, fmt.Errorf("wallet not found for user")
+                }
+                refund := roundAmount(subscription.Price * share)
+                if refund > paid {
+                    refund = paid
+                }
+                walletID = &wallet.WalletID
+                adjustment.Amount = -refund
+                adjustment.Method = "wallet"
+            }
+        }
+    }
+
+    if err := u.subscriptionRepo.CancelSubscription(subscriptionID, adjustment, walletID); err != nil {
+        return nil, err
+    }
+
+    if adjustment.RefundStatus == models.AdjustmentRefundPending {
+        adjustment.RefundStatus = models.AdjustmentRefunded
+        if err := u.paymentUsecase.ProcessPartialRefund(payment.RazorpayID, gatewayRefund); err != nil {
+            log.Printf("Subscription %d: cancelled but the refund of %.2f %s on %s failed: %v", subscriptionID, gatewayRefund, payment.Currency, payment.RazorpayID, err)
+            adjustment.RefundStatus = models.AdjustmentRefundFailed
+        }
+        if err := u.subscriptionRepo.UpdateAdjustmentRefundStatus(adjustment.AdjustmentID, adjustment.RefundStatus); err != nil {
+            log.Printf("Subscription %d: failed to record refund outcome %s: %v", subscriptionID, adjustment.RefundStatus, err)
+        }
+        if adjustment.RefundStatus == models.AdjustmentRefundFailed {
+            return adjustment, nil
+        }
+    }
+    if adjustment.Amount < 0 {
+        // Credit notes are in the invoice's currency, which for a gateway
+        // payment is the checkout currency; wallet refunds are converted.
+        var err error
+        if adjustment.Method == "razorpay" {
+            _, err = u.invoiceUsecase.IssueSubscriptionCreditNote(subscriptionID, gatewayRefund, "Pro-rata refund for cancelled subscription")
+        } else {
+            _, err = u.invoiceUsecase.IssueSubscriptionWalletCreditNote(subscriptionID, -adjustment.Amount, "Pro-rata refund for cancelled subscription")
+        }
+        if err != nil {
+            log.Printf("Subscription %d: refunded %.2f but no credit note was issued: %v", subscriptionID, -adjustment.Amount, err)
+        }
+    }
+    return adjustment, nil
+}
+
+// ChangePlan starts a fresh term on the new plan. The unused value of the
+// current term is set against the new plan's price and the difference is
+// charged to, or credited back to, the user's wallet.
+func (u *subscriptionUsecase) ChangePlan(userID, subscriptionID, newPlanID uint) (*models.SubscriptionAdjustment, error) {
+    subscription, err := u.ownSubscription(userID, subscriptionID)
+    if err != nil {
+        return nil, err
+    }
+    if !subscription.IsActive(time.Now()) || subscription.Status == models.SubscriptionStatusGrace {
+        return nil, fmt.Errorf("only active subscriptions can change plan")
+    }
+    if subscription.PlanID == newPlanID {
+        return nil, fmt.Errorf("subscription is already on this plan")
+    }
+    plan, err := u.planRepo.GetSubscriptionPlanByID(newPlanID)
+    if err != nil {
+        return nil, fmt.Errorf("plan not found: %w", err)
+    }
+    if plan.CardType != subscription.CardType {
+        return nil, fmt.Errorf("plan is for %s cards, this subscription is on a %s card", plan.CardType, subscription.CardType)
+    }
+    wallet, err := u.walletRepo.GetWalletByUserID(userID)
+    if err != nil {
+        return nil, fmt.Errorf("wallet not found for user")
+    }
+
+    now := time.Now()
+    credit, remainingDays := unusedValue(subscription, now)
+    adjustment := &models.SubscriptionAdjustment{
+        SubscriptionID: subscriptionID,
+        UserID:         userID,
+        Type:           models.AdjustmentPlanChange,
+        FromPlanID:     subscription.PlanID,
+        ToPlanID:       plan.PlanID,
+        Days:           remainingDays,
+        Amount:         roundAmount(plan.Price - credit),
+        Method:         "wallet",
+        Details:        fmt.Sprintf("%.2f unused credit against new plan price %.2f", credit, plan.Price),
+    }
+
+    subscription.PlanID = plan.PlanID
+    subscription.ServiceType = plan.PlanName
+    subscription.Price = plan.Price
+    subscription.DurationDays = plan.DurationDays
+    subscription.StartDate = now
+    subscription.EndDate = now.AddDate(0, 0, plan.DurationDays)
+    subscription.RideAllowance = plan.RideAllowance
+    if err := u.subscriptionRepo.ChangePlan(subscription, adjustment, wallet.WalletID); err != nil {
+        if errors.Is(err, repository.ErrInsufficientBalance) {
+            return nil, fmt.Errorf("insufficient wallet balance to pay the difference of %.2f", adjustment.Amount)
+        }
+        return nil, err
+    }
+    if adjustment.Amount < 0 {
+        if _, err := u.invoiceUsecase.IssueSubscriptionWalletCreditNote(subscriptionID, -adjustment.Amount, "Unused value credited on plan change"); err != nil {
+            log.Printf("Subscription %d: credited %.2f on plan change but no credit note was issued: %v", subscriptionID, -adjustment.Amount, err)
+        }
+    }
+    return adjustment, nil
+}
+
+func (u *subscriptionUsecase) GetAdjustments(userID, subscriptionID uint) ([]models.SubscriptionAdjustment, error) {
+    if _, err := u.ownSubscription(userID, subscriptionID); err != nil {
+        return nil, err
+    }
+    return u.subscriptionRepo.GetAdjustments(subscriptionID)
+}
+
+// FindCoveringSubscription checks each scope field the plan sets: the route's
+// category, the route list, the corridor (both journey stops must lie between
+// the corridor stops on this route) and the rides left in the allowance.
+func (u *subscriptionUsecase) FindCoveringSubscription(nolCardID uint, routeID, fromStopID, toStopID int) (*models.Subscription, error) {
+    subscription, err := u.subscriptionRepo.GetActiveSubscriptionByNolCardID(nolCardID)
+    if err != nil || subscription == nil {
+        return nil, err
+    }
+    if !subscription.IsActive(time.Now()) {
+        return nil, nil
+    }
+    plan, err := u.planRepo.GetSubscriptionPlanByID(subscription.PlanID)
+    if err != nil {
+        return nil, err
+    }
+
+    if plan.CategoryID != nil {
+        categoryID, err := u.planRepo.GetRouteCategoryID(routeID)
+        if err != nil || categoryID != *plan.CategoryID {
+            return nil, nil
+        }
+    }
+    if len(plan.RouteIDs) > 0 {
+        listed := false
+        for _, id := range plan.RouteIDs {
+            if id == routeID {
+                listed = true
+                break
+            }
+        }
+        if !listed {
+            return nil, nil
+        }
+    }
+    if plan.CorridorFromStopID != nil && plan.CorridorToStopID != nil {
+        covered, err := u.withinCorridor(routeID, *plan.CorridorFromStopID, *plan.CorridorToStopID, fromStopID, toStopID)
+        if err != nil || !covered {
+            return nil, err
+        }
+    }
+    if subscription.RideAllowance > 0 && subscription.RidesUsed >= subscription.RideAllowance {
+        return nil, nil
+    }
+    return subscription, nil
+}
+
+func (u *subscriptionUsecase) FindCoveringSubscriptionBySequence(nolCardID uint, routeID, fromSequence, toSequence int) (*models.Subscription, error) {
+    fromStopID, err := u.planRepo.GetStopIDAtSequence(routeID, fromSequence)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    toStopID, err := u.planRepo.GetStopIDAtSequence(routeID, toSequence)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    return u.FindCoveringSubscription(nolCardID, routeID, fromStopID, toStopID)
+}
+
+func (u *subscriptionUsecase) withinCorridor(routeID, corridorFrom, corridorTo, fromStopID, toStopID int) (bool, error) {
+    sequences := make([]int, 0, 4)
+    for _, stopID := range []int{corridorFrom, corridorTo, fromStopID, toStopID} {
+        sequence, err := u.planRepo.GetStopSequence(routeID, stopID)
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return false, nil
+        }
+        if err != nil {
+            return false, err
+        }
+        sequences = append(sequences, sequence)
+    }
+    low, high := sequences[0], sequences[1]
+    if low > high {
+        low, high = high, low
+    }
+    for _, sequence := range sequences[2:] {
+        if sequence < low || sequence > high {
+            return false, nil
+        }
+    }
+    return true, nil
+}
@@ -0,0 +1,178 @@
+package usecase
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "github.com/Prototype-1/xtrace/pkg/utils"
+)
+
+const (
+    // Daily caps per sender, counted from local midnight.
+    DailyWalletTransferLimit  = 10000.0
+    DailyNolCardTransferLimit = 5000.0
+
+    TransferOTPValidity    = 10 * time.Minute
+    MaxTransferOTPAttempts = 3
+)
+
+var (
+    ErrTransferNotFound    = errors.New("transfer not found")
+    ErrInvalidTransferOTP  = errors.New("invalid or expired OTP")
+    ErrDailyTransferLimit  = repository.ErrDailyTransferLimit
+)
+
+type WalletTransferUsecase interface {
+    InitiateWalletTransfer(senderUserID uint, recipientEmail string, amount float64, note string) (*models.WalletTransfer, error)
+    InitiateNolCardTransfer(senderUserID uint, cardNumber string, amount float64, note string) (*models.WalletTransfer, error)
+    ConfirmTransfer(senderUserID, transferID uint, otp string) (*models.WalletTransfer, error)
+    GetTransfersByUserID(userID uint) ([]models.WalletTransfer, error)
+}
+
+type walletTransferUsecaseImpl struct {
+    transferRepo repository.WalletTransferRepository
+    walletRepo   repository.WalletRepository
+    nolCardRepo  repository.NolCardRepository
+    userRepo     repository.UserRepository
+}
+
+func NewWalletTransferUsecase(transferRepo repository.WalletTransferRepository, walletRepo repository.WalletRepository, nolCardRepo repository.NolCardRepository, userRepo repository.UserRepository) WalletTransferUsecase {
+    return &walletTransferUsecaseImpl{
+        transferRepo: transferRepo,
+        walletRepo:   walletRepo,
+        nolCardRepo:  nolCardRepo,
+        userRepo:     userRepo,
+    }
+}
+
+func (u *walletTransferUsecaseImpl) InitiateWalletTransfer(senderUserID uint, recipientEmail string, amount float64, note string) (*models.WalletTransfer, error) {
+    recipient, err := u.userRepo.GetUserByEmail(strings.TrimSpace(recipientEmail))
+    if err != nil || recipient == nil {
+        return nil, fmt.Errorf("recipient not found")
+    }
+    if recipient.ID == senderUserID {
+        return nil, fmt.Errorf("cannot transfer to your own wallet")
+    }
+    recipientWallet, err := u.walletRepo.GetWalletByUserID(recipient.ID)
+    if err != nil {
+        return nil, fmt.Errorf("recipient does not have a wallet")
+    }
+
+    transfer := &models.WalletTransfer{
+        RecipientType:     models.TransferToWallet,
+        RecipientWalletID: &recipientWallet.WalletID,
+        Amount:            amount,
+        Note:              note,
+    }
+    return u.initiate(senderUserID, transfer, DailyWalletTransferLimit,
+        fmt.Sprintf("%s %s", recipient.FirstName, recipient.LastName))
+}
+
+func (u *walletTransferUsecaseImpl) InitiateNolCardTransfer(senderUserID uint, cardNumber string, amount float64, note string) (*models.WalletTransfer, error) {
+    nolCard, err := u.nolCardRepo.GetNolCardByNumber(strings.TrimSpace(cardNumber))
+    if errors.Is(err, utils.ErrInvalidCardNumber) {
+        return nil, err
+    }
+    if err != nil || nolCard == nil {
+        return nil, fmt.Errorf("NolCard not found")
+    }
+    if !nolCard.IsUsable() {
+        return nil, repository.ErrNolCardNotUsable
+    }
+
+    transfer := &models.WalletTransfer{
+        RecipientType:      models.TransferToNolCard,
+        RecipientNolCardID: &nolCard.NolCardID,
+        Amount:             amount,
+        Note:               note,
+    }
+    return u.initiate(senderUserID, transfer, DailyNolCardTransferLimit, "NolCard "+nolCard.CardNumber)
+}
+
+// initiate checks the sender's balance and daily limit, stores the pending
+// transfer and emails the OTP the sender must confirm it with.
+func (u *walletTransferUsecaseImpl) initiate(senderUserID uint, transfer *models.WalletTransfer, dailyLimit float64, recipientLabel string) (*models.WalletTransfer, error) {
+    if transfer.Amount <= 0 {
+        return nil, fmt.Errorf("transfer amount must be greater than zero")
+    }
+    transfer.Amount = roundAmount(transfer.Amount)
+
+    sender, err := u.userRepo.GetUserByID(senderUserID)
+    if err != nil || sender == nil {
+        return nil, fmt.Errorf("sender not found")
+    }
+    wallet, err := u.walletRepo.GetWalletByUserID(senderUserID)
+    if err != nil {
+        return nil, fmt.Errorf("wallet not found for user")
+    }
+    if transfer.RecipientWalletID != nil && *transfer.RecipientWalletID == wallet.WalletID {
+        return nil, fmt.Errorf("cannot transfer to your own wallet")
+    }
+    if wallet.Balance < transfer.Amount {
+        return nil, fmt.Errorf("insufficient balance in wallet ID %d", wallet.WalletID)
+    }
+
+    now := time.Now()
+    startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+    transfer.SenderUserID = senderUserID
+    transfer.SenderWalletID = wallet.WalletID
+    transfer.Status = models.TransferStatusPending
+    transfer.OTP = utils.GenerateOTP()
+    transfer.OTPExpiry = now.Add(TransferOTPValidity)
+    sentToday, err := u.transferRepo.CreateTransferWithinLimit(transfer, startOfDay, dailyLimit)
+    if errors.Is(err, ErrDailyTransferLimit) {
+        return nil, fmt.Errorf("%w: %.2f of %.2f already used today", ErrDailyTransferLimit, sentToday, dailyLimit)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("failed to create transfer: %v", err)
+    }
+
+    body := fmt.Sprintf("You requested a transfer of %.2f %s from your wallet to %s.\nYour OTP is: %s\nIt expires in %d minutes. If this wasn't you, ignore this email.",
+        transfer.Amount, models.BaseCurrency, recipientLabel, transfer.OTP, int(TransferOTPValidity.Minutes()))
+    if err := utils.SendEmail(sender.Email, "Confirm your wallet transfer", body); err != nil {
+        log.Printf("Failed to send transfer OTP for transfer %d: %v", transfer.TransferID, err)
+        _ = u.transferRepo.CancelTransfer(transfer.TransferID)
+        return nil, fmt.Errorf("failed to send OTP email")
+    }
+
+    return transfer, nil
+}
+
+func (u *walletTransferUsecaseImpl) ConfirmTransfer(senderUserID, transferID uint, otp string) (*models.WalletTransfer, error) {
+    transfer, err := u.transferRepo.GetTransferByID(transferID)
+    if err != nil || transfer.SenderUserID != senderUserID {
+        return nil, ErrTransferNotFound
+    }
+    if transfer.Status != models.TransferStatusPending {
+        return nil, fmt.Errorf("transfer is already %s", transfer.Status)
+    }
+
+    if time.Now().After(transfer.OTPExpiry) || transfer.OTPAttempts >= MaxTransferOTPAttempts {
+        _ = u.transferRepo.CancelTransfer(transfer.TransferID)
+        return nil, ErrInvalidTransferOTP
+    }
+    if transfer.OTP != strings.TrimSpace(otp) {
+        if err := u.transferRepo.IncrementOTPAttempts(transfer.TransferID); err != nil {
+            log.Printf("Failed to record OTP attempt for transfer %d: %v", transfer.TransferID, err)
+        }
+        return nil, ErrInvalidTransferOTP
+    }
+
+    if err := u.transferRepo.CompleteTransfer(transfer); err != nil {
+        if errors.Is(err, repository.ErrInsufficientBalance) {
+            _ = u.transferRepo.CancelTransfer(transfer.TransferID)
+            return nil, fmt.Errorf("insufficient balance in wallet ID %d", transfer.SenderWalletID)
+        }
+        return nil, fmt.Errorf("failed to complete transfer: %w", err)
+    }
+    return transfer, nil
+}
+
+func (u *walletTransferUsecaseImpl) GetTransfersByUserID(userID uint) ([]models.WalletTransfer, error) {
+    return u.transferRepo.GetTransfersByUserID(userID)
+}
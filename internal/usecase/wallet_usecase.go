@@ -3,8 +3,10 @@ package usecase
 import (
     "github.com/Prototype-1/xtrace/internal/models"
     "github.com/Prototype-1/xtrace/internal/repository"
+	"errors"
 	"fmt"
     "log"
+    "gorm.io/gorm"
 )
 
 type WalletUsecase interface {
@@ -47,68 +49,51 @@ func (u *walletUsecaseImpl) GetWalletByUserID(userID uint) (*models.Wallet, erro
 }
 
 func (u *walletUsecaseImpl) TopUpWallet(walletID *uint, adminID *uint, amount float64, description string, transactionType string) error {
-    wallet, err := u.walletRepo.GetWalletByID(*walletID)
-    if err != nil {
-        return fmt.Errorf("wallet not found: %v", err)
+    if walletID == nil {
+        return fmt.Errorf("wallet ID is required")
     }
-    newBalance := wallet.Balance + amount
-
-    if err := u.walletRepo.UpdateWalletBalance(wallet.WalletID, newBalance); err != nil {
-        return fmt.Errorf("failed to update wallet balance: %v", err)
-    }
-
-    var adminIDPtr *uint
-    if adminID != nil {
-        adminIDPtr = adminID
-    } else {
-        adminIDPtr = nil
+    if amount <= 0 {
+        return fmt.Errorf("top-up amount must be greater than zero")
     }
 
     transaction := models.WalletTransaction{
-        WalletID:    wallet.WalletID,
-        AdminID:     adminIDPtr,
-        Amount:      amount,
-        TransactionType:  transactionType,
-        Description: description,
+        AdminID:         adminID,
+        Amount:          amount,
+        TransactionType: transactionType,
+        Description:     description,
     }
-    return u.walletTransactionRepo.CreateTransaction(&transaction)
+    if _, err := u.walletRepo.CreditWallet(*walletID, amount, &transaction); err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            return fmt.Errorf("wallet not found: %v", err)
+        }
+        return fmt.Errorf("failed to update wallet balance: %v", err)
+    }
+    return nil
 }
 
 func (u *walletUsecaseImpl) MakePayment(walletID uint, amount float64, transactionType string) error {
-    wallet, err := u.walletRepo.GetWalletByID(walletID)
-    if err != nil {
-        log.Printf("Error retrieving wallet (ID: %d): %v", walletID, err)
-        return fmt.Errorf("wallet not found for ID %d: %v", walletID, err)
+    if amount <= 0 {
+        return fmt.Errorf("payment amount must be greater than zero")
     }
 
-    log.Printf("Retrieved wallet: %+v", wallet)
-
-    log.Printf("Wallet Balance: %.2f, Payment Amount: %.2f", wallet.Balance, amount)
-
-    if wallet.Balance < amount {
-        return fmt.Errorf("insufficient balance in wallet ID %d", walletID)
-    }
-
-    newBalance := wallet.Balance - amount
-    if err := u.walletRepo.UpdateWalletBalance(wallet.WalletID, newBalance); err != nil {
-        log.Printf("Failed to deduct balance from wallet (ID: %d): %v", wallet.WalletID, err)
-        return fmt.Errorf("failed to deduct wallet balance for ID %d: %v", wallet.WalletID, err)
-    }
-
-    var adminID *uint = nil 
     transaction := models.WalletTransaction{
-        WalletID:       wallet.WalletID,
-        AdminID:        adminID, 
-        Amount:         amount,
-        TransactionType: transactionType, // Use the transactionType parameter
+        Amount:          amount,
+        TransactionType: transactionType,
         Description:     "User made a payment",
     }
-    if err := u.walletTransactionRepo.CreateTransaction(&transaction); err != nil {
-        log.Printf("Error creating wallet transaction for wallet (ID: %d): %v", wallet.WalletID, err)
-        return fmt.Errorf("failed to create wallet transaction for ID %d: %v", wallet.WalletID, err)
+    wallet, err := u.walletRepo.DebitWallet(walletID, amount, &transaction)
+    if err != nil {
+        switch {
+        case errors.Is(err, repository.ErrInsufficientBalance):
+            return fmt.Errorf("insufficient balance in wallet ID %d", walletID)
+        case errors.Is(err, gorm.ErrRecordNotFound):
+            return fmt.Errorf("wallet not found for ID %d: %v", walletID, err)
+        }
+        log.Printf("Failed to deduct balance from wallet (ID: %d): %v", walletID, err)
+        return fmt.Errorf("failed to deduct wallet balance for ID %d: %v", walletID, err)
     }
-    log.Printf("Transaction created successfully: %+v", transaction)
-    
+    log.Printf("Wallet %d debited %.2f, new balance %.2f", walletID, amount, wallet.Balance)
+
     return nil
 }
 
@@ -0,0 +1,154 @@
+package usecase
+
+import (
+    "math/rand"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+
+    "github.com/Prototype-1/xtrace/internal/models"
+    "github.com/Prototype-1/xtrace/internal/repository"
+    "gorm.io/driver/postgres"
+    "gorm.io/gorm"
+    "gorm.io/gorm/logger"
+)
+
+// newTestWalletUsecase returns a wallet usecase over the Postgres database in
+// TEST_DATABASE_URL and a wallet holding balance, skipping the test when the
+// database is not configured.
+func newTestWalletUsecase(t *testing.T, balance float64) (WalletUsecase, *gorm.DB, uint) {
+    t.Helper()
+    dsn := os.Getenv("TEST_DATABASE_URL")
+    if dsn == "" {
+        t.Skip("TEST_DATABASE_URL is not set")
+    }
+    db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+    if err != nil {
+        t.Fatalf("connecting to test database: %v", err)
+    }
+    if err := db.AutoMigrate(&models.Wallet{}, &models.WalletTransaction{}); err != nil {
+        t.Fatalf("migrating test database: %v", err)
+    }
+    wallet := &models.Wallet{UserID: uint(rand.Int31()), Balance: balance}
+    if err := db.Create(wallet).Error; err != nil {
+        t.Fatalf("creating wallet: %v", err)
+    }
+    t.Cleanup(func() {
+        db.Where("wallet_id = ?", wallet.WalletID).Delete(&models.WalletTransaction{})
+        db.Where("wallet_id = ?", wallet.WalletID).Delete(&models.Wallet{})
+    })
+    walletUsecase := NewWalletUsecase(repository.NewWalletRepository(db), repository.NewWalletTransactionRepository(db))
+    return walletUsecase, db, wallet.WalletID
+}
+
+// TestWalletUsecaseConcurrentPaymentsAndTopUps races payments against top-ups
+// on one wallet and checks that every accepted move is in the balance and the
+// ledger, and that no payment was allowed to overdraw the wallet.
+func TestWalletUsecaseConcurrentPaymentsAndTopUps(t *testing.T) {
+    walletUsecase, db, walletID := newTestWalletUsecase(t, 50)
+
+    const workers = 30
+    var (
+        wg   sync.WaitGroup
+        mu   sync.Mutex
+        paid int
+    )
+    for i := 0; i < workers; i++ {
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            if err := walletUsecase.TopUpWallet(&walletID, nil, 5, "test top-up", "top-up"); err != nil {
+                t.Errorf("unexpected top-up error: %v", err)
+            }
+        }()
+        go func() {
+            defer wg.Done()
+            err := walletUsecase.MakePayment(walletID, 20, "payment")
+            mu.Lock()
+            defer mu.Unlock()
+            switch {
+            case err == nil:
+                paid++
+            case !strings.Contains(err.Error(), "insufficient balance"):
+                t.Errorf("unexpected payment error: %v", err)
+            }
+        }()
+    }
+    wg.Wait()
+
+    wallet, err := walletUsecase.GetWalletByID(walletID)
+    if err != nil {
+        t.Fatalf("reading wallet: %v", err)
+    }
+    want := 50 + workers*5 - float64(paid)*20
+    if wallet.Balance < 0 {
+        t.Errorf("balance = %.2f, went negative", wallet.Balance)
+    }
+    if wallet.Balance != want {
+        t.Errorf("balance = %.2f, want %.2f after %d payments", wallet.Balance, want, paid)
+    }
+
+    transactions, err := walletUsecase.GetWalletTransactions(walletID)
+    if err != nil {
+        t.Fatalf("reading transactions: %v", err)
+    }
+    if len(transactions) != workers+paid {
+        t.Errorf("transactions = %d, want %d", len(transactions), workers+paid)
+    }
+
+    // The ledger, replayed from the opening balance, must agree with the wallet.
+    var ordered []models.WalletTransaction
+    if err := db.Where("wallet_id = ?", walletID).Order("transaction_id").Find(&ordered).Error; err != nil {
+        t.Fatalf("reading ledger: %v", err)
+    }
+    running := 50.0
+    for _, transaction := range ordered {
+        if transaction.TransactionType == "payment" {
+            running -= transaction.Amount
+        } else {
+            running += transaction.Amount
+        }
+    }
+    if running != wallet.Balance {
+        t.Errorf("ledger replays to %.2f, balance is %.2f", running, wallet.Balance)
+    }
+}
+
+func TestWalletUsecaseConcurrentPaymentsStopAtZero(t *testing.T) {
+    walletUsecase, _, walletID := newTestWalletUsecase(t, 60)
+
+    const workers = 20
+    var (
+        wg   sync.WaitGroup
+        mu   sync.Mutex
+        paid int
+    )
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if err := walletUsecase.MakePayment(walletID, 15, "payment"); err == nil {
+                mu.Lock()
+                paid++
+                mu.Unlock()
+            }
+        }()
+    }
+    wg.Wait()
+
+    wallet, err := walletUsecase.GetWalletByID(walletID)
+    if err != nil {
+        t.Fatalf("reading wallet: %v", err)
+    }
+    if paid != 4 || wallet.Balance != 0 {
+        t.Errorf("paid = %d, balance = %.2f; want 4 payments and a zero balance", paid, wallet.Balance)
+    }
+    transactions, err := walletUsecase.GetWalletTransactions(walletID)
+    if err != nil {
+        t.Fatalf("reading transactions: %v", err)
+    }
+    if len(transactions) != paid {
+        t.Errorf("transactions = %d, want %d", len(transactions), paid)
+    }
+}
@@ -81,15 +81,21 @@ func main() {
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", middleware.IdempotencyKeyHeader},
 		AllowCredentials: true,
 	}))
 
 	router.Static("/static", "./static")
 
+	idempotencyRepo := repository.NewIdempotencyRepository(config.DB)
+	idempotency := middleware.IdempotencyMiddleware(idempotencyRepo)
+
 	razorpayRepo := repository.NewRazorpayPaymentRepository(config.DB)
 	couponRepo := repository.NewCouponRepository(config.DB)
-	razorpayUsecase := usecase.NewRazorpayPaymentUsecase(razorpayRepo, razorpayClient, couponRepo)
+	exchangeRateRepo := repository.NewExchangeRateRepository(config.DB)
+	loyaltyRepo := repository.NewLoyaltyRepository(config.DB)
+	exchangeRateUsecase := usecase.NewExchangeRateUsecase(exchangeRateRepo)
+	exchangeRateHandler := handler.NewExchangeRateHandler(exchangeRateUsecase)
 
 	userRepo := repository.NewUserRepository(config.DB)
 	userUsecase := usecase.NewUserUsecase(userRepo)
@@ -103,6 +109,9 @@ func main() {
             if err != nil {
                 log.Printf("Error running unsuspend task: %v\n", err)
             }
+			if err := idempotencyRepo.DeleteExpiredKeys(); err != nil {
+				log.Printf("Error purging expired idempotency keys: %v\n", err)
+			}
         }
     }()
 
@@ -131,11 +140,62 @@ func main() {
 	fareRuleRepo := repository.NewFareRuleRepository(config.DB)
 	osrmService := domain.NewOSRMService()
 	fareRuleUsecase := usecase.NewFareRuleUsecase(fareRuleRepo, osrmService)
-	fareRuleHandler := handler.NewFareRuleHandler(fareRuleUsecase)
+
+	loyaltyUsecase := usecase.NewLoyaltyUsecase(loyaltyRepo)
+	loyaltyHandler := handler.NewLoyaltyHandler(loyaltyUsecase)
+
+	if err := loyaltyRepo.EnsureDefaultEarnRates(); err != nil {
+		log.Fatalf("Error seeding loyalty earn rates: %v", err)
+	}
+
+	invoiceRepo := repository.NewInvoiceRepository(config.DB)
+	invoiceUsecase := usecase.NewInvoiceUsecase(invoiceRepo, userRepo)
+	invoiceHandler := handler.NewInvoiceHandler(invoiceUsecase)
+
+	if err := invoiceUsecase.EnsureDefaultTaxRules(); err != nil {
+		log.Fatalf("Error seeding tax rules: %v", err)
+	}
+
+	razorpayUsecase := usecase.NewRazorpayPaymentUsecase(razorpayRepo, razorpayClient, couponRepo, exchangeRateRepo, loyaltyRepo, invoiceUsecase)
+
+	// Coupon slots, points and draft invoices held by payments that were never
+	// completed are released after half an hour.
+	couponReservationTicker := time.NewTicker(15 * time.Minute)
+	go func() {
+		for range couponReservationTicker.C {
+			if _, err := razorpayUsecase.ReleaseStaleCouponReservations(time.Now().Add(-30 * time.Minute)); err != nil {
+				log.Printf("Error releasing stale coupon reservations: %v\n", err)
+			}
+			if _, err := loyaltyUsecase.ReleaseStaleRedemptions(time.Now().Add(-30 * time.Minute)); err != nil {
+				log.Printf("Error returning held loyalty points: %v\n", err)
+			}
+			if _, err := invoiceUsecase.VoidStaleDrafts(time.Now().Add(-30 * time.Minute)); err != nil {
+				log.Printf("Error voiding expired draft invoices: %v\n", err)
+			}
+		}
+	}()
+
+	loyaltyExpiryTicker := time.NewTicker(24 * time.Hour)
+	go func() {
+		for range loyaltyExpiryTicker.C {
+			expired, err := loyaltyUsecase.ExpirePoints(time.Now())
+			if err != nil {
+				log.Printf("Error expiring loyalty points: %v\n", err)
+				continue
+			}
+			if expired > 0 {
+				log.Printf("Expired %d loyalty points", expired)
+			}
+		}
+	}()
 
 	couponUsecase := usecase.NewCouponUsecase(couponRepo)
 	couponHandler := handler.NewCouponHandler(couponUsecase)
 
+	campaignRepo := repository.NewCampaignRepository(config.DB)
+	campaignUsecase := usecase.NewCampaignUsecase(campaignRepo)
+	campaignHandler := handler.NewCampaignHandler(campaignUsecase)
+
 	nolCardTopupRepo := repository.NewNolCardTopupRepository(config.DB)
 	nolCardTopupUsecase := usecase.NewNolCardTopupUsecase(nolCardTopupRepo)
 	nolCardTopupHandler := handler.NewNolCardTopupHandler(nolCardTopupUsecase)
@@ -144,34 +204,173 @@ func main() {
 	nolCardUsecase := usecase.NewNolCardUsecase(nolCardRepo)
 	nolCardHandler := handler.NewNolCardHandler(nolCardUsecase)
 
+	if err := nolCardRepo.EnsureDefaultCardNumberSeries(); err != nil {
+		log.Fatalf("Error seeding card number prefixes: %v", err)
+	}
+	// Cards issued before numbers were generated are only reported here; an
+	// admin reissues each one so the rider can be sent a matching card.
+	if invalidCards, err := nolCardUsecase.ReportInvalidCardNumbers(); err != nil {
+		log.Printf("Error checking Nol Card numbers: %v\n", err)
+	} else if len(invalidCards) > 0 {
+		log.Printf("%d Nol Cards have invalid numbers; see /admin/nolcard/invalid-numbers", len(invalidCards))
+	}
+
+	nolCardUpgradeRepo := repository.NewNolCardUpgradeRepository(config.DB)
+	nolCardUpgradeUsecase := usecase.NewNolCardUpgradeUsecase(nolCardUpgradeRepo, nolCardRepo)
+	nolCardUpgradeHandler := handler.NewNolCardUpgradeHandler(nolCardUpgradeUsecase)
+
+	nolCardExpiryTicker := time.NewTicker(24 * time.Hour)
+	go func() {
+		for range nolCardExpiryTicker.C {
+			expired, err := nolCardUsecase.ExpireNolCards()
+			if err != nil {
+				log.Printf("Error expiring Nol Cards: %v\n", err)
+				continue
+			}
+			log.Printf("Expired %d Nol Cards", expired)
+		}
+	}()
+
 	walletRepo := repository.NewWalletRepository(config.DB)
 	walletTransactionRepo := repository.NewWalletTransactionRepository(config.DB)
 	walletUsecase := usecase.NewWalletUsecase(walletRepo, walletTransactionRepo)
-	walletHandler := handler.NewWalletHandler(walletUsecase, razorpayUsecase)
+
+	autoReloadRepo := repository.NewAutoReloadRepository(config.DB)
+	autoReloadUsecase := usecase.NewAutoReloadUsecase(autoReloadRepo, walletRepo, userRepo, walletUsecase, nolCardTopupUsecase, razorpayUsecase, razorpayClient)
+	autoReloadHandler := handler.NewAutoReloadHandler(autoReloadUsecase)
+
+	walletHandler := handler.NewWalletHandler(walletUsecase, razorpayUsecase, exchangeRateUsecase, autoReloadUsecase)
+
+	walletTransferRepo := repository.NewWalletTransferRepository(config.DB)
+	walletTransferUsecase := usecase.NewWalletTransferUsecase(walletTransferRepo, walletRepo, nolCardRepo, userRepo)
+	walletTransferHandler := handler.NewWalletTransferHandler(walletTransferUsecase, autoReloadUsecase)
+
+	autoReloadTicker := time.NewTicker(15 * time.Minute)
+	go func() {
+		for range autoReloadTicker.C {
+			if err := autoReloadUsecase.RunAutoReloads(); err != nil {
+				log.Printf("Error running auto-reloads: %v\n", err)
+			}
+		}
+	}()
 
 	subscriptionRepo := repository.NewSubscriptionRepository(config.DB)
 	subscriptionPlanRepo := repository.NewSubscriptionPlanRepository(config.DB)
-	subscriptionUsecase := usecase.NewSubscriptionUsecase(subscriptionRepo, subscriptionPlanRepo, razorpayClient)
-	subscriptionHandler := handler.NewSubscriptionHandler(subscriptionUsecase, nolCardRepo, subscriptionRepo,  razorpayUsecase, walletUsecase)
+	subscriptionUsecase := usecase.NewSubscriptionUsecase(subscriptionRepo, subscriptionPlanRepo, razorpayClient, walletRepo, razorpayUsecase, invoiceUsecase)
+	subscriptionHandler := handler.NewSubscriptionHandler(subscriptionUsecase, nolCardRepo, subscriptionRepo,  razorpayUsecase, walletUsecase, loyaltyUsecase)
+	fareRuleHandler := handler.NewFareRuleHandler(fareRuleUsecase, exchangeRateUsecase, subscriptionUsecase, nolCardRepo)
+
+	nolCardStatementRepo := repository.NewNolCardStatementRepository(config.DB)
+	nolCardStatementUsecase := usecase.NewNolCardStatementUsecase(nolCardStatementRepo, nolCardRepo, userRepo, fareRuleUsecase, subscriptionUsecase, loyaltyUsecase, autoReloadUsecase, invoiceUsecase)
+	nolCardStatementHandler := handler.NewNolCardStatementHandler(nolCardStatementUsecase)
+
+	// Checked daily; each card's statement for the previous month is emailed once.
+	statementTicker := time.NewTicker(24 * time.Hour)
+	go func() {
+		for range statementTicker.C {
+			sent, err := nolCardStatementUsecase.SendMonthlyStatements(time.Now())
+			if err != nil {
+				log.Printf("Error sending monthly statements: %v\n", err)
+				continue
+			}
+			if sent > 0 {
+				log.Printf("Sent %d monthly NolCard statements", sent)
+			}
+		}
+	}()
+
+	subscriptionRenewalRepo := repository.NewSubscriptionRenewalRepository(config.DB)
+	subscriptionRenewalUsecase := usecase.NewSubscriptionRenewalUsecase(subscriptionRenewalRepo, subscriptionRepo, walletRepo, userRepo, razorpayUsecase, razorpayClient)
+	subscriptionRenewalHandler := handler.NewSubscriptionRenewalHandler(subscriptionRenewalUsecase)
+
+	subscriptionRenewalTicker := time.NewTicker(1 * time.Hour)
+	go func() {
+		for range subscriptionRenewalTicker.C {
+			if _, err := subscriptionUsecase.ResumeOverduePauses(time.Now()); err != nil {
+				log.Printf("Error resuming paused subscriptions: %v\n", err)
+			}
+			if err := subscriptionRenewalUsecase.RunRenewals(time.Now()); err != nil {
+				log.Printf("Error running subscription renewals: %v\n", err)
+			}
+		}
+	}()
+
+	organisationRepo := repository.NewOrganisationRepository(config.DB)
+	organisationUsecase := usecase.NewOrganisationUsecase(organisationRepo, userRepo, nolCardRepo, subscriptionRepo, invoiceUsecase)
+	organisationHandler := handler.NewOrganisationHandler(organisationUsecase)
+
+	// Checked daily; each organisation is invoiced once for the previous month.
+	organisationInvoiceTicker := time.NewTicker(24 * time.Hour)
+	go func() {
+		for range organisationInvoiceTicker.C {
+			issued, err := organisationUsecase.GenerateMonthlyInvoices(time.Now())
+			if err != nil {
+				log.Printf("Error generating organisation invoices: %v\n", err)
+				continue
+			}
+			if issued > 0 {
+				log.Printf("Issued %d organisation invoices", issued)
+			}
+		}
+	}()
 
 	bookingRepo := repository.NewBookingRepository(config.DB)
 	bookingUsecase := usecase.NewBookingUsecase(bookingRepo)
 	bookingHandler := handler.NewBookingHandler(bookingUsecase)
 
-	invoiceRepo := repository.NewInvoiceRepository(config.DB) 
-invoiceUsecase := usecase.NewInvoiceUsecase(invoiceRepo)
-invoiceHandler := handler.NewInvoiceHandler(userRepo, invoiceRepo)
-
-	razorpayHandler := handler.NewRazorpayHandler(walletUsecase, razorpayUsecase, bookingUsecase, subscriptionUsecase, razorpayClient, nolCardTopupUsecase, invoiceUsecase)
-
-	revenueHandler := handler.NewRevenueHandler()
+	referralRepo := repository.NewReferralRepository(config.DB)
+	referralUsecase := usecase.NewReferralUsecase(referralRepo, userRepo)
+	referralHandler := handler.NewReferralHandler(referralUsecase)
+
+	razorpayHandler := handler.NewRazorpayHandler(walletUsecase, razorpayUsecase, bookingUsecase, subscriptionUsecase, razorpayClient, nolCardTopupUsecase, invoiceUsecase, exchangeRateUsecase, nolCardUpgradeUsecase, referralUsecase, loyaltyUsecase)
+
+	ridershipRepo := repository.NewRidershipRepository(config.DB)
+	ridershipUsecase := usecase.NewRidershipUsecase(ridershipRepo)
+	ridershipHandler := handler.NewRidershipHandler(ridershipUsecase)
+
+	// Rollups are brought up to date at start-up, then the last two days are
+	// rebuilt every 15 minutes.
+	go func() {
+		if err := ridershipUsecase.RefreshRollups(time.Now()); err != nil {
+			log.Printf("Error refreshing ridership rollups: %v\n", err)
+		}
+		ridershipTicker := time.NewTicker(15 * time.Minute)
+		for range ridershipTicker.C {
+			if err := ridershipUsecase.RefreshRollups(time.Now()); err != nil {
+				log.Printf("Error refreshing ridership rollups: %v\n", err)
+			}
+		}
+	}()
+
+	dashboardRepo := repository.NewDashboardRepository(config.DB)
+	dashboardUsecase := usecase.NewDashboardUsecase(dashboardRepo)
+	dashboardHandler := handler.NewDashboardHandler(dashboardUsecase)
+
+	revenueRepo := repository.NewRevenueRepository(config.DB)
+	revenueUsecase := usecase.NewRevenueUsecase(revenueRepo)
+	revenueHandler := handler.NewRevenueHandler(revenueUsecase)
+
+	reportRepo := repository.NewReportRepository(config.DB)
+	reportUsecase := usecase.NewReportUsecase(reportRepo, userRepo, revenueUsecase, ridershipUsecase)
+	reportHandler := handler.NewReportHandler(reportUsecase)
+
+	// Report schedules are checked every minute, the finest step of a cron
+	// expression.
+	go func() {
+		reportTicker := time.NewTicker(time.Minute)
+		for range reportTicker.C {
+			if _, err := reportUsecase.RunDueSchedules(time.Now()); err != nil {
+				log.Printf("Error running scheduled reports: %v\n", err)
+			}
+		}
+	}()
 
 	router.POST("/admin/signup", handler.AdminSignUp)
 	router.POST("/admin/login", handler.AdminLogin)
 	router.POST("/admin/logout", middleware.TokenAuthMiddleware(), middleware.AdminAuthMiddleware(), handler.AdminLogout)
 
-	router.POST("/user/signup", handler.UserSignUp)
-	router.POST("/user/verify-otp", handler.VerifyOTP)
+	router.POST("/user/signup", handler.UserSignUp(referralUsecase))
+	router.POST("/user/verify-otp", handler.VerifyOTP(referralUsecase))
 	router.POST("/user/login", handler.UserLogin)
 	router.POST("/user/logout", middleware.TokenAuthMiddleware(), middleware.UserAuthMiddleware(), handler.UserLogout)
 	router.POST("/user/resend-otp", handler.ResendOTP)
@@ -180,28 +379,23 @@ invoiceHandler := handler.NewInvoiceHandler(userRepo, invoiceRepo)
 	router.GET("/user/google/callback", handler.GoogleCallback)
 
 	router.GET("/:id/status", userHandler.GetUserStatus)
-	router.POST("/user/:userID/payment/create", razorpayHandler.CreatePayment)
+	router.POST("/user/:userID/payment/create", idempotency, razorpayHandler.CreatePayment)
 	router.GET("/user/payment/:type/:id/amount", razorpayHandler.GetAmountByPaymentType)
 
 	router.GET("/user/:userID/wallet/show", walletHandler.GetWallet)
 	router.GET("/user/:userID/nol-card/balance/show", nolCardTopupHandler.GetNolCardBalance)
-	router.POST("/user/:userID/nol-card/topup", nolCardTopupHandler.AddTopup)
+	router.POST("/user/:userID/nol-card/topup", idempotency, nolCardTopupHandler.AddTopup)
 
 	router.POST("/user/payment/verify", razorpayHandler.VerifyPayment)
 
 	router.GET("/coupons/:paymentType", razorpayHandler.FetchApplicableCoupons)
 	router.POST("/coupons/apply", razorpayHandler.ApplyCoupon)
 
-	router.GET("/invoices/getUserEmail", invoiceHandler.GetUserEmail)
-	router.POST("/invoices/sendInvoice", invoiceHandler.SendInvoice)
 	
 	router.GET("/view/users", userHandler.GetAllUsers)
 	router.GET("/categories", categoryHandler.GetAllCategoriesAdmin)
 	router.GET("/services/:category", handler.ListServices)
 
-	router.GET("/revenue/total", revenueHandler.GetTotalRevenue)
-	router.GET("/revenue/monthly", revenueHandler.GetMonthlyRevenue)
-
 	adminRoutes := router.Group("/admin").Use(middleware.TokenAuthMiddleware()).Use(middleware.AdminAuthMiddleware())
 	{
 		adminRoutes.GET("/users", userHandler.GetAllUsers)
@@ -240,11 +434,62 @@ invoiceHandler := handler.NewInvoiceHandler(userRepo, invoiceRepo)
 		adminRoutes.DELETE("/delete/coupons/:id", couponHandler.DeleteCoupon)
 		adminRoutes.GET("/coupons/:id", couponHandler.GetCouponByID)
 		adminRoutes.GET("/coupons", couponHandler.GetAllCoupons)
+		adminRoutes.GET("/coupons/:id/redemptions", couponHandler.GetRedemptions)
+		adminRoutes.POST("/campaigns", campaignHandler.CreateCampaign)
+		adminRoutes.GET("/campaigns", campaignHandler.GetCampaigns)
+		adminRoutes.GET("/campaigns/:campaign_id", campaignHandler.GetCampaign)
+		adminRoutes.PUT("/campaigns/:campaign_id/activate", campaignHandler.ActivateCampaign)
+		adminRoutes.PUT("/campaigns/:campaign_id/deactivate", campaignHandler.DeactivateCampaign)
+		adminRoutes.POST("/campaigns/:campaign_id/codes", campaignHandler.GenerateCodes)
+		adminRoutes.GET("/campaigns/:campaign_id/codes", campaignHandler.ExportCodes)
+		adminRoutes.GET("/campaigns/:campaign_id/stats", campaignHandler.GetStats)
+		adminRoutes.GET("/referrals", referralHandler.GetReferrals)
+		adminRoutes.POST("/referrals/:referral_id/reject", referralHandler.RejectReferral)
+		adminRoutes.GET("/referral-programme", referralHandler.GetProgramme)
+		adminRoutes.PUT("/referral-programme", referralHandler.UpdateProgramme)
+		adminRoutes.GET("/loyalty-programme", loyaltyHandler.GetProgramme)
+		adminRoutes.PUT("/loyalty-programme", loyaltyHandler.UpdateProgramme)
+		adminRoutes.GET("/tax-rules", invoiceHandler.GetTaxRules)
+		adminRoutes.PUT("/tax-rules/:item_type", invoiceHandler.SetTaxRule)
+		adminRoutes.GET("/invoices/tax-summary", invoiceHandler.GetTaxSummary)
+		adminRoutes.POST("/invoices/:invoice_id/credit-notes", idempotency, invoiceHandler.IssueCreditNote)
+		adminRoutes.GET("/dashboard/summary", dashboardHandler.GetSummary)
+		adminRoutes.GET("/dashboard/timeseries/:metric", dashboardHandler.GetTimeSeries)
+		adminRoutes.GET("/revenue", revenueHandler.GetRevenue)
+		adminRoutes.GET("/revenue/export", revenueHandler.ExportRevenue)
+		adminRoutes.GET("/ridership/stops", ridershipHandler.GetStopHours)
+		adminRoutes.GET("/ridership/od-matrix", ridershipHandler.GetODMatrix)
+		adminRoutes.GET("/ridership/routes/:route_id/load-profile", ridershipHandler.GetLoadProfile)
+		adminRoutes.GET("/ridership/heatmap", ridershipHandler.GetHeatmap)
+		adminRoutes.POST("/ridership/rebuild", ridershipHandler.RebuildRollups)
+		adminRoutes.POST("/reports/schedules", idempotency, reportHandler.CreateSchedule)
+		adminRoutes.GET("/reports/schedules", reportHandler.GetSchedules)
+		adminRoutes.PUT("/reports/schedules/:schedule_id", reportHandler.UpdateSchedule)
+		adminRoutes.DELETE("/reports/schedules/:schedule_id", reportHandler.DeleteSchedule)
+		adminRoutes.POST("/reports/schedules/:schedule_id/run", idempotency, reportHandler.RunSchedule)
+		adminRoutes.GET("/reports/runs", reportHandler.GetRuns)
+		adminRoutes.GET("/reports/runs/:run_id/download", reportHandler.DownloadRun)
+		adminRoutes.GET("/loyalty/earn-rates", loyaltyHandler.GetEarnRates)
+		adminRoutes.PUT("/loyalty/earn-rates/:card_type", loyaltyHandler.SetEarnRate)
 
 		adminRoutes.POST("/add/nolcard", nolCardHandler.AddNolCard)
-		adminRoutes.POST("/add/topup", nolCardTopupHandler.AddTopup)
+		adminRoutes.POST("/add/topup", idempotency, nolCardTopupHandler.AddTopup)
 		adminRoutes.GET("/topups/:nol_card_id", nolCardTopupHandler.GetTopupsByCardID)
 		adminRoutes.GET("/topup/:topup_id", nolCardTopupHandler.GetTopupByID)
+		adminRoutes.PUT("/nolcard/:nol_card_id/status", nolCardHandler.UpdateNolCardStatus)
+		adminRoutes.POST("/nolcard/:nol_card_id/replace", nolCardHandler.ReplaceNolCard)
+		adminRoutes.GET("/nolcard/:nol_card_id/history", nolCardHandler.GetNolCardHistory)
+		adminRoutes.GET("/nolcard/card-number-prefixes", nolCardHandler.GetCardNumberSeries)
+		adminRoutes.PUT("/nolcard/card-number-prefix", nolCardHandler.SetCardNumberPrefix)
+		adminRoutes.GET("/nolcard/invalid-numbers", nolCardHandler.GetInvalidCardNumbers)
+		adminRoutes.POST("/nolcard/:nol_card_id/reissue-number", nolCardHandler.ReissueCardNumber)
+		adminRoutes.POST("/nolcard/:nol_card_id/journey", idempotency, nolCardStatementHandler.ChargeJourney)
+		adminRoutes.GET("/nolcard/:nol_card_id/statement", nolCardStatementHandler.GetStatement)
+		adminRoutes.POST("/nolcard/:nol_card_id/refund", idempotency, nolCardStatementHandler.RefundToCard)
+		adminRoutes.POST("/add/nolcard/upgrade-rule", nolCardUpgradeHandler.CreateUpgradeRule)
+		adminRoutes.PUT("/update/nolcard/upgrade-rule/:id", nolCardUpgradeHandler.UpdateUpgradeRule)
+		adminRoutes.DELETE("/delete/nolcard/upgrade-rule/:id", nolCardUpgradeHandler.DeleteUpgradeRule)
+		adminRoutes.GET("/nolcard/upgrade-rules", nolCardUpgradeHandler.GetAllUpgradeRules)
 
 		adminRoutes.POST("/add/subscription/plans", subscriptionHandler.CreateSubscriptionPlan)
 		adminRoutes.PUT("/update/subscription/plans/:id", subscriptionHandler.UpdateSubscriptionPlan)
@@ -252,8 +497,21 @@ invoiceHandler := handler.NewInvoiceHandler(userRepo, invoiceRepo)
 		adminRoutes.GET("/view/subscription/plans", subscriptionHandler.GetAllSubscriptionPlans)
 		adminRoutes.GET("/subscriptions/all", subscriptionHandler.GetAllSubscriptions)
 
-		adminRoutes.POST("/wallet/topup", walletHandler.TopUpWalletByAdmin)
+		adminRoutes.POST("/add/exchange-rate", exchangeRateHandler.CreateExchangeRate)
+		adminRoutes.PUT("/update/exchange-rate/:id", exchangeRateHandler.UpdateExchangeRate)
+		adminRoutes.DELETE("/delete/exchange-rate/:id", exchangeRateHandler.DeleteExchangeRate)
+		adminRoutes.GET("/exchange-rates", exchangeRateHandler.GetAllExchangeRates)
+
+		adminRoutes.POST("/wallet/topup", idempotency, walletHandler.TopUpWalletByAdmin)
 		adminRoutes.GET("/wallet/transactions/:wallet_id", walletHandler.GetWalletTransactionsAdmin)
+
+		adminRoutes.POST("/organisations", organisationHandler.CreateOrganisation)
+		adminRoutes.GET("/organisations", organisationHandler.GetOrganisations)
+		adminRoutes.GET("/organisations/:organisation_id", organisationHandler.GetOrganisation)
+		adminRoutes.PUT("/organisations/:organisation_id/billing", organisationHandler.UpdateBilling)
+		adminRoutes.PUT("/organisations/:organisation_id/tax-details", organisationHandler.UpdateTaxDetails)
+		adminRoutes.POST("/organisations/:organisation_id/fund", idempotency, organisationHandler.FundOrganisation)
+		adminRoutes.POST("/organisations/:organisation_id/invoices/:invoice_id/settle", idempotency, organisationHandler.SettleInvoice)
 	}
 
 	userRoutes := router.Group("/user").Use(middleware.TokenAuthMiddleware()).Use(middleware.UserAuthMiddleware())
@@ -271,21 +529,65 @@ invoiceHandler := handler.NewInvoiceHandler(userRepo, invoiceRepo)
 		userRoutes.GET("/nearest-stop", routeStopHandler.FindNearestStop)
 		userRoutes.GET("/fare/calculate/:route_id/:start_stop_sequence/:end_stop_sequence", fareRuleHandler.CalculateFare)
 		userRoutes.POST("/travel-time", fareRuleHandler.CalculateTravelTimes)
+		userRoutes.GET("/currency/quote", exchangeRateHandler.QuoteAmount)
 
-		userRoutes.POST("/add/topup", nolCardTopupHandler.AddTopup)
+		userRoutes.POST("/add/topup", idempotency, nolCardTopupHandler.AddTopup)
 		userRoutes.GET("/nol-card/:nol_card_id", nolCardHandler.GetNolCardDetails)
-
-		userRoutes.POST("/add/subscriptions", subscriptionHandler.CreateSubscription)
+		userRoutes.GET("/:userID/nol-cards", nolCardHandler.GetUserNolCards)
+		userRoutes.POST("/:userID/nol-card/:nol_card_id/report-lost", nolCardHandler.ReportLost)
+		userRoutes.GET("/:userID/nol-card/:nol_card_id/upgrade-options", nolCardUpgradeHandler.GetUpgradeOptions)
+		userRoutes.POST("/:userID/nol-card/:nol_card_id/upgrade", nolCardUpgradeHandler.RequestUpgrade)
+		userRoutes.GET("/:userID/nol-card/upgrades", nolCardUpgradeHandler.GetUserUpgrades)
+		userRoutes.GET("/:userID/nol-card/:nol_card_id/statement", nolCardStatementHandler.GetUserStatement)
+
+		userRoutes.POST("/add/subscriptions", idempotency, subscriptionHandler.CreateSubscription)
 		userRoutes.GET("/subscriptions/:id", subscriptionHandler.GetUserSubscriptions)
 		userRoutes.PUT("/extend/subscriptions/:id", subscriptionHandler.ExtendSubscription)
+		userRoutes.PUT("/:userID/subscription/:subscription_id/auto-renew", subscriptionRenewalHandler.SetAutoRenew)
+		userRoutes.GET("/:userID/subscription/:subscription_id/renewals", subscriptionRenewalHandler.GetRenewals)
+		userRoutes.POST("/:userID/subscription/:subscription_id/pause", subscriptionHandler.PauseSubscription)
+		userRoutes.POST("/:userID/subscription/:subscription_id/resume", subscriptionHandler.ResumeSubscription)
+		userRoutes.POST("/:userID/subscription/:subscription_id/cancel", idempotency, subscriptionHandler.CancelSubscription)
+		userRoutes.POST("/:userID/subscription/:subscription_id/change-plan", idempotency, subscriptionHandler.ChangePlan)
+		userRoutes.GET("/:userID/subscription/:subscription_id/adjustments", subscriptionHandler.GetAdjustments)
 
 		userRoutes.POST("/:userID/bookings", bookingHandler.CreateBooking)
 		
 		userRoutes.POST("/:userID/wallet", walletHandler.CreateWallet)
 		userRoutes.GET("/:userID/wallet", walletHandler.GetWallet)
-		userRoutes.POST("/:userID/wallet/topup", walletHandler.TopUpWalletByUser)
-		userRoutes.POST("/:userID/wallet/payment", walletHandler.MakePayment)
+		userRoutes.POST("/:userID/wallet/topup", idempotency, walletHandler.TopUpWalletByUser)
+		userRoutes.POST("/:userID/wallet/payment", idempotency, walletHandler.MakePayment)
 		userRoutes.GET("/:userID/wallet/transactions", walletHandler.GetWalletTransactions)
+		userRoutes.POST("/:userID/wallet/transfer", idempotency, walletTransferHandler.TransferToWallet)
+		userRoutes.POST("/:userID/wallet/transfer/nol-card", idempotency, walletTransferHandler.TransferToNolCard)
+		userRoutes.POST("/:userID/wallet/transfer/:transfer_id/confirm", idempotency, walletTransferHandler.ConfirmTransfer)
+		userRoutes.GET("/:userID/wallet/transfers", walletTransferHandler.GetTransfers)
+		userRoutes.GET("/:userID/referrals", referralHandler.GetMyReferrals)
+		userRoutes.GET("/:userID/loyalty", loyaltyHandler.GetBalance)
+		userRoutes.GET("/:userID/loyalty/history", loyaltyHandler.GetHistory)
+		userRoutes.POST("/:userID/auto-reload", autoReloadHandler.CreateRule)
+		userRoutes.GET("/:userID/auto-reload", autoReloadHandler.GetRules)
+		userRoutes.PUT("/:userID/auto-reload/:rule_id", autoReloadHandler.UpdateRule)
+		userRoutes.DELETE("/:userID/auto-reload/:rule_id", autoReloadHandler.DeleteRule)
+		userRoutes.GET("/:userID/auto-reload/:rule_id/events", autoReloadHandler.GetRuleEvents)
+
+		userRoutes.GET("/:userID/organisations", organisationHandler.GetMemberships)
+		userRoutes.POST("/:userID/organisations/join", organisationHandler.AcceptInvite)
+		userRoutes.POST("/:userID/organisations/join-domain", organisationHandler.JoinByDomain)
+		userRoutes.GET("/:userID/organisations/:organisation_id", organisationHandler.GetOwnOrganisation)
+		userRoutes.GET("/:userID/organisations/:organisation_id/members", organisationHandler.GetMembers)
+		userRoutes.DELETE("/:userID/organisations/:organisation_id/members/:member_user_id", organisationHandler.RemoveMember)
+		userRoutes.POST("/:userID/organisations/:organisation_id/members/:member_user_id/subscription", idempotency, organisationHandler.FundMemberSubscription)
+		userRoutes.POST("/:userID/organisations/:organisation_id/members/:member_user_id/topup", idempotency, organisationHandler.FundMemberTopup)
+		userRoutes.POST("/:userID/organisations/:organisation_id/invites", organisationHandler.InviteMember)
+		userRoutes.GET("/:userID/organisations/:organisation_id/invites", organisationHandler.GetInvites)
+		userRoutes.GET("/:userID/organisations/:organisation_id/usage", organisationHandler.GetUsageReport)
+		userRoutes.GET("/:userID/invoices", invoiceHandler.GetInvoices)
+		userRoutes.GET("/:userID/invoices/:invoice_id", invoiceHandler.GetInvoice)
+		userRoutes.GET("/:userID/invoices/:invoice_id/download", invoiceHandler.DownloadInvoice)
+		userRoutes.POST("/:userID/invoices/:invoice_id/resend", invoiceHandler.ResendInvoice)
+		userRoutes.GET("/:userID/organisations/:organisation_id/invoices", organisationHandler.GetInvoices)
+		userRoutes.GET("/:userID/organisations/:organisation_id/invoices/:invoice_id", organisationHandler.GetInvoice)
 	}
 
 	router.Run(":8000")
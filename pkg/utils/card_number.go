@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+)
+
+// CardNumberLength is the length of a NolCard number including its check digit.
+const CardNumberLength = 16
+
+var ErrInvalidCardNumber = errors.New("invalid card number")
+
+// LuhnCheckDigit returns the Luhn check digit for a string of digits.
+func LuhnCheckDigit(payload string) (int, error) {
+	sum := 0
+	double := true
+	for i := len(payload) - 1; i >= 0; i-- {
+		ch := payload[i]
+		if ch < '0' || ch > '9' {
+			return 0, ErrInvalidCardNumber
+		}
+		digit := int(ch - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return (10 - sum%10) % 10, nil
+}
+
+// ValidateCardNumber checks the length, characters and check digit of a card number.
+func ValidateCardNumber(number string) error {
+	number = strings.TrimSpace(number)
+	if len(number) != CardNumberLength {
+		return ErrInvalidCardNumber
+	}
+	checkDigit, err := LuhnCheckDigit(number[:len(number)-1])
+	if err != nil {
+		return err
+	}
+	if int(number[len(number)-1]-'0') != checkDigit {
+		return ErrInvalidCardNumber
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
     "gopkg.in/gomail.v2"
+    "io"
     "os"
 )
 
@@ -26,15 +27,17 @@ func SendEmail(to string, subject string, body string) error {
 }
 
 
-func SendEmailWithAttachment(to string, subject string, body string, attachmentPath string) error {
+func SendEmailWithAttachment(to string, subject string, body string, attachmentPaths ...string) error {
     msg := gomail.NewMessage()
     msg.SetHeader("From", os.Getenv("EMAIL_SENDER"))
     msg.SetHeader("To", to)
     msg.SetHeader("Subject", subject)
     msg.SetBody("text/plain", body)
 
-    // Attach the file
-    msg.Attach(attachmentPath)
+    // Attach the files
+    for _, attachmentPath := range attachmentPaths {
+        msg.Attach(attachmentPath)
+    }
 
     dialer := gomail.NewDialer(
         os.Getenv("EMAIL_SMTP_HOST"),
@@ -48,4 +51,26 @@ func SendEmailWithAttachment(to string, subject string, body string, attachmentP
     }
 
     return nil
+}
+
+// SendEmailWithAttachmentData sends one attachment built in memory, so callers
+// need not write it to disk first.
+func SendEmailWithAttachmentData(to string, subject string, body string, filename string, content []byte) error {
+    msg := gomail.NewMessage()
+    msg.SetHeader("From", os.Getenv("EMAIL_SENDER"))
+    msg.SetHeader("To", to)
+    msg.SetHeader("Subject", subject)
+    msg.SetBody("text/plain", body)
+    msg.Attach(filename, gomail.SetCopyFunc(func(w io.Writer) error {
+        _, err := w.Write(content)
+        return err
+    }))
+
+    dialer := gomail.NewDialer(
+        os.Getenv("EMAIL_SMTP_HOST"),
+        587, 
+        os.Getenv("EMAIL_SENDER"),
+        os.Getenv("EMAIL_PASSWORD"),
+    )
+    return dialer.DialAndSend(msg)
 }
\ No newline at end of file
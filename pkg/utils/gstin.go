@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidGSTIN = errors.New("invalid GSTIN")
+
+// gstinPattern is the 15-character GSTIN layout: state code, PAN, entity
+// number, "Z" and a check character.
+var gstinPattern = regexp.MustCompile(`^[0-9]{2}[A-Z]{5}[0-9]{4}[A-Z][1-9A-Z]Z[0-9A-Z]$`)
+
+const gstinCodeChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// ValidateGSTIN checks the format and check character of a GSTIN.
+func ValidateGSTIN(gstin string) error {
+	gstin = strings.ToUpper(strings.TrimSpace(gstin))
+	if !gstinPattern.MatchString(gstin) {
+		return ErrInvalidGSTIN
+	}
+	sum := 0
+	for i := 0; i < 14; i++ {
+		value := strings.IndexByte(gstinCodeChars, gstin[i])
+		product := value
+		if i%2 == 1 {
+			product = value * 2
+		}
+		sum += product/36 + product%36
+	}
+	check := (36 - sum%36) % 36
+	if gstin[14] != gstinCodeChars[check] {
+		return ErrInvalidGSTIN
+	}
+	return nil
+}
+
+// GSTINStateCode returns the two-digit state code a GSTIN was issued in.
+func GSTINStateCode(gstin string) string {
+	gstin = strings.TrimSpace(gstin)
+	if len(gstin) < 2 {
+		return ""
+	}
+	return gstin[:2]
+}